@@ -53,7 +53,9 @@ type IntFunction interface {
 // A byte function is an operation that transforms the input byte array and writes
 // the result in the output byte array. The result may have a different size.
 // The function may fail if input and output array are the same array.
-// Return index in src, index in dst and error
+// Return index in src (bytes consumed), index in dst (bytes produced) and error.
+// If the output buffer is too small and the required size is known, the error
+// is a *function.BufferTooSmallError (see Missing()).
 type ByteFunction interface {
 	Forward(src, dst []byte) (uint, uint, error)
 
@@ -131,6 +133,14 @@ type EntropyDecoder interface {
 	Dispose()
 }
 
+// Optional interface of the entropy encoders and decoders. A self-terminating
+// codec writes an end of data symbol: the decoder finds the end of the block
+// by itself. Otherwise (and for the codecs not implementing the interface),
+// the container must store the size of the block for the decoder.
+type EntropyTermination interface {
+	SelfTerminating() bool
+}
+
 type Sizeable interface {
 	Size() uint
 	
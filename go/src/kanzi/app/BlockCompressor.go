@@ -38,6 +38,12 @@ type BlockCompressor struct {
 	silent       bool
 	overwrite    bool
 	checksum     bool
+	streamCksum  bool
+	split        bool
+	contentHash  bool
+	rawCheck     bool
+	stored       bool
+	verify       bool
 	inputName    string
 	outputName   string
 	entropyCodec string
@@ -58,9 +64,15 @@ func NewBlockCompressor() (*BlockCompressor, error) {
 	var inputName = flag.String("input", "", "mandatory name of the input file to encode")
 	var outputName = flag.String("output", "", "optional name of the output file (defaults to <input.knz>), or 'none' for dry-run")
 	var blockSize = flag.String("block", "1048576", "size of the input blocks, multiple of 8, max 512 MB (depends on transform), min 1KB, default 1MB")
-	var entropy = flag.String("entropy", "Huffman", "entropy codec to use [None|Huffman*|ANS|Range|PAQ|FPAQ|CM]")
-	var function = flag.String("transform", "BWT+MTF", "transform to use [None|BWT|BWTS|Snappy|LZ4|RLT]")
+	var entropy = flag.String("entropy", "Huffman", "entropy codec to use [None|Huffman*|ANS|Range|Order1Range|MixedRange|PAQ|FPAQ|CM|PAQLite]")
+	var function = flag.String("transform", "BWT+MTF", "transform to use [None|BWT|BWTS|Snappy|LZ4|RLT|LineDedup|Remap|Haar|Color|PredictDelta|ZRLT|LZ]")
 	var cksum = flag.Bool("checksum", false, "enable block checksum")
+	var scksum = flag.Bool("streamchecksum", false, "enable stream checksum (verified at the end of decoding)")
+	var split = flag.Bool("split", false, "end blocks at content transitions instead of fixed offsets")
+	var chash = flag.Bool("hash", false, "embed a content hash (SHA-256) of the input for deduplication")
+	var raw = flag.Bool("raw", false, "store incompressible blocks raw (no entropy coding)")
+	var stored = flag.Bool("stored", false, "store the blocks expanded by entropy coding raw")
+	var verify = flag.Bool("verify", false, "decode each block before writing it (slower)")
 	var tasks = flag.Int("jobs", 1, "number of concurrent jobs")
 
 	// Parse
@@ -74,11 +86,17 @@ func NewBlockCompressor() (*BlockCompressor, error) {
 		printOut("-input=<inputName>   : mandatory name of the input file to encode", true)
 		printOut("-output=<outputName> : optional name of the output file (defaults to <input.knz>) or 'none' for dry-run", true)
 		printOut("-block=<size>        : size of the input blocks, multiple of 8, max 512 MB (depends on transform), min 1KB, default 1MB", true)
-		printOut("-entropy=<codec>     : entropy codec to use [None|Huffman*|ANS|Range|PAQ|FPAQ|CM]", true)
-		printOut("-transform=<codec>   : transform to use [None|BWT*|BWTS|Snappy|LZ4|RLT]", true)
+		printOut("-entropy=<codec>     : entropy codec to use [None|Huffman*|ANS|Range|Order1Range|MixedRange|PAQ|FPAQ|CM|PAQLite]", true)
+		printOut("-transform=<codec>   : transform to use [None|BWT*|BWTS|Snappy|LZ4|RLT|LineDedup|Remap|Haar|Color|PredictDelta|ZRLT|LZ]", true)
 		printOut("                       for BWT(S), an optional GST can be provided: [MTF|RANK|TIMESTAMP]", true)
 		printOut("                       EG: BWT+RANK or BWTS+MTF (default is BWT+MTF)", true)
 		printOut("-checksum            : enable block checksum", true)
+		printOut("-streamchecksum      : enable stream checksum (verified at the end of decoding)", true)
+		printOut("-split               : end blocks at content transitions instead of fixed offsets", true)
+		printOut("-hash                : embed a content hash (SHA-256) of the input for deduplication", true)
+		printOut("-raw                 : store incompressible blocks raw (no entropy coding)", true)
+		printOut("-stored              : store the blocks expanded by entropy coding raw", true)
+		printOut("-verify              : decode each block before writing it (slower)", true)
 		printOut("-jobs=<jobs>         : number of concurrent jobs", true)
 		printOut("", true)
 		printOut("EG. go run BlockCompressor -input=foo.txt -output=foo.knz -overwrite -transform=BWT+MTF -block=4m -entropy=FPAQ -verbose -jobs=4", true)
@@ -128,6 +146,12 @@ func NewBlockCompressor() (*BlockCompressor, error) {
 	this.entropyCodec = strings.ToUpper(*entropy)
 	this.transform = strings.ToUpper(*function)
 	this.checksum = *cksum
+	this.streamCksum = *scksum
+	this.split = *split
+	this.contentHash = *chash
+	this.rawCheck = *raw
+	this.stored = *stored
+	this.verify = *verify
 	this.jobs = uint(*tasks)
 	this.listeners = list.New()
 
@@ -197,6 +221,14 @@ func (this *BlockCompressor) call() (int, uint64) {
 	printOut(msg, this.verbose)
 	msg = fmt.Sprintf("Checksum set to %t", this.checksum)
 	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Stream checksum set to %t", this.streamCksum)
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Content split set to %t", this.split)
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Content hash set to %t", this.contentHash)
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Verify set to %t", this.verify)
+	printOut(msg, this.verbose)
 	w1 := "no"
 
 	if this.transform != "NONE" {
@@ -279,6 +311,16 @@ func (this *BlockCompressor) call() (int, uint64) {
 	}
 
 	defer cos.Close()
+	cos.SetContentSplit(this.split)
+	cos.SetContentHash(this.contentHash)
+	cos.SetIncompressibleCheck(this.rawCheck)
+	cos.SetStoredFallback(this.stored)
+	cos.SetVerify(this.verify)
+
+	if this.streamCksum == true {
+		cos.SetChecksumMode(cos.ChecksumMode() | io.CHECKSUM_STREAM)
+	}
+
 	input, err := os.Open(this.inputName)
 
 	if err != nil {
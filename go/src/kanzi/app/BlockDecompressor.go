@@ -38,6 +38,7 @@ type BlockDecompressor struct {
 	inputName  string
 	outputName string
 	jobs       uint
+	maxMemory  uint64
 	listeners  *list.List
 }
 
@@ -52,6 +53,7 @@ func NewBlockDecompressor() (*BlockDecompressor, error) {
 	var inputName = flag.String("input", "", "mandatory name of the input file to decode")
 	var outputName = flag.String("output", "", "optional name of the output file or 'none' for dry-run")
 	var tasks = flag.Int("jobs", 1, "number of concurrent jobs")
+	var maxMemory = flag.Int("maxmem", 0, "maximum memory used to decode in MB (0 means no limit)")
 
 	// Parse
 	flag.Parse()
@@ -64,6 +66,7 @@ func NewBlockDecompressor() (*BlockDecompressor, error) {
 		printOut("-input=<inputName>   : mandatory name of the input file to decode", true)
 		printOut("-output=<outputName> : optional name of the output file or 'none' for dry-run", true)
 		printOut("-jobs=<jobs>         : number of concurrent jobs", true)
+		printOut("-maxmem=<size>       : maximum memory used to decode in MB (0 means no limit)", true)
 		printOut("", true)
 		printOut("EG. go run BlockDecompressor -input=foo.knz -overwrite -verbose -jobs=2", true)
 		os.Exit(0)
@@ -97,6 +100,13 @@ func NewBlockDecompressor() (*BlockDecompressor, error) {
 	this.outputName = *outputName
 	this.overwrite = *overwrite
 	this.jobs = uint(*tasks)
+
+	if *maxMemory < 0 {
+		fmt.Printf("Invalid maximum memory provided on command line: %v\n", *maxMemory)
+		os.Exit(io.ERR_MEMORY_LIMIT)
+	}
+
+	this.maxMemory = uint64(*maxMemory) << 20
 	this.listeners = list.New()
 
 	if this.verbose == true {
@@ -169,6 +179,11 @@ func (this *BlockDecompressor) call() (int, uint64) {
 
 	msg = fmt.Sprintf("Using %d job%s", this.jobs, prefix)
 	printOut(msg, this.verbose)
+
+	if this.maxMemory > 0 {
+		msg = fmt.Sprintf("Memory limit set to %d MB", this.maxMemory>>20)
+		printOut(msg, this.verbose)
+	}
 	var output kanzi.OutputStream
 
 	if strings.ToUpper(this.outputName) == "NONE" {
@@ -234,6 +249,8 @@ func (this *BlockDecompressor) call() (int, uint64) {
 		}
 	}
 
+	cis.SetMemoryLimit(this.maxMemory)
+
 	for e := this.listeners.Front(); e != nil; e = e.Next() {
 		cis.AddListener(e.Value.(io.BlockListener))
 	}
@@ -283,6 +300,8 @@ func (this *BlockDecompressor) call() (int, uint64) {
 	printOut(msg, !this.silent)
 	msg = fmt.Sprintf("Output size:       %d", read)
 	printOut(msg, !this.silent)
+	msg = fmt.Sprintf("Peak memory:       %d", cis.GetPeakMemory())
+	printOut(msg, this.verbose)
 
 	if delta > 0 {
 		msg = fmt.Sprintf("Throughput (KB/s): %d", ((read*uint64(1000))>>10)/uint64(delta))
@@ -73,30 +73,26 @@ func (this *DefaultInputBitStream) ReadBits(count uint) uint64 {
 		panic(fmt.Errorf("Invalid count: %v (must be in [1..64])", count))
 	}
 
-	var res uint64
+	if this.bitIndex == 63 {
+		// 'current' is empty
+		this.pullCurrent()
+	}
 
 	if count <= this.bitIndex+1 {
 		// Enough spots available in 'current'
 		shift := this.bitIndex + 1 - count
-
-		if this.bitIndex == 63 {
-			this.pullCurrent()
-			shift += (this.bitIndex - 63) // adjust if bitIndex != 63 (end of stream)
-		}
-
-		res = (this.current >> shift) & (0xFFFFFFFFFFFFFFFF >> (64 - count))
+		res := (this.current >> shift) & (0xFFFFFFFFFFFFFFFF >> (64 - count))
 		this.bitIndex = (this.bitIndex - count) & 63
-	} else {
-		// Not enough spots available in 'current'
-		remaining := count - this.bitIndex - 1
-		res = this.current & (0xFFFFFFFFFFFFFFFF >> (63 - this.bitIndex))
-		res <<= remaining
-		this.pullCurrent()
-		this.bitIndex -= remaining
-		res |= (this.current >> (this.bitIndex + 1))
+		return res
 	}
 
-	return res
+	// Not enough spots available in 'current' (it may hold less than 64 bits
+	// at the end of the input): take them and read the rest. Reading past the
+	// end of the stream panics.
+	available := this.bitIndex + 1
+	res := this.current & (0xFFFFFFFFFFFFFFFF >> (64 - available))
+	this.bitIndex = 63
+	return (res << (count - available)) | this.ReadBits(count-available)
 }
 
 func (this *DefaultInputBitStream) readFromInputStream(count int) (int, error) {
@@ -130,7 +126,7 @@ func (this *DefaultInputBitStream) HasMoreToRead() (bool, error) {
 		return false, errors.New("Stream closed")
 	}
 
-	if this.position < this.maxPosition || this.bitIndex != 63 {
+	if this.position <= this.maxPosition || this.bitIndex != 63 {
 		return true, nil
 	}
 
@@ -182,18 +178,37 @@ func (this *DefaultInputBitStream) Close() (bool, error) {
 	}
 
 	this.closed = true
-	this.read += uint64(63)
+	this.read = this.Read()
 
 	// Reset fields to force a readFromInputStream() and trigger an error
 	// on ReadBit() or ReadBits()
 	this.bitIndex = 63
+	this.position = 0
 	this.maxPosition = -1
 	return true, this.is.Close()
 }
 
+// Discard the state (buffered bits are lost) and read from a new stream.
+// The buffer is reused. The stream can be reset after Close().
+func (this *DefaultInputBitStream) Reset(stream kanzi.InputStream) error {
+	if stream == nil {
+		return errors.New("Invalid null input stream parameter")
+	}
+
+	this.is = stream
+	this.closed = false
+	this.read = 0
+	this.position = 0
+	this.bitIndex = 63
+	this.maxPosition = -1
+	this.current = 0
+	return nil
+}
+
 // Return number of bits read so far
 func (this *DefaultInputBitStream) Read() uint64 {
-	return this.read + uint64(this.position)<<3 - uint64(this.bitIndex)
+	// bitIndex == 63 means that all the bits in 'current' have been read
+	return this.read + uint64(this.position)<<3 - uint64((this.bitIndex+1)&63)
 }
 
 func (this *DefaultInputBitStream) Closed() bool {
@@ -16,11 +16,16 @@ limitations under the License.
 package bitstream
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"kanzi"
 )
 
+const (
+	OUTPUT_BITSTREAM_SNAPSHOT_SIZE = 17
+)
+
 type DefaultOutputBitStream struct {
 	closed   bool
 	written  uint64
@@ -140,6 +145,30 @@ func (this *DefaultOutputBitStream) flush() error {
 	return nil
 }
 
+// Pad the pending bits with zeros to a byte boundary and write all the bytes
+// to the underlying stream, without closing it (EG. to let a reader make
+// progress). The reader must skip the padding (at most 7 bits).
+func (this *DefaultOutputBitStream) Flush() error {
+	if this.Closed() {
+		return errors.New("Stream closed")
+	}
+
+	if this.bitIndex != 63 {
+		// Complete bytes of 'current' (the buffer has room for 8 bytes)
+		size := ((63 - this.bitIndex) + 7) >> 3
+
+		for i := 0; i < size; i++ {
+			this.buffer[this.position] = byte(this.current >> uint(56-8*i))
+			this.position++
+		}
+
+		this.bitIndex = 63
+		this.current = 0
+	}
+
+	return this.flush()
+}
+
 func (this *DefaultOutputBitStream) Close() (bool, error) {
 	if this.Closed() {
 		return true, nil
@@ -172,11 +201,67 @@ func (this *DefaultOutputBitStream) Close() (bool, error) {
 	// Reset fields to force a flush() and trigger an error
 	// on WriteBit() or WriteBits()
 	this.bitIndex = -1
-	this.buffer = make([]byte, 8)
-	this.written -= 64 // adjust for method Written()
+	this.buffer = this.buffer[0:8] // keep the capacity for Reset()
+	this.written -= 64             // adjust for method Written()
 	return true, nil
 }
 
+// Discard the state (pending bits are not written) and write to a new
+// stream. The buffer is reused. The stream can be reset after Close().
+func (this *DefaultOutputBitStream) Reset(stream kanzi.OutputStream) error {
+	if stream == nil {
+		return errors.New("Invalid null output stream parameter")
+	}
+
+	this.os = stream
+	this.buffer = this.buffer[0:cap(this.buffer)]
+	this.closed = false
+	this.written = 0
+	this.position = 0
+	this.bitIndex = 63
+	this.current = 0
+	return nil
+}
+
+// Flush the complete bytes to the underlying stream and return the state of
+// the stream: number of bits flushed (64 bits), pending bits (64 bits) and
+// index of the next bit in the pending bits (8 bits). Restoring the state
+// into a stream writing after the flushed bytes resumes the bitstream.
+func (this *DefaultOutputBitStream) Snapshot() ([]byte, error) {
+	if err := this.flush(); err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, OUTPUT_BITSTREAM_SNAPSHOT_SIZE)
+	binary.BigEndian.PutUint64(res[0:], this.written)
+	binary.BigEndian.PutUint64(res[8:], this.current)
+	res[16] = byte(this.bitIndex)
+	return res, nil
+}
+
+// Restore the state returned by Snapshot. The underlying stream must be
+// positioned after the bytes flushed before the snapshot.
+func (this *DefaultOutputBitStream) Restore(snapshot []byte) error {
+	if len(snapshot) != OUTPUT_BITSTREAM_SNAPSHOT_SIZE {
+		return errors.New("Invalid bitstream snapshot size")
+	}
+
+	written := binary.BigEndian.Uint64(snapshot[0:])
+	bitIndex := int(snapshot[16])
+
+	if written&7 != 0 || bitIndex > 63 {
+		return errors.New("Invalid bitstream snapshot")
+	}
+
+	this.buffer = this.buffer[0:cap(this.buffer)]
+	this.closed = false
+	this.written = written
+	this.position = 0
+	this.current = binary.BigEndian.Uint64(snapshot[8:])
+	this.bitIndex = bitIndex
+	return nil
+}
+
 // Return number of bits written so far
 func (this *DefaultOutputBitStream) Written() uint64 {
 	// Number of bits flushed + bytes written in memory + bits written in memory
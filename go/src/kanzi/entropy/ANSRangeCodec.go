@@ -74,8 +74,9 @@ func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncode
 		return nil, errors.New("The chunk size must be at most 2^30")
 	}
 
-	if logRange < 8 || logRange > 16 {
-		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..16])", logRange)
+	// The log range is stored on 3 bits (minus 8) in the chunk header
+	if logRange < 8 || logRange > 15 {
+		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..15])", logRange)
 	}
 
 	this := new(ANSRangeEncoder)
@@ -109,62 +110,10 @@ func (this *ANSRangeEncoder) updateFrequencies(frequencies []int, size int, lr u
 		this.cumFreqs[i+1] = this.cumFreqs[i] + frequencies[i]
 	}
 
-	this.encodeHeader(alphabetSize, this.alphabet, frequencies, lr)
+	encodeFrequencies(this.bitstream, this.alphabet[0:alphabetSize], frequencies, lr)
 	return alphabetSize, nil
 }
 
-func (this *ANSRangeEncoder) encodeHeader(alphabetSize int, alphabet []byte, frequencies []int, lr uint) bool {
-	EncodeAlphabet(this.bitstream, alphabet[0:alphabetSize])
-
-	if alphabetSize == 0 {
-		return true
-	}
-
-	this.bitstream.WriteBits(uint64(lr-8), 3) // logRange
-	inc := 16
-
-	if alphabetSize <= 64 {
-		inc = 8
-	}
-
-	llr := uint(3)
-
-	for 1<<llr <= lr {
-		llr++
-	}
-
-	/// Encode all frequencies (but the first one) by chunks of size 'inc'
-	for i := 1; i < alphabetSize; i += inc {
-		max := 0
-		logMax := uint(1)
-		endj := i + inc
-
-		if endj > alphabetSize {
-			endj = alphabetSize
-		}
-
-		// Search for max frequency log size in next chunk
-		for j := i; j < endj; j++ {
-			if frequencies[alphabet[j]] > max {
-				max = frequencies[alphabet[j]]
-			}
-		}
-
-		for 1<<logMax <= max {
-			logMax++
-		}
-
-		this.bitstream.WriteBits(uint64(logMax-1), llr)
-
-		// Write frequencies
-		for j := i; j < endj; j++ {
-			this.bitstream.WriteBits(uint64(frequencies[alphabet[j]]), logMax)
-		}
-	}
-
-	return true
-}
-
 // Dynamically compute the frequencies for every chunk of data in the block
 func (this *ANSRangeEncoder) Encode(block []byte) (int, error) {
 	if block == nil {
@@ -308,64 +257,13 @@ func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder
 }
 
 func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, uint, error) {
-	alphabetSize, err := DecodeAlphabet(this.bitstream, this.alphabet)
+	alphabetSize, logRange, err := decodeFrequencies(this.bitstream, this.alphabet, frequencies)
 
 	if err != nil || alphabetSize == 0 {
-		return alphabetSize, 0, nil
+		return alphabetSize, logRange, err
 	}
 
-	if alphabetSize != 256 {
-		for i := range frequencies {
-			frequencies[i] = 0
-		}
-	}
-
-	// Decode frequencies
-	logRange := uint(8 + this.bitstream.ReadBits(3))
 	scale := 1 << logRange
-	sum := 0
-	inc := 16
-	llr := uint(3)
-
-	if alphabetSize <= 64 {
-		inc = 8
-	}
-
-	for 1<<llr <= logRange {
-		llr++
-	}
-
-	// Decode all frequencies (but the first one) by chunks of size 'inc'
-	for i := 1; i < alphabetSize; i += inc {
-		logMax := uint(1 + this.bitstream.ReadBits(llr))
-		endj := i + inc
-
-		if endj > alphabetSize {
-			endj = alphabetSize
-		}
-
-		// Read frequencies
-		for j := i; j < endj; j++ {
-			val := int(this.bitstream.ReadBits(logMax))
-
-			if val <= 0 || val >= scale {
-				error := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", val, this.alphabet[j])
-				return alphabetSize, logRange, error
-			}
-
-			frequencies[this.alphabet[j]] = val
-			sum += val
-		}
-	}
-
-	// Infer first frequency
-	frequencies[this.alphabet[0]] = scale - sum
-
-	if frequencies[this.alphabet[0]] <= 0 || frequencies[this.alphabet[0]] > 1<<logRange {
-		error := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", frequencies[this.alphabet[0]], this.alphabet[0])
-		return alphabetSize, logRange, error
-	}
-
 	this.cumFreqs[0] = 0
 
 	if len(this.f2s) < scale {
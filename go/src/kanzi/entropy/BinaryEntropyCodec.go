@@ -25,6 +25,7 @@ const (
 	MASK_24_56         = uint64(0x00FFFFFFFF000000)
 	MASK_0_24          = uint64(0x0000000000FFFFFF)
 	MASK_0_32          = uint64(0x00000000FFFFFFFF)
+	MAX_BINARY_ALIGN   = 4096 // max alignment of the end of the coded data (in bytes)
 )
 
 type Predictor interface {
@@ -42,6 +43,8 @@ type BinaryEntropyEncoder struct {
 	high      uint64
 	bitstream kanzi.OutputBitStream
 	disposed  bool
+	alignment uint // in bytes, 0 means no alignment
+	pad       byte
 }
 
 func NewBinaryEntropyEncoder(bs kanzi.OutputBitStream, predictor Predictor) (*BinaryEntropyEncoder, error) {
@@ -109,13 +112,51 @@ func (this *BinaryEntropyEncoder) BitStream() kanzi.OutputBitStream {
 	return this.bitstream
 }
 
+// Pad the bitstream after the final flush so that the number of bits written
+// is a multiple of 'alignment' bytes. The padding is made of 'pad' bytes
+// (EG. 0xAA to spot it in a dump) and is not needed to decode: the decoder
+// must skip it (see BinaryEntropyDecoder.SetAlignment).
+// An alignment of 0 or 1 byte with a stream at a byte boundary adds nothing.
+func (this *BinaryEntropyEncoder) SetPadding(alignment uint, pad byte) bool {
+	if this.disposed == true || alignment > MAX_BINARY_ALIGN || alignment&(alignment-1) != 0 {
+		return false
+	}
+
+	this.alignment = alignment
+	this.pad = pad
+	return true
+}
+
 func (this *BinaryEntropyEncoder) Dispose() {
 	if this.disposed == true {
 		return
 	}
 
 	this.disposed = true
+
+	// Decodable tail (a value in [low, high])
 	this.bitstream.WriteBits(this.low|MASK_0_24, 56)
+
+	if this.alignment == 0 {
+		return
+	}
+
+	// Alignment only padding
+	n := uint(this.bitstream.Written() & uint64(8*this.alignment-1))
+
+	if n == 0 {
+		return
+	}
+
+	n = 8*this.alignment - n
+
+	if n&7 != 0 {
+		this.bitstream.WriteBits(uint64(this.pad), n&7)
+	}
+
+	for n >>= 3; n > 0; n-- {
+		this.bitstream.WriteBits(uint64(this.pad), 8)
+	}
 }
 
 type BinaryEntropyDecoder struct {
@@ -125,6 +166,7 @@ type BinaryEntropyDecoder struct {
 	current     uint64
 	initialized bool
 	bitstream   kanzi.InputBitStream
+	alignment   uint // in bytes, 0 means no alignment
 }
 
 func NewBinaryEntropyDecoder(bs kanzi.InputBitStream, predictor Predictor) (*BinaryEntropyDecoder, error) {
@@ -216,9 +258,64 @@ func (this *BinaryEntropyDecoder) Decode(block []byte) (int, error) {
 	return len(block), err
 }
 
+// Decode into the provided buffers, filled in order (a buffer may be empty).
+// Same result as Decode into a single buffer of the total size, without the
+// final copy to scatter the data. Return the total number of bytes decoded.
+func (this *BinaryEntropyDecoder) DecodeScatter(dst [][]byte) (int, error) {
+	if dst == nil {
+		return 0, errors.New("Invalid null buffers parameter")
+	}
+
+	if this.Initialized() == false {
+		this.Initialize()
+	}
+
+	decoded := 0
+
+	for _, block := range dst {
+		for i := range block {
+			block[i] = this.decodeByte()
+		}
+
+		decoded += len(block)
+	}
+
+	return decoded, nil
+}
+
 func (this *BinaryEntropyDecoder) BitStream() kanzi.InputBitStream {
 	return this.bitstream
 }
 
+// Must match the alignment of the encoder (the pad value is not needed)
+func (this *BinaryEntropyDecoder) SetAlignment(alignment uint) bool {
+	if alignment > MAX_BINARY_ALIGN || alignment&(alignment-1) != 0 {
+		return false
+	}
+
+	this.alignment = alignment
+	return true
+}
+
+// Skip the alignment padding (if any)
 func (this *BinaryEntropyDecoder) Dispose() {
+	if this.alignment == 0 || this.initialized == false {
+		return
+	}
+
+	n := uint(this.bitstream.Read() & uint64(8*this.alignment-1))
+
+	if n == 0 {
+		return
+	}
+
+	n = 8*this.alignment - n
+
+	if n&7 != 0 {
+		this.bitstream.ReadBits(n & 7)
+	}
+
+	for n >>= 3; n > 0; n-- {
+		this.bitstream.ReadBits(8)
+	}
 }
@@ -18,6 +18,7 @@ package entropy
 import (
 	"fmt"
 	"kanzi"
+	"strconv"
 	"strings"
 )
 
@@ -29,6 +30,9 @@ const (
 	RANGE_TYPE   = byte(4) // Range
 	ANS_TYPE     = byte(5) // Asymetric Numerical System
 	CM_TYPE      = byte(6) // Context Model
+	PAQLITE_TYPE = byte(7) // Small PAQ (context mixing)
+	ORDER1_TYPE  = byte(8) // Adaptive order 1 range coder
+	MIXED_TYPE   = byte(9) // Adaptive order 0/order 1 mixing range coder
 )
 
 func NewEntropyDecoder(ibs kanzi.InputBitStream, entropyType byte) (kanzi.EntropyDecoder, error) {
@@ -43,6 +47,12 @@ func NewEntropyDecoder(ibs kanzi.InputBitStream, entropyType byte) (kanzi.Entrop
 	case RANGE_TYPE:
 		return NewRangeDecoder(ibs)
 
+	case ORDER1_TYPE:
+		return NewOrder1RangeDecoder(ibs)
+
+	case MIXED_TYPE:
+		return NewMixedRangeDecoder(ibs)
+
 	case PAQ_TYPE:
 		predictor, _ := NewPAQPredictor()
 		return NewBinaryEntropyDecoder(ibs, predictor)
@@ -55,6 +65,10 @@ func NewEntropyDecoder(ibs kanzi.InputBitStream, entropyType byte) (kanzi.Entrop
 		predictor, _ := NewCMPredictor()
 		return NewBinaryEntropyDecoder(ibs, predictor)
 
+	case PAQLITE_TYPE:
+		predictor, _ := NewPAQLitePredictor()
+		return NewBinaryEntropyDecoder(ibs, predictor)
+
 	case NONE_TYPE:
 		return NewNullEntropyDecoder(ibs)
 
@@ -75,6 +89,12 @@ func NewEntropyEncoder(obs kanzi.OutputBitStream, entropyType byte) (kanzi.Entro
 	case RANGE_TYPE:
 		return NewRangeEncoder(obs)
 
+	case ORDER1_TYPE:
+		return NewOrder1RangeEncoder(obs)
+
+	case MIXED_TYPE:
+		return NewMixedRangeEncoder(obs)
+
 	case PAQ_TYPE:
 		predictor, _ := NewPAQPredictor()
 		return NewBinaryEntropyEncoder(obs, predictor)
@@ -87,6 +107,10 @@ func NewEntropyEncoder(obs kanzi.OutputBitStream, entropyType byte) (kanzi.Entro
 		predictor, _ := NewCMPredictor()
 		return NewBinaryEntropyEncoder(obs, predictor)
 
+	case PAQLITE_TYPE:
+		predictor, _ := NewPAQLitePredictor()
+		return NewBinaryEntropyEncoder(obs, predictor)
+
 	case NONE_TYPE:
 		return NewNullEntropyEncoder(obs)
 
@@ -95,6 +119,67 @@ func NewEntropyEncoder(obs kanzi.OutputBitStream, entropyType byte) (kanzi.Entro
 	}
 }
 
+// Return true if the entropy encoder or decoder writes (reads) an end of data
+// symbol (see kanzi.EntropyTermination). If false, the size of the block must
+// be stored by the container.
+func IsSelfTerminating(codec interface{}) bool {
+	if et, ok := codec.(kanzi.EntropyTermination); ok == true {
+		return et.SelfTerminating()
+	}
+
+	return false
+}
+
+// Return an upper bound of the memory (in bytes) allocated by a decoder of the
+// provided type (models and lookup tables).
+func GetEntropyDecoderMemory(entropyType byte) uint64 {
+	intSize := uint64(strconv.IntSize >> 3)
+
+	switch entropyType {
+
+	case HUFFMAN_TYPE:
+		// sizes, ranks, codes, fdTable, sdTable, sdtIndexes
+		return 512 + intSize*(256+(1<<DECODING_BATCH_SIZE)+256+24)
+
+	case ANS_TYPE:
+		// alphabet, freqs, cumFreqs, f2s (max log range is 15)
+		return 256 + intSize*(256+257) + (1 << 15)
+
+	case RANGE_TYPE:
+		// alphabet, freqs, cumFreqs, f2s (max log range is 15)
+		return 256 + intSize*(256+257) + (1 << 15)
+
+	case ORDER1_TYPE:
+		// 256 context tables of frequencies, group sums and total (allocated on first use)
+		return 256 + intSize*256 + 256*(4*(256+16+1))
+
+	case MIXED_TYPE:
+		// Order 1 tables, order 0 table, weights
+		return 256 + intSize*(256+256) + 257*(4*(256+16+1))
+
+	case PAQ_TYPE:
+		// states, state map, 3 APMs
+		return intSize * (256 + 256 + 33*(1024+1024+8192))
+
+	case FPAQ_TYPE:
+		return intSize * 512
+
+	case CM_TYPE:
+		return intSize * (256 + 256*256 + 2*256*17)
+
+	case PAQLITE_TYPE:
+		// 3 context models, mixer weights, 2 APMs
+		return 2*(256+256*256+PAQLITE_ORDER2_MASK+1) +
+			intSize*(256*PAQLITE_INPUTS+33*(256+PAQLITE_APM2_CONTEXT))
+
+	case NONE_TYPE:
+		return 0
+
+	default:
+		panic(fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType))
+	}
+}
+
 func GetEntropyCodecName(entropyType byte) string {
 	switch byte(entropyType) {
 
@@ -107,6 +192,12 @@ func GetEntropyCodecName(entropyType byte) string {
 	case RANGE_TYPE:
 		return "RANGE"
 
+	case ORDER1_TYPE:
+		return "ORDER1RANGE"
+
+	case MIXED_TYPE:
+		return "MIXEDRANGE"
+
 	case PAQ_TYPE:
 		return "PAQ"
 
@@ -116,6 +207,9 @@ func GetEntropyCodecName(entropyType byte) string {
 	case CM_TYPE:
 		return "CM"
 
+	case PAQLITE_TYPE:
+		return "PAQLITE"
+
 	case NONE_TYPE:
 		return "NONE"
 
@@ -136,6 +230,12 @@ func GetEntropyCodecType(entropyName string) byte {
 	case "RANGE":
 		return RANGE_TYPE
 
+	case "ORDER1RANGE":
+		return ORDER1_TYPE
+
+	case "MIXEDRANGE":
+		return MIXED_TYPE
+
 	case "PAQ":
 		return PAQ_TYPE
 
@@ -145,6 +245,9 @@ func GetEntropyCodecType(entropyName string) byte {
 	case "CM":
 		return CM_TYPE
 
+	case "PAQLITE":
+		return PAQLITE_TYPE
+
 	case "NONE":
 		return NONE_TYPE
 
@@ -17,8 +17,11 @@ package entropy
 
 import (
 	"container/heap"
+	"errors"
 	"fmt"
+	"io"
 	"kanzi"
+	"kanzi/bitstream"
 )
 
 const (
@@ -30,8 +33,116 @@ const (
 	BIT_ENCODED_ALPHABET   = 1
 	PRESENT_SYMBOLS_MASK   = 0
 	ABSENT_SYMBOLS_MASK    = 1
+	MIN_COMPRESSIBLE_CHECK = 4096 // minimum size of the sampled prefix
 )
 
+var (
+	ErrIncompressible = errors.New("Incompressible data")
+
+	// End of a range coded stream (see RangeReader), io.EOF as required by io.Reader
+	ErrEndOfStream = io.EOF
+
+	// Invalid coded data, wrapped by the decoding errors (use errors.Is)
+	ErrCorruptStream = errors.New("Invalid bitstream")
+)
+
+// Output stream counting the bytes written
+type countingOutputStream struct {
+	written int
+}
+
+func (this *countingOutputStream) Write(b []byte) (int, error) {
+	this.written += len(b)
+	return len(b), nil
+}
+
+func (this *countingOutputStream) Close() error {
+	return nil
+}
+
+// Encode a prefix of the block (1/8 of the block, at least 4KB) and return
+// ErrIncompressible if the coded prefix is not smaller than the prefix. The
+// cost is a fraction of the full encoding: call it before encoding to store
+// incompressible blocks raw.
+func CheckCompressible(block []byte, entropyType byte) (err error) {
+	if entropyType == NONE_TYPE {
+		return nil
+	}
+
+	sampleSize := len(block) >> 3
+
+	if sampleSize < MIN_COMPRESSIBLE_CHECK {
+		sampleSize = MIN_COMPRESSIBLE_CHECK
+	}
+
+	if sampleSize > len(block) {
+		sampleSize = len(block)
+	}
+
+	// The bitstream panics on write errors
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	os := &countingOutputStream{}
+	obs, err := bitstream.NewDefaultOutputBitStream(os, 65536)
+
+	if err != nil {
+		return err
+	}
+
+	ee, err := NewEntropyEncoder(obs, entropyType)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = ee.Encode(block[0:sampleSize]); err != nil {
+		return err
+	}
+
+	ee.Dispose()
+	obs.Close()
+
+	if os.written >= sampleSize {
+		return ErrIncompressible
+	}
+
+	return nil
+}
+
+// Encode the block with the encoder (same output as ee.Encode(block)) using
+// the caller's scratch buffers. The range and ANS range encoders normalize
+// the frequencies of each chunk with the buffers of scratch instead of their
+// own: once scratch has served a first normalization (and the encoder a first
+// block), encoding allocates no memory. A scratch can be shared by encoders
+// used in turn (EG. one scratch per worker goroutine in a server) but not by
+// concurrent calls. If scratch is nil, the encoder uses its own buffers.
+// The other encoders ignore scratch.
+func EntropyEncodeArrayInto(ee kanzi.EntropyEncoder, block []byte, scratch *EntropyUtils) (int, error) {
+	if ee == nil {
+		return 0, errors.New("Invalid null encoder parameter")
+	}
+
+	if scratch != nil {
+		switch enc := ee.(type) {
+		case *RangeEncoder:
+			eu := enc.eu
+			enc.eu = scratch
+			defer func() { enc.eu = eu }()
+
+		case *ANSRangeEncoder:
+			eu := enc.eu
+			enc.eu = scratch
+			defer func() { enc.eu = eu }()
+		}
+	}
+
+	return ee.Encode(block)
+}
+
 type ErrorComparator struct {
 	symbols []byte
 	errors  []int
@@ -108,15 +219,21 @@ func (this *FreqSortPriorityQueue) Pop() interface{} {
 	return data
 }
 
+// Buffers of the frequency normalization, allocated by the first call to
+// NormalizeFrequencies and reused by the following calls
 type EntropyUtils struct {
-	ranks  []byte
-	errors []int
+	ranks    []byte
+	errors   []int
+	queue    FreqSortPriorityQueue
+	sortData []FreqSortData
 }
 
 func NewEntropyUtils() (*EntropyUtils, error) {
 	this := new(EntropyUtils)
 	this.ranks = make([]byte, 0)
 	this.errors = make([]int, 0)
+	this.queue = make(FreqSortPriorityQueue, 0)
+	this.sortData = make([]FreqSortData, 0)
 	return this, nil
 }
 
@@ -308,8 +425,181 @@ func DecodeAlphabet(ibs kanzi.InputBitStream, alphabet []byte) (int, error) {
 	return alphabetSize, nil
 }
 
+// Normalize the frequencies (indexed by symbol) so that they add up to
+// 2^logRange and write the histogram to the bitstream (format of the range
+// and ANS chunk headers). Symbols with a null frequency are absent from the
+// alphabet and keep a null frequency. Return the size of the alphabet.
+// The freqs parameter is updated.
+func WriteHistogram(obs kanzi.OutputBitStream, freqs []int, logRange uint) (int, error) {
+	if obs == nil {
+		return 0, errors.New("Invalid null bitstream parameter")
+	}
+
+	if len(freqs) != 256 {
+		return 0, errors.New("Invalid frequencies parameter (must have 256 values)")
+	}
+
+	if logRange < 8 || logRange > 15 {
+		return 0, fmt.Errorf("Invalid range parameter: %v (must be in [8..15])", logRange)
+	}
+
+	count := 0
+
+	for _, f := range freqs {
+		if f < 0 {
+			return 0, fmt.Errorf("Invalid negative frequency: %v", f)
+		}
+
+		count += f
+	}
+
+	eu, _ := NewEntropyUtils()
+	alphabet := make([]byte, 256)
+	alphabetSize, err := eu.NormalizeFrequencies(freqs, alphabet, count, 1<<logRange)
+
+	if err != nil {
+		return alphabetSize, err
+	}
+
+	encodeFrequencies(obs, alphabet[0:alphabetSize], freqs, logRange)
+	return alphabetSize, nil
+}
+
+// Read a histogram written by WriteHistogram into freqs (256 values). Return
+// the size of the alphabet and the log range (the frequencies add up to
+// 2^logRange).
+func ReadHistogram(ibs kanzi.InputBitStream, freqs []int) (int, uint, error) {
+	if ibs == nil {
+		return 0, 0, errors.New("Invalid null bitstream parameter")
+	}
+
+	if len(freqs) != 256 {
+		return 0, 0, errors.New("Invalid frequencies parameter (must have 256 values)")
+	}
+
+	return decodeFrequencies(ibs, make([]byte, 256), freqs)
+}
+
+// Write the alphabet, the log range (3 bits) and the normalized frequencies
+// but the first one (inferred), by groups of 8 or 16 sharing a bit size.
+func encodeFrequencies(obs kanzi.OutputBitStream, alphabet []byte, frequencies []int, lr uint) {
+	alphabetSize := len(alphabet)
+	EncodeAlphabet(obs, alphabet)
+
+	if alphabetSize == 0 {
+		return
+	}
+
+	obs.WriteBits(uint64(lr-8), 3) // logRange
+	inc := 16
+
+	if alphabetSize <= 64 {
+		inc = 8
+	}
+
+	llr := uint(3)
+
+	for 1<<llr <= lr {
+		llr++
+	}
+
+	/// Encode all frequencies (but the first one) by chunks of size 'inc'
+	for i := 1; i < alphabetSize; i += inc {
+		max := 0
+		logMax := uint(1)
+		endj := i + inc
+
+		if endj > alphabetSize {
+			endj = alphabetSize
+		}
+
+		// Search for max frequency log size in next chunk
+		for j := i; j < endj; j++ {
+			if frequencies[alphabet[j]] > max {
+				max = frequencies[alphabet[j]]
+			}
+		}
+
+		for 1<<logMax <= max {
+			logMax++
+		}
+
+		obs.WriteBits(uint64(logMax-1), llr)
+
+		// Write frequencies
+		for j := i; j < endj; j++ {
+			obs.WriteBits(uint64(frequencies[alphabet[j]]), logMax)
+		}
+	}
+}
+
+// Read the data written by encodeFrequencies. Return the size of the alphabet
+// and the log range (0 if the alphabet is empty).
+func decodeFrequencies(ibs kanzi.InputBitStream, alphabet []byte, frequencies []int) (int, uint, error) {
+	alphabetSize, err := DecodeAlphabet(ibs, alphabet)
+
+	if err != nil || alphabetSize == 0 {
+		return alphabetSize, 0, err
+	}
+
+	if alphabetSize != 256 {
+		for i := range frequencies {
+			frequencies[i] = 0
+		}
+	}
+
+	// Decode frequencies
+	logRange := uint(8 + ibs.ReadBits(3))
+	scale := 1 << logRange
+	sum := 0
+	inc := 16
+	llr := uint(3)
+
+	if alphabetSize <= 64 {
+		inc = 8
+	}
+
+	for 1<<llr <= logRange {
+		llr++
+	}
+
+	// Decode all frequencies (but the first one) by chunks of size 'inc'
+	for i := 1; i < alphabetSize; i += inc {
+		logMax := uint(1 + ibs.ReadBits(llr))
+		endj := i + inc
+
+		if endj > alphabetSize {
+			endj = alphabetSize
+		}
+
+		// Read frequencies
+		for j := i; j < endj; j++ {
+			val := int(ibs.ReadBits(logMax))
+
+			if val <= 0 || val >= scale {
+				return alphabetSize, logRange, fmt.Errorf("%w: incorrect frequency %v for symbol '%v'",
+					ErrCorruptStream, val, alphabet[j])
+			}
+
+			frequencies[alphabet[j]] = val
+			sum += val
+		}
+	}
+
+	// Infer first frequency
+	frequencies[alphabet[0]] = scale - sum
+
+	if frequencies[alphabet[0]] <= 0 || frequencies[alphabet[0]] > scale {
+		return alphabetSize, logRange, fmt.Errorf("%w: incorrect frequency %v for symbol '%v'",
+			ErrCorruptStream, frequencies[alphabet[0]], alphabet[0])
+	}
+
+	return alphabetSize, logRange, nil
+}
+
 // Returns the size of the alphabet
 // The alphabet and freqs parameters are updated
+// Once the buffers are allocated (first call), no memory is allocated.
 func (this *EntropyUtils) NormalizeFrequencies(freqs []int, alphabet []byte, count int, scale int) (int, error) {
 	if count == 0 {
 		return 0, nil
@@ -398,18 +688,28 @@ func (this *EntropyUtils) NormalizeFrequencies(freqs []int, alphabet []byte, cou
 			inc = 1
 		}
 
-		queue := make(FreqSortPriorityQueue, 0)
+		if len(this.sortData) < 256 {
+			this.sortData = make([]FreqSortData, 256)
+			this.queue = make(FreqSortPriorityQueue, 0, 256)
+		}
+
+		queue := &this.queue
+		*queue = (*queue)[0:0]
 
 		// Create sorted queue of present symbols (except those with 'quantum frequency')
 		for i := 0; i < alphabetSize; i++ {
 			if errors[alphabet[i]] >= 0 {
-				heap.Push(&queue, &FreqSortData{errors: errors, frequencies: freqs, symbol: alphabet[i]})
+				fsd := &this.sortData[i]
+				fsd.errors = errors
+				fsd.frequencies = freqs
+				fsd.symbol = alphabet[i]
+				heap.Push(queue, fsd)
 			}
 		}
 
-		for sum != 0 && len(queue) > 0 {
+		for sum != 0 && len(*queue) > 0 {
 			// Remove symbol with highest error
-			fsd := heap.Pop(&queue).(*FreqSortData)
+			fsd := heap.Pop(queue).(*FreqSortData)
 
 			// Do not zero out any frequency
 			if freqs[fsd.symbol] == -inc {
@@ -420,7 +720,7 @@ func (this *EntropyUtils) NormalizeFrequencies(freqs []int, alphabet []byte, cou
 			freqs[fsd.symbol] += inc
 			errors[fsd.symbol] -= scale
 			sum += inc
-			heap.Push(&queue, fsd)
+			heap.Push(queue, fsd)
 		}
 	}
 
@@ -451,12 +451,12 @@ func (this *HuffmanDecoder) ReadLengths() (int, error) {
 		currSize = int8(egdec.DecodeByte()) + prevSize
 
 		if currSize < 0 {
-			return 0, fmt.Errorf("Invalid bitstream: incorrect size %v for Huffman symbol %v", currSize, i)
+			return 0, fmt.Errorf("%w: incorrect size %v for Huffman symbol %v", ErrCorruptStream, currSize, i)
 		}
 
 		if currSize != 0 {
 			if currSize > 24 {
-				return 0, fmt.Errorf("Invalid bitstream: incorrect size %v for Huffman symbol %v", currSize, i)
+				return 0, fmt.Errorf("%w: incorrect size %v for Huffman symbol %v", ErrCorruptStream, currSize, i)
 			}
 
 			if this.minCodeLen > currSize {
@@ -615,7 +615,7 @@ func (this *HuffmanDecoder) slowDecodeByte(code int, codeLen uint) byte {
 		}
 	}
 
-	panic(errors.New("Invalid bitstream: incorrect Huffman code"))
+	panic(fmt.Errorf("%w: incorrect Huffman code", ErrCorruptStream))
 }
 
 // 64 bits must be available in the bitstream
@@ -0,0 +1,304 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"kanzi"
+)
+
+// Adaptive range coder mixing an order 0 model and an order 1 model (same
+// tables as the order 1 range coder). Each byte is coded with the
+// interpolation of the cumulated frequencies of both models:
+// C(s) = (w*C1(s)*(2^16/T1) + (MIXED_RANGE_WEIGHT-w)*C0(s)*(2^16/T0)) / MIXED_RANGE_WEIGHT + s
+// (T0, T1: totals of the models, +s gives every symbol a frequency of at
+// least 1). The total is at most 2^16+256.
+// The weight w of the order 1 model is kept per context byte, it starts at
+// half and moves by 1 towards the model which predicted the coded byte best,
+// identically in the encoder and the decoder: nothing but the coded bytes is
+// stored in the bitstream.
+// EG. order 1 table more accurate than the order 0 one after 'q' (always 'u'
+// in text): the weight of the order 1 model grows for the context 'q'.
+// Each call to Encode/Decode codes an independent block (models reset).
+
+const (
+	MIXED_RANGE_LOG_WEIGHT = 5
+	MIXED_RANGE_WEIGHT     = 1 << MIXED_RANGE_LOG_WEIGHT
+)
+
+// Models and weights shared by the encoder and the decoder
+type mixedModels struct {
+	order0  order1Model
+	models  []*order1Model
+	used    []bool
+	weights []int
+}
+
+func newMixedModels() *mixedModels {
+	this := new(mixedModels)
+	this.models = make([]*order1Model, 256)
+	this.used = make([]bool, 256)
+	this.weights = make([]int, 256)
+	return this
+}
+
+func (this *mixedModels) reset() {
+	this.order0.reset()
+	resetOrder1Models(this.used)
+
+	for i := range this.weights {
+		this.weights[i] = MIXED_RANGE_WEIGHT / 2
+	}
+}
+
+// Return the scaled weights of the order 1 and order 0 cumulated frequencies
+// and the total of the mixed frequencies
+func mixedScales(m0, m1 *order1Model, w int) (uint64, uint64, uint64) {
+	a := uint64(w) * uint64((1<<16)/m1.total)
+	b := uint64(MIXED_RANGE_WEIGHT-w) * uint64((1<<16)/m0.total)
+	total := (a*uint64(m1.total)+b*uint64(m0.total))>>MIXED_RANGE_LOG_WEIGHT + 256
+	return a, b, total
+}
+
+// Update both models with the coded byte and move the weight of the context
+// towards the model with the highest probability for the byte
+func (this *mixedModels) update(m1 *order1Model, ctx, b byte) {
+	m0 := &this.order0
+	p1 := uint64(m1.freqs[b]) * uint64(m0.total)
+	p0 := uint64(m0.freqs[b]) * uint64(m1.total)
+	w := this.weights[ctx]
+
+	if p1 > p0 && w < MIXED_RANGE_WEIGHT {
+		this.weights[ctx] = w + 1
+	} else if p1 < p0 && w > 0 {
+		this.weights[ctx] = w - 1
+	}
+
+	m0.update(b, ORDER1_RANGE_INCREMENT)
+	m1.update(b, ORDER1_RANGE_INCREMENT)
+}
+
+type MixedRangeEncoder struct {
+	low       uint64
+	range_    uint64
+	bitstream kanzi.OutputBitStream
+	mm        *mixedModels
+}
+
+func NewMixedRangeEncoder(bs kanzi.OutputBitStream) (*MixedRangeEncoder, error) {
+	if bs == nil {
+		return nil, errors.New("Invalid null bitstream parameter")
+	}
+
+	this := new(MixedRangeEncoder)
+	this.bitstream = bs
+	this.mm = newMixedModels()
+	return this, nil
+}
+
+func (this *MixedRangeEncoder) Encode(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	mm := this.mm
+	mm.reset()
+	m0 := &mm.order0
+	low := uint64(0)
+	range_ := TOP_RANGE
+	ctx := byte(0)
+
+	for i, b := range block {
+		m1 := order1Context(mm.models, mm.used, ctx)
+		a, c, total := mixedScales(m0, m1, mm.weights[ctx])
+		cum1 := m1.cumFreq(int(b))
+		cum0 := m0.cumFreq(int(b))
+		symbolLow := (a*cum1+c*cum0)>>MIXED_RANGE_LOG_WEIGHT + uint64(b)
+		symbolHigh := (a*(cum1+uint64(m1.freqs[b]))+c*(cum0+uint64(m0.freqs[b])))>>MIXED_RANGE_LOG_WEIGHT +
+			uint64(b) + 1
+
+		var err error
+
+		// Compute next low and range
+		if range_, err = ScaleRange(range_, total); err != nil {
+			return i, err
+		}
+
+		low += symbolLow * range_
+		range_ *= symbolHigh - symbolLow
+
+		// If the left-most digits are the same throughout the range, write bits to bitstream
+		for {
+			if (low^(low+range_))&MASK != 0 {
+				if range_ > BOTTOM_RANGE {
+					break
+				}
+
+				// Normalize
+				range_ = -low & BOTTOM_RANGE
+			}
+
+			this.bitstream.WriteBits(low>>40, 16)
+			range_ <<= 16
+			low <<= 16
+		}
+
+		mm.update(m1, ctx, b)
+		ctx = b
+	}
+
+	// Flush 'low'
+	this.bitstream.WriteBits(low, 56)
+	this.low = low
+	this.range_ = range_
+	return len(block), nil
+}
+
+func (this *MixedRangeEncoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+func (this *MixedRangeEncoder) Dispose() {
+}
+
+type MixedRangeDecoder struct {
+	code      uint64
+	low       uint64
+	range_    uint64
+	bitstream kanzi.InputBitStream
+	mm        *mixedModels
+}
+
+func NewMixedRangeDecoder(bs kanzi.InputBitStream) (*MixedRangeDecoder, error) {
+	if bs == nil {
+		return nil, errors.New("Invalid null bitstream parameter")
+	}
+
+	this := new(MixedRangeDecoder)
+	this.bitstream = bs
+	this.mm = newMixedModels()
+	return this, nil
+}
+
+func (this *MixedRangeDecoder) Decode(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	mm := this.mm
+	mm.reset()
+	m0 := &mm.order0
+	low := uint64(0)
+	range_ := TOP_RANGE
+	code := this.bitstream.ReadBits(56)
+	ctx := byte(0)
+
+	for i := range block {
+		m1 := order1Context(mm.models, mm.used, ctx)
+		a, c, total := mixedScales(m0, m1, mm.weights[ctx])
+		var err error
+
+		if range_, err = ScaleRange(range_, total); err != nil {
+			return i, err
+		}
+
+		count := (code - low) / range_
+
+		if count >= total {
+			return i, fmt.Errorf("%w: symbol out of range in mixed range decoder", ErrCorruptStream)
+		}
+
+		// Find the group, then the symbol, whose interval contains 'count'
+		cum1 := uint64(0)
+		cum0 := uint64(0)
+		g := 0
+
+		for g < 15 {
+			next1 := cum1 + uint64(m1.groups[g])
+			next0 := cum0 + uint64(m0.groups[g])
+
+			if (a*next1+c*next0)>>MIXED_RANGE_LOG_WEIGHT+uint64((g+1)<<4) > count {
+				break
+			}
+
+			cum1 = next1
+			cum0 = next0
+			g++
+		}
+
+		symbol := g << 4
+		symbolLow := (a*cum1+c*cum0)>>MIXED_RANGE_LOG_WEIGHT + uint64(symbol)
+
+		for {
+			next1 := cum1 + uint64(m1.freqs[symbol])
+			next0 := cum0 + uint64(m0.freqs[symbol])
+			symbolHigh := (a*next1+c*next0)>>MIXED_RANGE_LOG_WEIGHT + uint64(symbol) + 1
+
+			if symbolHigh > count {
+				// Compute next low and range
+				low += symbolLow * range_
+				range_ *= symbolHigh - symbolLow
+				break
+			}
+
+			cum1 = next1
+			cum0 = next0
+			symbolLow = symbolHigh
+			symbol++
+		}
+
+		for {
+			if (low^(low+range_))&MASK != 0 {
+				if range_ > BOTTOM_RANGE {
+					break
+				}
+
+				// Normalize
+				range_ = -low & BOTTOM_RANGE
+			}
+
+			code = (code << 16) | this.bitstream.ReadBits(16)
+			range_ <<= 16
+			low <<= 16
+		}
+
+		b := byte(symbol)
+		block[i] = b
+		mm.update(m1, ctx, b)
+		ctx = b
+	}
+
+	this.code = code
+	this.low = low
+	this.range_ = range_
+	return len(block), nil
+}
+
+func (this *MixedRangeDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+func (this *MixedRangeDecoder) Dispose() {
+}
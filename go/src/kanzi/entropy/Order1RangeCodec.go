@@ -0,0 +1,536 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"kanzi"
+)
+
+// Adaptive order 1 range coder: each byte is coded with the frequencies
+// observed after the previous byte (256 frequency tables). The frequencies
+// start at 1 for every symbol, are incremented after each byte and halved
+// when the total reaches ORDER1_RANGE_MAX_TOTAL, identically in the encoder
+// and the decoder: no frequency is stored in the bitstream.
+// Memory: up to 256 tables of 257 ints, a table is allocated the first time
+// its context occurs (about 1 KB per context).
+// Each call to Encode/Decode codes an independent block (the tables are reset).
+// An optional reset interval resets the tables every N bytes of a block (EG.
+// for data with phase changes). It is not stored in the bitstream: the
+// decoder must use the same interval (0 means never).
+// A check mode (see SetCheckModels) verifies the consistency of the updated
+// table after each byte (much slower, to catch model bugs where they occur).
+// The increment (ORDER1_RANGE_INCREMENT by default, see
+// NewOrder1RangeEncoderWithIncrement) sets the weight of the initial counts
+// (additive smoothing): a small increment adapts slowly (safer for diverse
+// data), a large one converges faster on skewed data. It is not stored in the
+// bitstream either.
+
+const (
+	ORDER1_RANGE_INCREMENT     = 24
+	ORDER1_RANGE_MAX_INCREMENT = 4096
+	ORDER1_RANGE_MAX_TOTAL     = 1 << 16
+)
+
+// Frequencies of the symbols following one context byte. The sums of the
+// frequencies by group of 16 symbols are kept up to date to speed up the
+// symbol search in the decoder (at most 16+16 steps instead of 256).
+type order1Model struct {
+	freqs  [256]uint32
+	groups [16]uint32
+	total  uint32
+}
+
+func (this *order1Model) reset() {
+	for i := range this.freqs {
+		this.freqs[i] = 1
+	}
+
+	for i := range this.groups {
+		this.groups[i] = 16
+	}
+
+	this.total = 256
+}
+
+func (this *order1Model) update(symbol byte, inc uint32) {
+	this.freqs[symbol] += inc
+	this.groups[symbol>>4] += inc
+	this.total += inc
+
+	if this.total < ORDER1_RANGE_MAX_TOTAL {
+		return
+	}
+
+	// Rescale, keep every frequency at least 1
+	this.total = 0
+
+	for g := range this.groups {
+		sum := uint32(0)
+
+		for i := g << 4; i < (g+1)<<4; i++ {
+			this.freqs[i] = (this.freqs[i] + 1) >> 1
+			sum += this.freqs[i]
+		}
+
+		this.groups[g] = sum
+		this.total += sum
+	}
+}
+
+// Verify the invariants of the table: every frequency at least 1, group sums
+// and total matching the frequencies, total below the maximum
+func (this *order1Model) check() error {
+	total := uint32(0)
+
+	for g := range this.groups {
+		sum := uint32(0)
+
+		for i, f := range this.freqs[g<<4 : (g+1)<<4] {
+			if f == 0 {
+				return fmt.Errorf("null frequency for symbol %v", g<<4+i)
+			}
+
+			sum += f
+		}
+
+		if sum != this.groups[g] {
+			return fmt.Errorf("group %v sum is %v (expected %v)", g, this.groups[g], sum)
+		}
+
+		total += sum
+	}
+
+	if total != this.total {
+		return fmt.Errorf("total is %v (expected %v)", this.total, total)
+	}
+
+	if total >= ORDER1_RANGE_MAX_TOTAL {
+		return fmt.Errorf("total %v not rescaled", total)
+	}
+
+	return nil
+}
+
+// Return the cumulated frequency of the symbols before 'symbol' (at most
+// 15 group sums and 15 frequencies instead of up to 255 frequencies)
+func (this *order1Model) cumFreq(symbol int) uint64 {
+	res := uint64(0)
+
+	for _, f := range this.groups[0 : symbol>>4] {
+		res += uint64(f)
+	}
+
+	for _, f := range this.freqs[symbol&-16 : symbol] {
+		res += uint64(f)
+	}
+
+	return res
+}
+
+// Return the symbol whose interval contains 'count' (must be less than the
+// total) and the cumulated frequency of the symbols before it. Same result
+// as a linear search over the frequencies.
+func (this *order1Model) findSymbol(count uint64) (int, uint64) {
+	cumFreq := uint64(0)
+	g := 0
+
+	for cumFreq+uint64(this.groups[g]) <= count {
+		cumFreq += uint64(this.groups[g])
+		g++
+	}
+
+	symbol := g << 4
+
+	for cumFreq+uint64(this.freqs[symbol]) <= count {
+		cumFreq += uint64(this.freqs[symbol])
+		symbol++
+	}
+
+	return symbol, cumFreq
+}
+
+// Return the model of the context (allocated and initialized on first use)
+func order1Context(models []*order1Model, used []bool, ctx byte) *order1Model {
+	m := models[ctx]
+
+	if m == nil {
+		m = new(order1Model)
+		models[ctx] = m
+	}
+
+	if used[ctx] == false {
+		m.reset()
+		used[ctx] = true
+	}
+
+	return m
+}
+
+// Mark all the models as unused (reset on next use)
+func resetOrder1Models(used []bool) {
+	for i := range used {
+		used[i] = false
+	}
+}
+
+func order1ResetInterval(args []uint) (int, error) {
+	if len(args) > 1 {
+		return 0, errors.New("At most one reset interval can be provided")
+	}
+
+	if len(args) == 0 {
+		return 0, nil
+	}
+
+	if args[0] > 1<<30 {
+		return 0, errors.New("The reset interval must be at most 2^30")
+	}
+
+	return int(args[0]), nil
+}
+
+// Below the maximum, the total is below ORDER1_RANGE_MAX_TOTAL after one rescale
+func order1Increment(increment uint) (uint32, error) {
+	if increment < 1 || increment > ORDER1_RANGE_MAX_INCREMENT {
+		return 0, fmt.Errorf("Invalid increment: %v (must be in [1..%v])", increment, ORDER1_RANGE_MAX_INCREMENT)
+	}
+
+	return uint32(increment), nil
+}
+
+type Order1RangeEncoder struct {
+	low           uint64
+	range_        uint64
+	bitstream     kanzi.OutputBitStream
+	models        []*order1Model
+	used          []bool
+	resetInterval int
+	checkModels   bool
+	increment     uint32
+	fault         func(pos int, freqs []uint32)
+}
+
+// Since the number of args is variable, this function can be called like this:
+// NewOrder1RangeEncoder(bs) or NewOrder1RangeEncoder(bs, 16384) (reset interval)
+func NewOrder1RangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*Order1RangeEncoder, error) {
+	if bs == nil {
+		return nil, errors.New("Invalid null bitstream parameter")
+	}
+
+	resetInterval, err := order1ResetInterval(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(Order1RangeEncoder)
+	this.bitstream = bs
+	this.models = make([]*order1Model, 256)
+	this.used = make([]bool, 256)
+	this.resetInterval = resetInterval
+	this.increment = ORDER1_RANGE_INCREMENT
+	return this, nil
+}
+
+// Increment the frequencies by the provided value (in [1..4096]) instead of
+// ORDER1_RANGE_INCREMENT. The decoder must use the same increment.
+// EG. NewOrder1RangeEncoderWithIncrement(bs, 64) or
+// NewOrder1RangeEncoderWithIncrement(bs, 64, 16384) (reset interval)
+func NewOrder1RangeEncoderWithIncrement(bs kanzi.OutputBitStream, increment uint, args ...uint) (*Order1RangeEncoder, error) {
+	inc, err := order1Increment(increment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this, err := NewOrder1RangeEncoder(bs, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.increment = inc
+	return this, nil
+}
+
+func (this *Order1RangeEncoder) Increment() uint {
+	return uint(this.increment)
+}
+
+func (this *Order1RangeEncoder) ResetInterval() uint {
+	return uint(this.resetInterval)
+}
+
+func (this *Order1RangeEncoder) CheckModels() bool {
+	return this.checkModels
+}
+
+// Verify the frequency tables after each update (debugging)
+func (this *Order1RangeEncoder) SetCheckModels(check bool) {
+	this.checkModels = check
+}
+
+// Test hook: the function is applied to the frequencies of the updated table
+// after each byte, before the check (EG. to simulate a model bug). Check
+// mode only.
+func (this *Order1RangeEncoder) SetModelFault(fault func(pos int, freqs []uint32)) {
+	this.fault = fault
+}
+
+func (this *Order1RangeEncoder) Encode(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	resetOrder1Models(this.used)
+	low := uint64(0)
+	range_ := TOP_RANGE
+	ctx := byte(0)
+	nextReset := this.resetInterval
+
+	for i, b := range block {
+		if i == nextReset && i > 0 {
+			resetOrder1Models(this.used)
+			nextReset += this.resetInterval
+		}
+
+		m := order1Context(this.models, this.used, ctx)
+		cumFreq := m.cumFreq(int(b))
+
+		var err error
+
+		// Compute next low and range
+		if range_, err = ScaleRange(range_, uint64(m.total)); err != nil {
+			return i, err
+		}
+
+		low += cumFreq * range_
+		range_ *= uint64(m.freqs[b])
+
+		// If the left-most digits are the same throughout the range, write bits to bitstream
+		for {
+			if (low^(low+range_))&MASK != 0 {
+				if range_ > BOTTOM_RANGE {
+					break
+				}
+
+				// Normalize
+				range_ = -low & BOTTOM_RANGE
+			}
+
+			this.bitstream.WriteBits(low>>40, 16)
+			range_ <<= 16
+			low <<= 16
+		}
+
+		m.update(b, this.increment)
+
+		if this.checkModels == true {
+			if this.fault != nil {
+				this.fault(i, m.freqs[:])
+			}
+
+			if err := m.check(); err != nil {
+				return i, fmt.Errorf("Invalid order 1 model after byte %v (context %v): %v", i, ctx, err)
+			}
+		}
+
+		ctx = b
+	}
+
+	// Flush 'low'
+	this.bitstream.WriteBits(low, 56)
+	this.low = low
+	this.range_ = range_
+	return len(block), nil
+}
+
+func (this *Order1RangeEncoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+func (this *Order1RangeEncoder) Dispose() {
+}
+
+type Order1RangeDecoder struct {
+	code          uint64
+	low           uint64
+	range_        uint64
+	bitstream     kanzi.InputBitStream
+	models        []*order1Model
+	used          []bool
+	resetInterval int
+	checkModels   bool
+	increment     uint32
+	fault         func(pos int, freqs []uint32)
+}
+
+// The reset interval (optional) must be the same as the encoder's
+func NewOrder1RangeDecoder(bs kanzi.InputBitStream, args ...uint) (*Order1RangeDecoder, error) {
+	if bs == nil {
+		return nil, errors.New("Invalid null bitstream parameter")
+	}
+
+	resetInterval, err := order1ResetInterval(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(Order1RangeDecoder)
+	this.bitstream = bs
+	this.models = make([]*order1Model, 256)
+	this.used = make([]bool, 256)
+	this.resetInterval = resetInterval
+	this.increment = ORDER1_RANGE_INCREMENT
+	return this, nil
+}
+
+// The increment and the reset interval (optional) must be the same as the
+// encoder's
+func NewOrder1RangeDecoderWithIncrement(bs kanzi.InputBitStream, increment uint, args ...uint) (*Order1RangeDecoder, error) {
+	inc, err := order1Increment(increment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this, err := NewOrder1RangeDecoder(bs, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.increment = inc
+	return this, nil
+}
+
+func (this *Order1RangeDecoder) Increment() uint {
+	return uint(this.increment)
+}
+
+func (this *Order1RangeDecoder) ResetInterval() uint {
+	return uint(this.resetInterval)
+}
+
+func (this *Order1RangeDecoder) CheckModels() bool {
+	return this.checkModels
+}
+
+// Verify the frequency tables after each update (debugging)
+func (this *Order1RangeDecoder) SetCheckModels(check bool) {
+	this.checkModels = check
+}
+
+// Test hook: the function is applied to the frequencies of the updated table
+// after each byte, before the check (EG. to simulate a model bug). Check
+// mode only.
+func (this *Order1RangeDecoder) SetModelFault(fault func(pos int, freqs []uint32)) {
+	this.fault = fault
+}
+
+func (this *Order1RangeDecoder) Decode(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	resetOrder1Models(this.used)
+	low := uint64(0)
+	range_ := TOP_RANGE
+	code := this.bitstream.ReadBits(56)
+	ctx := byte(0)
+	nextReset := this.resetInterval
+
+	for i := range block {
+		if i == nextReset && i > 0 {
+			resetOrder1Models(this.used)
+			nextReset += this.resetInterval
+		}
+
+		m := order1Context(this.models, this.used, ctx)
+		var err error
+
+		if range_, err = ScaleRange(range_, uint64(m.total)); err != nil {
+			return i, err
+		}
+
+		count := (code - low) / range_
+
+		if count >= uint64(m.total) {
+			return i, fmt.Errorf("%w: symbol out of range in order 1 range decoder", ErrCorruptStream)
+		}
+
+		// Find the symbol interval containing 'count'
+		symbol, cumFreq := m.findSymbol(count)
+
+		// Compute next low and range
+		low += cumFreq * range_
+		range_ *= uint64(m.freqs[symbol])
+
+		for {
+			if (low^(low+range_))&MASK != 0 {
+				if range_ > BOTTOM_RANGE {
+					break
+				}
+
+				// Normalize
+				range_ = -low & BOTTOM_RANGE
+			}
+
+			code = (code << 16) | this.bitstream.ReadBits(16)
+			range_ <<= 16
+			low <<= 16
+		}
+
+		b := byte(symbol)
+		block[i] = b
+		m.update(b, this.increment)
+
+		if this.checkModels == true {
+			if this.fault != nil {
+				this.fault(i, m.freqs[:])
+			}
+
+			if err := m.check(); err != nil {
+				return i, fmt.Errorf("Invalid order 1 model after byte %v (context %v): %v", i, ctx, err)
+			}
+		}
+
+		ctx = b
+	}
+
+	this.code = code
+	this.low = low
+	this.range_ = range_
+	return len(block), nil
+}
+
+func (this *Order1RangeDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+func (this *Order1RangeDecoder) Dispose() {
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+// Small PAQ style predictor (to be used with the binary entropy codec).
+// Three bit level context models predict the next bit:
+// - order 0: partial byte (c0)
+// - order 1: previous byte and c0
+// - order 2: hash of the 2 previous bytes and c0
+// The predictions are combined in the logistic domain by a gated linear
+// mixer (one weight set per c0) trained online to minimize coding cost.
+// The mixer output is refined by 2 APMs (contexts c0 and c0 + previous byte)
+// and the final probability is an average of the mixer and APM outputs.
+// Slower than the other predictors, better compression on text.
+//
+//  c0 ------------> order 0 -\
+//  c1,c0 ---------> order 1 ---> mixer --+--> APM(c0) ----+--> p
+//  hash(c2,c1),c0 -> order 2 -/          +--> APM(c1,c0) -+
+//                                        +----------------+
+
+const (
+	PAQLITE_INPUTS       = 4  // 3 models + bias
+	PAQLITE_ORDER2_BITS  = 22 // log size of the order 2 hash table
+	PAQLITE_MODEL_RATE   = 4  // adaptation rate of the context models
+	PAQLITE_MIXER_RATE   = 6  // learning rate of the mixer (higher is faster)
+	PAQLITE_INIT_WEIGHT  = 22000
+	PAQLITE_ORDER2_MASK  = (1 << PAQLITE_ORDER2_BITS) - 1
+	PAQLITE_APM2_CONTEXT = 1 << 14
+)
+
+type PAQLitePredictor struct {
+	pr      uint     // next predicted value (1-4095)
+	c0      uint     // bitwise context: last 0-7 bits with a leading 1 (1-255)
+	c1      uint     // previous byte
+	c2      uint     // byte before the previous one
+	bpos    uint     // number of bits in c0 (0-7)
+	hash    uint     // hash of c1 and c2
+	order0  []uint16 // c0 -> p (16 bits)
+	order1  []uint16 // c1, c0 -> p
+	order2  []uint16 // hash(c1, c2), c0 -> p
+	idx1    uint     // current index in order1
+	idx2    uint     // current index in order2
+	weights []int    // [256][PAQLITE_INPUTS], scaled by 16 bits
+	inputs  []int    // stretched predictions of the models (and bias)
+	pMix    int      // output of the mixer
+	apm1    *AdaptiveProbMap
+	apm2    *AdaptiveProbMap
+}
+
+func NewPAQLitePredictor() (*PAQLitePredictor, error) {
+	var err error
+	this := new(PAQLitePredictor)
+	this.pr = 2048
+	this.c0 = 1
+	this.bpos = 7
+	this.order0 = make([]uint16, 256)
+	this.order1 = make([]uint16, 256*256)
+	this.order2 = make([]uint16, PAQLITE_ORDER2_MASK+1)
+	this.weights = make([]int, 256*PAQLITE_INPUTS)
+	this.inputs = make([]int, PAQLITE_INPUTS)
+	this.pMix = 2048
+
+	for i := range this.order0 {
+		this.order0[i] = 32768
+	}
+
+	for i := range this.order1 {
+		this.order1[i] = 32768
+	}
+
+	for i := range this.order2 {
+		this.order2[i] = 32768
+	}
+
+	for i := range this.weights {
+		this.weights[i] = PAQLITE_INIT_WEIGHT
+	}
+
+	this.apm1, err = newAdaptiveProbMap(256)
+
+	if err == nil {
+		this.apm2, err = newAdaptiveProbMap(PAQLITE_APM2_CONTEXT)
+	}
+
+	return this, err
+}
+
+func updateCounter(counter *uint16, bit int) {
+	if bit == 0 {
+		*counter -= *counter >> PAQLITE_MODEL_RATE
+	} else {
+		*counter += (0xFFFF - *counter) >> PAQLITE_MODEL_RATE
+	}
+}
+
+// Update the probability model
+func (this *PAQLitePredictor) Update(bit byte) {
+	y := int(bit)
+
+	// Update the models and train the mixer
+	updateCounter(&this.order0[this.c0], y)
+	updateCounter(&this.order1[this.idx1], y)
+	updateCounter(&this.order2[this.idx2], y)
+	err := ((y << 12) - this.pMix) * PAQLITE_MIXER_RATE
+	w := this.weights[this.c0*PAQLITE_INPUTS : (this.c0+1)*PAQLITE_INPUTS]
+
+	for i := range w {
+		w[i] += (this.inputs[i]*err + 512) >> 10
+	}
+
+	// Update the contexts
+	this.c0 = (this.c0 << 1) | uint(y)
+	this.bpos--
+	this.bpos &= 7
+
+	if this.bpos == 7 {
+		this.c2 = this.c1
+		this.c1 = this.c0 & 0xFF
+		this.c0 = 1
+		this.hash = ((this.c2<<8 | this.c1) + 1) * 0x9E3779B1
+	}
+
+	this.idx1 = this.c1<<8 | this.c0
+	this.idx2 = ((this.hash >> 10) + this.c0) & PAQLITE_ORDER2_MASK
+
+	// Mix the stretched predictions
+	this.inputs[0] = STRETCH[this.order0[this.c0]>>4]
+	this.inputs[1] = STRETCH[this.order1[this.idx1]>>4]
+	this.inputs[2] = STRETCH[this.order2[this.idx2]>>4]
+	this.inputs[3] = 256
+	w = this.weights[this.c0*PAQLITE_INPUTS : (this.c0+1)*PAQLITE_INPUTS]
+	dot := 0
+
+	for i := range w {
+		dot += w[i] * this.inputs[i]
+	}
+
+	this.pMix = squash(dot >> 16)
+
+	// Refine with the APMs
+	p1 := this.apm1.get(y, this.pMix, this.c0, 7)
+	p2 := this.apm2.get(y, this.pMix, (this.c0|this.c1<<8)&(PAQLITE_APM2_CONTEXT-1), 7)
+	pred := (2*this.pMix + p1 + p2*5 + 4) >> 3
+
+	if pred < 1 {
+		pred = 1
+	} else if pred > 4095 {
+		pred = 4095
+	}
+
+	this.pr = uint(pred)
+}
+
+// Return the split value representing the probability of 1 in the [0..4095] range.
+func (this *PAQLitePredictor) Get() uint {
+	return this.pr
+}
@@ -18,6 +18,7 @@ package entropy
 import (
 	"errors"
 	"fmt"
+	"io"
 	"kanzi"
 )
 
@@ -29,6 +30,75 @@ const (
 	DEFAULT_RANGE_LOG_RANGE  = uint(13)
 )
 
+// Upper bound of the size of a chunk header (alphabet, log range and
+// frequencies on at most 16 bits) plus the final flush of 'low'
+const RANGE_MAX_CHUNK_OVERHEAD = 576
+
+// Return an upper bound of the size in bytes of the range coding of srcLen
+// bytes (to size output buffers). The chunk size (optional, same meaning as
+// in NewRangeEncoder) defaults to DEFAULT_RANGE_CHUNK_SIZE. The coded data
+// takes at most 8 bits per byte (plus a margin of 1/64 for the precision
+// loss of the coder), each chunk adds its header.
+func RangeEncodedBound(srcLen uint, args ...uint) uint {
+	if srcLen == 0 {
+		return 0
+	}
+
+	chkSize := DEFAULT_RANGE_CHUNK_SIZE
+
+	if len(args) > 0 {
+		chkSize = args[0]
+	}
+
+	chunks := uint(1)
+
+	if chkSize != 0 {
+		// Not (srcLen+chkSize-1)/chkSize: no overflow with a 32 bit uint
+		chunks = srcLen / chkSize
+
+		if srcLen%chkSize != 0 {
+			chunks++
+		}
+	}
+
+	// Saturate rather than wrap around (32 bit uint, huge srcLen)
+	maxLen := ^uint(0)
+
+	if srcLen > maxLen-srcLen>>6 || chunks > (maxLen-srcLen-srcLen>>6)/RANGE_MAX_CHUNK_OVERHEAD {
+		return maxLen
+	}
+
+	return srcLen + srcLen>>6 + chunks*RANGE_MAX_CHUNK_OVERHEAD
+}
+
+// Return the end of the chunk starting at start: min(start+size, end) without
+// overflowing a 32 bit int (EG. chunks of 2^30 bytes in a block of 2^31-1 bytes)
+func chunkEnd(start, size, end int) int {
+	if size >= end-start {
+		return end
+	}
+
+	return start + size
+}
+
+// Divide the range by the total of the frequencies before coding a symbol
+// (adaptive coders). The normalization keeps the range above BOTTOM_RANGE
+// between two symbols, far above any total (at most 2^17): the quotient is at
+// least 2^15. A range below the total would give a null quotient and corrupt
+// the output silently: an error is returned instead (broken invariant).
+func ScaleRange(range_, total uint64) (uint64, error) {
+	if range_ <= BOTTOM_RANGE || total == 0 || total > 1<<17 {
+		return 0, rangeScaleError(range_, total)
+	}
+
+	return range_ / total, nil
+}
+
+func rangeScaleError(range_, total uint64) error {
+	return fmt.Errorf("Invalid range coder state: range %#x, total %v (expected range > %#x, total in [1..2^17])",
+		range_, total, BOTTOM_RANGE)
+}
+
 type RangeEncoder struct {
 	low       uint64
 	range_    uint64
@@ -40,6 +110,8 @@ type RangeEncoder struct {
 	eu        *EntropyUtils
 	chunkSize int
 	logRange  uint
+	model     *RangeModel
+	buffer    []byte // chunk read by EncodeFrom
 }
 
 // The chunk size indicates how many bytes are encoded (per block) before
@@ -73,8 +145,9 @@ func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, err
 		return nil, errors.New("The chunk size must be at most 2^30")
 	}
 
-	if logRange < 8 || logRange > 16 {
-		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..16])", logRange)
+	// The log range is stored on 3 bits (minus 8) in the chunk header
+	if logRange < 8 || logRange > 15 {
+		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..15])", logRange)
 	}
 
 	this := new(RangeEncoder)
@@ -82,6 +155,7 @@ func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, err
 	this.alphabet = make([]byte, 256)
 	this.freqs = make([]int, 256)
 	this.cumFreqs = make([]int, 257)
+	this.buffer = make([]byte, 0)
 	this.logRange = logRange
 	this.chunkSize = int(chkSize)
 	var err error
@@ -89,6 +163,39 @@ func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, err
 	return this, err
 }
 
+// Code every block with the frequencies of the model (no chunk header). The
+// decoder must be created with the same model (see NewRangeDecoderWithModel).
+func NewRangeEncoderWithModel(bs kanzi.OutputBitStream, model *RangeModel) (*RangeEncoder, error) {
+	if model == nil {
+		return nil, errors.New("Invalid null model parameter")
+	}
+
+	this, err := NewRangeEncoder(bs, 0, model.logRange)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.model = model
+	return this, nil
+}
+
+func (this *RangeEncoder) Model() *RangeModel {
+	return this.model
+}
+
+// Use the frequencies of the model (nothing written to the bitstream)
+func (this *RangeEncoder) setModelFrequencies() {
+	copy(this.freqs, this.model.freqs)
+	this.cumFreqs[0] = 0
+
+	for i := 0; i < 256; i++ {
+		this.cumFreqs[i+1] = this.cumFreqs[i] + this.freqs[i]
+	}
+
+	this.invSum = uint64(1<<24) / uint64(this.cumFreqs[256])
+}
+
 func (this *RangeEncoder) updateFrequencies(frequencies []int, size int, lr uint) (int, error) {
 	if frequencies == nil || len(frequencies) != 256 {
 		return 0, errors.New("Invalid frequencies parameter")
@@ -109,65 +216,31 @@ func (this *RangeEncoder) updateFrequencies(frequencies []int, size int, lr uint
 		}
 
 		this.invSum = uint64(1 << 24) / uint64(this.cumFreqs[256])
-		this.encodeHeader(alphabetSize, this.alphabet, frequencies, lr)
+		encodeFrequencies(this.bitstream, this.alphabet[0:alphabetSize], frequencies, lr)
 	}
 
 	return alphabetSize, nil
 }
 
-func (this *RangeEncoder) encodeHeader(alphabetSize int, alphabet []byte, frequencies []int, lr uint) bool {
-	EncodeAlphabet(this.bitstream, alphabet[0:alphabetSize])
-
-	if alphabetSize == 0 {
-		return true
-	}
-
-	this.bitstream.WriteBits(uint64(lr-8), 3) // logRange
-	inc := 16
-
-	if alphabetSize <= 64 {
-		inc = 8
-	}
-
-	llr := uint(3)
-
-	for 1<<llr <= lr {
-		llr++
-	}
-
-	/// Encode all frequencies (but the first one) by chunks of size 'inc'
-	for i := 1; i < alphabetSize; i += inc {
-		max := 0
-		logMax := uint(1)
-		endj := i + inc
-
-		if endj > alphabetSize {
-			endj = alphabetSize
-		}
-
-		// Search for max frequency log size in next chunk
-		for j := i; j < endj; j++ {
-			if frequencies[alphabet[j]] > max {
-				max = frequencies[alphabet[j]]
-			}
-		}
-
-		for 1<<logMax <= max {
-			logMax++
-		}
-
-		this.bitstream.WriteBits(uint64(logMax-1), llr)
+// The bitstream panics on write errors (EG. disk full): the error is returned
+// (with 0 bytes written) instead.
+func (this *RangeEncoder) Encode(block []byte) (written int, err error) {
+	return this.encode(block, nil)
+}
 
-		// Write frequencies
-		for j := i; j < endj; j++ {
-			this.bitstream.WriteBits(uint64(frequencies[alphabet[j]]), logMax)
-		}
+// Same output as Encode. Also store in counts[i] the number of bytes output by
+// the normalization after coding block[i] (instantaneous cost of each byte,
+// EG. for rate control). The chunk headers and the final flush of each chunk
+// (7 bytes) are not counted.
+func (this *RangeEncoder) EncodeCounted(block []byte, counts []int) (int, error) {
+	if len(counts) < len(block) {
+		return 0, fmt.Errorf("Invalid counts parameter: %v values (must be at least %v)", len(counts), len(block))
 	}
 
-	return true
+	return this.encode(block, counts)
 }
 
-func (this *RangeEncoder) Encode(block []byte) (int, error) {
+func (this *RangeEncoder) encode(block []byte, counts []int) (written int, err error) {
 	if block == nil {
 		return 0, errors.New("Invalid null block parameter")
 	}
@@ -176,6 +249,13 @@ func (this *RangeEncoder) Encode(block []byte) (int, error) {
 		return 0, nil
 	}
 
+	defer func() {
+		if r := recover(); r != nil {
+			written = 0
+			err = recoverError(r)
+		}
+	}()
+
 	sizeChunk := this.chunkSize
 
 	if sizeChunk == 0 {
@@ -191,32 +271,35 @@ func (this *RangeEncoder) Encode(block []byte) (int, error) {
 		this.low = 0
 		lr := this.logRange
 
-		endChunk := startChunk + sizeChunk
+		endChunk := chunkEnd(startChunk, sizeChunk, end)
 
-		if endChunk > end {
-			endChunk = end
-		}
-
-		// Lower log range if the size of the data block is small
-		for lr > 8 && 1<<lr > endChunk-startChunk {
-			lr--
-		}
+		if this.model != nil {
+			// Frequencies of the model, no header
+			this.setModelFrequencies()
+		} else {
+			// Lower log range if the size of the data block is small
+			for lr > 8 && 1<<lr > endChunk-startChunk {
+				lr--
+			}
 
-		for i := range frequencies {
-			frequencies[i] = 0
-		}
+			for i := range frequencies {
+				frequencies[i] = 0
+			}
 
-		for i := startChunk; i < endChunk; i++ {
-			frequencies[block[i]]++
-		}
+			for i := startChunk; i < endChunk; i++ {
+				frequencies[block[i]]++
+			}
 
-		// Rebuild statistics
-		if _, err := this.updateFrequencies(frequencies, endChunk-startChunk, lr); err != nil {
-			return startChunk, err
+			// Rebuild statistics
+			if _, err := this.updateFrequencies(frequencies, endChunk-startChunk, lr); err != nil {
+				return startChunk, err
+			}
 		}
 
-		for i := startChunk; i < endChunk; i++ {
-			this.encodeByte(block[i])
+		if counts != nil {
+			this.encodeChunk(block[startChunk:endChunk], counts[startChunk:endChunk])
+		} else {
+			this.encodeChunk(block[startChunk:endChunk], nil)
 		}
 
 		// Flush 'low'
@@ -227,31 +310,284 @@ func (this *RangeEncoder) Encode(block []byte) (int, error) {
 	return len(block), nil
 }
 
-func (this *RangeEncoder) encodeByte(b byte) {
-	value := int(b)
-	symbolLow := uint64(this.cumFreqs[value])
-	symbolHigh := uint64(this.cumFreqs[value+1])
+// The coded data of a call to Encode is complete (the state of the coder is
+// flushed at the end of each chunk) but the bitstream buffers it. Write it to
+// the underlying stream now (EG. low latency streaming) without ending it.
+// The flush is marked in the stream by an empty chunk header (an empty alphabet
+// in delta mode, never written otherwise) followed by the padding to a byte:
+// the decoder skips both when it starts the next block.
+// Each flush costs up to 18 bits (header and padding), and encoding small
+// blocks to flush them often costs a chunk header and the final 56 bits of
+// each block.
+// The bitstream must support flushing (EG. DefaultOutputBitStream) and the
+// encoder must write chunk headers (no model).
+func (this *RangeEncoder) Flush() (err error) {
+	if this.model != nil {
+		return errors.New("Cannot mark a flush in the stream: no chunk headers with a model")
+	}
 
-	// Compute next low and range
-	this.range_ = (this.range_ >> 24) * this.invSum
-	this.low += (symbolLow * this.range_)
-	this.range_ *= (symbolHigh - symbolLow)
+	bs, ok := this.bitstream.(interface {
+		Flush() error
+	})
+
+	if ok == false {
+		return errors.New("The bitstream does not support flushing")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+		}
+	}()
+
+	this.bitstream.WriteBit(PARTIAL_ALPHABET)
+	this.bitstream.WriteBit(DELTA_ENCODED_ALPHABET)
+	this.bitstream.WriteBits(0, 9) // no symbol, delta size
+	return bs.Flush()
+}
+
+// Return the size in bytes of the range coding of the block by this encoder
+// (same chunk size, log range and model) without producing any output: the
+// chunks are coded into a bitstream that only counts bits. The result is the
+// exact size of the output of Encode into an empty bitstream, once closed
+// (padded to a byte), EG. to choose a codec before encoding. The state of the
+// encoder is not modified.
+func (this *RangeEncoder) EstimateSize(block []byte) uint {
+	if len(block) == 0 {
+		return 0
+	}
+
+	counter := &bitCounter{}
+	est, err := NewRangeEncoder(counter, uint(this.chunkSize), this.logRange)
+
+	if err != nil {
+		return 0
+	}
+
+	est.model = this.model
+
+	if _, err := est.Encode(block); err != nil {
+		return 0
+	}
+
+	return uint((counter.written + 7) >> 3)
+}
+
+// Output bitstream counting the bits written (nothing is stored)
+type bitCounter struct {
+	written uint64
+}
+
+func (this *bitCounter) WriteBit(bit int) {
+	this.written++
+}
+
+func (this *bitCounter) WriteBits(bits uint64, length uint) uint {
+	this.written += uint64(length)
+	return length
+}
+
+func (this *bitCounter) Close() (bool, error) {
+	return true, nil
+}
+
+func (this *bitCounter) Written() uint64 {
+	return this.written
+}
+
+// Encode the data read from the reader until io.EOF, one chunk at a time: the
+// chunks are coded independently, hence the output is the same as Encode with
+// all the data, without loading it in memory (the decoder needs the total
+// size, as with Encode). Short reads are accumulated up to the chunk size.
+// Return the number of bytes encoded (complete chunks only on a read error).
+// A chunk size is required (not 0). The chunk buffer is reused by the next
+// calls.
+func (this *RangeEncoder) EncodeFrom(r io.Reader) (int64, error) {
+	if r == nil {
+		return 0, errors.New("Invalid null reader parameter")
+	}
+
+	if this.chunkSize == 0 {
+		return 0, errors.New("Encoding from a reader requires a chunk size")
+	}
+
+	if len(this.buffer) < this.chunkSize {
+		this.buffer = make([]byte, this.chunkSize)
+	}
+
+	buffer := this.buffer[0:this.chunkSize]
+	total := int64(0)
 
-	// If the left-most digits are the same throughout the range, write bits to bitstream
 	for {
-		if (this.low^(this.low+this.range_))&MASK != 0 {
-			if this.range_ > BOTTOM_RANGE {
-				break
+		n, err := io.ReadFull(r, buffer)
+
+		// A partial chunk is encoded at the end of the data only
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return total, err
+		}
+
+		if n > 0 {
+			if _, err := this.Encode(buffer[0:n]); err != nil {
+				return total, err
 			}
 
-			// Normalize
-			this.range_ = -this.low & BOTTOM_RANGE
+			total += int64(n)
 		}
 
-		this.bitstream.WriteBits(this.low>>40, 16)
-		this.range_ <<= 16
-		this.low <<= 16
+		if err != nil {
+			return total, nil
+		}
+	}
+}
+
+// Encode the bytes of a chunk. The frequencies are static in a chunk, so the
+// symbol interval is looked up only when the symbol changes (fast path for
+// runs of the same byte).
+// The 16 bit words produced by the normalization are accumulated and written
+// to the bitstream 64 bits at a time (same bits, fewer calls).
+// If counts is not nil, the number of bytes output for each byte is stored.
+func (this *RangeEncoder) encodeChunk(chunk []byte, counts []int) {
+	low := this.low
+	range_ := this.range_
+	invSum := this.invSum
+	prev := -1
+	symbolLow := uint64(0)
+	symbolRange := uint64(0)
+	pending := uint64(0)
+	pendingBits := uint(0)
+
+	for n, b := range chunk {
+		words := 0
+
+		if int(b) != prev {
+			prev = int(b)
+			symbolLow = uint64(this.cumFreqs[b])
+			symbolRange = uint64(this.cumFreqs[prev+1]) - symbolLow
+		}
+
+		// Compute next low and range
+		range_ = (range_ >> 24) * invSum
+		low += (symbolLow * range_)
+		range_ *= symbolRange
+
+		// If the left-most digits are the same throughout the range, write bits to bitstream
+		for {
+			if (low^(low+range_))&MASK != 0 {
+				if range_ > BOTTOM_RANGE {
+					break
+				}
+
+				// Normalize
+				range_ = -low & BOTTOM_RANGE
+			}
+
+			pending = (pending << 16) | ((low >> 40) & 0xFFFF)
+			pendingBits += 16
+			words++
+
+			if pendingBits == 64 {
+				this.bitstream.WriteBits(pending, 64)
+				pending = 0
+				pendingBits = 0
+			}
+
+			range_ <<= 16
+			low <<= 16
+		}
+
+		if counts != nil {
+			counts[n] = words << 1
+		}
 	}
+
+	if pendingBits > 0 {
+		this.bitstream.WriteBits(pending, pendingBits)
+	}
+
+	this.low = low
+	this.range_ = range_
+}
+
+// Restore the initial state to encode an independent block (the tables are
+// reused). The bitstream is not reset.
+func (this *RangeEncoder) Reset() {
+	this.low = 0
+	this.range_ = TOP_RANGE
+	this.invSum = 0
+
+	for i := range this.freqs {
+		this.freqs[i] = 0
+		this.alphabet[i] = 0
+	}
+
+	for i := range this.cumFreqs {
+		this.cumFreqs[i] = 0
+	}
+}
+
+// Adaptive state of a range encoder (see Snapshot)
+type RangeEncoderState struct {
+	low      uint64
+	range_   uint64
+	invSum   uint64
+	freqs    []int
+	cumFreqs []int
+	alphabet []byte
+}
+
+// Return a deep copy of the state of the coder (EG. to try an encoding,
+// measure its size and roll back). The bitstream is not part of the state:
+// the bits written after the snapshot are not removed by Restore.
+func (this *RangeEncoder) Snapshot() *RangeEncoderState {
+	state := &RangeEncoderState{
+		low:      this.low,
+		range_:   this.range_,
+		invSum:   this.invSum,
+		freqs:    make([]int, len(this.freqs)),
+		cumFreqs: make([]int, len(this.cumFreqs)),
+		alphabet: make([]byte, len(this.alphabet)),
+	}
+
+	copy(state.freqs, this.freqs)
+	copy(state.cumFreqs, this.cumFreqs)
+	copy(state.alphabet, this.alphabet)
+	return state
+}
+
+// Restore the state returned by Snapshot (copied: the snapshot can be
+// restored several times)
+func (this *RangeEncoder) Restore(state *RangeEncoderState) error {
+	if state == nil {
+		return errors.New("Invalid null state parameter")
+	}
+
+	this.low = state.low
+	this.range_ = state.range_
+	this.invSum = state.invSum
+	copy(this.freqs, state.freqs)
+	copy(this.cumFreqs, state.cumFreqs)
+	copy(this.alphabet, state.alphabet)
+	return nil
+}
+
+// Return a copy of the frequencies (indexed by symbol) used to code the last
+// chunk, normalized so that they add up to 2^logRange (the log range may be
+// lowered for small chunks). All zero before the first call to Encode.
+func (this *RangeEncoder) Frequencies() []int {
+	res := make([]int, len(this.freqs))
+	copy(res, this.freqs)
+	return res
+}
+
+// Return the number of bits written to the bitstream so far (EG. to report
+// the progress between calls to Encode)
+func (this *RangeEncoder) Written() uint64 {
+	return this.bitstream.Written()
+}
+
+// No end of data symbol: the decoder requires the size of the block
+func (this *RangeEncoder) SelfTerminating() bool {
+	return false
 }
 
 func (this *RangeEncoder) BitStream() kanzi.OutputBitStream {
@@ -262,16 +598,21 @@ func (this *RangeEncoder) Dispose() {
 }
 
 type RangeDecoder struct {
-	code      uint64
-	low       uint64
-	range_    uint64
-	invSum    uint64
-	bitstream kanzi.InputBitStream
-	freqs     []int
-	cumFreqs  []int
-	f2s       []byte // mapping frequency -> symbol
-	alphabet  []byte
-	chunkSize int
+	code       uint64
+	low        uint64
+	range_     uint64
+	invSum     uint64
+	bitstream  kanzi.InputBitStream
+	freqs      []int
+	cumFreqs   []int
+	f2s        []byte // mapping frequency -> symbol
+	alphabet   []byte
+	chunkSize  int
+	model      *RangeModel
+	blockSize  int    // size of the block decoded by DecodeN
+	pending    int    // bytes of this block not decoded yet
+	chunkLeft  int    // bytes of the current chunk not decoded yet
+	inputLimit uint64 // end of the encoded data in the bitstream (bits), 0 if unknown
 }
 
 // The chunk size indicates how many bytes are encoded (per block) before
@@ -313,64 +654,64 @@ func NewRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*RangeDecoder, erro
 	return this, nil
 }
 
-func (this *RangeDecoder) decodeHeader(frequencies []int) (int, uint, error) {
-	alphabetSize, err := DecodeAlphabet(this.bitstream, this.alphabet)
-
-	if err != nil || alphabetSize == 0 {
-		return alphabetSize, 0, nil
+// The model must be the same as the encoder's (see NewRangeEncoderWithModel)
+func NewRangeDecoderWithModel(bs kanzi.InputBitStream, model *RangeModel) (*RangeDecoder, error) {
+	if model == nil {
+		return nil, errors.New("Invalid null model parameter")
 	}
 
-	if alphabetSize != 256 {
-		for i := range frequencies {
-			frequencies[i] = 0
-		}
-	}
-
-	// Decode frequencies
-	logRange := uint(8 + this.bitstream.ReadBits(3))
-	sum := 0
-	inc := 16
-	llr := uint(3)
+	this, err := NewRangeDecoder(bs, 0)
 
-	if alphabetSize <= 64 {
-		inc = 8
-	}
-
-	for 1<<llr <= logRange {
-		llr++
+	if err != nil {
+		return nil, err
 	}
 
-	// Decode all frequencies (but the first one) by chunks of size 'inc'
-	for i := 1; i < alphabetSize; i += inc {
-		logMax := uint(1 + this.bitstream.ReadBits(llr))
-		endj := i + inc
+	this.model = model
+	return this, nil
+}
 
-		if endj > alphabetSize {
-			endj = alphabetSize
-		}
+func (this *RangeDecoder) Model() *RangeModel {
+	return this.model
+}
 
-		// Read frequencies
-		for j := i; j < endj; j++ {
-			val := int(this.bitstream.ReadBits(logMax))
+// Set the position (in bits) of the end of the encoded data in the bitstream,
+// 0 (the default) means unknown. Some input streams pad a truncated input
+// with zeros: with a known end, the chunks decoded from the padding are
+// reported as ErrCorruptStream instead of garbage.
+// EG. rd.SetInputLimit(ibs.Read() + 8*uint64(len(encoded)))
+func (this *RangeDecoder) SetInputLimit(limit uint64) {
+	this.inputLimit = limit
+}
 
-			if val <= 0 || val >= 1<<logRange {
-				error := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", val, this.alphabet[j])
-				return alphabetSize, logRange, error
-			}
+func (this *RangeDecoder) InputLimit() uint64 {
+	return this.inputLimit
+}
 
-			frequencies[this.alphabet[j]] = val
-			sum += val
-		}
+// The decoder reads exactly the bits written by the encoder: after a chunk,
+// the bitstream must not be past the end of the encoded data
+func (this *RangeDecoder) checkInputLimit() error {
+	if this.inputLimit > 0 && this.bitstream.Read() > this.inputLimit {
+		return fmt.Errorf("%w: read past the end of the input in range decoder (%v bits, limit %v)",
+			ErrCorruptStream, this.bitstream.Read(), this.inputLimit)
 	}
 
-	// Infer first frequency
-	frequencies[this.alphabet[0]] = (1 << logRange) - sum
+	return nil
+}
 
-	if frequencies[this.alphabet[0]] <= 0 || frequencies[this.alphabet[0]] > 1<<logRange {
-		error := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", frequencies[this.alphabet[0]], this.alphabet[0])
-		return alphabetSize, logRange, error
+func (this *RangeDecoder) decodeHeader(frequencies []int) (int, uint, error) {
+	alphabetSize, logRange, err := decodeFrequencies(this.bitstream, this.alphabet, frequencies)
+
+	if err != nil || alphabetSize == 0 {
+		return alphabetSize, logRange, err
 	}
 
+	this.buildTables(frequencies, logRange)
+	return alphabetSize, logRange, nil
+}
+
+// Build the cumulated frequencies and the reverse mapping from the
+// frequencies (normalized to 2^logRange)
+func (this *RangeDecoder) buildTables(frequencies []int, logRange uint) {
 	this.cumFreqs[0] = 0
 
 	if len(this.f2s) < 1<<logRange {
@@ -387,16 +728,39 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, uint, error) {
 	}
 
 	this.invSum = uint64(1 << 24) / uint64(this.cumFreqs[256])
-	return alphabetSize, logRange, nil
+}
+
+// The bitstream panics when reading past the end of a truncated stream and
+// decodeByte panics on an invalid symbol interval: both are reported as
+// ErrCorruptStream (the end of the input also as io.ErrUnexpectedEOF).
+func corruptStreamError(r interface{}) error {
+	err := recoverError(r)
+
+	if errors.Is(err, ErrCorruptStream) {
+		return err
+	}
+
+	if err == io.EOF {
+		return fmt.Errorf("%w: %w", ErrCorruptStream, io.ErrUnexpectedEOF)
+	}
+
+	return fmt.Errorf("%w: %v", ErrCorruptStream, err)
 }
 
 // Initialize once (if necessary) at the beginning, the use the faster decodeByte_()
 // Reset frequency stats for each chunk of data in the block
-func (this *RangeDecoder) Decode(block []byte) (int, error) {
+func (this *RangeDecoder) Decode(block []byte) (decoded int, err error) {
 	if block == nil {
 		return 0, errors.New("Invalid null block parameter")
 	}
 
+	defer func() {
+		if r := recover(); r != nil {
+			decoded = 0
+			err = corruptStreamError(r)
+		}
+	}()
+
 	end := len(block)
 	startChunk := 0
 	sizeChunk := this.chunkSize
@@ -406,34 +770,142 @@ func (this *RangeDecoder) Decode(block []byte) (int, error) {
 	}
 
 	for startChunk < end {
-		alphabetSize, _, err := this.decodeHeader(this.freqs)
-
-		if err != nil || alphabetSize == 0 {
+		// The empty chunk headers mark flushes (skipped by startChunk)
+		if err := this.startChunk(); err != nil {
 			return startChunk, err
 		}
 
-		this.range_ = TOP_RANGE
-		this.low = 0
-		this.code = this.bitstream.ReadBits(56)
-		endChunk := startChunk + sizeChunk
-
-		if endChunk > end {
-			endChunk = end
-		}
+		endChunk := chunkEnd(startChunk, sizeChunk, end)
 
 		for i := startChunk; i < endChunk; i++ {
 			block[i] = this.decodeByte()
 		}
 
+		if err := this.checkInputLimit(); err != nil {
+			return startChunk, err
+		}
+
 		startChunk = endChunk
 	}
 
 	return len(block), nil
 }
 
+// Read the frequencies of the next chunk (unless there is a model), skipping
+// the flushes, and initialize the coder state
+func (this *RangeDecoder) startChunk() error {
+	if this.model != nil {
+		// Frequencies of the model, no header
+		copy(this.freqs, this.model.freqs)
+		this.buildTables(this.freqs, this.model.logRange)
+	} else {
+		alphabetSize, _, err := this.decodeHeader(this.freqs)
+
+		// Skip the flushes (empty header then padding to a byte boundary)
+		for err == nil && alphabetSize == 0 {
+			if pad := uint((8 - this.bitstream.Read()&7) & 7); pad > 0 {
+				this.bitstream.ReadBits(pad)
+			}
+
+			alphabetSize, _, err = this.decodeHeader(this.freqs)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	this.range_ = TOP_RANGE
+	this.low = 0
+	this.code = this.bitstream.ReadBits(56)
+	return nil
+}
+
+// Decode a block of n bytes (encoded by one call to Encode) in several calls,
+// at most len(dst) bytes per call, so that a large block can be decoded with
+// a small buffer. Return the number of bytes written to dst.
+// n must be the same for all the calls of a block: the block is complete when
+// the returned counts add up to n and the next call starts a new block.
+// Do not call Decode before the block is complete.
+// EG. for i := 0; i < n; { k, err := rd.DecodeN(buf, uint(n)); ... i += k }
+func (this *RangeDecoder) DecodeN(dst []byte, n uint) (decoded int, err error) {
+	if dst == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			this.pending = 0
+			decoded = 0
+			err = corruptStreamError(r)
+		}
+	}()
+
+	// The block size is used as an int (index): reject values that do not
+	// fit (32 bit platforms)
+	if n > uint(^uint(0)>>1) {
+		return 0, fmt.Errorf("Invalid block size: %v (max value is %v)", n, ^uint(0)>>1)
+	}
+
+	if this.pending == 0 {
+		// New block
+		this.blockSize = int(n)
+		this.pending = int(n)
+		this.chunkLeft = 0
+	} else if int(n) != this.blockSize {
+		return 0, fmt.Errorf("Invalid block size: %v (the current block has %v bytes, %v left)",
+			n, this.blockSize, this.pending)
+	}
+
+	count := len(dst)
+
+	if count > this.pending {
+		count = this.pending
+	}
+
+	for i := 0; i < count; {
+		if this.chunkLeft == 0 {
+			if err := this.startChunk(); err != nil {
+				this.pending = 0
+				return i, err
+			}
+
+			this.chunkLeft = this.chunkSize
+
+			if this.chunkLeft == 0 || this.chunkLeft > this.pending {
+				this.chunkLeft = this.pending
+			}
+		}
+
+		end := chunkEnd(i, this.chunkLeft, count)
+
+		this.chunkLeft -= end - i
+		this.pending -= end - i
+
+		for ; i < end; i++ {
+			dst[i] = this.decodeByte()
+		}
+
+		if this.chunkLeft == 0 {
+			if err := this.checkInputLimit(); err != nil {
+				this.pending = 0
+				return i, err
+			}
+		}
+	}
+
+	return count, nil
+}
+
 func (this *RangeDecoder) decodeByte() byte {
 	this.range_ = (this.range_ >> 24) * this.invSum
-	count := int((this.code - this.low) / this.range_)
+	count := (this.code - this.low) / this.range_
+
+	// Only possible with a corrupted stream
+	if count >= uint64(this.cumFreqs[256]) {
+		panic(fmt.Errorf("%w: invalid symbol interval %v in range decoder", ErrCorruptStream, count))
+	}
+
 	value := int(this.f2s[count])
 
 	// Compute next low and range
@@ -460,6 +932,104 @@ func (this *RangeDecoder) decodeByte() byte {
 	return byte(value)
 }
 
+// Restore the initial state to decode an independent block (the tables are
+// reused). The bitstream is not reset.
+func (this *RangeDecoder) Reset() {
+	this.blockSize = 0
+	this.pending = 0
+	this.chunkLeft = 0
+	this.code = 0
+	this.low = 0
+	this.range_ = TOP_RANGE
+	this.invSum = 0
+
+	for i := range this.freqs {
+		this.freqs[i] = 0
+		this.alphabet[i] = 0
+	}
+
+	for i := range this.cumFreqs {
+		this.cumFreqs[i] = 0
+	}
+
+	for i := range this.f2s {
+		this.f2s[i] = 0
+	}
+}
+
+// Adaptive state of a range decoder (see Snapshot)
+type RangeDecoderState struct {
+	code      uint64
+	low       uint64
+	range_    uint64
+	invSum    uint64
+	freqs     []int
+	cumFreqs  []int
+	f2s       []byte
+	alphabet  []byte
+	blockSize int
+	pending   int
+	chunkLeft int
+}
+
+// Return a deep copy of the state of the decoder, including the progress of
+// a block decoded by DecodeN. The bitstream is not part of the state: to
+// resume, the state must be restored into a decoder (created with the same
+// parameters) which bitstream is at the position of the snapshot.
+func (this *RangeDecoder) Snapshot() *RangeDecoderState {
+	state := &RangeDecoderState{
+		code:      this.code,
+		low:       this.low,
+		range_:    this.range_,
+		invSum:    this.invSum,
+		freqs:     make([]int, len(this.freqs)),
+		cumFreqs:  make([]int, len(this.cumFreqs)),
+		f2s:       make([]byte, len(this.f2s)),
+		alphabet:  make([]byte, len(this.alphabet)),
+		blockSize: this.blockSize,
+		pending:   this.pending,
+		chunkLeft: this.chunkLeft,
+	}
+
+	copy(state.freqs, this.freqs)
+	copy(state.cumFreqs, this.cumFreqs)
+	copy(state.f2s, this.f2s)
+	copy(state.alphabet, this.alphabet)
+	return state
+}
+
+// Restore the state returned by Snapshot (copied: the snapshot can be
+// restored several times)
+func (this *RangeDecoder) Restore(state *RangeDecoderState) error {
+	if state == nil {
+		return errors.New("Invalid null state parameter")
+	}
+
+	// The mapping grows with the log range of the chunks
+	if len(this.f2s) != len(state.f2s) {
+		this.f2s = make([]byte, len(state.f2s))
+	}
+
+	this.code = state.code
+	this.low = state.low
+	this.range_ = state.range_
+	this.invSum = state.invSum
+	copy(this.freqs, state.freqs)
+	copy(this.cumFreqs, state.cumFreqs)
+	copy(this.f2s, state.f2s)
+	copy(this.alphabet, state.alphabet)
+	this.blockSize = state.blockSize
+	this.pending = state.pending
+	this.chunkLeft = state.chunkLeft
+	return nil
+}
+
+// No end of data symbol: the size of the block must be provided (see Decode
+// and DecodeN)
+func (this *RangeDecoder) SelfTerminating() bool {
+	return false
+}
+
 func (this *RangeDecoder) BitStream() kanzi.InputBitStream {
 	return this.bitstream
 }
@@ -0,0 +1,384 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"kanzi"
+	"sort"
+)
+
+// Range coder for alphabets of more than 256 symbols (EG. tokens, UTF-16
+// code units, word indexes): the symbols are ints in [0..nbSymbols-1].
+// Each call to EncodeSymbols/DecodeSymbols codes an independent block with
+// static frequencies normalized to a power of 2 (no division per symbol).
+// Block header: number of present symbols, log range (minus 8) on 5 bits,
+// then for each present symbol, the gap from the previous present symbol and
+// the frequency minus 1 (each as a 5 bit size followed by the value).
+// The byte oriented RangeEncoder/RangeDecoder remain the fast default for
+// byte data.
+
+const (
+	MAX_RANGE_N_SYMBOLS   = 1 << 20
+	MIN_RANGE_N_LOG_RANGE = 16
+	MAX_RANGE_N_LOG_RANGE = 24
+)
+
+// Number of bits required to write 'val'
+func bitLength(val int) uint {
+	res := uint(0)
+
+	for val > 0 {
+		res++
+		val >>= 1
+	}
+
+	return res
+}
+
+func writeSizedValue(bs kanzi.OutputBitStream, val int) {
+	n := bitLength(val)
+	bs.WriteBits(uint64(n), 5)
+
+	if n > 0 {
+		bs.WriteBits(uint64(val), n)
+	}
+}
+
+func readSizedValue(bs kanzi.InputBitStream) int {
+	n := uint(bs.ReadBits(5))
+
+	if n == 0 {
+		return 0
+	}
+
+	return int(bs.ReadBits(n))
+}
+
+// Scale the frequencies of the present symbols so that they add up to 2^lr,
+// every present symbol keeping a frequency of at least 1
+func normalizeFrequenciesN(freqs []int, alphabet []int, count int, lr uint) {
+	scale := 1 << lr
+	sum := 0
+
+	for _, s := range alphabet {
+		f := int(int64(freqs[s]) * int64(scale) / int64(count))
+
+		if f == 0 {
+			f = 1
+		}
+
+		freqs[s] = f
+		sum += f
+	}
+
+	if sum == scale {
+		return
+	}
+
+	// Adjust the largest frequencies first
+	ranks := make([]int, len(alphabet))
+	copy(ranks, alphabet)
+	sort.SliceStable(ranks, func(i, j int) bool { return freqs[ranks[i]] > freqs[ranks[j]] })
+
+	if sum < scale {
+		freqs[ranks[0]] += scale - sum
+		return
+	}
+
+	// The scale is at least twice the number of symbols: the excess can be
+	// taken from the frequencies above 1
+	for sum > scale {
+		for _, s := range ranks {
+			if sum == scale {
+				break
+			}
+
+			if freqs[s] > 1 {
+				freqs[s]--
+				sum--
+			}
+		}
+	}
+}
+
+type RangeEncoderN struct {
+	bitstream kanzi.OutputBitStream
+	nbSymbols int
+	freqs     []int
+	cumFreqs  []int
+	alphabet  []int
+}
+
+func NewRangeEncoderN(bs kanzi.OutputBitStream, nbSymbols uint) (*RangeEncoderN, error) {
+	if bs == nil {
+		return nil, errors.New("Invalid null bitstream parameter")
+	}
+
+	if nbSymbols < 2 || nbSymbols > MAX_RANGE_N_SYMBOLS {
+		return nil, fmt.Errorf("Invalid number of symbols: %v (must be in [2..%v])", nbSymbols, MAX_RANGE_N_SYMBOLS)
+	}
+
+	this := new(RangeEncoderN)
+	this.bitstream = bs
+	this.nbSymbols = int(nbSymbols)
+	this.freqs = make([]int, nbSymbols)
+	this.cumFreqs = make([]int, nbSymbols+1)
+	this.alphabet = make([]int, 0, 256)
+	return this, nil
+}
+
+func (this *RangeEncoderN) NbSymbols() uint {
+	return uint(this.nbSymbols)
+}
+
+// Return the number of symbols encoded
+func (this *RangeEncoderN) EncodeSymbols(symbols []int) (int, error) {
+	if symbols == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	freqs := this.freqs
+
+	for i := range freqs {
+		freqs[i] = 0
+	}
+
+	for i, s := range symbols {
+		if s < 0 || s >= this.nbSymbols {
+			return 0, fmt.Errorf("Invalid symbol at index %v: %v (must be in [0..%v])", i, s, this.nbSymbols-1)
+		}
+
+		freqs[s]++
+	}
+
+	this.alphabet = this.alphabet[:0]
+
+	for s, f := range freqs {
+		if f > 0 {
+			this.alphabet = append(this.alphabet, s)
+		}
+	}
+
+	this.bitstream.WriteBits(uint64(len(this.alphabet)), bitLength(this.nbSymbols))
+
+	if len(this.alphabet) == 0 {
+		return 0, nil
+	}
+
+	// At least twice as many frequency units as symbols
+	lr := uint(MIN_RANGE_N_LOG_RANGE)
+
+	for 1<<lr < 2*len(this.alphabet) {
+		lr++
+	}
+
+	normalizeFrequenciesN(freqs, this.alphabet, len(symbols), lr)
+	this.bitstream.WriteBits(uint64(lr-8), 5)
+	prev := -1
+
+	for _, s := range this.alphabet {
+		writeSizedValue(this.bitstream, s-prev-1)
+		writeSizedValue(this.bitstream, freqs[s]-1)
+		prev = s
+	}
+
+	for s := 0; s < this.nbSymbols; s++ {
+		this.cumFreqs[s+1] = this.cumFreqs[s] + freqs[s]
+	}
+
+	low := uint64(0)
+	range_ := TOP_RANGE
+
+	for _, s := range symbols {
+		// Compute next low and range (the sum of frequencies is 2^lr)
+		range_ >>= lr
+		low += uint64(this.cumFreqs[s]) * range_
+		range_ *= uint64(freqs[s])
+
+		// If the left-most digits are the same throughout the range, write bits to bitstream
+		for {
+			if (low^(low+range_))&MASK != 0 {
+				if range_ > BOTTOM_RANGE {
+					break
+				}
+
+				// Normalize
+				range_ = -low & BOTTOM_RANGE
+			}
+
+			this.bitstream.WriteBits(low>>40, 16)
+			range_ <<= 16
+			low <<= 16
+		}
+	}
+
+	// Flush 'low'
+	this.bitstream.WriteBits(low, 56)
+	return len(symbols), nil
+}
+
+func (this *RangeEncoderN) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+func (this *RangeEncoderN) Dispose() {
+}
+
+type RangeDecoderN struct {
+	bitstream kanzi.InputBitStream
+	nbSymbols int
+	alphabet  []int
+	freqs     []int
+	cumFreqs  []int // cumulated frequencies of the present symbols
+}
+
+func NewRangeDecoderN(bs kanzi.InputBitStream, nbSymbols uint) (*RangeDecoderN, error) {
+	if bs == nil {
+		return nil, errors.New("Invalid null bitstream parameter")
+	}
+
+	if nbSymbols < 2 || nbSymbols > MAX_RANGE_N_SYMBOLS {
+		return nil, fmt.Errorf("Invalid number of symbols: %v (must be in [2..%v])", nbSymbols, MAX_RANGE_N_SYMBOLS)
+	}
+
+	this := new(RangeDecoderN)
+	this.bitstream = bs
+	this.nbSymbols = int(nbSymbols)
+	this.alphabet = make([]int, 0, 256)
+	this.freqs = make([]int, 0, 256)
+	this.cumFreqs = make([]int, 0, 257)
+	return this, nil
+}
+
+func (this *RangeDecoderN) NbSymbols() uint {
+	return uint(this.nbSymbols)
+}
+
+// Read the present symbols and their frequencies, return the log range
+func (this *RangeDecoderN) decodeHeader() (uint, error) {
+	count := int(this.bitstream.ReadBits(bitLength(this.nbSymbols)))
+	this.alphabet = this.alphabet[:0]
+	this.freqs = this.freqs[:0]
+	this.cumFreqs = append(this.cumFreqs[:0], 0)
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	if count > this.nbSymbols {
+		return 0, fmt.Errorf("%w: invalid number of symbols %v", ErrCorruptStream, count)
+	}
+
+	lr := uint(this.bitstream.ReadBits(5)) + 8
+
+	if lr < MIN_RANGE_N_LOG_RANGE || lr > MAX_RANGE_N_LOG_RANGE {
+		return 0, fmt.Errorf("%w: invalid log range %v", ErrCorruptStream, lr)
+	}
+
+	prev := -1
+
+	for i := 0; i < count; i++ {
+		s := prev + 1 + readSizedValue(this.bitstream)
+		f := 1 + readSizedValue(this.bitstream)
+
+		if s >= this.nbSymbols {
+			return 0, fmt.Errorf("%w: invalid symbol %v", ErrCorruptStream, s)
+		}
+
+		this.alphabet = append(this.alphabet, s)
+		this.freqs = append(this.freqs, f)
+		this.cumFreqs = append(this.cumFreqs, this.cumFreqs[i]+f)
+		prev = s
+	}
+
+	if this.cumFreqs[count] != 1<<lr {
+		return 0, fmt.Errorf("%w: incorrect sum of frequencies %v (expected %v)",
+			ErrCorruptStream, this.cumFreqs[count], 1<<lr)
+	}
+
+	return lr, nil
+}
+
+// Decode len(symbols) symbols, return the number of symbols decoded
+func (this *RangeDecoderN) DecodeSymbols(symbols []int) (int, error) {
+	if symbols == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	lr, err := this.decodeHeader()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if lr == 0 {
+		if len(symbols) != 0 {
+			return 0, fmt.Errorf("%w: empty block", ErrCorruptStream)
+		}
+
+		return 0, nil
+	}
+
+	cumFreqs := this.cumFreqs
+	count := len(this.alphabet)
+	low := uint64(0)
+	range_ := TOP_RANGE
+	code := this.bitstream.ReadBits(56)
+
+	for i := range symbols {
+		range_ >>= lr
+		value := int((code - low) / range_)
+
+		if value >= 1<<lr {
+			return i, fmt.Errorf("%w: symbol out of range in range decoder", ErrCorruptStream)
+		}
+
+		// Index of the last cumulated frequency <= value
+		idx := sort.Search(count, func(j int) bool { return cumFreqs[j+1] > value })
+
+		// Compute next low and range
+		low += uint64(cumFreqs[idx]) * range_
+		range_ *= uint64(this.freqs[idx])
+
+		for {
+			if (low^(low+range_))&MASK != 0 {
+				if range_ > BOTTOM_RANGE {
+					break
+				}
+
+				// Normalize
+				range_ = -low & BOTTOM_RANGE
+			}
+
+			code = (code << 16) | this.bitstream.ReadBits(16)
+			range_ <<= 16
+			low <<= 16
+		}
+
+		symbols[i] = this.alphabet[idx]
+	}
+
+	return len(symbols), nil
+}
+
+func (this *RangeDecoderN) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+func (this *RangeDecoderN) Dispose() {
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Preset frequencies for the range coder (like a compression dictionary).
+// The encoder and the decoder created with the same model code each block
+// with the frequencies of the model instead of the frequencies of the block,
+// hence without chunk header: small blocks (EG. messages of a few hundred
+// bytes, where the header is a large part of the output) compress better if
+// the model is trained on representative data (see TrainRangeModel).
+// Every symbol has a non null frequency so that any byte can be coded.
+// The model is not stored in the bitstream: it must be shared out of band
+// (see Bytes and NewRangeModelFromBytes).
+// Serialized format: log range (1 byte) + frequency-1 of each symbol (256
+// varints, about 300 bytes).
+
+type RangeModel struct {
+	freqs    []int // normalized to 2^logRange
+	logRange uint
+}
+
+// Count the bytes of the samples (plus 1 for each symbol) and normalize the
+// frequencies to 2^logRange. The log range (optional) must be in [8..15],
+// DEFAULT_RANGE_LOG_RANGE by default.
+func TrainRangeModel(samples [][]byte, args ...uint) (*RangeModel, error) {
+	if len(args) > 1 {
+		return nil, errors.New("At most one log range can be provided")
+	}
+
+	logRange := DEFAULT_RANGE_LOG_RANGE
+
+	if len(args) == 1 {
+		logRange = args[0]
+	}
+
+	if logRange < 8 || logRange > 15 {
+		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..15])", logRange)
+	}
+
+	freqs := make([]int, 256)
+	count := 256
+
+	for i := range freqs {
+		freqs[i] = 1
+	}
+
+	for _, sample := range samples {
+		for _, b := range sample {
+			freqs[b]++
+		}
+
+		count += len(sample)
+	}
+
+	eu, err := NewEntropyUtils()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = eu.NormalizeFrequencies(freqs, make([]byte, 256), count, 1<<logRange); err != nil {
+		return nil, err
+	}
+
+	return newRangeModel(freqs, logRange)
+}
+
+func newRangeModel(freqs []int, logRange uint) (*RangeModel, error) {
+	sum := 0
+
+	for i, f := range freqs {
+		if f <= 0 {
+			return nil, fmt.Errorf("Invalid null frequency for symbol %v", i)
+		}
+
+		sum += f
+	}
+
+	if sum != 1<<logRange {
+		return nil, fmt.Errorf("Invalid sum of frequencies: %v (expected %v)", sum, 1<<logRange)
+	}
+
+	this := new(RangeModel)
+	this.freqs = freqs
+	this.logRange = logRange
+	return this, nil
+}
+
+// Decode a model serialized with Bytes
+func NewRangeModelFromBytes(buf []byte) (*RangeModel, error) {
+	if len(buf) < 257 {
+		return nil, errors.New("Invalid range model: truncated data")
+	}
+
+	logRange := uint(buf[0])
+
+	if logRange < 8 || logRange > 15 {
+		return nil, fmt.Errorf("Invalid range model: log range %v (must be in [8..15])", logRange)
+	}
+
+	freqs := make([]int, 256)
+	idx := 1
+
+	for i := range freqs {
+		val, n := binary.Uvarint(buf[idx:])
+
+		if n <= 0 || val >= 1<<logRange {
+			return nil, fmt.Errorf("Invalid range model: frequency of symbol %v", i)
+		}
+
+		freqs[i] = int(val) + 1
+		idx += n
+	}
+
+	if idx != len(buf) {
+		return nil, errors.New("Invalid range model: trailing data")
+	}
+
+	res, err := newRangeModel(freqs, logRange)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid range model: %v", err)
+	}
+
+	return res, nil
+}
+
+// Serialize the model (see NewRangeModelFromBytes)
+func (this *RangeModel) Bytes() []byte {
+	res := make([]byte, 1, 1+2*len(this.freqs))
+	res[0] = byte(this.logRange)
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	for _, f := range this.freqs {
+		n := binary.PutUvarint(buf, uint64(f-1))
+		res = append(res, buf[0:n]...)
+	}
+
+	return res
+}
+
+// Return a copy of the frequencies (indexed by symbol), they add up to
+// 2^LogRange()
+func (this *RangeModel) Frequencies() []int {
+	res := make([]int, len(this.freqs))
+	copy(res, this.freqs)
+	return res
+}
+
+func (this *RangeModel) LogRange() uint {
+	return this.logRange
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"kanzi"
+)
+
+// Decoder of concatenated segments, each segment being the output of a range
+// encoder (one call to Encode) written to its own bitstream (EG. blocks
+// compressed in parallel). A closed bitstream is padded to a byte boundary,
+// hence a segment starts on the byte following the end of the previous one.
+// The coded data has no end of data symbol: the size of each decoded segment
+// must be provided. The decoder is reset at each segment boundary.
+type SegmentedDecoder struct {
+	decoder   *RangeDecoder
+	bitstream kanzi.InputBitStream
+	segments  int
+}
+
+// The chunk size (optional) must be the one of the encoders (see
+// NewRangeDecoder)
+func NewSegmentedDecoder(bs kanzi.InputBitStream, args ...uint) (*SegmentedDecoder, error) {
+	decoder, err := NewRangeDecoder(bs, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(SegmentedDecoder)
+	this.decoder = decoder
+	this.bitstream = bs
+	return this, nil
+}
+
+// Decode the next segment (len(block) bytes) and skip the padding after it
+func (this *SegmentedDecoder) DecodeSegment(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	this.decoder.Reset()
+	decoded, err := this.decoder.Decode(block)
+
+	if err != nil {
+		return decoded, fmt.Errorf("segment %v: %w", this.segments, err)
+	}
+
+	if pad := uint((8 - this.bitstream.Read()&7) & 7); pad > 0 {
+		this.bitstream.ReadBits(pad)
+	}
+
+	this.segments++
+	return decoded, nil
+}
+
+// Decode consecutive segments of the provided sizes into dst. Return the
+// number of bytes decoded.
+func (this *SegmentedDecoder) DecodeSegments(dst []byte, sizes []int) (int, error) {
+	if dst == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	total := 0
+
+	for _, size := range sizes {
+		if size < 0 {
+			return 0, fmt.Errorf("Invalid segment size: %v", size)
+		}
+
+		total += size
+	}
+
+	if total > len(dst) {
+		return 0, fmt.Errorf("Output buffer too small: %v bytes (segments: %v bytes)", len(dst), total)
+	}
+
+	offset := 0
+
+	for _, size := range sizes {
+		n, err := this.DecodeSegment(dst[offset : offset+size])
+		offset += n
+
+		if err != nil {
+			return offset, err
+		}
+	}
+
+	return offset, nil
+}
+
+// Return the number of segments decoded so far
+func (this *SegmentedDecoder) Segments() int {
+	return this.segments
+}
+
+func (this *SegmentedDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+func (this *SegmentedDecoder) Dispose() {
+	this.decoder.Dispose()
+}
@@ -0,0 +1,365 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"kanzi"
+	"kanzi/bitstream"
+)
+
+// io.WriteCloser and io.Reader adapters around the range coder. The writer
+// buffers the data and range codes it by chunks (the chunks are coded
+// independently, hence the split of the data across Write calls does not
+// matter). Stream format: for each chunk, 32 bits of length then the range
+// coded chunk; a length of 0 ends the stream. A flush (RangeWriter.Flush) is
+// recorded as a length of RANGE_STREAM_FLUSH_MARKER followed by the padding
+// to a byte boundary. The stream is padded to a byte boundary, hence several
+// streams can be concatenated (EG. appended to the same file) and read in
+// sequence by one RangeReader (see SetMultiStream).
+
+const (
+	RANGE_STREAM_CHUNK_SIZE   = int(DEFAULT_RANGE_CHUNK_SIZE)
+	RANGE_STREAM_FLUSH_MARKER = uint64(0xFFFFFFFF)
+)
+
+type writerOutputStream struct {
+	writer io.Writer
+}
+
+func (this *writerOutputStream) Write(b []byte) (int, error) {
+	return this.writer.Write(b)
+}
+
+func (this *writerOutputStream) Close() error {
+	return nil
+}
+
+type readerInputStream struct {
+	reader io.Reader
+}
+
+func (this *readerInputStream) Read(b []byte) (int, error) {
+	n, err := this.reader.Read(b)
+
+	// The bitstream fails on any error, report the end of stream on the next call
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (this *readerInputStream) Close() error {
+	return nil
+}
+
+// The bitstream panics on I/O errors
+func recoverError(r interface{}) error {
+	if err, isErr := r.(error); isErr == true {
+		return err
+	}
+
+	return fmt.Errorf("%v", r)
+}
+
+type RangeWriter struct {
+	obs     *bitstream.DefaultOutputBitStream
+	encoder *RangeEncoder
+	buffer  []byte
+	size    int
+	closed  bool
+}
+
+// The underlying writer is not closed by Close
+func NewRangeWriter(w io.Writer) (*RangeWriter, error) {
+	if w == nil {
+		return nil, errors.New("Invalid null writer parameter")
+	}
+
+	obs, err := bitstream.NewDefaultOutputBitStream(&writerOutputStream{writer: w}, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, err := NewRangeEncoder(obs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(RangeWriter)
+	this.obs = obs
+	this.encoder = encoder
+	this.buffer = make([]byte, RANGE_STREAM_CHUNK_SIZE)
+	return this, nil
+}
+
+func (this *RangeWriter) Write(b []byte) (n int, err error) {
+	if this.closed == true {
+		return 0, errors.New("Stream closed")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+		}
+	}()
+
+	for n < len(b) {
+		copied := copy(this.buffer[this.size:], b[n:])
+		this.size += copied
+		n += copied
+
+		if this.size == len(this.buffer) {
+			if err = this.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Code the buffered data (even if less than a chunk) and write everything to
+// the underlying writer, so that a reader can decode all the data written so
+// far (EG. live telemetry). The stream is not ended.
+// Each flush costs a chunk header (see RANGE_MAX_CHUNK_OVERHEAD) and up to 71
+// bits (marker and padding): frequent flushes of small writes lower the
+// compression ratio.
+func (this *RangeWriter) Flush() (err error) {
+	if this.closed == true {
+		return errors.New("Stream closed")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+		}
+	}()
+
+	if err = this.flush(); err != nil {
+		return err
+	}
+
+	this.obs.WriteBits(RANGE_STREAM_FLUSH_MARKER, 32)
+	return this.obs.Flush()
+}
+
+// Range code the buffered data
+func (this *RangeWriter) flush() error {
+	if this.size == 0 {
+		return nil
+	}
+
+	this.obs.WriteBits(uint64(this.size), 32)
+
+	if _, err := this.encoder.Encode(this.buffer[0:this.size]); err != nil {
+		return err
+	}
+
+	this.size = 0
+	return nil
+}
+
+// Code the buffered data, write the end of stream and flush the bitstream
+func (this *RangeWriter) Close() (err error) {
+	if this.closed == true {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+		}
+	}()
+
+	if err = this.flush(); err != nil {
+		return err
+	}
+
+	this.obs.WriteBits(0, 32)
+	this.encoder.Dispose()
+
+	if _, err = this.obs.Close(); err != nil {
+		return err
+	}
+
+	this.closed = true
+	return nil
+}
+
+type RangeReader struct {
+	ibs         kanzi.InputBitStream
+	decoder     *RangeDecoder
+	buffer      []byte
+	index       int
+	size        int
+	eos         bool
+	multiStream bool
+}
+
+func NewRangeReader(r io.Reader) (*RangeReader, error) {
+	if r == nil {
+		return nil, errors.New("Invalid null reader parameter")
+	}
+
+	ibs, err := bitstream.NewDefaultInputBitStream(&readerInputStream{reader: r}, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := NewRangeDecoder(ibs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(RangeReader)
+	this.ibs = ibs
+	this.decoder = decoder
+	this.buffer = make([]byte, RANGE_STREAM_CHUNK_SIZE)
+	return this, nil
+}
+
+func (this *RangeReader) MultiStream() bool {
+	return this.multiStream
+}
+
+// If enabled (disabled by default), the end of a stream followed by more data
+// is not the end: the next stream is read (the result is the concatenation of
+// the decoded streams). Otherwise, the data after the end of the first stream
+// is ignored.
+func (this *RangeReader) SetMultiStream(enabled bool) {
+	this.multiStream = enabled
+}
+
+// Short reads: return the bytes of the current chunk, the next chunk is only
+// decoded when no byte is left (nothing is read from the underlying reader
+// before the first call). Return ErrEndOfStream (io.EOF) at the end of the
+// range coded stream and an error wrapping both ErrCorruptStream and
+// io.ErrUnexpectedEOF if the underlying reader ends before it.
+func (this *RangeReader) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+
+			if err == io.EOF {
+				err = fmt.Errorf("%w: %w", ErrCorruptStream, io.ErrUnexpectedEOF)
+			}
+		}
+	}()
+
+	for this.index == this.size {
+		if this.eos == true {
+			return 0, ErrEndOfStream
+		}
+
+		if err = this.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(b, this.buffer[this.index:this.size])
+	this.index += n
+	return n, nil
+}
+
+// Decode the rest of the stream, up to the end of stream marker written by
+// RangeWriter.Close (the stream is self-terminating: the size of the data is
+// not needed). The output grows one chunk at a time. On error, return the data
+// decoded so far with the error.
+func (this *RangeReader) DecodeAll() (res []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverError(r)
+
+			if err == io.EOF {
+				err = fmt.Errorf("%w: %w", ErrCorruptStream, io.ErrUnexpectedEOF)
+			}
+		}
+	}()
+
+	res = make([]byte, 0, this.size-this.index)
+
+	for {
+		res = append(res, this.buffer[this.index:this.size]...)
+		this.index = this.size
+
+		if this.eos == true {
+			return res, nil
+		}
+
+		if err = this.readChunk(); err != nil {
+			return res, err
+		}
+	}
+}
+
+// Decode the next chunk
+func (this *RangeReader) readChunk() error {
+	// Check the size before the conversion: int(size) may be negative with
+	// a 32 bit int (corrupted stream)
+	size64 := this.ibs.ReadBits(32)
+	this.index = 0
+	this.size = 0
+
+	// Skip the flushes (padding to a byte boundary)
+	for size64 == RANGE_STREAM_FLUSH_MARKER {
+		if pad := uint((8 - this.ibs.Read()&7) & 7); pad > 0 {
+			this.ibs.ReadBits(pad)
+		}
+
+		size64 = this.ibs.ReadBits(32)
+	}
+
+	if size64 == 0 {
+		if this.multiStream == true {
+			// Skip the padding to the next stream (if any)
+			if pad := uint((8 - this.ibs.Read()&7) & 7); pad > 0 {
+				this.ibs.ReadBits(pad)
+			}
+
+			if more, _ := this.ibs.HasMoreToRead(); more == true {
+				return nil
+			}
+		}
+
+		this.eos = true
+		return nil
+	}
+
+	if size64 > uint64(len(this.buffer)) {
+		return fmt.Errorf("%w: invalid chunk size %v (must be at most %v)", ErrCorruptStream, size64, len(this.buffer))
+	}
+
+	size := int(size64)
+
+	if _, err := this.decoder.Decode(this.buffer[0:size]); err != nil {
+		return err
+	}
+
+	this.size = size
+	return nil
+}
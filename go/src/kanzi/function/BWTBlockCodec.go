@@ -161,6 +161,11 @@ func (this *BWTBlockCodec) Forward(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New(errMsg)
 	}
 
+	if blockSize == 0 {
+		// Empty block: a size of 0 would mean 'whole buffer' for the GST
+		return 0, 0, nil
+	}
+
 	this.transform.(kanzi.Sizeable).SetSize(blockSize)
 
 	// Apply forward Transform
@@ -0,0 +1,55 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+// Error returned when the output buffer of a function is too small and the
+// required size is known: the caller can grow the buffer by Missing() bytes
+// and call the function again.
+// EG. if e, ok := err.(*BufferTooSmallError); ok { dst = make([]byte, e.Required()) }
+
+type BufferTooSmallError struct {
+	msg       string
+	required  uint
+	available uint
+}
+
+func NewBufferTooSmallError(msg string, required, available uint) *BufferTooSmallError {
+	this := new(BufferTooSmallError)
+	this.msg = msg
+	this.required = required
+	this.available = available
+	return this
+}
+
+// Implement error interface
+func (this BufferTooSmallError) Error() string {
+	return this.msg
+}
+
+// Required size of the output buffer
+func (this BufferTooSmallError) Required() uint {
+	return this.required
+}
+
+// Size of the output buffer provided
+func (this BufferTooSmallError) Available() uint {
+	return this.available
+}
+
+// Additional capacity required
+func (this BufferTooSmallError) Missing() uint {
+	return this.required - this.available
+}
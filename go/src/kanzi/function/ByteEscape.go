@@ -0,0 +1,229 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"kanzi"
+)
+
+// Escape a set of disallowed byte values, EG. to embed (compressed) data in a
+// protocol where some bytes are forbidden. Each disallowed byte and the escape
+// byte itself are replaced by the escape byte followed by a code. The codes
+// are the smallest allowed values (neither disallowed nor the escape byte):
+// the i-th escaped value (ascending) is coded with the i-th allowed value.
+// Hence the output never contains a disallowed byte. At most 127 bytes can be
+// disallowed. The output is at most twice as big as the input.
+// EG. disallowed 0 10 13, escape 27 (codes 0=>1 10=>2 13=>3 27=>4)
+//  input: 65 0 66 13 10 27
+// output: 65 27 1 66 27 3 27 2 27 4
+
+const (
+	MAX_ESCAPED_BYTES = 127
+)
+
+type ByteEscape struct {
+	size    uint
+	escape  byte
+	escaped [256]bool
+	codes   [256]byte // code of each escaped byte
+	values  [256]int  // escaped byte of each code (-1 if not a code)
+}
+
+func NewByteEscape(sz uint, disallowed []byte, escape byte) (*ByteEscape, error) {
+	if disallowed == nil {
+		return nil, errors.New("Invalid null disallowed bytes parameter")
+	}
+
+	this := new(ByteEscape)
+	this.size = sz
+	this.escape = escape
+	count := 0
+
+	for _, b := range disallowed {
+		if b == escape {
+			return nil, errors.New("The escape byte cannot be disallowed")
+		}
+
+		if this.escaped[b] == false {
+			this.escaped[b] = true
+			count++
+		}
+	}
+
+	if count > MAX_ESCAPED_BYTES {
+		return nil, errors.New("Invalid disallowed bytes parameter (at most 127 distinct bytes)")
+	}
+
+	this.escaped[escape] = true
+
+	for i := range this.values {
+		this.values[i] = -1
+	}
+
+	// Assign the allowed values to the escaped values, both in ascending order
+	code := 0
+
+	for b := 0; b < 256; b++ {
+		if this.escaped[b] == false {
+			continue
+		}
+
+		for this.escaped[code] == true {
+			code++
+		}
+
+		this.codes[b] = byte(code)
+		this.values[code] = b
+		code++
+	}
+
+	return this, nil
+}
+
+func (this *ByteEscape) Size() uint {
+	return this.size
+}
+
+func (this *ByteEscape) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *ByteEscape) Escape() byte {
+	return this.escape
+}
+
+// Return true if the byte is disallowed in the output (the escape byte is
+// allowed)
+func (this *ByteEscape) Disallowed(b byte) bool {
+	return b != this.escape && this.escaped[b] == true
+}
+
+func (this *ByteEscape) checkBuffers(src, dst []byte) (int, error) {
+	if src == nil {
+		return 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := len(src)
+
+	if this.size > 0 {
+		length = int(this.size)
+
+		if length > len(src) {
+			return 0, errors.New("Source buffer too small")
+		}
+	}
+
+	return length, nil
+}
+
+func (this *ByteEscape) Forward(src, dst []byte) (uint, uint, error) {
+	srcEnd, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	srcIdx := 0
+	dstIdx := 0
+	dstEnd := len(dst)
+
+	for srcIdx < srcEnd {
+		val := src[srcIdx]
+
+		if this.escaped[val] == false {
+			if dstIdx >= dstEnd {
+				break
+			}
+
+			dst[dstIdx] = val
+			dstIdx++
+		} else {
+			if dstIdx+1 >= dstEnd {
+				break
+			}
+
+			dst[dstIdx] = this.escape
+			dst[dstIdx+1] = this.codes[val]
+			dstIdx += 2
+		}
+
+		srcIdx++
+	}
+
+	if srcIdx != srcEnd {
+		return uint(srcIdx), uint(dstIdx), errors.New("Output buffer is too small")
+	}
+
+	return uint(srcIdx), uint(dstIdx), nil
+}
+
+func (this *ByteEscape) Inverse(src, dst []byte) (uint, uint, error) {
+	srcEnd, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	srcIdx := 0
+	dstIdx := 0
+	dstEnd := len(dst)
+
+	for srcIdx < srcEnd {
+		if dstIdx >= dstEnd {
+			return uint(srcIdx), uint(dstIdx), errors.New("Output buffer is too small")
+		}
+
+		val := src[srcIdx]
+		srcIdx++
+
+		if val == this.escape {
+			if srcIdx >= srcEnd {
+				return uint(srcIdx), uint(dstIdx), errors.New("Invalid truncated escape sequence")
+			}
+
+			v := this.values[src[srcIdx]]
+			srcIdx++
+
+			if v < 0 {
+				return uint(srcIdx), uint(dstIdx), errors.New("Invalid escape sequence")
+			}
+
+			val = byte(v)
+		} else if this.escaped[val] == true {
+			return uint(srcIdx), uint(dstIdx), errors.New("Invalid disallowed byte in input")
+		}
+
+		dst[dstIdx] = val
+		dstIdx++
+	}
+
+	return uint(srcIdx), uint(dstIdx), nil
+}
+
+// Every byte may be escaped
+func (this ByteEscape) MaxEncodedLen(srcLen int) int {
+	return 2 * srcLen
+}
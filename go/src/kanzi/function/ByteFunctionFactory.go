@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"kanzi"
 	"kanzi/transform"
+	"strconv"
 	"strings"
 )
 
@@ -30,10 +31,23 @@ const (
 	LZ4_TYPE            = byte(3)
 	SNAPPY_TYPE         = byte(4)
 	RLT_TYPE            = byte(5)
+	LINE_DEDUP_TYPE     = byte(6)
+	REMAP_TYPE          = byte(7)
+	HAAR_TYPE           = byte(8)
+	COLOR_TYPE          = byte(9)
+	PREDICT_DELTA_TYPE  = byte(10)
+	ZRLT_TYPE           = byte(11)
+	LZ_TYPE             = byte(12)
 
 	// GST: 3 msb
 )
 
+// Return the types of all the registered functions (4 lsb only)
+func GetByteFunctionTypes() []byte {
+	return []byte{NULL_TRANSFORM_TYPE, BWT_TYPE, BWTS_TYPE, LZ4_TYPE, SNAPPY_TYPE, RLT_TYPE,
+		LINE_DEDUP_TYPE, REMAP_TYPE, HAAR_TYPE, COLOR_TYPE, PREDICT_DELTA_TYPE, ZRLT_TYPE, LZ_TYPE}
+}
+
 func NewByteFunction(size uint, functionType byte) (kanzi.ByteFunction, error) {
 	switch functionType & 0x0F {
 
@@ -46,6 +60,27 @@ func NewByteFunction(size uint, functionType byte) (kanzi.ByteFunction, error) {
 	case RLT_TYPE:
 		return NewRLT(size, 3)
 
+	case LINE_DEDUP_TYPE:
+		return NewLineDedup(size, '\n', DEFAULT_LINE_DEDUP_DICT)
+
+	case REMAP_TYPE:
+		return NewFrequencyRemap(size, DEFAULT_REMAP_SYMBOLS)
+
+	case HAAR_TYPE:
+		return NewHaarByte(size, DEFAULT_HAAR_LEVELS)
+
+	case COLOR_TYPE:
+		return NewColorTransform(size)
+
+	case PREDICT_DELTA_TYPE:
+		return NewPredictDelta(size, DEFAULT_PREDICT_DELTA_SHIFT)
+
+	case ZRLT_TYPE:
+		return NewZRLT(size)
+
+	case LZ_TYPE:
+		return NewLZCodec(size, DEFAULT_LZ_LOG_WINDOW, DEFAULT_LZ_LOG_HASH)
+
 	case BWT_TYPE:
 		bwt, err := transform.NewBWT(size)
 
@@ -72,6 +107,66 @@ func NewByteFunction(size uint, functionType byte) (kanzi.ByteFunction, error) {
 	}
 }
 
+// Return an upper bound of the working memory (in bytes) allocated by the
+// inverse function of the provided type for a block of the provided size.
+// The input and output block buffers are not included.
+func GetByteFunctionMemory(functionType byte, blockSize uint) uint64 {
+	intSize := uint64(strconv.IntSize >> 3)
+	size := uint64(blockSize)
+
+	switch functionType & 0x0F {
+
+	case SNAPPY_TYPE:
+		return 0
+
+	case LZ4_TYPE:
+		return intSize * (1 << HASH_LOG_64K)
+
+	case RLT_TYPE:
+		return 0
+
+	case LINE_DEDUP_TYPE:
+		// Ring buffers of line starts and lengths, hash table
+		return intSize * 4 * DEFAULT_LINE_DEDUP_DICT
+
+	case REMAP_TYPE:
+		return 0
+
+	case HAAR_TYPE:
+		// Scratch buffer of one block
+		return size
+
+	case COLOR_TYPE:
+		return 0
+
+	case PREDICT_DELTA_TYPE:
+		return 0
+
+	case ZRLT_TYPE:
+		return 0
+
+	case LZ_TYPE:
+		return 0
+
+	case BWT_TYPE:
+		// Inverse BWT uses one int per byte (plus one byte for big blocks)
+		if blockSize >= 1<<24 {
+			return intSize*(size+256) + size
+		}
+
+		return intSize * (size + 256)
+
+	case BWTS_TYPE:
+		return intSize * (size + 256)
+
+	case NULL_TRANSFORM_TYPE:
+		return 0
+
+	default:
+		panic(fmt.Errorf("Unsupported function type: '%c'", functionType))
+	}
+}
+
 func getGSTType(args string) byte {
 	switch strings.ToUpper(args) {
 	case "MTF":
@@ -125,6 +220,27 @@ func GetByteFunctionName(functionType byte) string {
 	case RLT_TYPE:
 		return "RLT"
 
+	case LINE_DEDUP_TYPE:
+		return "LINEDEDUP"
+
+	case REMAP_TYPE:
+		return "REMAP"
+
+	case HAAR_TYPE:
+		return "HAAR"
+
+	case COLOR_TYPE:
+		return "COLOR"
+
+	case PREDICT_DELTA_TYPE:
+		return "PREDICTDELTA"
+
+	case ZRLT_TYPE:
+		return "ZRLT"
+
+	case LZ_TYPE:
+		return "LZ"
+
 	case BWT_TYPE:
 		gstName := getGSTName(int(functionType) >> 4)
 
@@ -175,6 +291,27 @@ func GetByteFunctionType(functionName string) byte {
 	case "RLT":
 		return RLT_TYPE
 
+	case "LINEDEDUP":
+		return LINE_DEDUP_TYPE
+
+	case "REMAP":
+		return REMAP_TYPE
+
+	case "HAAR":
+		return HAAR_TYPE
+
+	case "COLOR":
+		return COLOR_TYPE
+
+	case "PREDICTDELTA":
+		return PREDICT_DELTA_TYPE
+
+	case "ZRLT":
+		return ZRLT_TYPE
+
+	case "LZ":
+		return LZ_TYPE
+
 	case "BWT":
 		gst := getGSTType(args)
 		return byte((gst << 4) | BWT_TYPE)
@@ -0,0 +1,380 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"fmt"
+	"kanzi"
+	"strings"
+)
+
+const (
+	MAX_PIPELINE_STAGES = 8
+)
+
+// Chain of byte functions (EG. BWT+MTF then ZRLT then RLT) applied in order by
+// Forward and in reverse order by Inverse.
+// The output starts with a header recording the recipe, so that the inverse
+// pipeline can be rebuilt from the encoded data (see NewByteFunctionPipelineFromHeader):
+// 1 byte: number of stages N
+// 1 byte: mask of the skipped stages (bit i set if stage i failed and was bypassed)
+// N bytes: function types
+// (N+1)*4 bytes: input size of each stage then size of the output of the last stage
+// A stage failing in Forward (EG. expanding data beyond its buffer) is bypassed
+// rather than failing the whole pipeline.
+type ByteFunctionPipeline struct {
+	size  uint
+	types []byte
+}
+
+// The size is the size of the input block for Forward and of the encoded
+// block for Inverse (0 means the whole buffer).
+func NewByteFunctionPipeline(size uint, types []byte) (*ByteFunctionPipeline, error) {
+	if types == nil {
+		return nil, errors.New("Invalid null types parameter")
+	}
+
+	if len(types) == 0 || len(types) > MAX_PIPELINE_STAGES {
+		return nil, fmt.Errorf("Invalid number of stages: %v (must be in [1..%v])", len(types), MAX_PIPELINE_STAGES)
+	}
+
+	for _, t := range types {
+		// Check that the function type is supported
+		if _, err := NewByteFunction(0, t); err != nil {
+			return nil, err
+		}
+	}
+
+	this := new(ByteFunctionPipeline)
+	this.size = size
+	this.types = make([]byte, len(types))
+	copy(this.types, types)
+	return this, nil
+}
+
+// Create the pipeline described by the header at the start of the encoded data
+func NewByteFunctionPipelineFromHeader(size uint, src []byte) (*ByteFunctionPipeline, error) {
+	if src == nil {
+		return nil, errors.New("Invalid null source buffer")
+	}
+
+	if len(src) < 2 || len(src) < pipelineHeaderSize(int(src[0])) {
+		return nil, errors.New("Invalid pipeline header: source buffer too small")
+	}
+
+	return NewByteFunctionPipeline(size, src[2:2+int(src[0])])
+}
+
+func pipelineHeaderSize(stages int) int {
+	return 2 + stages + 4*(stages+1)
+}
+
+// Return a copy of the function types of the stages
+func (this *ByteFunctionPipeline) Types() []byte {
+	res := make([]byte, len(this.types))
+	copy(res, this.types)
+	return res
+}
+
+func (this *ByteFunctionPipeline) Size() uint {
+	return this.size
+}
+
+func (this *ByteFunctionPipeline) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+// EG. "BWT+MTF|ZRLT|RLT"
+func (this *ByteFunctionPipeline) String() string {
+	names := make([]string, len(this.types))
+
+	for i, t := range this.types {
+		names[i] = GetByteFunctionName(t)
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Apply one stage to src, return the output (src itself if the stage failed)
+func forwardStage(functionType byte, src []byte) ([]byte, bool) {
+	length := len(src)
+
+	if length == 0 {
+		return src, false
+	}
+
+	fn, err := NewByteFunction(uint(length), functionType)
+
+	if err != nil {
+		return src, false
+	}
+
+	// The stage may expand the data: size the output with the bound or, if
+	// unknown, with a margin then grow it as reported by the function
+	bufSize := fn.MaxEncodedLen(length)
+
+	if bufSize < length {
+		bufSize = length + length>>2 + 64
+	}
+
+	// Some functions modify their input: work on a copy to bypass the stage
+	// on failure
+	work := make([]byte, length)
+
+	for retry := 0; retry < 2; retry++ {
+		copy(work, src)
+		dst := make([]byte, bufSize)
+		iIdx, oIdx, err := fn.Forward(work, dst)
+
+		if err == nil {
+			if int(iIdx) != length {
+				break
+			}
+
+			return dst[0:oIdx], true
+		}
+
+		e, ok := err.(*BufferTooSmallError)
+
+		if ok == false || int(e.Required()) <= bufSize {
+			break
+		}
+
+		bufSize = int(e.Required())
+
+		if fn, err = NewByteFunction(uint(length), functionType); err != nil {
+			break
+		}
+	}
+
+	return src, false
+}
+
+func (this *ByteFunctionPipeline) Forward(src, dst []byte) (uint, uint, error) {
+	if src == nil {
+		return 0, 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, 0, errors.New("Invalid null destination buffer")
+	}
+
+	if kanzi.SameByteSlices(src, dst, false) {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := this.size
+
+	if length == 0 {
+		length = uint(len(src))
+	} else if length > uint(len(src)) {
+		return 0, 0, fmt.Errorf("Block size is %v, input buffer length is %v", length, len(src))
+	}
+
+	if uint64(length) > 0xFFFFFFFF {
+		return 0, 0, fmt.Errorf("Block size is %v, max value is %v", length, uint64(0xFFFFFFFF))
+	}
+
+	stages := len(this.types)
+	sizes := make([]int, stages+1)
+	skipped := byte(0)
+	data := src[0:length]
+
+	for i, t := range this.types {
+		sizes[i] = len(data)
+		out, ok := forwardStage(t, data)
+
+		if ok == false {
+			skipped |= byte(1 << uint(i))
+		}
+
+		data = out
+	}
+
+	sizes[stages] = len(data)
+	headerSize := pipelineHeaderSize(stages)
+	required := uint(headerSize + len(data))
+
+	if required > uint(len(dst)) {
+		return 0, 0, NewBufferTooSmallError("Output buffer is too small", required, uint(len(dst)))
+	}
+
+	dst[0] = byte(stages)
+	dst[1] = skipped
+	copy(dst[2:], this.types)
+	idx := 2 + stages
+
+	for _, sz := range sizes {
+		dst[idx] = byte(sz >> 24)
+		dst[idx+1] = byte(sz >> 16)
+		dst[idx+2] = byte(sz >> 8)
+		dst[idx+3] = byte(sz)
+		idx += 4
+	}
+
+	copy(dst[idx:], data)
+	return length, required, nil
+}
+
+func (this *ByteFunctionPipeline) Inverse(src, dst []byte) (uint, uint, error) {
+	if src == nil {
+		return 0, 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, 0, errors.New("Invalid null destination buffer")
+	}
+
+	if kanzi.SameByteSlices(src, dst, false) {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := this.size
+
+	if length == 0 {
+		length = uint(len(src))
+	} else if length > uint(len(src)) {
+		return 0, 0, fmt.Errorf("Block size is %v, input buffer length is %v", length, len(src))
+	}
+
+	stages := len(this.types)
+	headerSize := pipelineHeaderSize(stages)
+
+	if length < uint(headerSize) {
+		return 0, 0, errors.New("Invalid pipeline header: source buffer too small")
+	}
+
+	if int(src[0]) != stages {
+		return 0, 0, fmt.Errorf("Invalid pipeline header: %v stages, expected %v", src[0], stages)
+	}
+
+	if string(src[2:2+stages]) != string(this.types) {
+		return 0, 0, fmt.Errorf("Invalid pipeline header: recipe %v does not match %v", src[2:2+stages], this.types)
+	}
+
+	skipped := src[1]
+	sizes := make([]int, stages+1)
+	idx := 2 + stages
+
+	for i := range sizes {
+		sizes[i] = int(src[idx])<<24 | int(src[idx+1])<<16 | int(src[idx+2])<<8 | int(src[idx+3])
+		idx += 4
+
+		// Sizes above 2^31-1 overflow on 32 bit platforms
+		if sizes[i] < 0 {
+			return 0, 0, fmt.Errorf("Invalid pipeline header: size of stage %v", i)
+		}
+	}
+
+	if sizes[0] > len(dst) {
+		return 0, 0, NewBufferTooSmallError("Output buffer is too small", uint(sizes[0]), uint(len(dst)))
+	}
+
+	// Check the sizes before any allocation: the output of each stage is at
+	// most the bound of the stage applied to its input
+	for i := 0; i < stages; i++ {
+		bound := stageMaxEncodedLen(this.types[i], sizes[i])
+
+		if bound < 0 || sizes[i+1] > bound {
+			return 0, 0, fmt.Errorf("Invalid pipeline header: size of stage %v is %v, max is %v", i+1, sizes[i+1], bound)
+		}
+	}
+
+	if uint(headerSize+sizes[stages]) > length {
+		return 0, 0, fmt.Errorf("Invalid pipeline header: data size is %v, available %v", sizes[stages], int(length)-headerSize)
+	}
+
+	data := src[headerSize : headerSize+sizes[stages]]
+
+	for i := stages - 1; i >= 0; i-- {
+		if skipped&byte(1<<uint(i)) != 0 {
+			if sizes[i] != sizes[i+1] {
+				return 0, 0, fmt.Errorf("Invalid pipeline header: size of bypassed stage %v", i)
+			}
+
+			continue
+		}
+
+		fn, err := NewByteFunction(uint(sizes[i+1]), this.types[i])
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		// The inverse function may use its input buffer as scratch space
+		bufSize := sizes[i+1]
+
+		if bufSize < sizes[i] {
+			bufSize = sizes[i]
+		}
+
+		buf := make([]byte, bufSize)
+		copy(buf, data)
+		out := make([]byte, sizes[i])
+		_, oIdx, err := fn.Inverse(buf, out)
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("Stage %v (%v): %v", i, GetByteFunctionName(this.types[i]), err)
+		}
+
+		if int(oIdx) != sizes[i] {
+			return 0, 0, fmt.Errorf("Stage %v (%v): decoded %v bytes, expected %v", i,
+				GetByteFunctionName(this.types[i]), oIdx, sizes[i])
+		}
+
+		data = out
+	}
+
+	copy(dst, data)
+	return uint(headerSize + sizes[stages]), uint(sizes[0]), nil
+}
+
+// Return the max output size of one stage (-1 if unknown)
+func stageMaxEncodedLen(functionType byte, srcLen int) int {
+	fn, err := NewByteFunction(uint(srcLen), functionType)
+
+	if err != nil {
+		return -1
+	}
+
+	bound := fn.MaxEncodedLen(srcLen)
+
+	if bound < 0 {
+		return -1
+	}
+
+	// A bypassed stage leaves the data unchanged
+	if bound < srcLen {
+		return srcLen
+	}
+
+	return bound
+}
+
+// Return -1 if the bound of any stage is unknown
+func (this *ByteFunctionPipeline) MaxEncodedLen(srcLen int) int {
+	res := srcLen
+
+	for _, t := range this.types {
+		if res = stageMaxEncodedLen(t, res); res < 0 {
+			return -1
+		}
+	}
+
+	return pipelineHeaderSize(len(this.types)) + res
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+// Reverse the order of the bytes within fixed size words (2, 4 or 8 bytes).
+// Useful to change the endianness of arrays of multi-byte integers before a
+// transform that expects the most significant bytes first (EG. a delta).
+// Trailing bytes that do not fill a complete word are copied unchanged.
+// The transform is its own inverse.
+// EG. word size 4
+//  input: 0x01 0x02 0x03 0x04 0x05 0x06 0x07 0x08 0x09 0x0A
+// output: 0x04 0x03 0x02 0x01 0x08 0x07 0x06 0x05 0x09 0x0A
+
+import (
+	"errors"
+)
+
+type ByteSwap struct {
+	size     uint
+	wordSize uint
+}
+
+func NewByteSwap(sz, wordSize uint) (*ByteSwap, error) {
+	if wordSize != 2 && wordSize != 4 && wordSize != 8 {
+		return nil, errors.New("Invalid word size parameter (must be 2, 4 or 8)")
+	}
+
+	this := new(ByteSwap)
+	this.size = sz
+	this.wordSize = wordSize
+	return this, nil
+}
+
+func (this *ByteSwap) Size() uint {
+	return this.size
+}
+
+func (this *ByteSwap) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *ByteSwap) WordSize() uint {
+	return this.wordSize
+}
+
+func (this *ByteSwap) swap(src, dst []byte) (uint, uint, error) {
+	if src == nil {
+		return uint(0), uint(0), errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+	}
+
+	length := uint(len(src))
+
+	if this.size > 0 {
+		length = this.size
+
+		if length > uint(len(src)) {
+			return uint(0), uint(0), errors.New("Source buffer too small")
+		}
+	}
+
+	if length > uint(len(dst)) {
+		return uint(0), uint(0), NewBufferTooSmallError("Destination buffer too small", length, uint(len(dst)))
+	}
+
+	w := this.wordSize
+	end := length - length%w
+
+	for i := uint(0); i < end; i += w {
+		// Support in place swap (src == dst)
+		for j, k := i, i+w-1; j <= k; j, k = j+1, k-1 {
+			dst[j], dst[k] = src[k], src[j]
+		}
+	}
+
+	// Trailing partial word
+	copy(dst[end:length], src[end:length])
+	return length, length, nil
+}
+
+func (this *ByteSwap) Forward(src, dst []byte) (uint, uint, error) {
+	return this.swap(src, dst)
+}
+
+func (this *ByteSwap) Inverse(src, dst []byte) (uint, uint, error) {
+	return this.swap(src, dst)
+}
+
+func (this ByteSwap) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
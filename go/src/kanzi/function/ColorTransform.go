@@ -0,0 +1,144 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+// Reversible color space decorrelation of RGB pixels (3 bytes per pixel):
+// integer lifting YCoCg-R computed modulo 256 so that each channel still
+// fits in a byte.
+//   Co = R - B
+//   t  = B + (Co >> 1)
+//   Cg = G - t
+//   Y  = t + (Cg >> 1)
+// Co and Cg are taken as signed bytes for the shifts (large differences wrap
+// around). The inverse undoes the lifting steps in reverse order, hence is
+// exact for any input.
+// The luma (Y) carries most of the information, Co and Cg are close to 0 on
+// natural images. Output pixels are Y Co Cg. Trailing bytes that do not fill
+// a complete pixel are copied unchanged.
+// Use Columnar (record size 3) afterwards to get planes.
+// EG.
+//  input: 120 100 80 (R G B)
+// output: 100 40 0 (Y Co Cg)
+
+import (
+	"errors"
+	"kanzi"
+)
+
+type ColorTransform struct {
+	size uint
+}
+
+func NewColorTransform(sz uint) (*ColorTransform, error) {
+	this := new(ColorTransform)
+	this.size = sz
+	return this, nil
+}
+
+func (this *ColorTransform) Size() uint {
+	return this.size
+}
+
+func (this *ColorTransform) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+// Return the number of bytes to process
+func (this *ColorTransform) checkBuffers(src, dst []byte) (uint, error) {
+	if src == nil {
+		return 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := uint(len(src))
+
+	if this.size > 0 {
+		length = this.size
+
+		if length > uint(len(src)) {
+			return 0, errors.New("Source buffer too small")
+		}
+	}
+
+	if length > uint(len(dst)) {
+		return 0, NewBufferTooSmallError("Destination buffer too small", length, uint(len(dst)))
+	}
+
+	return length, nil
+}
+
+func (this *ColorTransform) Forward(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end := length - length%3
+
+	for i := uint(0); i < end; i += 3 {
+		r := src[i]
+		g := src[i+1]
+		b := src[i+2]
+		co := r - b
+		t := b + byte(int8(co)>>1)
+		cg := g - t
+		dst[i] = t + byte(int8(cg)>>1)
+		dst[i+1] = co
+		dst[i+2] = cg
+	}
+
+	// Trailing partial pixel
+	copy(dst[end:length], src[end:length])
+	return length, length, nil
+}
+
+func (this *ColorTransform) Inverse(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end := length - length%3
+
+	for i := uint(0); i < end; i += 3 {
+		y := src[i]
+		co := src[i+1]
+		cg := src[i+2]
+		t := y - byte(int8(cg)>>1)
+		b := t - byte(int8(co)>>1)
+		dst[i] = b + co
+		dst[i+1] = cg + t
+		dst[i+2] = b
+	}
+
+	// Trailing partial pixel
+	copy(dst[end:length], src[end:length])
+	return length, length, nil
+}
+
+func (this ColorTransform) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
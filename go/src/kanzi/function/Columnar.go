@@ -0,0 +1,149 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+// Reorganize an array of fixed size records (array of structs) into columns
+// (struct of arrays): byte i of every record is gathered in column i. Similar
+// fields end up next to each other which helps the following stages.
+// Trailing bytes that do not fill a complete record are copied unchanged.
+// EG. record size 3
+//  input: a0 b0 c0 a1 b1 c1 a2 b2 c2 x y
+// output: a0 a1 a2 b0 b1 b2 c0 c1 c2 x y
+
+import (
+	"errors"
+	"kanzi"
+)
+
+const (
+	MAX_COLUMNAR_RECORD_SIZE = 65536
+)
+
+type Columnar struct {
+	size       uint
+	recordSize uint
+}
+
+func NewColumnar(sz, recordSize uint) (*Columnar, error) {
+	if recordSize < 1 {
+		return nil, errors.New("Invalid record size parameter (must be at least 1)")
+	}
+
+	if recordSize > MAX_COLUMNAR_RECORD_SIZE {
+		return nil, errors.New("Invalid record size parameter (must be at most 65536)")
+	}
+
+	this := new(Columnar)
+	this.size = sz
+	this.recordSize = recordSize
+	return this, nil
+}
+
+func (this *Columnar) Size() uint {
+	return this.size
+}
+
+func (this *Columnar) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *Columnar) RecordSize() uint {
+	return this.recordSize
+}
+
+// Return the number of bytes to process
+func (this *Columnar) checkBuffers(src, dst []byte) (uint, error) {
+	if src == nil {
+		return 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := uint(len(src))
+
+	if this.size > 0 {
+		length = this.size
+
+		if length > uint(len(src)) {
+			return 0, errors.New("Source buffer too small")
+		}
+	}
+
+	if length > uint(len(dst)) {
+		return 0, NewBufferTooSmallError("Destination buffer too small", length, uint(len(dst)))
+	}
+
+	return length, nil
+}
+
+func (this *Columnar) Forward(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rs := this.recordSize
+	records := length / rs
+	end := records * rs
+	dstIdx := uint(0)
+
+	for col := uint(0); col < rs; col++ {
+		for srcIdx := col; srcIdx < end; srcIdx += rs {
+			dst[dstIdx] = src[srcIdx]
+			dstIdx++
+		}
+	}
+
+	// Trailing partial record
+	copy(dst[end:length], src[end:length])
+	return length, length, nil
+}
+
+func (this *Columnar) Inverse(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rs := this.recordSize
+	records := length / rs
+	end := records * rs
+	srcIdx := uint(0)
+
+	for col := uint(0); col < rs; col++ {
+		for dstIdx := col; dstIdx < end; dstIdx += rs {
+			dst[dstIdx] = src[srcIdx]
+			srcIdx++
+		}
+	}
+
+	// Trailing partial record
+	copy(dst[end:length], src[end:length])
+	return length, length, nil
+}
+
+func (this Columnar) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
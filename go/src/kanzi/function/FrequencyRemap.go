@@ -0,0 +1,214 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"kanzi"
+	"sort"
+)
+
+// Static remapping of the most frequent byte values to the lowest values.
+// The (at most topK) most frequent symbols of the block are mapped to
+// 0..count-1 by decreasing frequency (ties broken by increasing symbol value)
+// and the other symbols are mapped to count..255 by increasing value. The
+// mapping is a permutation: it concentrates the data on low values which
+// helps ZRLT and bit oriented entropy coders.
+// Header: 1 byte (count), count bytes (the symbols by rank)
+// EG. topK = 2
+//  input:  "xxyxzy" (x:3, y:2, z:1)
+// output:  2 'x' 'y' 0 0 1 0 'z' 1
+// ('z' is unchanged: 'x' and 'y' are the only symbols below it moved to 0, 1)
+
+const (
+	DEFAULT_REMAP_SYMBOLS = 32
+	MAX_REMAP_SYMBOLS     = 255
+)
+
+type FrequencyRemap struct {
+	size uint
+	topK int
+}
+
+func NewFrequencyRemap(sz uint, topK uint) (*FrequencyRemap, error) {
+	if topK < 1 || topK > MAX_REMAP_SYMBOLS {
+		return nil, errors.New("Invalid number of remapped symbols (must be in [1..255])")
+	}
+
+	this := new(FrequencyRemap)
+	this.size = sz
+	this.topK = int(topK)
+	return this, nil
+}
+
+func (this *FrequencyRemap) Size() uint {
+	return this.size
+}
+
+func (this *FrequencyRemap) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *FrequencyRemap) checkBuffers(src, dst []byte) (int, error) {
+	if src == nil {
+		return 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := len(src)
+
+	if this.size > 0 {
+		length = int(this.size)
+
+		if length > len(src) {
+			return 0, errors.New("Source buffer too small")
+		}
+	}
+
+	return length, nil
+}
+
+// Fill 'mapping' (rank -> symbol): ranked symbols first, then the other
+// symbols by increasing value. Fail if a ranked symbol appears twice.
+func buildRemapTable(ranked []byte, mapping []byte) error {
+	used := [256]bool{}
+
+	for i, s := range ranked {
+		if used[s] == true {
+			return errors.New("Invalid remap table: duplicate symbol")
+		}
+
+		used[s] = true
+		mapping[i] = s
+	}
+
+	n := len(ranked)
+
+	for s := 0; s < 256; s++ {
+		if used[s] == false {
+			mapping[n] = byte(s)
+			n++
+		}
+	}
+
+	return nil
+}
+
+func (this *FrequencyRemap) Forward(src, dst []byte) (uint, uint, error) {
+	srcEnd, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	freqs := [256]int{}
+
+	for _, b := range src[0:srcEnd] {
+		freqs[b]++
+	}
+
+	symbols := make([]int, 0, 256)
+
+	for s := range freqs {
+		if freqs[s] > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+
+	sort.SliceStable(symbols, func(i, j int) bool { return freqs[symbols[i]] > freqs[symbols[j]] })
+
+	if len(symbols) > this.topK {
+		symbols = symbols[0:this.topK]
+	}
+
+	count := len(symbols)
+
+	if 1+count+srcEnd > len(dst) {
+		return 0, 0, NewBufferTooSmallError("Output buffer too small", uint(1+count+srcEnd), uint(len(dst)))
+	}
+
+	dst[0] = byte(count)
+
+	for i, s := range symbols {
+		dst[1+i] = byte(s)
+	}
+
+	mapping := [256]byte{}
+	buildRemapTable(dst[1:1+count], mapping[:])
+	ranks := [256]byte{}
+
+	for r, s := range mapping {
+		ranks[s] = byte(r)
+	}
+
+	dstIdx := 1 + count
+
+	for _, b := range src[0:srcEnd] {
+		dst[dstIdx] = ranks[b]
+		dstIdx++
+	}
+
+	return uint(srcEnd), uint(dstIdx), nil
+}
+
+func (this *FrequencyRemap) Inverse(src, dst []byte) (uint, uint, error) {
+	srcEnd, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if srcEnd == 0 {
+		return 0, 0, nil
+	}
+
+	count := int(src[0])
+
+	if 1+count > srcEnd {
+		return 0, 0, errors.New("Invalid truncated remap table")
+	}
+
+	if srcEnd-1-count > len(dst) {
+		return 0, 0, NewBufferTooSmallError("Output buffer too small", uint(srcEnd-1-count), uint(len(dst)))
+	}
+
+	mapping := [256]byte{}
+
+	if err := buildRemapTable(src[1:1+count], mapping[:]); err != nil {
+		return 0, 0, err
+	}
+
+	dstIdx := 0
+
+	for _, b := range src[1+count : srcEnd] {
+		dst[dstIdx] = mapping[b]
+		dstIdx++
+	}
+
+	return uint(srcEnd), uint(dstIdx), nil
+}
+
+func (this FrequencyRemap) MaxEncodedLen(srcLen int) int {
+	return srcLen + 1 + this.topK
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"kanzi"
+)
+
+// Reversible Haar wavelet on bytes (integer lifting, S-transform modulo 256).
+// For each pair (a, b):
+//   d = b - a (mod 256)
+//   s = a + (d >> 1) (mod 256, d taken as a signed byte)
+// s is the (rounded down) average of a and b when |b - a| < 128, d is the
+// difference, stored zigzag coded (0, -1, 1, -2 ... => 0, 1, 2, 3 ...).
+// The inverse is exact: a = s - (d >> 1), b = a + d (mod 256).
+// Each level stores the averages in the first half of the block (followed
+// by the last byte if the length is odd) and the differences in the second
+// half. The next level transforms the first half again.
+// On smooth data, the differences are small values close to 0.
+// EG. 1 level
+//  input:  10 12 13 13 20
+// output:  11 13 20 4 0 (averages 11 13, last byte 20, differences 2 0)
+
+const (
+	DEFAULT_HAAR_LEVELS = 3
+	MAX_HAAR_LEVELS     = 16
+)
+
+type HaarByte struct {
+	size   uint
+	levels uint
+	buffer []byte
+}
+
+func NewHaarByte(sz uint, levels uint) (*HaarByte, error) {
+	if levels < 1 || levels > MAX_HAAR_LEVELS {
+		return nil, errors.New("Invalid number of levels parameter (must be in [1..16])")
+	}
+
+	this := new(HaarByte)
+	this.size = sz
+	this.levels = levels
+	this.buffer = make([]byte, 0)
+	return this, nil
+}
+
+func (this *HaarByte) Size() uint {
+	return this.size
+}
+
+func (this *HaarByte) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *HaarByte) Levels() uint {
+	return this.levels
+}
+
+func (this *HaarByte) checkBuffers(src, dst []byte) (int, error) {
+	if src == nil {
+		return 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := len(src)
+
+	if this.size > 0 {
+		length = int(this.size)
+
+		if length > len(src) {
+			return 0, errors.New("Source buffer too small")
+		}
+	}
+
+	if length > len(dst) {
+		return 0, NewBufferTooSmallError("Destination buffer too small", uint(length), uint(len(dst)))
+	}
+
+	if len(this.buffer) < length {
+		this.buffer = make([]byte, length)
+	}
+
+	return length, nil
+}
+
+func (this *HaarByte) Forward(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	copy(dst, src[0:length])
+	diffs := this.buffer
+
+	for level, n := uint(0), length; level < this.levels && n >= 2; level++ {
+		half := n >> 1
+		odd := n & 1
+
+		// Averages in place (index i is written after 2i and 2i+1 are read)
+		for i := 0; i < half; i++ {
+			a := dst[2*i]
+			d := dst[2*i+1] - a
+			dst[i] = a + byte(int8(d)>>1)
+			diffs[i] = byte((int8(d) << 1) ^ (int8(d) >> 7))
+		}
+
+		if odd == 1 {
+			dst[half] = dst[n-1]
+		}
+
+		copy(dst[half+odd:n], diffs[0:half])
+		n = half + odd
+	}
+
+	return uint(length), uint(length), nil
+}
+
+func (this *HaarByte) Inverse(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	copy(dst, src[0:length])
+	sizes := make([]int, 0, this.levels)
+
+	// Input length of each level
+	for level, n := uint(0), length; level < this.levels && n >= 2; level++ {
+		sizes = append(sizes, n)
+		n = (n >> 1) + (n & 1)
+	}
+
+	buf := this.buffer
+
+	for l := len(sizes) - 1; l >= 0; l-- {
+		n := sizes[l]
+		half := n >> 1
+		odd := n & 1
+		diffs := dst[half+odd : n]
+
+		for i := 0; i < half; i++ {
+			z := diffs[i]
+			d := byte(int8(z>>1) ^ -int8(z&1))
+			a := dst[i] - byte(int8(d)>>1)
+			buf[2*i] = a
+			buf[2*i+1] = a + d
+		}
+
+		if odd == 1 {
+			buf[n-1] = dst[half]
+		}
+
+		copy(dst[0:n], buf[0:n])
+	}
+
+	return uint(length), uint(length), nil
+}
+
+func (this HaarByte) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
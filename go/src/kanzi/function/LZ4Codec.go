@@ -167,7 +167,8 @@ func (this *LZ4Codec) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if n := this.MaxEncodedLen(count); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		errMsg := fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, NewBufferTooSmallError(errMsg, uint(n), uint(len(dst)))
 	}
 
 	if count < MIN_LENGTH {
@@ -0,0 +1,400 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+// LZ77 transform with a hash chain match finder, meant to be followed by an
+// entropy coder (range, Huffman). The positions of the last 2^logWindow bytes
+// are chained by hash of their first 4 bytes (2^logHash chain heads); up to
+// LZ_MAX_CHAIN candidates are compared to find the longest match.
+// The output is a list of sequences: a token (literal count on the 4 msb,
+// match length-LZ_MIN_MATCH on the 4 lsb, 15 means more bytes follow:
+// 255 ... 255 n), the literals, then the match distance as a varint (7 bits
+// per byte, lsb first) and the rest of the match length. The last sequence
+// has literals only (the input ends after its literals).
+// EG. input: "abcdabcdabcd" => output: 0x44 "abcd" 0x04 0x00 (4 literals,
+// match of 8 bytes at distance 4, 0 literal left)
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"kanzi"
+)
+
+const (
+	LZ_MIN_MATCH          = 4
+	LZ_MAX_CHAIN          = 32 // candidates compared per position
+	DEFAULT_LZ_LOG_WINDOW = 16
+	DEFAULT_LZ_LOG_HASH   = 15
+)
+
+type LZCodec struct {
+	size      uint
+	logWindow uint
+	logHash   uint
+	heads     []int32 // hash -> last position + 1 (0 means empty)
+	chain     []int32 // position in window -> previous position + 1
+}
+
+// The window (maximum match distance) is 2^logWindow bytes, in [10..24]. The
+// hash table has 2^logHash entries, in [8..20]. Only the forward transform
+// uses them: the inverse works with any window.
+func NewLZCodec(sz uint, logWindow, logHash uint) (*LZCodec, error) {
+	if logWindow < 10 || logWindow > 24 {
+		return nil, fmt.Errorf("Invalid window parameter: %v (must be in [10..24])", logWindow)
+	}
+
+	if logHash < 8 || logHash > 20 {
+		return nil, fmt.Errorf("Invalid hash parameter: %v (must be in [8..20])", logHash)
+	}
+
+	this := new(LZCodec)
+	this.size = sz
+	this.logWindow = logWindow
+	this.logHash = logHash
+	return this, nil
+}
+
+func (this *LZCodec) Size() uint {
+	return this.size
+}
+
+func (this *LZCodec) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *LZCodec) LogWindow() uint {
+	return this.logWindow
+}
+
+func (this *LZCodec) LogHash() uint {
+	return this.logHash
+}
+
+func (this *LZCodec) hash(src []byte, idx int) uint32 {
+	return (binary.LittleEndian.Uint32(src[idx:]) * HASH_SEED) >> (32 - this.logHash)
+}
+
+// Write the part of a length above 14 (the token holds 15): 255 per byte
+// until the last byte
+func writeLZLength(dst []byte, length int) int {
+	idx := 0
+
+	for length >= 255 {
+		dst[idx] = 255
+		length -= 255
+		idx++
+	}
+
+	dst[idx] = byte(length)
+	return idx + 1
+}
+
+func readLZLength(src []byte, srcIdx int) (int, int, error) {
+	length := 0
+
+	for {
+		if srcIdx >= len(src) {
+			return 0, srcIdx, errors.New("Invalid truncated length")
+		}
+
+		b := int(src[srcIdx])
+		srcIdx++
+		length += b
+
+		if b != 255 {
+			return length, srcIdx, nil
+		}
+	}
+}
+
+func uvarintSize(val int) int {
+	res := 1
+
+	for val >= 0x80 {
+		val >>= 7
+		res++
+	}
+
+	return res
+}
+
+// Write a sequence (literals, then a match if length > 0) and return the new
+// output index
+func writeLZSequence(dst []byte, dstIdx int, literals []byte, distance, length int) int {
+	litToken := len(literals)
+	matchToken := 0
+
+	if litToken > 15 {
+		litToken = 15
+	}
+
+	if length > 0 {
+		matchToken = length - LZ_MIN_MATCH
+
+		if matchToken > 15 {
+			matchToken = 15
+		}
+	}
+
+	dst[dstIdx] = byte(litToken<<4 | matchToken)
+	dstIdx++
+
+	if litToken == 15 {
+		dstIdx += writeLZLength(dst[dstIdx:], len(literals)-15)
+	}
+
+	dstIdx += copy(dst[dstIdx:], literals)
+
+	if length == 0 {
+		return dstIdx
+	}
+
+	dstIdx += binary.PutUvarint(dst[dstIdx:], uint64(distance))
+
+	if matchToken == 15 {
+		dstIdx += writeLZLength(dst[dstIdx:], length-LZ_MIN_MATCH-15)
+	}
+
+	return dstIdx
+}
+
+func (this *LZCodec) Forward(src, dst []byte) (uint, uint, error) {
+	if src == nil {
+		return uint(0), uint(0), errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := int(this.size)
+
+	if this.size == 0 {
+		count = len(src)
+	}
+
+	if count > len(src) {
+		return 0, 0, errors.New("Invalid size (larger than the input buffer)")
+	}
+
+	if count == 0 {
+		return 0, 0, nil
+	}
+
+	if n := this.MaxEncodedLen(count); len(dst) < n {
+		errMsg := fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, NewBufferTooSmallError(errMsg, uint(n), uint(len(dst)))
+	}
+
+	if len(this.heads) != 1<<this.logHash {
+		this.heads = make([]int32, 1<<this.logHash)
+	} else {
+		for i := range this.heads {
+			this.heads[i] = 0
+		}
+	}
+
+	if len(this.chain) != 1<<this.logWindow {
+		this.chain = make([]int32, 1<<this.logWindow)
+	}
+
+	heads := this.heads
+	chain := this.chain
+	windowMask := (1 << this.logWindow) - 1
+	maxDistance := windowMask
+	anchor := 0
+	dstIdx := 0
+	srcIdx := 0
+
+	// The last match ends at most at count (4 bytes read by the hash)
+	for srcIdx+LZ_MIN_MATCH <= count {
+		h := this.hash(src, srcIdx)
+		bestLength := 0
+		bestDistance := 0
+		candidate := int(heads[h]) - 1
+
+		for attempts := 0; candidate >= 0 && srcIdx-candidate <= maxDistance && attempts < LZ_MAX_CHAIN; attempts++ {
+			// Check the byte after the best length first
+			if src[candidate+bestLength] == src[srcIdx+bestLength] {
+				length := 0
+
+				for srcIdx+length < count && src[candidate+length] == src[srcIdx+length] {
+					length++
+				}
+
+				if length > bestLength {
+					bestLength = length
+					bestDistance = srcIdx - candidate
+
+					if srcIdx+length == count {
+						break
+					}
+				}
+			}
+
+			prev := int(chain[candidate&windowMask]) - 1
+
+			if prev >= candidate {
+				break
+			}
+
+			candidate = prev
+		}
+
+		chain[srcIdx&windowMask] = heads[h]
+		heads[h] = int32(srcIdx + 1)
+
+		// A match must save at least one byte (a far short match would expand
+		// the data, and MaxEncodedLen only accounts for literals)
+		if bestLength < LZ_MIN_MATCH || bestLength < 2+uvarintSize(bestDistance) {
+			srcIdx++
+			continue
+		}
+
+		dstIdx = writeLZSequence(dst, dstIdx, src[anchor:srcIdx], bestDistance, bestLength)
+		end := srcIdx + bestLength
+		srcIdx++
+
+		// Chain the positions inside the match
+		for ; srcIdx < end && srcIdx+LZ_MIN_MATCH <= count; srcIdx++ {
+			h = this.hash(src, srcIdx)
+			chain[srcIdx&windowMask] = heads[h]
+			heads[h] = int32(srcIdx + 1)
+		}
+
+		srcIdx = end
+		anchor = end
+	}
+
+	// Last literals
+	dstIdx = writeLZSequence(dst, dstIdx, src[anchor:count], 0, 0)
+	return uint(count), uint(dstIdx), nil
+}
+
+func (this *LZCodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if src == nil {
+		return uint(0), uint(0), errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := int(this.size)
+
+	if this.size == 0 {
+		count = len(src)
+	}
+
+	if count > len(src) {
+		return 0, 0, errors.New("Invalid size (larger than the input buffer)")
+	}
+
+	src = src[0:count]
+	srcIdx := 0
+	dstIdx := 0
+	var err error
+
+	for srcIdx < count {
+		token := int(src[srcIdx])
+		srcIdx++
+		literals := token >> 4
+		length := token & 0x0F
+
+		if literals == 15 {
+			var n int
+
+			if n, srcIdx, err = readLZLength(src, srcIdx); err != nil {
+				return uint(srcIdx), uint(dstIdx), err
+			}
+
+			literals += n
+		}
+
+		if srcIdx+literals > count {
+			return uint(srcIdx), uint(dstIdx), errors.New("Invalid truncated literals")
+		}
+
+		if dstIdx+literals > len(dst) {
+			return uint(srcIdx), uint(dstIdx), errors.New("Output buffer is too small")
+		}
+
+		dstIdx += copy(dst[dstIdx:], src[srcIdx:srcIdx+literals])
+		srcIdx += literals
+
+		if srcIdx == count {
+			// Last sequence
+			if length != 0 {
+				return uint(srcIdx), uint(dstIdx), errors.New("Invalid truncated match")
+			}
+
+			break
+		}
+
+		distance, n := binary.Uvarint(src[srcIdx:])
+
+		if n <= 0 {
+			return uint(srcIdx), uint(dstIdx), errors.New("Invalid match distance")
+		}
+
+		srcIdx += n
+		length += LZ_MIN_MATCH
+
+		if length == LZ_MIN_MATCH+15 {
+			var n int
+
+			if n, srcIdx, err = readLZLength(src, srcIdx); err != nil {
+				return uint(srcIdx), uint(dstIdx), err
+			}
+
+			length += n
+		}
+
+		if distance == 0 || distance > uint64(dstIdx) {
+			return uint(srcIdx), uint(dstIdx), fmt.Errorf("Invalid match distance: %v", distance)
+		}
+
+		if dstIdx+length > len(dst) {
+			return uint(srcIdx), uint(dstIdx), errors.New("Output buffer is too small")
+		}
+
+		// Byte per byte: the match may overlap its own output
+		ref := dstIdx - int(distance)
+
+		for i := 0; i < length; i++ {
+			dst[dstIdx+i] = dst[ref+i]
+		}
+
+		dstIdx += length
+	}
+
+	return uint(srcIdx), uint(dstIdx), nil
+}
+
+// Worst case: literals only (token and length bytes)
+func (this LZCodec) MaxEncodedLen(srcLen int) int {
+	return srcLen + srcLen/255 + 16
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+// Replace lines identical to one of the recent lines with a back-reference.
+// A line ends with the delimiter (included). The dictionary holds the last
+// 'dictSize' lines (as positions in the block, no copy) and a hash table
+// indexes them. A reference is the escape byte followed by the distance
+// (in lines) to the identical line as a varint (7 bits per byte, lsb first,
+// first byte never 0). A literal line starting with the escape byte is
+// emitted as escape + 0x00 + remaining bytes of the line.
+// EG. delimiter '\n'
+//  input: "abcd\nefgh\nabcd\nabcd\n"
+// output: "abcd\nefgh\n" 0xFF 0x02 0xFF 0x01
+
+import (
+	"bytes"
+	"errors"
+	"kanzi"
+)
+
+const (
+	LINE_DEDUP_ESCAPE        = 0xFF
+	LINE_DEDUP_MIN_LENGTH    = 4 // shorter lines are never replaced
+	DEFAULT_LINE_DEDUP_DICT  = 4096
+	MAX_LINE_DEDUP_DICT_SIZE = 65536
+)
+
+type LineDedup struct {
+	size      uint
+	delimiter byte
+	dictSize  uint
+	starts    []int // ring buffer of line starts
+	lengths   []int // ring buffer of line lengths
+	hashes    []int // hash -> line number + 1 (0 means empty)
+	hashMask  uint32
+}
+
+func NewLineDedup(sz uint, delimiter byte, dictSize uint) (*LineDedup, error) {
+	if dictSize < 1 {
+		return nil, errors.New("Invalid dictionary size parameter (must be at least 1)")
+	}
+
+	if dictSize > MAX_LINE_DEDUP_DICT_SIZE {
+		return nil, errors.New("Invalid dictionary size parameter (must be at most 65536)")
+	}
+
+	if delimiter == LINE_DEDUP_ESCAPE {
+		return nil, errors.New("Invalid delimiter parameter (cannot be the escape byte 0xFF)")
+	}
+
+	this := new(LineDedup)
+	this.size = sz
+	this.delimiter = delimiter
+	this.dictSize = dictSize
+	this.starts = make([]int, dictSize)
+	this.lengths = make([]int, dictSize)
+	hashSize := uint(1)
+
+	for hashSize < 2*dictSize {
+		hashSize <<= 1
+	}
+
+	this.hashes = make([]int, hashSize)
+	this.hashMask = uint32(hashSize - 1)
+	return this, nil
+}
+
+func (this *LineDedup) Size() uint {
+	return this.size
+}
+
+func (this *LineDedup) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *LineDedup) Delimiter() byte {
+	return this.delimiter
+}
+
+func (this *LineDedup) DictionarySize() uint {
+	return this.dictSize
+}
+
+func (this *LineDedup) reset() {
+	for i := range this.hashes {
+		this.hashes[i] = 0
+	}
+}
+
+func hashLine(line []byte) uint32 {
+	h := uint32(2166136261)
+
+	for _, b := range line {
+		h = (h ^ uint32(b)) * 16777619
+	}
+
+	return h
+}
+
+// Return the end of the line starting at 'start' (delimiter included)
+func (this *LineDedup) lineEnd(buf []byte, start, end int) int {
+	for i := start; i < end; i++ {
+		if buf[i] == this.delimiter {
+			return i + 1
+		}
+	}
+
+	return end
+}
+
+func (this *LineDedup) checkBuffers(src, dst []byte) (int, error) {
+	if src == nil {
+		return 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := len(src)
+
+	if this.size > 0 {
+		length = int(this.size)
+
+		if length > len(src) {
+			return 0, errors.New("Source buffer too small")
+		}
+	}
+
+	return length, nil
+}
+
+func (this *LineDedup) Forward(src, dst []byte) (uint, uint, error) {
+	srcEnd, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	this.reset()
+	dictSize := this.dictSize
+	srcIdx := 0
+	dstIdx := 0
+	lineNo := uint(0)
+
+	for srcIdx < srcEnd {
+		end := this.lineEnd(src, srcIdx, srcEnd)
+		line := src[srcIdx:end]
+		length := end - srcIdx
+		hIdx := hashLine(line) & this.hashMask
+		distance := uint(0)
+
+		if length >= LINE_DEDUP_MIN_LENGTH {
+			if ref := this.hashes[hIdx]; ref > 0 && lineNo-uint(ref-1) <= dictSize {
+				slot := uint(ref-1) % dictSize
+
+				if this.lengths[slot] == length &&
+					bytes.Equal(src[this.starts[slot]:this.starts[slot]+length], line) {
+					distance = lineNo - uint(ref-1)
+				}
+			}
+		}
+
+		if distance > 0 {
+			// Back-reference
+			if dstIdx+4 > len(dst) {
+				return uint(srcIdx), uint(dstIdx), errors.New("Output buffer too small")
+			}
+
+			dst[dstIdx] = LINE_DEDUP_ESCAPE
+			dstIdx++
+
+			for distance >= 0x80 {
+				dst[dstIdx] = byte(0x80 | (distance & 0x7F))
+				dstIdx++
+				distance >>= 7
+			}
+
+			dst[dstIdx] = byte(distance)
+			dstIdx++
+		} else {
+			// Literal line
+			if dstIdx+length+2 > len(dst) {
+				return uint(srcIdx), uint(dstIdx), errors.New("Output buffer too small")
+			}
+
+			if line[0] == LINE_DEDUP_ESCAPE {
+				dst[dstIdx] = LINE_DEDUP_ESCAPE
+				dst[dstIdx+1] = 0
+				dstIdx += 2
+				line = line[1:]
+			}
+
+			dstIdx += copy(dst[dstIdx:], line)
+		}
+
+		// Register the line (most recent occurrence)
+		slot := lineNo % dictSize
+		this.starts[slot] = srcIdx
+		this.lengths[slot] = length
+		this.hashes[hIdx] = int(lineNo + 1)
+		lineNo++
+		srcIdx = end
+	}
+
+	return uint(srcIdx), uint(dstIdx), nil
+}
+
+func (this *LineDedup) Inverse(src, dst []byte) (uint, uint, error) {
+	srcEnd, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dictSize := this.dictSize
+	srcIdx := 0
+	dstIdx := 0
+	lineNo := uint(0)
+
+	for srcIdx < srcEnd {
+		start := dstIdx
+
+		if src[srcIdx] == LINE_DEDUP_ESCAPE {
+			srcIdx++
+
+			if srcIdx >= srcEnd {
+				return uint(srcIdx), uint(dstIdx), errors.New("Invalid truncated line reference")
+			}
+
+			if src[srcIdx] == 0 {
+				// Literal line starting with the escape byte
+				srcIdx++
+				end := this.lineEnd(src, srcIdx, srcEnd)
+
+				if dstIdx+1+end-srcIdx > len(dst) {
+					return uint(srcIdx), uint(dstIdx), errors.New("Output buffer too small")
+				}
+
+				dst[dstIdx] = LINE_DEDUP_ESCAPE
+				dstIdx++
+				dstIdx += copy(dst[dstIdx:], src[srcIdx:end])
+				srcIdx = end
+			} else {
+				// Back-reference
+				distance := uint(0)
+				shift := uint(0)
+
+				for srcIdx < srcEnd && src[srcIdx] >= 0x80 && shift < 21 {
+					distance |= uint(src[srcIdx]&0x7F) << shift
+					srcIdx++
+					shift += 7
+				}
+
+				if srcIdx >= srcEnd {
+					return uint(srcIdx), uint(dstIdx), errors.New("Invalid truncated line reference")
+				}
+
+				distance |= uint(src[srcIdx]) << shift
+				srcIdx++
+
+				if distance == 0 || distance > lineNo || distance > dictSize {
+					return uint(srcIdx), uint(dstIdx), errors.New("Invalid line reference")
+				}
+
+				slot := (lineNo - distance) % dictSize
+				length := this.lengths[slot]
+
+				if dstIdx+length > len(dst) {
+					return uint(srcIdx), uint(dstIdx), errors.New("Output buffer too small")
+				}
+
+				dstIdx += copy(dst[dstIdx:], dst[this.starts[slot]:this.starts[slot]+length])
+			}
+		} else {
+			// Literal line
+			end := this.lineEnd(src, srcIdx, srcEnd)
+
+			if dstIdx+end-srcIdx > len(dst) {
+				return uint(srcIdx), uint(dstIdx), errors.New("Output buffer too small")
+			}
+
+			dstIdx += copy(dst[dstIdx:], src[srcIdx:end])
+			srcIdx = end
+		}
+
+		slot := lineNo % dictSize
+		this.starts[slot] = start
+		this.lengths[slot] = dstIdx - start
+		lineNo++
+	}
+
+	return uint(srcIdx), uint(dstIdx), nil
+}
+
+// Worst case: every line is an escape byte followed by the delimiter
+func (this LineDedup) MaxEncodedLen(srcLen int) int {
+	return srcLen + (srcLen+1)/2
+}
@@ -54,7 +54,7 @@ func doCopy(src, dst []byte, sz uint) (uint, uint, error) {
 	}
 
 	if length > len(dst) {
-		return uint(0), uint(0), errors.New("Destination buffer too small")
+		return uint(0), uint(0), NewBufferTooSmallError("Destination buffer too small", uint(length), uint(len(dst)))
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) == false {
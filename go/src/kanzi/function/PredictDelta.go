@@ -0,0 +1,143 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"kanzi"
+)
+
+// Delta against a prediction: each byte is replaced by the difference (modulo
+// 256) between the byte and an exponential moving average of the previous
+// bytes. The average is kept in fixed point (8 fractional bits) and updated
+// with integer arithmetic only, hence the inverse replays exactly the same
+// predictions:
+//   prediction = round(avg)
+//   avg += (x - avg) / 2^shift (arithmetic shift)
+// With shift 0, the prediction is the previous byte (plain delta). Bigger
+// shifts average more bytes, which suits slowly drifting noisy data (EG.
+// sensor baselines) where the previous byte is a noisy predictor.
+// The average starts at 0.
+// EG. shift 1
+//  input:  100 102 101 103
+// output:  100 52 25 14 (predictions 0 50 76 89)
+
+const (
+	DEFAULT_PREDICT_DELTA_SHIFT = 2
+	MAX_PREDICT_DELTA_SHIFT     = 7
+	PREDICT_DELTA_PRECISION     = 8 // fractional bits of the average
+)
+
+type PredictDelta struct {
+	size  uint
+	shift uint
+}
+
+func NewPredictDelta(sz uint, shift uint) (*PredictDelta, error) {
+	if shift > MAX_PREDICT_DELTA_SHIFT {
+		return nil, errors.New("Invalid shift parameter (must be in [0..7])")
+	}
+
+	this := new(PredictDelta)
+	this.size = sz
+	this.shift = shift
+	return this, nil
+}
+
+func (this *PredictDelta) Size() uint {
+	return this.size
+}
+
+func (this *PredictDelta) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *PredictDelta) Shift() uint {
+	return this.shift
+}
+
+func (this *PredictDelta) checkBuffers(src, dst []byte) (int, error) {
+	if src == nil {
+		return 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := len(src)
+
+	if this.size > 0 {
+		length = int(this.size)
+
+		if length > len(src) {
+			return 0, errors.New("Source buffer too small")
+		}
+	}
+
+	if length > len(dst) {
+		return 0, NewBufferTooSmallError("Destination buffer too small", uint(length), uint(len(dst)))
+	}
+
+	return length, nil
+}
+
+func (this *PredictDelta) Forward(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	shift := this.shift
+	avg := int32(0)
+
+	for i := 0; i < length; i++ {
+		x := src[i]
+		dst[i] = x - byte((avg+(1<<(PREDICT_DELTA_PRECISION-1)))>>PREDICT_DELTA_PRECISION)
+		avg += ((int32(x) << PREDICT_DELTA_PRECISION) - avg) >> shift
+	}
+
+	return uint(length), uint(length), nil
+}
+
+func (this *PredictDelta) Inverse(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	shift := this.shift
+	avg := int32(0)
+
+	for i := 0; i < length; i++ {
+		x := src[i] + byte((avg+(1<<(PREDICT_DELTA_PRECISION-1)))>>PREDICT_DELTA_PRECISION)
+		dst[i] = x
+		avg += ((int32(x) << PREDICT_DELTA_PRECISION) - avg) >> shift
+	}
+
+	return uint(length), uint(length), nil
+}
+
+func (this PredictDelta) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
@@ -21,6 +21,9 @@ package function
 // For a run threshold of 2:
 // EG input: 0x10 0x11 0x11 0x17 0x13 0x13 0x13 0x13 0x13 0x13 0x12 (160 times) 0x14
 //   output: 0x10 0x11 0x11 0x17 0x13 0x13 0x13 0x05 0x12 0x12 0x80 0xA0 0x14
+// Runs longer than RLT_MAX_RUN+threshold are split in several runs.
+// Worst case: runs of exactly 'threshold' bytes, each followed by a length
+// byte of 0 (see MaxEncodedLen).
 
 import (
 	"errors"
@@ -70,7 +73,7 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 		return uint(0), uint(0), errors.New("Invalid null destination buffer")
 	}
 
-	if kanzi.SameByteSlices(src, dst, false) {
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
@@ -80,6 +83,10 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 		srcEnd = uint(len(src))
 	}
 
+	if srcEnd == 0 {
+		return 0, 0, nil
+	}
+
 	dstEnd := uint(len(dst))
 	run := 1
 	threshold := int(this.runThreshold)
@@ -90,22 +97,32 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 	// Initialize with a value different from the first data
 	prev := ^src[srcIdx]
 
-	for srcIdx < srcEnd && dstIdx < dstEnd {
+	for srcIdx < srcEnd {
 		val := byte(src[srcIdx])
-		srcIdx++
 
 		// Encode up to 0x7FFF repetitions in the 'length' information
 		if prev == val && run < maxThreshold {
-			run++
+			if run+1 < threshold {
+				if dstIdx >= dstEnd {
+					break
+				}
 
-			if run < threshold {
 				dst[dstIdx] = prev
 				dstIdx++
 			}
 
+			run++
+			srcIdx++
 			continue
 		}
 
+		// Room for the end of the run and the value
+		if dstIdx+rltRunEndLen(run, threshold)+1 > dstEnd {
+			break
+		}
+
+		srcIdx++
+
 		if run >= threshold {
 			dst[dstIdx] = prev
 			dstIdx++
@@ -131,6 +148,11 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 		}
 	}
 
+	if srcIdx < srcEnd || dstIdx+rltRunEndLen(run, threshold) > dstEnd {
+		return srcIdx, dstIdx, NewBufferTooSmallError("Output buffer is too small",
+			uint(this.MaxEncodedLen(int(srcEnd))), dstEnd)
+	}
+
 	// Fill up the destination array
 	if run >= threshold {
 		dst[dstIdx] = prev
@@ -150,6 +172,20 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 	return srcIdx, dstIdx, nil
 }
 
+// Return the number of bytes written to end a run: the last byte of the run
+// and 1 or 2 length bytes (0 if the run is shorter than the threshold)
+func rltRunEndLen(run, threshold int) uint {
+	if run < threshold {
+		return 0
+	}
+
+	if run-threshold >= TWO_BYTE_RLE_MASK {
+		return 3
+	}
+
+	return 2
+}
+
 func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 	if src == nil {
 		return uint(0), uint(0), errors.New("Invalid null source buffer")
@@ -159,7 +195,7 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 		return uint(0), uint(0), errors.New("Invalid null destination buffer")
 	}
 
-	if kanzi.SameByteSlices(src, dst, false) {
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
@@ -169,6 +205,10 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 		srcEnd = uint(len(src))
 	}
 
+	if srcEnd == 0 {
+		return 0, 0, nil
+	}
+
 	dstEnd := uint(len(dst))
 	run := 0
 	threshold := int(this.runThreshold)
@@ -186,16 +226,28 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 			run++
 
 			if run >= threshold {
+				if srcIdx >= srcEnd {
+					return srcIdx, dstIdx, errors.New("Invalid truncated run length")
+				}
+
 				// Read the length
 				run = int(src[srcIdx])
 				srcIdx++
 
 				// If the length is encoded in 2 bytes, process next byte
 				if run&TWO_BYTE_RLE_MASK != 0 {
+					if srcIdx >= srcEnd {
+						return srcIdx, dstIdx, errors.New("Invalid truncated run length")
+					}
+
 					run = ((run & (^TWO_BYTE_RLE_MASK)) << 8) | int(src[srcIdx])
 					srcIdx++
 				}
 
+				if dstIdx+uint(run) >= dstEnd {
+					return srcIdx, dstIdx, errors.New("Output buffer is too small")
+				}
+
 				// Emit length times the previous byte
 				for run > 0 {
 					dst[dstIdx] = prev
@@ -215,7 +267,7 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 	return srcIdx, dstIdx, nil
 }
 
-// Required encoding output buffer size unknown
+// One length byte per run of 'threshold' bytes at most
 func (this RLT) MaxEncodedLen(srcLen int) int {
-	return -1
+	return srcLen + srcLen/int(this.runThreshold)
 }
@@ -65,7 +65,8 @@ func (this *SnappyCodec) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if n := snappy.MaxEncodedLen(int(count)); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		errMsg := fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, NewBufferTooSmallError(errMsg, uint(n), uint(len(dst)))
 	}
 
 	res, err := snappy.Encode(dst, src[0:count])
@@ -105,7 +106,8 @@ func (this *SnappyCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	if len(res) > len(dst) {
 		// Encode returns a newly allocated slice if the provided 'dst' array is too small.
 		// There is no way to return this new slice, so treat it as an error
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(res), len(dst))
+		errMsg := fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), len(res))
+		return 0, 0, NewBufferTooSmallError(errMsg, uint(len(res)), uint(len(dst)))
 	}
 
 	return count, uint(len(res)), nil
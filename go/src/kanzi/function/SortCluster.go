@@ -0,0 +1,285 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+// Sort an array of fixed size records by a key (a range of bytes in each
+// record) to cluster similar records. The sort is stable, records with equal
+// keys keep their relative order. The permutation (original index of each
+// sorted record) is stored to restore the original order: each index is
+// coded as the difference with the previous index (modulo the number of
+// records) on the smallest number of bytes able to hold an index. Within a
+// group of equal keys the indexes increase, hence the differences are small.
+// Output format:
+// - 32 bits: number of records (at most 2^24)
+// - sorted records
+// - permutation
+// - trailing bytes that do not fill a complete record (copied unchanged)
+// EG. record size 2, key offset 0, key length 1
+//  input: c 1 a 2 c 3 b 4 a 5
+// output: 0 0 0 5 a 2 a 5 b 4 c 1 c 3 1 3 4 2 2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"kanzi"
+	"sort"
+)
+
+const (
+	MAX_SORT_CLUSTER_RECORD_SIZE = 65536
+	MAX_SORT_CLUSTER_RECORDS     = 1 << 24
+	SORT_CLUSTER_HEADER_SIZE     = 4
+)
+
+type SortCluster struct {
+	size       uint
+	recordSize uint
+	keyOffset  uint
+	keyLength  uint
+}
+
+func NewSortCluster(sz, recordSize, keyOffset, keyLength uint) (*SortCluster, error) {
+	if recordSize < 1 {
+		return nil, errors.New("Invalid record size parameter (must be at least 1)")
+	}
+
+	if recordSize > MAX_SORT_CLUSTER_RECORD_SIZE {
+		return nil, errors.New("Invalid record size parameter (must be at most 65536)")
+	}
+
+	if keyLength < 1 {
+		return nil, errors.New("Invalid key length parameter (must be at least 1)")
+	}
+
+	if keyOffset+keyLength > recordSize {
+		return nil, errors.New("Invalid key parameters (the key must fit in the record)")
+	}
+
+	this := new(SortCluster)
+	this.size = sz
+	this.recordSize = recordSize
+	this.keyOffset = keyOffset
+	this.keyLength = keyLength
+	return this, nil
+}
+
+func (this *SortCluster) Size() uint {
+	return this.size
+}
+
+func (this *SortCluster) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *SortCluster) RecordSize() uint {
+	return this.recordSize
+}
+
+func (this *SortCluster) KeyOffset() uint {
+	return this.keyOffset
+}
+
+func (this *SortCluster) KeyLength() uint {
+	return this.keyLength
+}
+
+// Number of bytes used to code an index in [0..records-1]
+func indexWidth(records uint) uint {
+	if records <= 1<<8 {
+		return 1
+	}
+
+	if records <= 1<<16 {
+		return 2
+	}
+
+	return 3
+}
+
+// Return the number of bytes to process
+func (this *SortCluster) checkBuffers(src, dst []byte) (uint, error) {
+	if src == nil {
+		return 0, errors.New("Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return 0, errors.New("Invalid null destination buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
+		return 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	length := uint(len(src))
+
+	if this.size > 0 {
+		length = this.size
+
+		if length > uint(len(src)) {
+			return 0, errors.New("Source buffer too small")
+		}
+	}
+
+	return length, nil
+}
+
+func (this *SortCluster) Forward(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rs := this.recordSize
+	records := length / rs
+
+	if records > MAX_SORT_CLUSTER_RECORDS {
+		return 0, 0, errors.New("Too many records (at most 16777216)")
+	}
+
+	if n := this.MaxEncodedLen(int(length)); n > len(dst) {
+		return 0, 0, NewBufferTooSmallError("Destination buffer too small", uint(n), uint(len(dst)))
+	}
+
+	perm := make([]uint32, records)
+
+	for i := range perm {
+		perm[i] = uint32(i)
+	}
+
+	ko := this.keyOffset
+	kl := this.keyLength
+
+	sort.SliceStable(perm, func(i, j int) bool {
+		k1 := uint(perm[i])*rs + ko
+		k2 := uint(perm[j])*rs + ko
+		return bytes.Compare(src[k1:k1+kl], src[k2:k2+kl]) < 0
+	})
+
+	binary.BigEndian.PutUint32(dst, uint32(records))
+	dstIdx := uint(SORT_CLUSTER_HEADER_SIZE)
+
+	for _, p := range perm {
+		srcIdx := uint(p) * rs
+		copy(dst[dstIdx:dstIdx+rs], src[srcIdx:srcIdx+rs])
+		dstIdx += rs
+	}
+
+	width := indexWidth(records)
+	prev := uint32(0)
+
+	for _, p := range perm {
+		delta := (p + uint32(records) - prev) % uint32(records)
+		prev = p
+
+		for shift := 8 * (width - 1); ; shift -= 8 {
+			dst[dstIdx] = byte(delta >> shift)
+			dstIdx++
+
+			if shift == 0 {
+				break
+			}
+		}
+	}
+
+	// Trailing partial record
+	end := records * rs
+	dstIdx += uint(copy(dst[dstIdx:], src[end:length]))
+	return length, dstIdx, nil
+}
+
+func (this *SortCluster) Inverse(src, dst []byte) (uint, uint, error) {
+	length, err := this.checkBuffers(src, dst)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if length < SORT_CLUSTER_HEADER_SIZE {
+		return 0, 0, errors.New("Invalid input: missing header")
+	}
+
+	records := uint(binary.BigEndian.Uint32(src))
+
+	if records > MAX_SORT_CLUSTER_RECORDS {
+		return 0, 0, errors.New("Invalid input: too many records")
+	}
+
+	rs := this.recordSize
+	width := indexWidth(records)
+	permIdx := SORT_CLUSTER_HEADER_SIZE + uint64(records)*uint64(rs)
+	trailingIdx := permIdx + uint64(records)*uint64(width)
+
+	if trailingIdx > uint64(length) {
+		return 0, 0, errors.New("Invalid input: truncated data")
+	}
+
+	end := records * rs
+	trailing := length - uint(trailingIdx)
+
+	if end+trailing > uint(len(dst)) {
+		return 0, 0, NewBufferTooSmallError("Destination buffer too small", end+trailing, uint(len(dst)))
+	}
+
+	// Check that the indexes form a permutation before writing the records
+	perm := make([]uint32, records)
+	seen := make([]bool, records)
+	srcIdx := uint(permIdx)
+	prev := uint32(0)
+
+	for i := range perm {
+		delta := uint32(0)
+
+		for j := uint(0); j < width; j++ {
+			delta = (delta << 8) | uint32(src[srcIdx])
+			srcIdx++
+		}
+
+		if delta >= uint32(records) {
+			return 0, 0, errors.New("Invalid input: incorrect record index")
+		}
+
+		p := (prev + delta) % uint32(records)
+
+		if seen[p] == true {
+			return 0, 0, errors.New("Invalid input: duplicate record index")
+		}
+
+		seen[p] = true
+		perm[i] = p
+		prev = p
+	}
+
+	srcIdx = SORT_CLUSTER_HEADER_SIZE
+
+	for _, p := range perm {
+		dstIdx := uint(p) * rs
+		copy(dst[dstIdx:dstIdx+rs], src[srcIdx:srcIdx+rs])
+		srcIdx += rs
+	}
+
+	// Trailing partial record
+	copy(dst[end:end+trailing], src[trailingIdx:length])
+	return length, end + trailing, nil
+}
+
+// Header and one index per record
+func (this SortCluster) MaxEncodedLen(srcLen int) int {
+	records := uint(srcLen) / this.recordSize
+	return SORT_CLUSTER_HEADER_SIZE + srcLen + int(records*indexWidth(records))
+}
@@ -17,7 +17,9 @@ package function
 
 import (
 	"errors"
+	"fmt"
 	"kanzi"
+	"math"
 )
 
 // Zero Length Encoding is a simple encoding algorithm by Wheeler
@@ -25,18 +27,63 @@ import (
 // that only runs of 0 values are processed. Also, the length is
 // encoded in a different way (each digit in a different byte)
 // This algorithm is well adapted to process post BWT/MTFT data
+// A run of n zeros is encoded as the binary digits of n+1 (most significant
+// first) without the leading 1, one digit per byte: n=1 => 0, n=2 => 1,
+// n=3 => 0 0, n=6 => 1 1 (log2(n+1) bytes, no length limit nor terminator).
+// Literals are shifted by 1 (0xFE and 0xFF are escaped as 0xFF 0x00 and
+// 0xFF 0x01) so that output bytes 0 and 1 are only run length bits, which
+// the decoder reads until a byte > 1. Two runs are never adjacent (a run
+// includes all the consecutive zeros), hence the input must be shorter than
+// ZRLT_MAX_RUN so that a run is never split.
+// EG. input: 0 1 0 0 0xFE => output: 0 2 1 0xFF 0x00
+// Runs of another byte value (EG. 0x20 spaces or 0xFF padding) can be encoded
+// instead of runs of 0 (see NewZRLTForValue): the input bytes are XORed with
+// the run value before encoding (and after decoding), so the run value becomes
+// 0 and the escapes apply to the XORed bytes, never to the run value.
+// EG. run value 0x20, input: 0x20 0x20 0x20 0x41 => output: 0 0 0x62
+// In stateful mode (see NewZRLTWithState), a run at the end of the input of
+// Forward is kept pending and continued by the next call (or written by
+// Flush), so the outputs of successive calls followed by the output of Flush
+// are the encoding of the concatenated inputs (to invert in one call).
+// In resumable mode (see SetResumable), when the output buffer is too small,
+// Forward keeps the run it could not write and returns the number of bytes
+// consumed: the next call with the rest of the input and a new output buffer
+// continues the encoding (the outputs are concatenated). The size must be 0
+// (whole input) to resume with the rest of the input.
 
 const (
-	ZRLT_MAX_RUN = int(1<<31) - 1
+	ZRLT_MAX_RUN = math.MaxInt32
 )
 
 type ZRLT struct {
-	size uint
+	size      uint
+	runValue  byte
+	stateful  bool
+	resumable bool
+	runLength int // pending run length + 1 (stateful or resumable mode)
 }
 
 func NewZRLT(sz uint) (*ZRLT, error) {
 	this := new(ZRLT)
 	this.size = sz
+	this.runLength = 1
+	return this, nil
+}
+
+// Encode the runs of 'runValue' instead of the runs of 0. The decoder must
+// use the same run value.
+func NewZRLTForValue(sz uint, runValue byte) (*ZRLT, error) {
+	return NewZRLTWithState(sz, runValue, false)
+}
+
+// If 'stateful' is true, the runs can span several calls to Forward and
+// Flush must be called after the last one.
+func NewZRLTWithState(sz uint, runValue byte, stateful bool) (*ZRLT, error) {
+	this := new(ZRLT)
+	this.size = sz
+	this.runValue = runValue
+	this.stateful = stateful
+	this.runLength = 1
 	return this, nil
 }
 
@@ -44,6 +91,73 @@ func (this *ZRLT) Size() uint {
 	return this.size
 }
 
+func (this *ZRLT) RunValue() byte {
+	return this.runValue
+}
+
+func (this *ZRLT) Stateful() bool {
+	return this.stateful
+}
+
+func (this *ZRLT) Resumable() bool {
+	return this.resumable
+}
+
+// If enabled (disabled by default), Forward can be resumed after an output
+// buffer too small: the required size reported by the BufferTooSmallError is
+// then the size of the output of the rest of the input.
+func (this *ZRLT) SetResumable(resumable bool) {
+	this.resumable = resumable
+}
+
+// Return the pending run length + 1 at the start of a call
+func (this *ZRLT) pendingRun() int {
+	if this.stateful == true || this.resumable == true {
+		return this.runLength
+	}
+
+	return 1
+}
+
+// Write the bits of the run length as bytes except the most significant one.
+// Return the number of bytes written or 0 if dst is too small.
+func writeRunLength(runLength int, dst []byte) uint {
+	log2 := uint(1)
+
+	for runLength>>log2 > 1 {
+		log2++
+	}
+
+	// log2 bytes required
+	if log2 > uint(len(dst)) {
+		return 0
+	}
+
+	for i := uint(0); i < log2; i++ {
+		dst[i] = byte((runLength >> (log2 - 1 - i)) & 1)
+	}
+
+	return log2
+}
+
+// Write the pending run (stateful mode) to dst and return the number of
+// bytes written
+func (this *ZRLT) Flush(dst []byte) (uint, error) {
+	if this.runLength <= 1 {
+		return 0, nil
+	}
+
+	n := writeRunLength(this.runLength, dst)
+
+	if n == 0 {
+		required := uint(writeRunLength(this.runLength, make([]byte, 32)))
+		return 0, NewBufferTooSmallError("Output buffer is too small", required, uint(len(dst)))
+	}
+
+	this.runLength = 1
+	return n, nil
+}
+
 func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 	if src == nil {
 		return uint(0), uint(0), errors.New("Invalid null source buffer")
@@ -53,24 +167,114 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 		return uint(0), uint(0), errors.New("Invalid null destination buffer")
 	}
 
-	if kanzi.SameByteSlices(src, dst, false) {
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
+	return this.forward(src, dst)
+}
+
+// Encode buf in place (the input size is Size() or len(buf) if 0) and return
+// the encoded size. Aliasing is safe as long as the output never overtakes
+// the unread input: a run of n values shrinks to log2(n+1) <= n bytes but an
+// escaped byte (0xFE or 0xFF after the XOR with the run value) expands to 2
+// bytes, and so does a run pending from the previous call (stateful mode).
+// An escape is only safe after runs have saved enough room.
+// The whole input is checked before anything is written: if the output would
+// overwrite unread input, an error is returned and buf is left unchanged (use
+// Forward with another buffer).
+// EG. 0 0 0 0xFE => 0 0 0xFF 0x00, but 0xFE 0 => error
+func (this *ZRLT) InPlaceForward(buf []byte) (uint, error) {
+	if buf == nil {
+		return 0, errors.New("Invalid null buffer")
+	}
+
+	srcEnd := this.size
+
+	if this.size == 0 {
+		srcEnd = uint(len(buf))
+	}
+
+	if srcEnd > uint(len(buf)) {
+		return 0, errors.New("Invalid size (larger than the input buffer)")
+	}
+
+	if idx := this.inPlaceOverlap(buf[0:srcEnd], this.pendingRun()); idx >= 0 {
+		return 0, fmt.Errorf("Cannot encode in place: the output would overwrite the input at index %v", idx)
+	}
+
+	_, dstIdx, err := this.forward(buf, buf)
+	return dstIdx, err
+}
+
+// Return the index of the first input byte overwritten before being read if
+// src is encoded in place after a run of runLength-1 values, or -1. Same
+// steps as forward: a run length is written after the end of the run, before
+// the next byte is read; a literal is written after being read.
+func (this *ZRLT) inPlaceOverlap(src []byte, runLength int) int {
+	runValue := this.runValue
+	dstIdx := 0
+
+	for srcIdx, b := range src {
+		val := b ^ runValue
+
+		if val == 0 {
+			runLength++
+			continue
+		}
+
+		for runLength > 1 {
+			runLength >>= 1
+			dstIdx++
+		}
+
+		if dstIdx > srcIdx {
+			return srcIdx
+		}
+
+		if val >= 0xFE {
+			dstIdx += 2
+		} else {
+			dstIdx++
+		}
+
+		if dstIdx > srcIdx+1 {
+			return srcIdx + 1
+		}
+	}
+
+	return -1
+}
+
+func (this *ZRLT) forward(src, dst []byte) (uint, uint, error) {
 	srcEnd := this.size
 
 	if this.size == 0 {
 		srcEnd = uint(len(src))
 	}
 
+	if srcEnd > uint(len(src)) {
+		return 0, 0, errors.New("Invalid size (larger than the input buffer)")
+	}
+
+	// Run length + 1 (the number of zeros seen is runLength-1)
+	runLength := this.pendingRun()
+
+	if uint(runLength-1)+srcEnd >= uint(ZRLT_MAX_RUN) {
+		return 0, 0, errors.New("Input too large (runs would be split)")
+	}
+
+	initRunLength := runLength
+	this.runLength = 1
+
 	dstEnd := uint(len(dst))
-	dstEnd2 := dstEnd - 2
-	runLength := 1
 	srcIdx := uint(0)
 	dstIdx := uint(0)
+	runValue := this.runValue
 
-	for srcIdx < srcEnd && dstIdx < dstEnd {
-		val := src[srcIdx]
+	// The run values do not use the output buffer until the end of the run
+	for srcIdx < srcEnd {
+		val := src[srcIdx] ^ runValue
 
 		if val == 0 {
 			runLength++
@@ -79,33 +283,28 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 			if srcIdx < srcEnd && runLength < ZRLT_MAX_RUN {
 				continue
 			}
+
+			if srcIdx == srcEnd && this.stateful == true {
+				// The run may continue in the next call
+				break
+			}
 		}
 
 		if runLength > 1 {
 			// Encode length
-			log2 := uint(1)
+			n := writeRunLength(runLength, dst[dstIdx:])
 
-			for runLength>>log2 > 1 {
-				log2++
-			}
-
-			if dstIdx >= dstEnd-log2 {
+			if n == 0 {
 				break
 			}
 
-			// Write every bit as a byte except the most significant one
-			for log2 > 0 {
-				log2--
-				dst[dstIdx] = byte((runLength >> log2) & 1)
-				dstIdx++
-			}
-
+			dstIdx += n
 			runLength = 1
 			continue
 		}
 
 		if val >= 0xFE {
-			if dstIdx >= dstEnd2 {
+			if dstIdx+2 > dstEnd {
 				break
 			}
 
@@ -114,6 +313,10 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 			dst[dstIdx] = val - 0xFE
 			dstIdx++
 		} else {
+			if dstIdx >= dstEnd {
+				break
+			}
+
 			dst[dstIdx] = val + 1
 			dstIdx++
 		}
@@ -121,8 +324,35 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 		srcIdx++
 	}
 
+	if srcIdx == srcEnd && runLength > 1 {
+		if this.stateful == true {
+			this.runLength = runLength
+			return srcIdx, dstIdx, nil
+		}
+
+		// Run kept by a call stopped at the end of the input (resumable mode)
+		if n := writeRunLength(runLength, dst[dstIdx:]); n > 0 {
+			dstIdx += n
+			runLength = 1
+		}
+	}
+
 	if srcIdx != srcEnd || runLength != 1 {
-		return srcIdx, dstIdx, errors.New("Output buffer is too small")
+		if this.resumable == true {
+			// Keep the run not written, the next call continues at srcIdx
+			this.runLength = runLength
+			required := this.encodedLen(src[srcIdx:srcEnd], runLength)
+			return srcIdx, dstIdx, NewBufferTooSmallError("Output buffer is too small", uint(required), dstEnd-dstIdx)
+		}
+
+		// Keep the pending run for another attempt with a larger buffer
+		required := this.encodedLen(src[0:srcEnd], initRunLength)
+
+		if this.stateful == true {
+			this.runLength = initRunLength
+		}
+
+		return srcIdx, dstIdx, NewBufferTooSmallError("Output buffer is too small", uint(required), dstEnd)
 	}
 
 	return srcIdx, dstIdx, nil
@@ -137,7 +367,7 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 		return uint(0), uint(0), errors.New("Invalid null destination buffer")
 	}
 
-	if kanzi.SameByteSlices(src, dst, false) {
+	if len(src) > 0 && kanzi.SameByteSlices(src, dst, false) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
@@ -147,15 +377,20 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 		srcEnd = uint(len(src))
 	}
 
+	if srcEnd > uint(len(src)) {
+		return 0, 0, errors.New("Invalid size (larger than the input buffer)")
+	}
+
 	dstEnd := uint(len(dst))
 	runLength := 1
 	srcIdx := uint(0)
 	dstIdx := uint(0)
+	runValue := this.runValue
 
 	for srcIdx < srcEnd && dstIdx < dstEnd {
 		if runLength > 1 {
 			runLength--
-			dst[dstIdx] = 0
+			dst[dstIdx] = runValue
 			dstIdx++
 			continue
 		}
@@ -167,6 +402,10 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 			runLength = 1
 
 			for {
+				if runLength > ZRLT_MAX_RUN>>1 {
+					return srcIdx, dstIdx, errors.New("Invalid run length")
+				}
+
 				runLength = (runLength << 1) | int(val)
 				srcIdx++
 
@@ -189,19 +428,23 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 			srcIdx++
 
 			if srcIdx >= srcEnd {
-				break
+				return srcIdx, dstIdx, errors.New("Invalid truncated escape sequence")
 			}
 
-			dst[dstIdx] = 0xFE + src[srcIdx]
+			if src[srcIdx] > 1 {
+				return srcIdx, dstIdx, errors.New("Invalid escape sequence")
+			}
+
+			dst[dstIdx] = (0xFE + src[srcIdx]) ^ runValue
 		} else {
-			dst[dstIdx] = val - 1
+			dst[dstIdx] = (val - 1) ^ runValue
 		}
 
 		dstIdx++
 		srcIdx++
 	}
 
-	// If runLength is not 1, add trailing 0s
+	// If runLength is not 1, add the trailing run
 	end := dstIdx + uint(runLength) - 1
 
 	if end > dstEnd {
@@ -209,7 +452,7 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	for dstIdx < end {
-		dst[dstIdx] = 0
+		dst[dstIdx] = runValue
 		dstIdx++
 	}
 
@@ -220,7 +463,75 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 	return srcIdx, dstIdx, nil
 }
 
-// Required encoding output buffer size unknown
+// Every byte may be escaped (a run of n values uses at most n bytes). In
+// stateful or resumable mode, the pending run of the previous call may add
+// 31 bytes.
 func (this ZRLT) MaxEncodedLen(srcLen int) int {
-	return -1
+	if this.stateful == true || this.resumable == true {
+		return 2*srcLen + 31
+	}
+
+	return 2 * srcLen
+}
+
+// Return the exact size of the encoded data (same as the output index of
+// Forward, including the run pending from the previous call) without
+// encoding
+func (this *ZRLT) EncodedLen(src []byte) int {
+	srcEnd := int(this.size)
+
+	if this.size == 0 || srcEnd > len(src) {
+		srcEnd = len(src)
+	}
+
+	return this.encodedLen(src[0:srcEnd], this.pendingRun())
+}
+
+// Size of the encoding of src following a run of runLength-1 values (the
+// final run is pending in stateful mode)
+func (this *ZRLT) encodedLen(src []byte, runLength int) int {
+	res := 0
+	runValue := this.runValue
+
+	for _, b := range src {
+		val := b ^ runValue
+
+		if val == 0 {
+			runLength++
+			continue
+		}
+
+		// One byte per bit of the run length but the most significant one
+		for runLength > 1 {
+			runLength >>= 1
+			res++
+		}
+
+		if val >= 0xFE {
+			res += 2
+		} else {
+			res++
+		}
+	}
+
+	if this.stateful == false {
+		for runLength > 1 {
+			runLength >>= 1
+			res++
+		}
+	}
+
+	return res
+}
+
+// Return the ratio encoded size / original size that ZRLT would achieve on
+// the data (lower is better, above 1 the data expands). Cheap estimate used
+// to decide whether to apply the transform.
+func ZRLTGain(src []byte) float64 {
+	if len(src) == 0 {
+		return 1
+	}
+
+	zrlt := ZRLT{}
+	return float64(zrlt.EncodedLen(src)) / float64(len(src))
 }
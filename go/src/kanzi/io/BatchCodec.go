@@ -0,0 +1,316 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/function"
+	"kanzi/util"
+	"sync"
+)
+
+// Compression of many small independent inputs. Each input is compressed to
+// a self contained blob without stream header or end block. The entropy codec
+// and transform are not recorded: the same BatchCodec parameters must be used
+// to decode. Scratch buffers are pooled and shared between calls (BatchCodec
+// is safe for concurrent use).
+// Blob format:
+// - 1 bit: transform skipped
+// - 6 bits + n bits: original length (n is the 6 bit value)
+// - 6 bits + n bits: transformed length (only if the transform is not skipped)
+// - entropy coded data
+
+const (
+	BATCH_BITSTREAM_BUFFER_SIZE = 1024
+)
+
+type batchContext struct {
+	output byteOutputStream
+	input  []byte
+	buffer []byte
+}
+
+type BatchCodec struct {
+	entropyType   byte
+	transformType byte
+	pool          sync.Pool
+}
+
+func NewBatchCodec(entropyCodec, functionType string) (*BatchCodec, error) {
+	this := new(BatchCodec)
+	err := error(nil)
+
+	// The factories panic on unknown names
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("Invalid codec or function type: %v", r)
+			}
+		}()
+
+		this.entropyType = entropy.GetEntropyCodecType(entropyCodec)
+		this.transformType = function.GetByteFunctionType(functionType)
+	}()
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.pool.New = func() interface{} { return &batchContext{input: EMPTY_BYTE_SLICE, buffer: EMPTY_BYTE_SLICE} }
+	return this, nil
+}
+
+// Return one compressed blob per input
+func (this *BatchCodec) BatchEncode(inputs [][]byte) ([][]byte, error) {
+	if inputs == nil {
+		return nil, errors.New("Invalid null inputs parameter")
+	}
+
+	ctx := this.pool.Get().(*batchContext)
+	defer this.pool.Put(ctx)
+	res := make([][]byte, len(inputs))
+
+	for i := range inputs {
+		var err error
+
+		if res[i], err = this.encode(ctx, inputs[i]); err != nil {
+			return nil, fmt.Errorf("Failed to encode input %d: %v", i, err)
+		}
+	}
+
+	return res, nil
+}
+
+// Return the original data for each compressed blob
+func (this *BatchCodec) BatchDecode(inputs [][]byte) ([][]byte, error) {
+	if inputs == nil {
+		return nil, errors.New("Invalid null inputs parameter")
+	}
+
+	ctx := this.pool.Get().(*batchContext)
+	defer this.pool.Put(ctx)
+	res := make([][]byte, len(inputs))
+
+	for i := range inputs {
+		var err error
+
+		if res[i], err = this.decode(ctx, inputs[i]); err != nil {
+			return nil, fmt.Errorf("Failed to decode input %d: %v", i, err)
+		}
+	}
+
+	return res, nil
+}
+
+func writeLength(obs kanzi.OutputBitStream, length uint) {
+	n := uint(0)
+
+	for length>>n != 0 {
+		n++
+	}
+
+	obs.WriteBits(uint64(n), 6)
+
+	if n > 0 {
+		obs.WriteBits(uint64(length), n)
+	}
+}
+
+func readLength(ibs kanzi.InputBitStream) uint {
+	n := uint(ibs.ReadBits(6))
+
+	if n == 0 {
+		return 0
+	}
+
+	return uint(ibs.ReadBits(n))
+}
+
+func (this *BatchCodec) encode(ctx *batchContext, data []byte) (res []byte, err error) {
+	if data == nil {
+		return nil, errors.New("Invalid null data parameter")
+	}
+
+	// The bitstream panics on write errors
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	length := uint(len(data))
+	buffer := data
+	postTransformLength := length
+	skip := true
+
+	if length > SMALL_BLOCK_SIZE && this.transformType != function.NULL_TRANSFORM_TYPE {
+		transform, err := function.NewByteFunction(length, this.transformType)
+
+		if err != nil {
+			return nil, err
+		}
+
+		requiredSize := transform.MaxEncodedLen(int(length))
+
+		if requiredSize == -1 {
+			// Max size unknown => guess
+			requiredSize = int(length) * 5 >> 2
+		}
+
+		if len(ctx.buffer) < requiredSize {
+			ctx.buffer = make([]byte, requiredSize)
+		}
+
+		// The forward transform may modify its input: work on a copy
+		if uint(len(ctx.input)) < length {
+			ctx.input = make([]byte, length)
+		}
+
+		copy(ctx.input, data)
+
+		// Forward transform (skip the transform if it fails)
+		if _, oIdx, err := transform.Forward(ctx.input[0:length], ctx.buffer); err == nil {
+			buffer = ctx.buffer
+			postTransformLength = oIdx
+			skip = false
+		}
+	}
+
+	ctx.output.buffer.Reset()
+	obs, err := bitstream.NewDefaultOutputBitStream(&ctx.output, BATCH_BITSTREAM_BUFFER_SIZE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if skip == true {
+		obs.WriteBit(1)
+		writeLength(obs, length)
+	} else {
+		obs.WriteBit(0)
+		writeLength(obs, length)
+		writeLength(obs, postTransformLength)
+	}
+
+	ee, err := entropy.NewEntropyEncoder(obs, this.entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = ee.Encode(buffer[0:postTransformLength]); err != nil {
+		return nil, err
+	}
+
+	ee.Dispose()
+
+	if _, err = obs.Close(); err != nil {
+		return nil, err
+	}
+
+	res = make([]byte, ctx.output.buffer.Len())
+	copy(res, ctx.output.buffer.Bytes())
+	return res, nil
+}
+
+func (this *BatchCodec) decode(ctx *batchContext, data []byte) (res []byte, err error) {
+	if data == nil {
+		return nil, errors.New("Invalid null data parameter")
+	}
+
+	// The bitstream panics on read errors
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	// The entropy decoder may read ahead past the end of the data: pad with zeros
+	is, _ := util.NewByteArrayInputStream(data, true)
+	ibs, err := bitstream.NewDefaultInputBitStream(is, BATCH_BITSTREAM_BUFFER_SIZE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	skip := ibs.ReadBit() == 1
+	length := readLength(ibs)
+	postTransformLength := length
+
+	if skip == false {
+		postTransformLength = readLength(ibs)
+	}
+
+	if length > MAX_BITSTREAM_BLOCK_SIZE || postTransformLength > MAX_BITSTREAM_BLOCK_SIZE {
+		return nil, fmt.Errorf("Invalid length in blob: %d", length)
+	}
+
+	res = make([]byte, length)
+	buffer := res
+
+	if skip == false {
+		// The inverse transform may use the input buffer as scratch space
+		bufferSize := length
+
+		if bufferSize < postTransformLength {
+			bufferSize = postTransformLength
+		}
+
+		if uint(len(ctx.buffer)) < bufferSize {
+			ctx.buffer = make([]byte, bufferSize)
+		}
+
+		buffer = ctx.buffer
+	}
+
+	ed, err := entropy.NewEntropyDecoder(ibs, this.entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer ed.Dispose()
+
+	if _, err = ed.Decode(buffer[0:postTransformLength]); err != nil {
+		return nil, err
+	}
+
+	if skip == false {
+		transform, err := function.NewByteFunction(postTransformLength, this.transformType)
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, oIdx, err := transform.Inverse(buffer, res)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if oIdx != length {
+			return nil, fmt.Errorf("Invalid decoded length: expected %d, got %d", length, oIdx)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,535 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+	"io"
+	"kanzi"
+	"kanzi/entropy"
+	"kanzi/util"
+)
+
+// Entropy coding of a stream of blocks with a checksum of the original data
+// in each frame. Frames are byte aligned and self contained (new entropy
+// coder per block), so a decoder reading from a random access source can
+// re-read a frame when the decoded block does not match its checksum (EG.
+// flaky storage returning bad data once) and retry before failing.
+// Stream format:
+// - 32 bits: CHECKED_STREAM_TYPE
+// - 8 bits: entropy codec type
+// - frames: 32 bits original length, 32 bits coded length, 32 bits XXHash32
+//   of the original data, coded data
+// - end frame: original length 0, coded length 0, checksum 0
+// - index trailer (random access to the blocks):
+//   for each block, 64 bits frame offset, 32 bits coded length, 32 bits
+//   original length, then 32 bits block count, 32 bits CHECKED_INDEX_TYPE
+
+const (
+	CHECKED_STREAM_TYPE       = 0x4B43484B // "KCHK"
+	CHECKED_INDEX_TYPE        = 0x4B494458 // "KIDX"
+	CHECKED_INDEX_ENTRY_SIZE  = 16
+	CHECKED_INDEX_FOOTER_SIZE = 8
+	CHECKED_HEADER_SIZE       = 5
+	CHECKED_FRAME_HEADER_SIZE = 12
+	DEFAULT_CHECKED_RETRIES   = 3
+)
+
+type checkedIndexEntry struct {
+	offset    int64 // offset of the frame in the stream
+	codedSize int
+	size      int
+}
+
+type CheckedBlockEncoder struct {
+	os          kanzi.OutputStream
+	entropyType byte
+	block       []byte
+	hasher      *util.XXHash
+	headerDone  bool
+	closed      bool
+	written     int64
+	index       []checkedIndexEntry
+}
+
+func NewCheckedBlockEncoder(entropyCodec string, os kanzi.OutputStream, blockSize uint) (*CheckedBlockEncoder, error) {
+	if os == nil {
+		return nil, NewIOError("Invalid null output stream parameter", ERR_CREATE_COMPRESSOR)
+	}
+
+	if err := checkParallelParameters(blockSize, 1); err != nil {
+		return nil, NewIOError(err.Error(), ERR_CREATE_COMPRESSOR)
+	}
+
+	this := new(CheckedBlockEncoder)
+	err := error(nil)
+
+	// The factory panics on unknown names
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = NewIOError(fmt.Sprintf("Invalid entropy codec type: %v", r), ERR_INVALID_CODEC)
+			}
+		}()
+
+		this.entropyType = entropy.GetEntropyCodecType(entropyCodec)
+	}()
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.os = os
+	this.block = make([]byte, 0, blockSize)
+	this.index = make([]checkedIndexEntry, 0)
+	this.hasher, err = util.NewXXHash(CHECKED_STREAM_TYPE)
+	return this, err
+}
+
+// Implement kanzi.OutputStream interface
+func (this *CheckedBlockEncoder) Write(array []byte) (int, error) {
+	if this.closed == true {
+		return 0, NewIOError("Stream closed", ERR_WRITE_FILE)
+	}
+
+	written := 0
+
+	for written < len(array) {
+		n := copy(this.block[len(this.block):cap(this.block)], array[written:])
+		this.block = this.block[0 : len(this.block)+n]
+		written += n
+
+		if len(this.block) == cap(this.block) {
+			if err := this.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Write the end frame and the index, then close the underlying stream
+func (this *CheckedBlockEncoder) Close() error {
+	if this.closed == true {
+		return nil
+	}
+
+	this.closed = true
+
+	if err := this.flush(); err != nil {
+		return err
+	}
+
+	if err := this.writeFrame(0, 0, EMPTY_BYTE_SLICE); err != nil {
+		return err
+	}
+
+	if err := this.writeIndex(); err != nil {
+		return err
+	}
+
+	if err := this.os.Close(); err != nil {
+		return NewIOError(err.Error(), ERR_WRITE_FILE)
+	}
+
+	return nil
+}
+
+// Encode the current block (if any) and write it as a frame
+func (this *CheckedBlockEncoder) flush() error {
+	if this.headerDone == false {
+		header := make([]byte, CHECKED_HEADER_SIZE)
+		putInt32(header, CHECKED_STREAM_TYPE)
+		header[4] = this.entropyType
+
+		if err := this.write(header); err != nil {
+			return err
+		}
+
+		this.headerDone = true
+	}
+
+	if len(this.block) == 0 {
+		return nil
+	}
+
+	data, err := encodeEntropyBlock(this.entropyType, this.block)
+
+	if err != nil {
+		return NewIOError("Failed to encode block: "+err.Error(), ERR_PROCESS_BLOCK)
+	}
+
+	checksum := this.hasher.Hash(this.block)
+	this.index = append(this.index, checkedIndexEntry{offset: this.written, codedSize: len(data), size: len(this.block)})
+
+	if err = this.writeFrame(len(this.block), checksum, data); err != nil {
+		return err
+	}
+
+	this.block = this.block[0:0]
+	return nil
+}
+
+func (this *CheckedBlockEncoder) writeFrame(length int, checksum uint32, data []byte) error {
+	frame := make([]byte, CHECKED_FRAME_HEADER_SIZE)
+	putInt32(frame[0:4], uint32(length))
+	putInt32(frame[4:8], uint32(len(data)))
+	putInt32(frame[8:12], checksum)
+
+	if err := this.write(frame); err != nil {
+		return err
+	}
+
+	return this.write(data)
+}
+
+func (this *CheckedBlockEncoder) writeIndex() error {
+	buf := make([]byte, len(this.index)*CHECKED_INDEX_ENTRY_SIZE+CHECKED_INDEX_FOOTER_SIZE)
+	n := 0
+
+	for _, e := range this.index {
+		putInt32(buf[n:], uint32(e.offset>>32))
+		putInt32(buf[n+4:], uint32(e.offset))
+		putInt32(buf[n+8:], uint32(e.codedSize))
+		putInt32(buf[n+12:], uint32(e.size))
+		n += CHECKED_INDEX_ENTRY_SIZE
+	}
+
+	putInt32(buf[n:], uint32(len(this.index)))
+	putInt32(buf[n+4:], CHECKED_INDEX_TYPE)
+	return this.write(buf)
+}
+
+func (this *CheckedBlockEncoder) write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if _, err := this.os.Write(data); err != nil {
+		return NewIOError(err.Error(), ERR_WRITE_FILE)
+	}
+
+	this.written += int64(len(data))
+	return nil
+}
+
+// Decoder of a checked stream. The frames are read at their offset in the
+// source: a frame that cannot be read, decoded or verified is read again,
+// up to 'retries' times, before the decoder fails.
+type RetryingBlockDecoder struct {
+	ra          io.ReaderAt
+	retries     uint
+	retried     uint
+	entropyType byte
+	hasher      *util.XXHash
+	offset      int64 // offset of the next frame
+	current     []byte
+	curIdx      int
+	blockId     int
+	eos         bool
+	err         error
+	closed      bool
+}
+
+func NewRetryingBlockDecoder(ra io.ReaderAt, retries uint) (*RetryingBlockDecoder, error) {
+	if ra == nil {
+		return nil, NewIOError("Invalid null reader parameter", ERR_CREATE_DECOMPRESSOR)
+	}
+
+	var err error
+	this := new(RetryingBlockDecoder)
+	this.ra = ra
+	this.retries = retries
+	this.current = EMPTY_BYTE_SLICE
+	this.hasher, err = util.NewXXHash(CHECKED_STREAM_TYPE)
+	return this, err
+}
+
+// Return the number of frame reads that have been retried so far
+func (this *RetryingBlockDecoder) Retried() uint {
+	return this.retried
+}
+
+// Read len(buf) bytes at the provided offset
+func readFully(ra io.ReaderAt, buf []byte, offset int64) error {
+	n, err := ra.ReadAt(buf, offset)
+
+	if n == len(buf) {
+		return nil
+	}
+
+	if err == nil || err == io.EOF {
+		return NewIOError("Unexpected end of stream", ERR_READ_FILE)
+	}
+
+	return NewIOError(err.Error(), ERR_READ_FILE)
+}
+
+// Return the entropy codec type read from the stream header
+func readCheckedHeader(ra io.ReaderAt) (byte, error) {
+	header := make([]byte, CHECKED_HEADER_SIZE)
+
+	if err := readFully(ra, header, 0); err != nil {
+		return 0, err
+	}
+
+	if getInt32(header) != CHECKED_STREAM_TYPE {
+		return 0, &IOError{msg: ErrInvalidStreamType.Error(), code: ERR_INVALID_FILE, err: ErrInvalidStreamType}
+	}
+
+	return header[4], nil
+}
+
+// Read, decode and verify the frame at the provided offset. Return the block
+// and the frame size. Return nil data for the end frame.
+func readCheckedFrame(ra io.ReaderAt, offset int64, entropyType byte, hasher *util.XXHash,
+	blockId int) ([]byte, int64, error) {
+	frame := make([]byte, CHECKED_FRAME_HEADER_SIZE)
+
+	if err := readFully(ra, frame, offset); err != nil {
+		return nil, 0, err
+	}
+
+	length := getInt32(frame[0:4])
+	codedLength := getInt32(frame[4:8])
+	checksum1 := getInt32(frame[8:12])
+
+	if length == 0 && codedLength == 0 && checksum1 == 0 {
+		// End frame
+		return nil, CHECKED_FRAME_HEADER_SIZE, nil
+	}
+
+	if length == 0 || length > MAX_BITSTREAM_BLOCK_SIZE || codedLength > MAX_BITSTREAM_BLOCK_SIZE {
+		return nil, 0, NewIOError(fmt.Sprintf("Invalid frame length in block %d", blockId), ERR_INVALID_FILE)
+	}
+
+	data := make([]byte, codedLength)
+
+	if err := readFully(ra, data, offset+CHECKED_FRAME_HEADER_SIZE); err != nil {
+		return nil, 0, err
+	}
+
+	block, err := decodeEntropyBlock(entropyType, data, int(length))
+
+	if err != nil {
+		return nil, 0, NewIOError(fmt.Sprintf("Failed to decode block %d: %v", blockId, err), ERR_PROCESS_BLOCK)
+	}
+
+	if checksum2 := hasher.Hash(block); checksum2 != checksum1 {
+		errMsg := fmt.Sprintf("Corrupted block %d: expected checksum %x, found %x", blockId, checksum1, checksum2)
+		return nil, 0, newChecksumError(errMsg)
+	}
+
+	return block, CHECKED_FRAME_HEADER_SIZE + int64(codedLength), nil
+}
+
+// Decode the next frame, retrying on failure. Return nil at the end of stream.
+func (this *RetryingBlockDecoder) nextBlock() ([]byte, error) {
+	var err error
+
+	for attempt := uint(0); attempt <= this.retries; attempt++ {
+		if attempt > 0 {
+			this.retried++
+		}
+
+		if this.offset == 0 {
+			if this.entropyType, err = readCheckedHeader(this.ra); err != nil {
+				continue
+			}
+
+			this.offset = CHECKED_HEADER_SIZE
+		}
+
+		block, frameSize, err2 := readCheckedFrame(this.ra, this.offset, this.entropyType, this.hasher, this.blockId)
+
+		if err = err2; err != nil {
+			continue
+		}
+
+		this.offset += frameSize
+		this.blockId++
+		return block, nil
+	}
+
+	return nil, err
+}
+
+// Implement kanzi.InputStream interface
+func (this *RetryingBlockDecoder) Read(array []byte) (int, error) {
+	if this.closed == true {
+		return 0, NewIOError("Stream closed", ERR_READ_FILE)
+	}
+
+	read := 0
+
+	for read < len(array) {
+		if this.curIdx >= len(this.current) {
+			if this.err != nil {
+				return read, this.err
+			}
+
+			if this.eos == false {
+				block, err := this.nextBlock()
+
+				if err != nil {
+					this.err = err
+					return read, err
+				}
+
+				if block == nil {
+					this.eos = true
+				} else {
+					this.current = block
+					this.curIdx = 0
+				}
+			}
+
+			if this.eos == true {
+				// Reached end of stream
+				if read == 0 {
+					return -1, nil
+				}
+
+				break
+			}
+		}
+
+		n := copy(array[read:], this.current[this.curIdx:])
+		this.curIdx += n
+		read += n
+	}
+
+	return read, nil
+}
+
+func (this *RetryingBlockDecoder) Close() error {
+	this.closed = true
+	return nil
+}
+
+// Random access to the blocks of a checked stream using the index trailer.
+// Any block can be decoded without decoding the previous ones.
+type BlockIndexReader struct {
+	ra          io.ReaderAt
+	entropyType byte
+	hasher      *util.XXHash
+	index       []checkedIndexEntry
+}
+
+// The size is the size of the stream (the index is at the end)
+func NewBlockIndexReader(ra io.ReaderAt, size int64) (*BlockIndexReader, error) {
+	if ra == nil {
+		return nil, NewIOError("Invalid null reader parameter", ERR_CREATE_DECOMPRESSOR)
+	}
+
+	if size < CHECKED_HEADER_SIZE+CHECKED_FRAME_HEADER_SIZE+CHECKED_INDEX_FOOTER_SIZE {
+		return nil, NewIOError("Invalid stream size", ERR_INVALID_FILE)
+	}
+
+	var err error
+	this := new(BlockIndexReader)
+	this.ra = ra
+
+	if this.entropyType, err = readCheckedHeader(ra); err != nil {
+		return nil, err
+	}
+
+	footer := make([]byte, CHECKED_INDEX_FOOTER_SIZE)
+
+	if err = readFully(ra, footer, size-CHECKED_INDEX_FOOTER_SIZE); err != nil {
+		return nil, err
+	}
+
+	if getInt32(footer[4:8]) != CHECKED_INDEX_TYPE {
+		return nil, NewIOError("Missing block index", ERR_INVALID_FILE)
+	}
+
+	count := int64(getInt32(footer[0:4]))
+	indexOffset := size - CHECKED_INDEX_FOOTER_SIZE - count*CHECKED_INDEX_ENTRY_SIZE
+
+	// The index follows the end frame
+	if indexOffset < CHECKED_HEADER_SIZE+CHECKED_FRAME_HEADER_SIZE {
+		return nil, NewIOError(fmt.Sprintf("Invalid number of blocks in index: %d", count), ERR_INVALID_FILE)
+	}
+
+	buf := make([]byte, count*CHECKED_INDEX_ENTRY_SIZE)
+
+	if err = readFully(ra, buf, indexOffset); err != nil {
+		return nil, err
+	}
+
+	this.index = make([]checkedIndexEntry, count)
+	end := int64(CHECKED_HEADER_SIZE)
+
+	for i := range this.index {
+		e := &this.index[i]
+		n := i * CHECKED_INDEX_ENTRY_SIZE
+		e.offset = int64(getInt32(buf[n:]))<<32 | int64(getInt32(buf[n+4:]))
+		e.codedSize = int(getInt32(buf[n+8:]))
+		e.size = int(getInt32(buf[n+12:]))
+
+		// Frames are contiguous and in order
+		if e.offset != end || e.size == 0 || e.size > MAX_BITSTREAM_BLOCK_SIZE || e.codedSize > MAX_BITSTREAM_BLOCK_SIZE {
+			return nil, NewIOError(fmt.Sprintf("Invalid index entry for block %d", i), ERR_INVALID_FILE)
+		}
+
+		end += CHECKED_FRAME_HEADER_SIZE + int64(e.codedSize)
+	}
+
+	if end+CHECKED_FRAME_HEADER_SIZE != indexOffset {
+		return nil, NewIOError("Invalid block index: inconsistent frame offsets", ERR_INVALID_FILE)
+	}
+
+	this.hasher, err = util.NewXXHash(CHECKED_STREAM_TYPE)
+	return this, err
+}
+
+// Return the number of blocks in the stream
+func (this *BlockIndexReader) Count() int {
+	return len(this.index)
+}
+
+// Return the frame offset, coded size and original size of a block
+func (this *BlockIndexReader) BlockInfo(index int) (int64, int, int, error) {
+	if index < 0 || index >= len(this.index) {
+		return 0, 0, 0, NewIOError(fmt.Sprintf("Invalid block index: %d", index), ERR_INVALID_FILE)
+	}
+
+	e := this.index[index]
+	return e.offset, e.codedSize, e.size, nil
+}
+
+// Read, decode and verify one block (and only this block)
+func (this *BlockIndexReader) DecodeBlockAt(index int) ([]byte, error) {
+	offset, codedSize, size, err := this.BlockInfo(index)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, frameSize, err := readCheckedFrame(this.ra, offset, this.entropyType, this.hasher, index)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(block) != size || frameSize != CHECKED_FRAME_HEADER_SIZE+int64(codedSize) {
+		return nil, NewIOError(fmt.Sprintf("Frame of block %d does not match the index", index), ERR_INVALID_FILE)
+	}
+
+	return block, nil
+}
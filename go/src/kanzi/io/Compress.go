@@ -0,0 +1,153 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// In memory compression/decompression of a complete kanzi stream.
+// The stream header records the entropy codec and the transform, hence
+// Decompress reconstructs the inverse pipeline without any knowledge of the
+// parameters provided to Compress.
+
+const (
+	DECOMPRESS_CHUNK_SIZE = 65536
+)
+
+type byteOutputStream struct {
+	buffer bytes.Buffer
+}
+
+func (this *byteOutputStream) Write(b []byte) (int, error) {
+	return this.buffer.Write(b)
+}
+
+func (this *byteOutputStream) Close() error {
+	return nil
+}
+
+// Return the compressed stream (header + blocks)
+func Compress(data []byte, entropyCodec, functionType string, blockSize uint) ([]byte, error) {
+	return CompressContext(context.Background(), data, entropyCodec, functionType, blockSize)
+}
+
+// Same as Compress. Stop at the next block boundary and return the context
+// error if the context is cancelled.
+func CompressContext(ctx context.Context, data []byte, entropyCodec, functionType string,
+	blockSize uint) (res []byte, err error) {
+	if ctx == nil {
+		return nil, errors.New("Invalid null context parameter")
+	}
+
+	if data == nil {
+		return nil, errors.New("Invalid null data parameter")
+	}
+
+	// The stream and codec factories panic on invalid parameters or I/O errors
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("Compression failed: %v", r)
+		}
+	}()
+
+	os := &byteOutputStream{}
+	var cos *CompressedOutputStream
+	cos, err = NewCompressedOutputStream(entropyCodec, functionType, os, blockSize, false, nil, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cos.SetContext(ctx)
+
+	if _, err = cos.Write(data); err != nil {
+		cos.Close()
+		return nil, err
+	}
+
+	if err = cos.Close(); err != nil {
+		return nil, err
+	}
+
+	return os.buffer.Bytes(), nil
+}
+
+// Return the original data. The entropy codec and transform are read from
+// the stream header.
+func Decompress(data []byte) ([]byte, error) {
+	return DecompressContext(context.Background(), data)
+}
+
+// Same as Decompress. Stop at the next block boundary and return the context
+// error if the context is cancelled.
+func DecompressContext(ctx context.Context, data []byte) (res []byte, err error) {
+	if ctx == nil {
+		return nil, errors.New("Invalid null context parameter")
+	}
+
+	if data == nil {
+		return nil, errors.New("Invalid null data parameter")
+	}
+
+	// Reading an invalid stream header or past the end of the data panics
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("Decompression failed: %v", r)
+		}
+	}()
+
+	// Short reads then io.EOF at the end of the data: reading past the end
+	// of a truncated stream fails instead of returning zeros
+	is := &readerInputStream{reader: bytes.NewReader(data)}
+	var cis *CompressedInputStream
+	cis, err = NewCompressedInputStream(is, nil, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cis.SetContext(ctx)
+	res = make([]byte, 0, 2*len(data))
+	buf := make([]byte, DECOMPRESS_CHUNK_SIZE)
+
+	for {
+		var n int
+
+		if n, err = cis.Read(buf); err != nil {
+			cis.Close()
+			return nil, err
+		}
+
+		if n <= 0 {
+			// End of stream
+			break
+		}
+
+		res = append(res, buf[0:n]...)
+	}
+
+	if err = cis.Close(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -18,14 +18,19 @@ package io
 import (
 	"bytes"
 	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"kanzi"
 	"kanzi/bitstream"
 	"kanzi/entropy"
 	"kanzi/function"
 	"kanzi/util"
+	"sync/atomic"
 )
 
 // Write to/read from stream using a 2 step process:
@@ -36,14 +41,27 @@ import (
 
 const (
 	BITSTREAM_TYPE             = 0x4B414E5A // "KANZ"
-	BITSTREAM_FORMAT_VERSION   = 0
+	BITSTREAM_FORMAT_VERSION   = 1          // version written
+	MIN_BITSTREAM_VERSION      = 0          // oldest version read
 	STREAM_DEFAULT_BUFFER_SIZE = 1024 * 1024
 	COPY_LENGTH_MASK           = 0x0F
 	SMALL_BLOCK_MASK           = 0x80
 	SKIP_FUNCTION_MASK         = 0x40
+	RAW_BLOCK_MASK             = 0x20 // block not entropy coded
 	MIN_BITSTREAM_BLOCK_SIZE   = 1024
 	MAX_BITSTREAM_BLOCK_SIZE   = 512 * 1024 * 1024
 	SMALL_BLOCK_SIZE           = 15
+	CONTENT_HASH_SIZE          = sha256.Size
+
+	// Handling of the bits following the end of the stream
+	TRAILING_IGNORE  = 0 // not read
+	TRAILING_PADDING = 1 // padding bits up to the byte boundary must be 0
+	TRAILING_STRICT  = 2 // valid padding and no data after the stream
+
+	// Checksum granularity (flags, both can be combined)
+	CHECKSUM_NONE   = 0
+	CHECKSUM_BLOCK  = 1 // checksum of each block, verified when the block is decoded
+	CHECKSUM_STREAM = 2 // checksum of all the data, verified at the end of the stream
 
 	ERR_MISSING_FILENAME    = -1
 	ERR_BLOCK_SIZE          = -2
@@ -61,16 +79,26 @@ const (
 	ERR_CREATE_CODEC        = -14
 	ERR_INVALID_FILE        = -15
 	ERR_STREAM_VERSION      = -16
+	ERR_MEMORY_LIMIT        = -17
+	ERR_CONTENT_HASH        = -18
+	ERR_TRAILING_DATA       = -19
 	ERR_UNKNOWN             = -127
 )
 
 var (
 	EMPTY_BYTE_SLICE = make([]byte, 0)
+
+	ErrUnsupportedVersion = errors.New("Unsupported stream version")
+
+	ErrChecksumMismatch = errors.New("Checksum mismatch")
+
+	ErrInvalidStreamType = errors.New("Invalid stream type")
 )
 
 type IOError struct {
 	msg  string
 	code int
+	err  error // underlying error (optional)
 }
 
 func NewIOError(msg string, code int) *IOError {
@@ -93,22 +121,67 @@ func (this IOError) ErrorCode() int {
 	return this.code
 }
 
+// Return the underlying error (EG. ErrUnsupportedVersion) or nil
+func (this IOError) Unwrap() error {
+	return this.err
+}
+
+// Error of a block or stream checksum verification (wraps ErrChecksumMismatch)
+func newChecksumError(msg string) *IOError {
+	return &IOError{msg: msg, code: ERR_PROCESS_BLOCK, err: ErrChecksumMismatch}
+}
+
+// Return an error if the stream version cannot be read by this version of
+// the library. The error wraps ErrUnsupportedVersion.
+func checkStreamVersion(version int) *IOError {
+	if version >= MIN_BITSTREAM_VERSION && version <= BITSTREAM_FORMAT_VERSION {
+		return nil
+	}
+
+	errMsg := fmt.Sprintf("%v: version %d required, this library reads versions %d to %d",
+		ErrUnsupportedVersion, version, MIN_BITSTREAM_VERSION, BITSTREAM_FORMAT_VERSION)
+	return &IOError{msg: errMsg, code: ERR_STREAM_VERSION, err: ErrUnsupportedVersion}
+}
+
+// Bitstreams that can be redirected to a new stream (see Reset). Only the
+// kanzi bitstream interfaces are assumed otherwise.
+type resettableOutputBitStream interface {
+	kanzi.OutputBitStream
+
+	Reset(stream kanzi.OutputStream) error
+}
+
+type resettableInputBitStream interface {
+	kanzi.InputBitStream
+
+	Reset(stream kanzi.InputStream) error
+}
+
 type CompressedOutputStream struct {
-	blockSize     uint
-	hasher        *util.XXHash
-	data          []byte
-	buffers       [][]byte
-	entropyType   byte
-	transformType byte
-	obs           kanzi.OutputBitStream
-	debugWriter   io.Writer
-	initialized   bool
-	closed        bool
-	blockId       int
-	curIdx        int
-	jobs          int
-	channels      []chan error
-	listeners     *list.List
+	blockSize      uint
+	hasher         *util.XXHash
+	streamHasher   *util.XXHash
+	streamChecksum uint32
+	data           []byte
+	buffers        [][]byte
+	entropyType    byte
+	transformType  byte
+	obs            kanzi.OutputBitStream
+	debugWriter    io.Writer
+	initialized    bool
+	closed         bool
+	blockId        int
+	curIdx         int
+	jobs           int
+	channels       []chan error
+	listeners      *list.List
+	splitter       *util.ContentSplitter
+	contentHasher  hash.Hash
+	rawCheck       bool
+	storedFallback bool
+	ctx            context.Context
+	verify         bool
+	fault          func(blockId int, encoded []byte)
 }
 
 func NewCompressedOutputStream(entropyCodec string, functionType string, os kanzi.OutputStream, blockSize uint,
@@ -164,6 +237,7 @@ func NewCompressedOutputStream(entropyCodec string, functionType string, os kanz
 		}
 	}
 
+	this.streamChecksum = BITSTREAM_TYPE
 	this.data = make([]byte, jobs*blockSize)
 	this.buffers = make([][]byte, jobs)
 
@@ -208,6 +282,164 @@ func (this *CompressedOutputStream) RemoveListener(bl BlockListener) bool {
 	return false
 }
 
+// Enable or disable content-aware block splitting. When enabled, blocks end
+// at content transitions (between half and full block size) instead of fixed
+// offsets. Must be called before the first block is written.
+func (this *CompressedOutputStream) SetContentSplit(split bool) bool {
+	if this.initialized == true {
+		return false
+	}
+
+	if split == false {
+		this.splitter = nil
+		return true
+	}
+
+	var err error
+	this.splitter, err = util.NewContentSplitter()
+	return err == nil
+}
+
+// Enable or disable the detection of incompressible blocks. When enabled, a
+// prefix of each block is entropy coded first and the block is stored raw
+// (not entropy coded) if the prefix does not shrink. Must be called before
+// the first block is written.
+func (this *CompressedOutputStream) SetIncompressibleCheck(enabled bool) bool {
+	if this.initialized == true {
+		return false
+	}
+
+	this.rawCheck = enabled
+	return true
+}
+
+// Enable or disable the stored fallback: each block is entropy coded in
+// memory first and stored raw (not entropy coded, same as an incompressible
+// block) if the entropy coded data is not smaller than the block. Unlike the
+// incompressible check (estimate on a prefix), the output of a block is then
+// never larger than its input plus the block header. Must be called before
+// the first block is written.
+func (this *CompressedOutputStream) SetStoredFallback(enabled bool) bool {
+	if this.initialized == true {
+		return false
+	}
+
+	this.storedFallback = enabled
+	return true
+}
+
+// Enable or disable the content hash: a SHA-256 hash of the original data
+// appended to the stream (after the end block) and signaled in the header.
+// Identical inputs yield identical hashes regardless of the compression
+// parameters. See ReadContentHash. Must be called before the first block is
+// written.
+func (this *CompressedOutputStream) SetContentHash(enabled bool) bool {
+	if this.initialized == true {
+		return false
+	}
+
+	if enabled == false {
+		this.contentHasher = nil
+	} else {
+		this.contentHasher = sha256.New()
+	}
+
+	return true
+}
+
+// Enable or disable the verification of the encoded blocks. When enabled,
+// each block is entropy coded in memory, decoded (entropy decoding and
+// inverse transform) and compared to the original data before being written
+// to the bitstream. Write or Close fail if a block cannot be decoded. This
+// roughly doubles the encoding time. Must be called before the first block
+// is written.
+func (this *CompressedOutputStream) SetVerify(enabled bool) bool {
+	if this.initialized == true {
+		return false
+	}
+
+	this.verify = enabled
+	return true
+}
+
+// Test hook: the function is applied to the entropy coded data of each block
+// before verification (EG. to simulate a faulty encoder). Verify mode only.
+func (this *CompressedOutputStream) SetEncodeFault(fault func(blockId int, encoded []byte)) {
+	this.fault = fault
+}
+
+// Attach a context: once it is cancelled (or past its deadline), the
+// processing stops at the next block boundary and Write and Close return
+// the context error. Close still releases the resources (the stream is
+// incomplete). Can be called at any time.
+func (this *CompressedOutputStream) SetContext(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+
+	this.ctx = ctx
+	return true
+}
+
+// Return the error of the context if it is done, nil otherwise
+func contextError(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+
+	default:
+		return nil
+	}
+}
+
+// Select the checksum granularity: CHECKSUM_NONE, CHECKSUM_BLOCK (one 32 bit
+// checksum per block, a corrupted block is detected when decoded), or
+// CHECKSUM_STREAM (one 32 bit checksum of all the data after the end block,
+// verified once the whole stream has been decoded) or both flags. Overrides
+// the checksum parameter of the constructor. Must be called before the first
+// block is written.
+func (this *CompressedOutputStream) SetChecksumMode(mode int) bool {
+	if this.initialized == true || mode&^(CHECKSUM_BLOCK|CHECKSUM_STREAM) != 0 {
+		return false
+	}
+
+	this.hasher = nil
+	this.streamHasher = nil
+
+	if mode&CHECKSUM_BLOCK != 0 {
+		this.hasher, _ = util.NewXXHash(BITSTREAM_TYPE)
+	}
+
+	if mode&CHECKSUM_STREAM != 0 {
+		this.streamHasher, _ = util.NewXXHash(BITSTREAM_TYPE)
+	}
+
+	return true
+}
+
+// Return the checksum granularity (combination of checksum flags)
+func (this *CompressedOutputStream) ChecksumMode() int {
+	return checksumMode(this.hasher, this.streamHasher)
+}
+
+func checksumMode(hasher, streamHasher *util.XXHash) int {
+	mode := CHECKSUM_NONE
+
+	if hasher != nil {
+		mode |= CHECKSUM_BLOCK
+	}
+
+	if streamHasher != nil {
+		mode |= CHECKSUM_STREAM
+	}
+
+	return mode
+}
+
 func (this *CompressedOutputStream) WriteHeader() *IOError {
 	if this.initialized == true {
 		return nil
@@ -219,6 +451,18 @@ func (this *CompressedOutputStream) WriteHeader() *IOError {
 		cksum = 1
 	}
 
+	chash := 0
+
+	if this.contentHasher != nil {
+		chash = 1
+	}
+
+	scksum := 0
+
+	if this.streamHasher != nil {
+		scksum = 1
+	}
+
 	if this.obs.WriteBits(BITSTREAM_TYPE, 32) != 32 {
 		return NewIOError("Cannot write bitstream type to header", ERR_WRITE_FILE)
 	}
@@ -243,10 +487,23 @@ func (this *CompressedOutputStream) WriteHeader() *IOError {
 		return NewIOError("Cannot write block size to header", ERR_WRITE_FILE)
 	}
 
-	if this.obs.WriteBits(0, 4) != 4 {
+	if this.obs.WriteBits(uint64(chash), 1) != 1 {
+		return NewIOError("Cannot write content hash flag to header", ERR_WRITE_FILE)
+	}
+
+	if this.obs.WriteBits(uint64(scksum), 1) != 1 {
+		return NewIOError("Cannot write stream checksum flag to header", ERR_WRITE_FILE)
+	}
+
+	if this.obs.WriteBits(0, 2) != 2 {
 		return NewIOError("Cannot write reserved bits to header", ERR_WRITE_FILE)
 	}
 
+	// Header extension (no field defined yet)
+	if this.obs.WriteBits(0, 16) != 16 {
+		return NewIOError("Cannot write header extension size to header", ERR_WRITE_FILE)
+	}
+
 	return nil
 }
 
@@ -277,6 +534,11 @@ func (this *CompressedOutputStream) Write(array []byte) (int, error) {
 
 		// Process a chunk of in-buffer data. No access to bitstream required
 		copy(this.data[this.curIdx:], array[startChunk:startChunk+lenChunk])
+
+		if this.contentHasher != nil {
+			this.contentHasher.Write(array[startChunk : startChunk+lenChunk])
+		}
+
 		this.curIdx += lenChunk
 		startChunk += lenChunk
 		remaining -= lenChunk
@@ -291,25 +553,174 @@ func (this *CompressedOutputStream) Close() error {
 		return nil
 	}
 
-	if this.curIdx > 0 {
+	if err := contextError(this.ctx); err != nil {
+		// Cancelled: drop the pending data and release the resources
+		this.obs.Close()
+		this.release()
+		return err
+	}
+
+	// Several passes may be required if blocks are split on content
+	for this.curIdx > 0 {
 		if err := this.processBlock(); err != nil {
 			return err
 		}
+	}
+
+	if this.initialized == false {
+		// Empty stream: the header is still required
+		if err := this.WriteHeader(); err != nil {
+			return err
+		}
 
-		this.curIdx = 0
+		this.initialized = true
 	}
 
 	// Write end block of size 0
 	this.obs.WriteBits(SMALL_BLOCK_MASK, 8)
 
+	if this.streamHasher != nil {
+		// Byte aligned stream checksum after the end block
+		this.obs.WriteBits(0, uint((8-this.obs.Written()&7)&7))
+		this.obs.WriteBits(uint64(this.streamChecksum), 32)
+	}
+
+	if this.contentHasher != nil {
+		// Byte aligned content hash at the very end of the stream
+		this.obs.WriteBits(0, uint((8-this.obs.Written()&7)&7))
+
+		for _, b := range this.contentHasher.Sum(nil) {
+			this.obs.WriteBits(uint64(b), 8)
+		}
+	}
+
 	if _, err := this.obs.Close(); err != nil {
 		return err
 	}
 
-	this.closed = true
+	this.release()
+	return nil
+}
 
-	// Release resources
-	this.data = EMPTY_BYTE_SLICE
+// Entropy code the transformed block to memory, then decode it like the
+// decoder would and compare to the original data. Return the encoded data
+// and its size in bits.
+func (this *CompressedOutputStream) encodeAndVerify(data, transformed []byte, mode byte,
+	typeOfTransform, typeOfEntropy byte, currentBlockId int) (res []byte, bits uint64, ioerr *IOError) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg := fmt.Sprintf("Verification of block %d failed: %v", currentBlockId, r)
+			ioerr = NewIOError(errMsg, ERR_PROCESS_BLOCK)
+		}
+	}()
+
+	res, bits, ioerr = entropyEncodeToMemory(transformed, typeOfEntropy)
+
+	if ioerr != nil {
+		return nil, 0, ioerr
+	}
+
+	if this.fault != nil {
+		this.fault(currentBlockId, res)
+	}
+
+	// Decode (the bitstream may read ahead past the end of the data)
+	is, _ := util.NewByteArrayInputStream(res, true)
+	ibs, err := bitstream.NewDefaultInputBitStream(is, 65536)
+
+	if err != nil {
+		return nil, 0, NewIOError(err.Error(), ERR_CREATE_BITSTREAM)
+	}
+
+	ed, err := entropy.NewEntropyDecoder(ibs, typeOfEntropy)
+
+	if err != nil {
+		return nil, 0, NewIOError(err.Error(), ERR_CREATE_CODEC)
+	}
+
+	// Same buffer sizes as the decoder
+	bufferSize := int(this.blockSize)
+
+	if bufferSize < len(transformed) {
+		bufferSize = len(transformed)
+	}
+
+	buffer := make([]byte, bufferSize)
+	decoded := buffer[0:len(transformed)]
+	_, err = ed.Decode(decoded)
+	ed.Dispose()
+
+	if err == nil && mode&(SMALL_BLOCK_MASK|SKIP_FUNCTION_MASK) == 0 {
+		var transform kanzi.ByteFunction
+
+		if transform, err = function.NewByteFunction(uint(len(decoded)), typeOfTransform); err == nil {
+			output := make([]byte, this.blockSize)
+			var oIdx uint
+
+			if _, oIdx, err = transform.Inverse(buffer, output); err == nil {
+				decoded = output[0:oIdx]
+			}
+		}
+	}
+
+	if err == nil && bytes.Equal(decoded, data) == false {
+		err = errors.New("Decoded data differs from original data")
+	}
+
+	if err != nil {
+		errMsg := fmt.Sprintf("Verification of block %d failed: %v", currentBlockId, err)
+		return nil, 0, NewIOError(errMsg, ERR_PROCESS_BLOCK)
+	}
+
+	return res, bits, nil
+}
+
+// Entropy code the block to memory. Return the encoded data and its size in
+// bits.
+func entropyEncodeToMemory(block []byte, typeOfEntropy byte) ([]byte, uint64, *IOError) {
+	os := &byteOutputStream{}
+	obs, err := bitstream.NewDefaultOutputBitStream(os, 65536)
+
+	if err != nil {
+		return nil, 0, NewIOError(err.Error(), ERR_CREATE_BITSTREAM)
+	}
+
+	ee, err := entropy.NewEntropyEncoder(obs, typeOfEntropy)
+
+	if err != nil {
+		return nil, 0, NewIOError(err.Error(), ERR_CREATE_CODEC)
+	}
+
+	if _, err = ee.Encode(block); err != nil {
+		return nil, 0, NewIOError(err.Error(), ERR_PROCESS_BLOCK)
+	}
+
+	ee.Dispose()
+	bits := obs.Written()
+	obs.Close()
+	return os.buffer.Bytes(), bits, nil
+}
+
+// Write the first 'bits' bits of the data to the bitstream
+func writeBits(obs kanzi.OutputBitStream, data []byte, bits uint64) {
+	n := int(bits >> 6)
+
+	for i := 0; i < n; i++ {
+		obs.WriteBits(binary.BigEndian.Uint64(data[8*i:]), 64)
+	}
+
+	if remaining := uint(bits & 63); remaining > 0 {
+		last := make([]byte, 8)
+		copy(last, data[8*n:])
+		obs.WriteBits(binary.BigEndian.Uint64(last)>>(64-remaining), remaining)
+	}
+}
+
+// Mark the stream closed and release resources (the data buffer is kept for
+// Reset)
+func (this *CompressedOutputStream) release() {
+	this.closed = true
+	this.curIdx = 0
 
 	for i := range this.buffers {
 		this.buffers[i] = EMPTY_BYTE_SLICE
@@ -320,6 +731,42 @@ func (this *CompressedOutputStream) Close() error {
 	}
 
 	this.listeners.Init()
+}
+
+// Discard the state of the stream (unwritten data is lost) and write a new
+// stream to 'os' with the same parameters (codecs, block size, checksum,
+// options). The data and bitstream buffers are reused. Can be called after
+// Close to reuse the stream object (EG. from a pool).
+func (this *CompressedOutputStream) Reset(os kanzi.OutputStream) error {
+	if os == nil {
+		return errors.New("Invalid null output stream parameter")
+	}
+
+	obs, isResettable := this.obs.(resettableOutputBitStream)
+
+	if isResettable == false {
+		return errors.New("The output bitstream cannot be reset")
+	}
+
+	if err := obs.Reset(os); err != nil {
+		return err
+	}
+
+	if this.closed == true {
+		for i := range this.channels {
+			this.channels[i] = make(chan error)
+		}
+	}
+
+	if this.contentHasher != nil {
+		this.contentHasher.Reset()
+	}
+
+	this.initialized = false
+	this.closed = false
+	this.blockId = 0
+	this.curIdx = 0
+	this.streamChecksum = BITSTREAM_TYPE
 	return nil
 }
 
@@ -328,6 +775,11 @@ func (this *CompressedOutputStream) processBlock() error {
 		return nil
 	}
 
+	// Block boundary: stop if the context is done
+	if err := contextError(this.ctx); err != nil {
+		return err
+	}
+
 	if this.initialized == false {
 		if err := this.WriteHeader(); err != nil {
 			return err
@@ -358,6 +810,21 @@ func (this *CompressedOutputStream) processBlock() error {
 
 		if sz >= this.blockSize {
 			sz = this.blockSize
+
+			if this.splitter != nil {
+				// Look for a content transition in the second half of the block
+				end := offset + uint(this.curIdx)
+
+				if idx, err := this.splitter.Split(this.data[offset:end], int(sz>>1), int(sz)); err == nil {
+					sz = uint(idx)
+				}
+			}
+		}
+
+		if this.streamHasher != nil {
+			// Chain the block checksums, in block order
+			this.streamHasher.SetSeed(this.streamChecksum)
+			this.streamChecksum = this.streamHasher.Hash(this.data[offset : offset+sz])
 		}
 
 		// Invoke the tasks concurrently
@@ -381,6 +848,12 @@ func (this *CompressedOutputStream) processBlock() error {
 	// Wait for completion of last task
 	err := <-this.channels[blockNumber-this.blockId]
 
+	// Move unprocessed data (if split blocks left some) to the start of buffer
+	// All tasks are completed, hence the data is not accessed concurrently
+	if this.curIdx > 0 {
+		copy(this.data, this.data[offset:offset+uint(this.curIdx)])
+	}
+
 	this.blockId += this.jobs
 	return err
 }
@@ -427,6 +900,14 @@ func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
 		checksum = this.hasher.Hash(data[0:blockLength])
 	}
 
+	var original []byte
+
+	if this.verify == true {
+		// Some transforms use the source buffer as work buffer
+		original = make([]byte, blockLength)
+		copy(original, data[0:blockLength])
+	}
+
 	if len(listeners_) > 0 {
 		// Notify before transform
 		evt, err := NewBlockEvent(EVT_BEFORE_TRANSFORM, currentBlockId,
@@ -447,7 +928,7 @@ func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
 
 		iIdx += blockLength
 		oIdx += blockLength
-		mode = byte(SMALL_BLOCK_SIZE | (blockLength & COPY_LENGTH_MASK))
+		mode = byte(SMALL_BLOCK_MASK | (blockLength & COPY_LENGTH_MASK))
 	} else {
 
 		// Forward transform
@@ -479,6 +960,13 @@ func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
 		// Record size of 'block size' - 1 in bytes
 		mode |= byte(dataSize & 0x03)
 		dataSize++
+
+		// Store the block raw rather than expanding it
+		if this.rawCheck == true && postTransformLength >= entropy.MIN_COMPRESSIBLE_CHECK &&
+			entropy.CheckCompressible(buffer[0:postTransformLength], typeOfEntropy) == entropy.ErrIncompressible {
+			mode |= RAW_BLOCK_MASK
+			typeOfEntropy = entropy.NONE_TYPE
+		}
 	}
 
 	if len(listeners_) > 0 {
@@ -493,6 +981,41 @@ func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
 		}
 	}
 
+	var encoded []byte
+	var encodedBits uint64
+
+	// Entropy code in memory and store the block raw if it does not shrink
+	if this.storedFallback == true && mode&(SMALL_BLOCK_MASK|RAW_BLOCK_MASK) == 0 &&
+		typeOfEntropy != entropy.NONE_TYPE {
+		var ioerr *IOError
+		encoded, encodedBits, ioerr = entropyEncodeToMemory(buffer[0:postTransformLength], typeOfEntropy)
+
+		if ioerr != nil {
+			<-input
+			output <- ioerr
+			return
+		}
+
+		if encodedBits >= 8*uint64(postTransformLength) {
+			mode |= RAW_BLOCK_MASK
+			typeOfEntropy = entropy.NONE_TYPE
+			encoded = nil
+		}
+	}
+
+	if this.verify == true {
+		// Entropy code in memory (concurrently) and decode before writing
+		var ioerr *IOError
+		encoded, encodedBits, ioerr = this.encodeAndVerify(original, buffer[0:postTransformLength],
+			mode, typeOfTransform, typeOfEntropy, currentBlockId)
+
+		if ioerr != nil {
+			<-input
+			output <- ioerr
+			return
+		}
+	}
+
 	// Wait for the concurrent task processing the previous block to complete
 	// entropy encoding. Entropy encoding must happen sequentially (and
 	// in the correct block order) in the bitstream.
@@ -503,13 +1026,17 @@ func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
 		return
 	}
 
-	// Each block is encoded separately
-	// Rebuild the entropy encoder to reset block statistics
-	ee, err := entropy.NewEntropyEncoder(this.obs, typeOfEntropy)
+	var ee kanzi.EntropyEncoder
 
-	if err != nil {
-		output <- NewIOError(err.Error(), ERR_CREATE_CODEC)
-		return
+	if encoded == nil {
+		// Each block is encoded separately
+		// Rebuild the entropy encoder to reset block statistics
+		ee, err = entropy.NewEntropyEncoder(this.obs, typeOfEntropy)
+
+		if err != nil {
+			output <- NewIOError(err.Error(), ERR_CREATE_CODEC)
+			return
+		}
 	}
 
 	// Write block 'header' (mode + compressed length)
@@ -537,16 +1064,21 @@ func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
 		}
 	}
 
-	// Entropy encode block
-	_, err = ee.Encode(buffer[0:postTransformLength])
+	if encoded != nil {
+		// Copy the block encoded in memory (verified in verify mode)
+		writeBits(this.obs, encoded, encodedBits)
+	} else {
+		// Entropy encode block
+		_, err = ee.Encode(buffer[0:postTransformLength])
 
-	if err != nil {
-		output <- NewIOError(err.Error(), ERR_PROCESS_BLOCK)
-		return
-	}
+		if err != nil {
+			output <- NewIOError(err.Error(), ERR_PROCESS_BLOCK)
+			return
+		}
 
-	// Dispose before displaying statistics. Dispose may write to the bitstream
-	ee.Dispose()
+		// Dispose before displaying statistics. Dispose may write to the bitstream
+		ee.Dispose()
+	}
 
 	if len(listeners_) > 0 {
 		// Notify after entropy
@@ -565,36 +1097,49 @@ func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
 }
 
 type Message struct {
-	err      *IOError
-	decoded  int
-	blockId  int
-	text     string
-	checksum uint32
+	err       *IOError
+	decoded   int
+	blockId   int
+	text      string
+	checksum  uint32
+	corrupted bool // block checksum mismatch
 }
 
 type semaphore chan bool
 
 type CompressedInputStream struct {
-	blockSize     uint
-	hasher        *util.XXHash
-	data          []byte
-	buffers       [][]byte
-	entropyType   byte
-	transformType byte
-	ibs           kanzi.InputBitStream
-	debugWriter   io.Writer
-	initialized   bool
-	closed        bool
-	blockId       int
-	maxIdx        int
-	curIdx        int
-	jobs          int
-	syncChan      []semaphore
-	resChan       chan Message
-	listeners     *list.List
-}
-
-func NewCompressedInputStream(is *BufferedInputStream,
+	blockSize      uint
+	hasher         *util.XXHash
+	streamHasher   *util.XXHash
+	streamChecksum uint32
+	corruptedBlock int
+	data           []byte
+	buffers        [][]byte
+	entropyType    byte
+	transformType  byte
+	ibs            kanzi.InputBitStream
+	debugWriter    io.Writer
+	initialized    bool
+	closed         bool
+	blockId        int
+	maxIdx         int
+	curIdx         int
+	jobs           int
+	syncChan       []semaphore
+	resChan        chan Message
+	listeners      *list.List
+	memoryLimit    uint64 // 0 means no limit
+	memory         uint64 // accessed atomically
+	peakMemory     uint64 // accessed atomically
+	hasContentHash bool
+	contentHash    []byte
+	endOfStream    bool
+	trailingMode   int
+	trailerRead    bool
+	ctx            context.Context
+}
+
+func NewCompressedInputStream(is kanzi.InputStream,
 	debugWriter io.Writer, jobs uint) (*CompressedInputStream, error) {
 	if is == nil {
 		return nil, errors.New("Invalid null input stream parameter")
@@ -661,6 +1206,68 @@ func (this *CompressedInputStream) RemoveListener(bl BlockListener) bool {
 	return false
 }
 
+// Set the maximum amount of memory (in bytes) used to decode the stream:
+// block buffers, entropy models and transform work buffers. A stream that
+// requires more memory is rejected before any allocation. 0 means no limit.
+// Must be called before the first block is read.
+func (this *CompressedInputStream) SetMemoryLimit(limit uint64) bool {
+	if this.initialized == true {
+		return false
+	}
+
+	this.memoryLimit = limit
+	return true
+}
+
+// Set how the bits following the end block (and the content hash) are
+// checked: TRAILING_IGNORE (default), TRAILING_PADDING or TRAILING_STRICT.
+// TRAILING_STRICT requires an input stream that reports its end (a stream
+// padded with zeros always has trailing data).
+// Must be called before the first block is read.
+func (this *CompressedInputStream) SetTrailingMode(mode int) bool {
+	if this.initialized == true {
+		return false
+	}
+
+	if mode != TRAILING_IGNORE && mode != TRAILING_PADDING && mode != TRAILING_STRICT {
+		return false
+	}
+
+	this.trailingMode = mode
+	return true
+}
+
+// Return the peak amount of memory (in bytes) used to decode the stream so far
+func (this *CompressedInputStream) GetPeakMemory() uint64 {
+	return atomic.LoadUint64(&this.peakMemory)
+}
+
+// Account for an allocation of 'size' bytes. Fail if the memory limit would
+// be exceeded.
+func (this *CompressedInputStream) reserveMemory(size uint64) *IOError {
+	mem := atomic.AddUint64(&this.memory, size)
+
+	if this.memoryLimit > 0 && mem > this.memoryLimit {
+		atomic.AddUint64(&this.memory, ^(size - 1))
+		errMsg := fmt.Sprintf("Decompression requires %d bytes, memory limit is %d bytes", mem, this.memoryLimit)
+		return NewIOError(errMsg, ERR_MEMORY_LIMIT)
+	}
+
+	for {
+		peak := atomic.LoadUint64(&this.peakMemory)
+
+		if mem <= peak || atomic.CompareAndSwapUint64(&this.peakMemory, peak, mem) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (this *CompressedInputStream) releaseMemory(size uint64) {
+	atomic.AddUint64(&this.memory, ^(size - 1))
+}
+
 func (this *CompressedInputStream) ReadHeader() error {
 	if this.initialized == true {
 		return nil
@@ -677,16 +1284,15 @@ func (this *CompressedInputStream) ReadHeader() error {
 
 	// Sanity check
 	if fileType != BITSTREAM_TYPE {
-		errMsg := fmt.Sprintf("Invalid stream type: expected %#x, got %#x", BITSTREAM_TYPE, fileType)
-		return NewIOError(errMsg, ERR_INVALID_FILE)
+		errMsg := fmt.Sprintf("%v: expected %#x, got %#x", ErrInvalidStreamType, BITSTREAM_TYPE, fileType)
+		return &IOError{msg: errMsg, code: ERR_INVALID_FILE, err: ErrInvalidStreamType}
 	}
 
-	version := this.ibs.ReadBits(7)
+	version := int(this.ibs.ReadBits(7))
 
 	// Sanity check
-	if version != BITSTREAM_FORMAT_VERSION {
-		errMsg := fmt.Sprintf("Invalid bitstream, cannot read this version of the stream: %d", version)
-		return NewIOError(errMsg, ERR_STREAM_VERSION)
+	if err := checkStreamVersion(version); err != nil {
+		return err
 	}
 
 	// Read block checksum
@@ -713,11 +1319,34 @@ func (this *CompressedInputStream) ReadHeader() error {
 		return NewIOError(errMsg, ERR_BLOCK_SIZE)
 	}
 
+	// Read content hash flag
+	this.hasContentHash = this.ibs.ReadBit() == 1
+
+	// Read stream checksum flag
+	if this.ibs.ReadBit() == 1 {
+		var err error
+		this.streamHasher, err = util.NewXXHash(BITSTREAM_TYPE)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	this.streamChecksum = BITSTREAM_TYPE
+
 	// Read reserved bits
-	this.ibs.ReadBits(4)
+	this.ibs.ReadBits(2)
+
+	if version > 0 {
+		if err := this.readHeaderExtension(); err != nil {
+			return err
+		}
+	}
 
 	if this.debugWriter != nil {
 		fmt.Fprintf(this.debugWriter, "Checksum set to %v\n", (this.hasher != nil))
+		fmt.Fprintf(this.debugWriter, "Stream checksum set to %v\n", (this.streamHasher != nil))
+		fmt.Fprintf(this.debugWriter, "Content hash set to %v\n", this.hasContentHash)
 		fmt.Fprintf(this.debugWriter, "Block size set to %d bytes\n", this.blockSize)
 		w1 := function.GetByteFunctionName(this.transformType)
 
@@ -738,6 +1367,35 @@ func (this *CompressedInputStream) ReadHeader() error {
 	return nil
 }
 
+// Read the header extension (version 1+): 16 bits for the size in bytes of
+// the fields, then the fields (8 bits tag, 8 bits length, data). Fields are
+// added by newer versions of the library, unknown fields are skipped.
+func (this *CompressedInputStream) readHeaderExtension() error {
+	size := int(this.ibs.ReadBits(16))
+
+	for size > 0 {
+		if size < 2 {
+			return NewIOError("Invalid bitstream, incorrect header extension", ERR_INVALID_FILE)
+		}
+
+		this.ibs.ReadBits(8) // tag (no field defined yet)
+		length := int(this.ibs.ReadBits(8))
+		size -= 2
+
+		if length > size {
+			return NewIOError("Invalid bitstream, incorrect header field length", ERR_INVALID_FILE)
+		}
+
+		for i := 0; i < length; i++ {
+			this.ibs.ReadBits(8)
+		}
+
+		size -= length
+	}
+
+	return nil
+}
+
 // Implement kanzi.InputStream interface
 func (this *CompressedInputStream) Close() error {
 	if this.closed == true {
@@ -750,9 +1408,8 @@ func (this *CompressedInputStream) Close() error {
 
 	this.closed = true
 
-	// Release resources
+	// Release resources (the data buffer is kept for Reset)
 	this.maxIdx = 0
-	this.data = EMPTY_BYTE_SLICE
 
 	for i := range this.buffers {
 		this.buffers[i] = EMPTY_BYTE_SLICE
@@ -769,6 +1426,54 @@ func (this *CompressedInputStream) Close() error {
 	return nil
 }
 
+// Discard the state of the stream (undecoded data is lost) and read a new
+// stream from 'is'. The parameters are read from the new stream header, the
+// options (memory limit, trailing mode) are kept. The data and bitstream
+// buffers are reused. Can be called after Close to reuse the stream object
+// (EG. from a pool).
+func (this *CompressedInputStream) Reset(is kanzi.InputStream) error {
+	if is == nil {
+		return errors.New("Invalid null input stream parameter")
+	}
+
+	ibs, isResettable := this.ibs.(resettableInputBitStream)
+
+	if isResettable == false {
+		return errors.New("The input bitstream cannot be reset")
+	}
+
+	if err := ibs.Reset(is); err != nil {
+		return err
+	}
+
+	if this.closed == true {
+		for i := range this.syncChan {
+			if i > 0 {
+				this.syncChan[i] = make(semaphore)
+			}
+		}
+
+		this.resChan = make(chan Message)
+	}
+
+	this.initialized = false
+	this.closed = false
+	this.hasher = nil
+	this.streamHasher = nil
+	this.corruptedBlock = 0
+	this.blockSize = 0
+	this.blockId = 0
+	this.maxIdx = 0
+	this.curIdx = 0
+	this.hasContentHash = false
+	this.contentHash = nil
+	this.endOfStream = false
+	this.trailerRead = false
+	atomic.StoreUint64(&this.memory, 0)
+	atomic.StoreUint64(&this.peakMemory, 0)
+	return nil
+}
+
 // Implement kanzi.InputStream interface
 func (this *CompressedInputStream) Read(array []byte) (int, error) {
 	if this.closed == true {
@@ -822,13 +1527,66 @@ func (this *CompressedInputStream) Read(array []byte) (int, error) {
 	return len(array) - remaining, nil
 }
 
+// Decode and return the next n bytes of the stream (fewer if the end of the
+// stream is reached first). Only the blocks required to produce these bytes
+// are decoded and the bitstream is left positioned after the last decoded
+// block: decoding can resume with Read or DecodePrefix.
+func (this *CompressedInputStream) DecodePrefix(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("Invalid negative length parameter")
+	}
+
+	res := make([]byte, n)
+	read := 0
+
+	for read < n {
+		decoded, err := this.Read(res[read:])
+
+		if err != nil {
+			return res[0:read], err
+		}
+
+		if decoded <= 0 {
+			// End of stream
+			break
+		}
+
+		read += decoded
+	}
+
+	return res[0:read], nil
+}
+
 func (this *CompressedInputStream) processBlock() (int, error) {
+	if this.endOfStream == true {
+		// Do not read past the end block
+		return 0, nil
+	}
+
+	// Block boundary: stop if the context is done
+	if err := contextError(this.ctx); err != nil {
+		return 0, err
+	}
+
 	if this.initialized == false {
 		if err := this.ReadHeader(); err != nil {
 			return 0, err
 		}
 
 		this.initialized = true
+
+		// Data buffer, transform buffers, entropy models and transform work
+		// buffers for each job (all allocated for the lifetime of the stream)
+		perJob := uint64(this.blockSize) + entropy.GetEntropyDecoderMemory(this.entropyType) +
+			function.GetByteFunctionMemory(this.transformType, this.blockSize)
+
+		if this.transformType != function.NULL_TRANSFORM_TYPE {
+			perJob += uint64(this.blockSize)
+		}
+
+		if err := this.reserveMemory(uint64(this.jobs) * perJob); err != nil {
+			return 0, err
+		}
 	}
 
 	if len(this.data) < int(this.blockSize)*this.jobs {
@@ -872,6 +1630,7 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 	var err error
 	decoded := 0
 	results := make([]Message, this.jobs)
+	bSize := int(this.blockSize)
 
 	// Wait for completion of all concurrent tasks
 	for i := 0; i < this.jobs; i++ {
@@ -889,7 +1648,25 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 				// Keep first error encountered
 				err = res.err
 			}
+
+			if res.corrupted == true && this.corruptedBlock == 0 {
+				this.corruptedBlock = res.blockId
+			}
 		} else {
+			// Blocks can be shorter than the block size (EG. content-aware
+			// split). Move the decoded data to keep the buffer contiguous.
+			blockOffset := (res.blockId - this.blockId - 1) * bSize
+
+			if decoded != blockOffset && res.decoded > 0 {
+				copy(this.data[decoded:], this.data[blockOffset:blockOffset+res.decoded])
+			}
+
+			if this.streamHasher != nil && err == nil && res.decoded > 0 {
+				// Chain the block checksums, in block order
+				this.streamHasher.SetSeed(this.streamChecksum)
+				this.streamChecksum = this.streamHasher.Hash(this.data[decoded : decoded+res.decoded])
+			}
+
 			// Add the number of decoded bytes for the current block
 			decoded += res.decoded
 		}
@@ -909,9 +1686,143 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 
 	this.blockId += this.jobs
 	this.curIdx = 0
+
+	if err == nil && this.endOfStream == true && this.trailerRead == false {
+		this.trailerRead = true
+		err = this.readTrailer()
+	}
+
 	return decoded, err
 }
 
+// Read the padding up to the byte boundary that follows the end block, the
+// stream checksum and content hash (if any) and check that the stream ends
+// (if required)
+func (this *CompressedInputStream) readTrailer() error {
+	if this.trailingMode == TRAILING_IGNORE && this.hasContentHash == false && this.streamHasher == nil {
+		return nil
+	}
+
+	if err := this.readPadding(); err != nil && this.trailingMode != TRAILING_IGNORE {
+		return err
+	}
+
+	if this.streamHasher != nil {
+		if err := this.verifyStreamChecksum(); err != nil {
+			return err
+		}
+	}
+
+	if this.hasContentHash == true {
+		if err := this.readContentHash(); err != nil {
+			return err
+		}
+	}
+
+	if this.trailingMode == TRAILING_STRICT && this.hasMoreData() == true {
+		return NewIOError("Unexpected data after the end of the stream", ERR_TRAILING_DATA)
+	}
+
+	return nil
+}
+
+// Read the bits up to the byte boundary, fail if they are not 0
+func (this *CompressedInputStream) readPadding() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewIOError("Cannot read padding: "+r.(error).Error(), ERR_TRAILING_DATA)
+		}
+	}()
+
+	if padding := uint((8 - this.ibs.Read()&7) & 7); padding > 0 {
+		if this.ibs.ReadBits(padding) != 0 {
+			return NewIOError("Invalid padding after the end of the stream", ERR_TRAILING_DATA)
+		}
+	}
+
+	return nil
+}
+
+// Return true if at least one more byte can be read from the bitstream
+func (this *CompressedInputStream) hasMoreData() (res bool) {
+	// The bitstream panics at the end of the input stream
+	defer func() {
+		if r := recover(); r != nil {
+			res = false
+		}
+	}()
+
+	this.ibs.ReadBits(8)
+	return true
+}
+
+// Read the stream checksum that follows the end block (byte aligned) and
+// compare it to the checksum of the decoded data
+func (this *CompressedInputStream) verifyStreamChecksum() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewIOError("Cannot read stream checksum: "+r.(error).Error(), ERR_PROCESS_BLOCK)
+		}
+	}()
+
+	checksum := uint32(this.ibs.ReadBits(32))
+
+	if checksum != this.streamChecksum {
+		errMsg := fmt.Sprintf("Corrupted bitstream: expected stream checksum %x, found %x", checksum, this.streamChecksum)
+		return newChecksumError(errMsg)
+	}
+
+	return nil
+}
+
+// Attach a context: once it is cancelled (or past its deadline), the
+// decoding stops at the next block boundary and Read returns the context
+// error. Can be called at any time.
+func (this *CompressedInputStream) SetContext(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+
+	this.ctx = ctx
+	return true
+}
+
+// Return the checksum granularity of the stream (combination of checksum
+// flags). Available once the header has been read.
+func (this *CompressedInputStream) ChecksumMode() int {
+	return checksumMode(this.hasher, this.streamHasher)
+}
+
+// Return the id (starting at 1) of the first block that failed the block
+// checksum verification or 0 if none did
+func (this *CompressedInputStream) CorruptedBlock() int {
+	return this.corruptedBlock
+}
+
+// Read the content hash that follows the end block (byte aligned)
+func (this *CompressedInputStream) readContentHash() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewIOError("Cannot read content hash: "+r.(error).Error(), ERR_CONTENT_HASH)
+		}
+	}()
+
+	res := make([]byte, CONTENT_HASH_SIZE)
+
+	for i := range res {
+		res[i] = byte(this.ibs.ReadBits(8))
+	}
+
+	this.contentHash = res
+	return nil
+}
+
+// Return the content hash embedded in the stream or nil if there is none.
+// Available once the end of the stream has been reached.
+func (this *CompressedInputStream) ContentHash() []byte {
+	return this.contentHash
+}
+
 // Return the number of bytes read so far
 func (this *CompressedInputStream) GetRead() uint64 {
 	return (this.ibs.Read() + 7) >> 3
@@ -963,6 +1874,11 @@ func (this *CompressedInputStream) decode(data, buf []byte,
 	if (mode & SMALL_BLOCK_MASK) != 0 {
 		preTransformLength = uint(mode & COPY_LENGTH_MASK)
 	} else {
+		if (mode & RAW_BLOCK_MASK) != 0 {
+			// Block stored raw (incompressible)
+			typeOfEntropy = entropy.NONE_TYPE
+		}
+
 		dataSize := uint(1 + (mode & 0x03))
 		length := dataSize << 3
 		mask := uint64(1<<length) - 1
@@ -971,6 +1887,7 @@ func (this *CompressedInputStream) decode(data, buf []byte,
 
 	if preTransformLength == 0 {
 		// Last block is empty, return success and cancel pending tasks
+		this.endOfStream = true
 		res.decoded = 0
 		notify(output, result, false, res)
 		return
@@ -1003,6 +1920,23 @@ func (this *CompressedInputStream) decode(data, buf []byte,
 
 	res.checksum = checksum1
 
+	if preTransformLength > this.blockSize {
+		// Larger block than announced in the header: account for the extra
+		// transform buffer and work buffers
+		extra := uint64(preTransformLength-this.blockSize) +
+			function.GetByteFunctionMemory(this.transformType, preTransformLength) -
+			function.GetByteFunctionMemory(this.transformType, this.blockSize)
+
+		if err := this.reserveMemory(extra); err != nil {
+			// Error => cancel concurrent decoding tasks
+			res.err = err
+			notify(output, result, false, res)
+			return
+		}
+
+		defer this.releaseMemory(extra)
+	}
+
 	if this.transformType == function.NULL_TRANSFORM_TYPE {
 		buffer = data // share buffers if no transform
 	} else {
@@ -1103,8 +2037,10 @@ func (this *CompressedInputStream) decode(data, buf []byte,
 			checksum2 := this.hasher.Hash(data[0:res.decoded])
 
 			if checksum2 != checksum1 {
-				errMsg := fmt.Sprintf("Corrupted bitstream: expected checksum %x, found %x", checksum1, checksum2)
-				res.err = NewIOError(errMsg, ERR_PROCESS_BLOCK)
+				errMsg := fmt.Sprintf("Corrupted bitstream: block %d, expected checksum %x, found %x",
+					currentBlockId, checksum1, checksum2)
+				res.err = newChecksumError(errMsg)
+				res.corrupted = true
 				notify(nil, result, false, res)
 				return
 			}
@@ -0,0 +1,68 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	BITSTREAM_HEADER_SIZE = 10 // in bytes
+)
+
+// Return the content hash of a compressed stream without decompressing it:
+// only the header and the last bytes of the stream are read. Return nil if
+// the stream has no content hash.
+func ReadContentHash(rs io.ReadSeeker) ([]byte, error) {
+	header := make([]byte, BITSTREAM_HEADER_SIZE)
+
+	if _, err := rs.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(rs, header); err != nil {
+		return nil, NewIOError("Cannot read bitstream header: "+err.Error(), ERR_READ_FILE)
+	}
+
+	fileType := uint32(header[0])<<24 | uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+
+	if fileType != BITSTREAM_TYPE {
+		errMsg := fmt.Sprintf("Invalid stream type: expected %#x, got %#x", BITSTREAM_TYPE, fileType)
+		return nil, NewIOError(errMsg, ERR_INVALID_FILE)
+	}
+
+	if err := checkStreamVersion(int(header[4] >> 1)); err != nil {
+		return nil, err
+	}
+
+	// Content hash flag: first reserved bit, after 76 bits of header
+	if (header[9]>>3)&1 == 0 {
+		return nil, nil
+	}
+
+	res := make([]byte, CONTENT_HASH_SIZE)
+
+	if _, err := rs.Seek(-CONTENT_HASH_SIZE, 2); err != nil {
+		return nil, NewIOError("Cannot read content hash: "+err.Error(), ERR_CONTENT_HASH)
+	}
+
+	if _, err := io.ReadFull(rs, res); err != nil {
+		return nil, NewIOError("Cannot read content hash: "+err.Error(), ERR_CONTENT_HASH)
+	}
+
+	return res, nil
+}
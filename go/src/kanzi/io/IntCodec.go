@@ -0,0 +1,276 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/util"
+)
+
+// Compression of arrays of signed integers ([]int32 or []int64):
+// - optional delta (difference with the previous value, wrapping in the
+//   element width), used when it makes the output smaller
+// - zigzag: signed to unsigned (0, -1, 1, -2, 2 ... => 0, 1, 2, 3, 4 ...)
+// - varint: 7 bits per byte, lsb first, msb set if more bytes follow
+// - range coding of the varint bytes
+// EG. [1000, 1001, 999] => delta [1000, 1, -2] => zigzag [2000, 2, 3]
+//     => varint 0xD0 0x0F 0x02 0x03
+// Format:
+// - 4 bits: element width in bytes (4 or 8)
+// - 1 bit: delta applied
+// - 6 bits + n bits: number of values (n is the 6 bit value)
+// - 6 bits + n bits: number of varint bytes
+// - range coded varint bytes
+
+const (
+	INT_CODEC_MAX_VARINT_SIZE = 10 // bytes for a 64 bit value
+)
+
+func zigzag(val int64) uint64 {
+	return uint64(val<<1) ^ uint64(val>>63)
+}
+
+func unzigzag(val uint64) int64 {
+	return int64(val>>1) ^ -int64(val&1)
+}
+
+// Return the value to encode at index i (delta wraps in the element width)
+func intCodecValue(values []int64, i int, delta bool, width uint) int64 {
+	if delta == false || i == 0 {
+		return values[i]
+	}
+
+	if width == 4 {
+		return int64(int32(values[i]) - int32(values[i-1]))
+	}
+
+	return values[i] - values[i-1]
+}
+
+func putVarint(buf []byte, val uint64) int {
+	n := 0
+
+	for val >= 0x80 {
+		buf[n] = byte(val | 0x80)
+		val >>= 7
+		n++
+	}
+
+	buf[n] = byte(val)
+	return n + 1
+}
+
+func varintSize(val uint64) int {
+	n := 1
+
+	for val >= 0x80 {
+		val >>= 7
+		n++
+	}
+
+	return n
+}
+
+// Return the compressed values. 'values' must be a []int32 or a []int64.
+func EncodeInts(values interface{}) (res []byte, err error) {
+	var data []int64
+	var width uint
+
+	switch v := values.(type) {
+	case []int32:
+		width = 4
+		data = make([]int64, len(v))
+
+		for i := range v {
+			data[i] = int64(v[i])
+		}
+
+	case []int64:
+		width = 8
+		data = v
+
+	default:
+		return nil, fmt.Errorf("Invalid values parameter type: %T (must be []int32 or []int64)", values)
+	}
+
+	// The bitstream panics on write errors
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("Encoding failed: %v", r)
+		}
+	}()
+
+	// Use delta if it reduces the number of varint bytes
+	size1 := 0
+	size2 := 0
+
+	for i := range data {
+		size1 += varintSize(zigzag(intCodecValue(data, i, false, width)))
+		size2 += varintSize(zigzag(intCodecValue(data, i, true, width)))
+	}
+
+	delta := size2 < size1
+	buffer := make([]byte, len(data)*INT_CODEC_MAX_VARINT_SIZE)
+	length := 0
+
+	for i := range data {
+		length += putVarint(buffer[length:], zigzag(intCodecValue(data, i, delta, width)))
+	}
+
+	os := &byteOutputStream{}
+	obs, err := bitstream.NewDefaultOutputBitStream(os, STREAM_DEFAULT_BUFFER_SIZE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obs.WriteBits(uint64(width), 4)
+
+	if delta == true {
+		obs.WriteBit(1)
+	} else {
+		obs.WriteBit(0)
+	}
+
+	writeLength(obs, uint(len(data)))
+	writeLength(obs, uint(length))
+	ee, err := entropy.NewEntropyEncoder(obs, entropy.RANGE_TYPE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = ee.Encode(buffer[0:length]); err != nil {
+		return nil, err
+	}
+
+	ee.Dispose()
+
+	if _, err = obs.Close(); err != nil {
+		return nil, err
+	}
+
+	return os.buffer.Bytes(), nil
+}
+
+// Return the original values: a []int32 or a []int64 depending on the
+// element width recorded in the data
+func DecodeInts(data []byte) (res interface{}, err error) {
+	if data == nil {
+		return nil, errors.New("Invalid null data parameter")
+	}
+
+	// The bitstream panics on read errors
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("Decoding failed: %v", r)
+		}
+	}()
+
+	// The entropy decoder may read ahead past the end of the data: pad with zeros
+	is, _ := util.NewByteArrayInputStream(data, true)
+	ibs, err := bitstream.NewDefaultInputBitStream(is, STREAM_DEFAULT_BUFFER_SIZE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	width := uint(ibs.ReadBits(4))
+
+	if width != 4 && width != 8 {
+		return nil, fmt.Errorf("Invalid element width: %d", width)
+	}
+
+	delta := ibs.ReadBit() == 1
+	count := readLength(ibs)
+	length := readLength(ibs)
+
+	if length > MAX_BITSTREAM_BLOCK_SIZE || count > length {
+		return nil, fmt.Errorf("Invalid number of values (%d) or bytes (%d)", count, length)
+	}
+
+	buffer := make([]byte, length)
+	ed, err := entropy.NewEntropyDecoder(ibs, entropy.RANGE_TYPE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer ed.Dispose()
+
+	if _, err = ed.Decode(buffer); err != nil {
+		return nil, err
+	}
+
+	values := make([]int64, count)
+	idx := 0
+	prev := int64(0)
+
+	for i := range values {
+		val := uint64(0)
+		shift := uint(0)
+
+		for {
+			if idx >= len(buffer) || shift >= 64 {
+				return nil, errors.New("Invalid varint data")
+			}
+
+			b := buffer[idx]
+			idx++
+			val |= uint64(b&0x7F) << shift
+			shift += 7
+
+			if b < 0x80 {
+				break
+			}
+		}
+
+		v := unzigzag(val)
+
+		if delta == true && i > 0 {
+			v += prev
+		}
+
+		if width == 4 {
+			// Wrap in 32 bits
+			v = int64(int32(v))
+		}
+
+		values[i] = v
+		prev = v
+	}
+
+	if idx != len(buffer) {
+		return nil, fmt.Errorf("Invalid varint data: %d unused bytes", len(buffer)-idx)
+	}
+
+	if width == 8 {
+		return values, nil
+	}
+
+	res32 := make([]int32, count)
+
+	for i := range values {
+		res32[i] = int32(values[i])
+	}
+
+	return res32, nil
+}
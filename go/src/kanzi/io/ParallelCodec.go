@@ -0,0 +1,641 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"container/heap"
+	"fmt"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/util"
+	"sync"
+)
+
+// Entropy coding of a stream of blocks by a pool of workers. Each block is
+// coded independently (new entropy coder per block) by the first available
+// worker. Workers complete out of order: the results are kept in a heap
+// ordered by block id and emitted as soon as the next expected block is
+// available, so the output order is the input order. The number of blocks
+// in flight is bounded to limit memory usage.
+// Stream format:
+// - 32 bits: PARALLEL_STREAM_TYPE
+// - 8 bits: entropy codec type
+// - frames: 32 bits original length, 32 bits coded length, coded data
+// - end frame: original length 0, coded length 0
+
+const (
+	PARALLEL_STREAM_TYPE        = 0x4B504152 // "KPAR"
+	PARALLEL_FRAME_HEADER_SIZE  = 8
+	PARALLEL_MAX_BLOCKS_PER_JOB = 2 // blocks in flight per worker
+)
+
+type parallelBlock struct {
+	id     int
+	length int // original length
+	data   []byte
+	err    error
+}
+
+// Min heap of blocks ordered by id
+type parallelBlockHeap []*parallelBlock
+
+func (this parallelBlockHeap) Len() int {
+	return len(this)
+}
+
+func (this parallelBlockHeap) Less(i, j int) bool {
+	return this[i].id < this[j].id
+}
+
+func (this parallelBlockHeap) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+func (this *parallelBlockHeap) Push(x interface{}) {
+	*this = append(*this, x.(*parallelBlock))
+}
+
+func (this *parallelBlockHeap) Pop() interface{} {
+	old := *this
+	n := len(old)
+	res := old[n-1]
+	old[n-1] = nil
+	*this = old[0 : n-1]
+	return res
+}
+
+func checkParallelParameters(blockSize, jobs uint) error {
+	if blockSize < MIN_BITSTREAM_BLOCK_SIZE {
+		return fmt.Errorf("The block size must be at least %d", MIN_BITSTREAM_BLOCK_SIZE)
+	}
+
+	if blockSize > MAX_BITSTREAM_BLOCK_SIZE {
+		return fmt.Errorf("The block size must be at most %d MB", MAX_BITSTREAM_BLOCK_SIZE>>20)
+	}
+
+	if jobs < 1 || jobs > 16 {
+		return fmt.Errorf("The number of jobs must be in [1..16]")
+	}
+
+	return nil
+}
+
+func putInt32(buf []byte, val uint32) {
+	buf[0] = byte(val >> 24)
+	buf[1] = byte(val >> 16)
+	buf[2] = byte(val >> 8)
+	buf[3] = byte(val)
+}
+
+func getInt32(buf []byte) uint32 {
+	return uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+}
+
+type OrderedParallelEncoder struct {
+	os          kanzi.OutputStream
+	entropyType byte
+	blockSize   uint
+	block       []byte
+	blockId     int
+	tasks       chan *parallelBlock
+	results     chan *parallelBlock
+	slots       chan bool
+	workers     sync.WaitGroup
+	done        chan bool
+	mutex       sync.Mutex
+	err         error
+	closed      bool
+}
+
+func NewOrderedParallelEncoder(entropyCodec string, os kanzi.OutputStream,
+	blockSize, jobs uint) (*OrderedParallelEncoder, error) {
+	if os == nil {
+		return nil, NewIOError("Invalid null output stream parameter", ERR_CREATE_COMPRESSOR)
+	}
+
+	if err := checkParallelParameters(blockSize, jobs); err != nil {
+		return nil, NewIOError(err.Error(), ERR_CREATE_COMPRESSOR)
+	}
+
+	this := new(OrderedParallelEncoder)
+	err := error(nil)
+
+	// The factory panics on unknown names
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = NewIOError(fmt.Sprintf("Invalid entropy codec type: %v", r), ERR_INVALID_CODEC)
+			}
+		}()
+
+		this.entropyType = entropy.GetEntropyCodecType(entropyCodec)
+	}()
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.os = os
+	this.blockSize = blockSize
+	this.block = make([]byte, 0, blockSize)
+	this.tasks = make(chan *parallelBlock)
+	this.results = make(chan *parallelBlock)
+	this.slots = make(chan bool, PARALLEL_MAX_BLOCKS_PER_JOB*jobs)
+	this.done = make(chan bool)
+
+	for i := uint(0); i < jobs; i++ {
+		this.workers.Add(1)
+
+		go func() {
+			defer this.workers.Done()
+
+			for task := range this.tasks {
+				this.results <- this.encode(task)
+			}
+		}()
+	}
+
+	go this.writeBlocks()
+	return this, nil
+}
+
+func (this *OrderedParallelEncoder) setError(err error) {
+	this.mutex.Lock()
+
+	if this.err == nil {
+		this.err = err
+	}
+
+	this.mutex.Unlock()
+}
+
+func (this *OrderedParallelEncoder) getError() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.err
+}
+
+// Implement kanzi.OutputStream interface
+func (this *OrderedParallelEncoder) Write(array []byte) (int, error) {
+	if this.closed == true {
+		return 0, NewIOError("Stream closed", ERR_WRITE_FILE)
+	}
+
+	written := 0
+
+	for written < len(array) {
+		if err := this.getError(); err != nil {
+			return written, err
+		}
+
+		n := copy(this.block[len(this.block):cap(this.block)], array[written:])
+		this.block = this.block[0 : len(this.block)+n]
+		written += n
+
+		if len(this.block) == cap(this.block) {
+			this.submit()
+		}
+	}
+
+	return written, nil
+}
+
+// Hand the current block to the workers (blocks when too many blocks are in flight)
+func (this *OrderedParallelEncoder) submit() {
+	this.slots <- true
+	this.tasks <- &parallelBlock{id: this.blockId, length: len(this.block), data: this.block}
+	this.blockId++
+	this.block = make([]byte, 0, this.blockSize)
+}
+
+// Wait for all blocks to be written, write the end frame and close the
+// underlying stream
+func (this *OrderedParallelEncoder) Close() error {
+	if this.closed == true {
+		return nil
+	}
+
+	this.closed = true
+
+	if len(this.block) > 0 {
+		this.submit()
+	}
+
+	close(this.tasks)
+	this.workers.Wait()
+	close(this.results)
+	<-this.done
+
+	if err := this.os.Close(); err != nil {
+		this.setError(NewIOError(err.Error(), ERR_WRITE_FILE))
+	}
+
+	return this.getError()
+}
+
+func (this *OrderedParallelEncoder) encode(task *parallelBlock) *parallelBlock {
+	res := &parallelBlock{id: task.id, length: task.length}
+	res.data, res.err = encodeEntropyBlock(this.entropyType, task.data)
+
+	if res.err != nil {
+		res.err = NewIOError(fmt.Sprintf("Failed to encode block %d: %v", task.id, res.err), ERR_PROCESS_BLOCK)
+	}
+
+	return res
+}
+
+// Entropy code the block with a new encoder
+func encodeEntropyBlock(entropyType byte, block []byte) (res []byte, err error) {
+	// The bitstream panics on write errors
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	output := &byteOutputStream{}
+	obs, err := bitstream.NewDefaultOutputBitStream(output, STREAM_DEFAULT_BUFFER_SIZE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ee, err := entropy.NewEntropyEncoder(obs, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = ee.Encode(block); err != nil {
+		return nil, err
+	}
+
+	ee.Dispose()
+
+	if _, err = obs.Close(); err != nil {
+		return nil, err
+	}
+
+	return output.buffer.Bytes(), nil
+}
+
+// Write the header, then the coded blocks in input order, then the end frame
+func (this *OrderedParallelEncoder) writeBlocks() {
+	pending := &parallelBlockHeap{}
+	nextId := 0
+	header := make([]byte, 5)
+	putInt32(header, PARALLEL_STREAM_TYPE)
+	header[4] = this.entropyType
+	err := this.write(header)
+
+	// Keep draining the results after an error to release the workers
+	for res := range this.results {
+		heap.Push(pending, res)
+
+		for pending.Len() > 0 && (*pending)[0].id == nextId {
+			block := heap.Pop(pending).(*parallelBlock)
+
+			if err == nil {
+				err = block.err
+			}
+
+			if err == nil {
+				err = this.writeFrame(block.length, block.data)
+			}
+
+			nextId++
+			<-this.slots
+		}
+
+		if err != nil {
+			this.setError(err)
+		}
+	}
+
+	if err == nil {
+		err = this.writeFrame(0, EMPTY_BYTE_SLICE)
+	}
+
+	if err != nil {
+		this.setError(err)
+	}
+
+	close(this.done)
+}
+
+func (this *OrderedParallelEncoder) writeFrame(length int, data []byte) error {
+	frame := make([]byte, PARALLEL_FRAME_HEADER_SIZE)
+	putInt32(frame[0:4], uint32(length))
+	putInt32(frame[4:8], uint32(len(data)))
+
+	if err := this.write(frame); err != nil {
+		return err
+	}
+
+	return this.write(data)
+}
+
+func (this *OrderedParallelEncoder) write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if _, err := this.os.Write(data); err != nil {
+		return NewIOError(err.Error(), ERR_WRITE_FILE)
+	}
+
+	return nil
+}
+
+type OrderedParallelDecoder struct {
+	is          kanzi.InputStream
+	entropyType byte
+	jobs        int
+	tasks       chan *parallelBlock
+	results     chan *parallelBlock
+	slots       chan bool
+	quit        chan bool
+	workers     sync.WaitGroup
+	pending     parallelBlockHeap
+	nextId      int
+	current     []byte
+	curIdx      int
+	err         error
+	closed      bool
+}
+
+func NewOrderedParallelDecoder(is kanzi.InputStream, jobs uint) (*OrderedParallelDecoder, error) {
+	if is == nil {
+		return nil, NewIOError("Invalid null input stream parameter", ERR_CREATE_DECOMPRESSOR)
+	}
+
+	if jobs < 1 || jobs > 16 {
+		return nil, NewIOError("The number of jobs must be in [1..16]", ERR_CREATE_DECOMPRESSOR)
+	}
+
+	this := new(OrderedParallelDecoder)
+	this.is = is
+	this.jobs = int(jobs)
+	this.tasks = make(chan *parallelBlock)
+	this.results = make(chan *parallelBlock)
+	this.slots = make(chan bool, PARALLEL_MAX_BLOCKS_PER_JOB*jobs)
+	this.quit = make(chan bool)
+	this.current = EMPTY_BYTE_SLICE
+	go this.readBlocks()
+	return this, nil
+}
+
+// Read the header and the frames, dispatch the frames to the workers.
+// A read error is reported as the result of the next block.
+func (this *OrderedParallelDecoder) readBlocks() {
+	defer close(this.results)
+	blockId := 0
+
+	fail := func(err error) {
+		select {
+		case this.slots <- true:
+		case <-this.quit:
+			return
+		}
+
+		select {
+		case this.results <- &parallelBlock{id: blockId, err: err}:
+		case <-this.quit:
+		}
+	}
+
+	header := make([]byte, 5)
+
+	if err := this.read(header); err != nil {
+		fail(err)
+		return
+	}
+
+	if getInt32(header) != PARALLEL_STREAM_TYPE {
+		fail(NewIOError("Invalid stream type", ERR_INVALID_FILE))
+		return
+	}
+
+	this.entropyType = header[4]
+
+	for i := 0; i < this.jobs; i++ {
+		this.workers.Add(1)
+
+		go func() {
+			defer this.workers.Done()
+
+			for task := range this.tasks {
+				select {
+				case this.results <- this.decode(task):
+				case <-this.quit:
+					return
+				}
+			}
+		}()
+	}
+
+	// Wait for the workers before closing the results channel
+	defer this.workers.Wait()
+	defer close(this.tasks)
+	frame := make([]byte, PARALLEL_FRAME_HEADER_SIZE)
+
+	for {
+		if err := this.read(frame); err != nil {
+			fail(err)
+			return
+		}
+
+		length := getInt32(frame[0:4])
+		codedLength := getInt32(frame[4:8])
+
+		if length == 0 {
+			// End frame
+			return
+		}
+
+		if length > MAX_BITSTREAM_BLOCK_SIZE || codedLength > MAX_BITSTREAM_BLOCK_SIZE {
+			fail(NewIOError(fmt.Sprintf("Invalid frame length in block %d", blockId), ERR_INVALID_FILE))
+			return
+		}
+
+		data := make([]byte, codedLength)
+
+		if err := this.read(data); err != nil {
+			fail(err)
+			return
+		}
+
+		select {
+		case this.slots <- true:
+		case <-this.quit:
+			return
+		}
+
+		select {
+		case this.tasks <- &parallelBlock{id: blockId, length: int(length), data: data}:
+		case <-this.quit:
+			return
+		}
+
+		blockId++
+	}
+}
+
+// Fill the buffer from the input stream
+func (this *OrderedParallelDecoder) read(buf []byte) error {
+	for off := 0; off < len(buf); {
+		n, err := this.is.Read(buf[off:])
+
+		if err != nil {
+			return NewIOError(err.Error(), ERR_READ_FILE)
+		}
+
+		if n <= 0 {
+			return NewIOError("Unexpected end of stream", ERR_READ_FILE)
+		}
+
+		off += n
+	}
+
+	return nil
+}
+
+func (this *OrderedParallelDecoder) decode(task *parallelBlock) *parallelBlock {
+	res := &parallelBlock{id: task.id, length: task.length}
+	res.data, res.err = decodeEntropyBlock(this.entropyType, task.data, task.length)
+
+	if res.err != nil {
+		res.err = NewIOError(fmt.Sprintf("Failed to decode block %d: %v", task.id, res.err), ERR_PROCESS_BLOCK)
+	}
+
+	return res
+}
+
+// Return the 'length' bytes decoded from the block with a new decoder
+func decodeEntropyBlock(entropyType byte, block []byte, length int) (res []byte, err error) {
+	// The bitstream panics on read errors
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	// The entropy decoder may read ahead past the end of the data: pad with zeros
+	is, _ := util.NewByteArrayInputStream(block, true)
+	ibs, err := bitstream.NewDefaultInputBitStream(is, STREAM_DEFAULT_BUFFER_SIZE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ed, err := entropy.NewEntropyDecoder(ibs, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer ed.Dispose()
+	res = make([]byte, length)
+	decoded, err := ed.Decode(res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if decoded != length {
+		return nil, fmt.Errorf("expected %d bytes, got %d", length, decoded)
+	}
+
+	return res, nil
+}
+
+// Return the next decoded block in input order or nil at the end of the stream
+func (this *OrderedParallelDecoder) nextBlock() *parallelBlock {
+	for this.pending.Len() == 0 || this.pending[0].id != this.nextId {
+		res, ok := <-this.results
+
+		if ok == false {
+			return nil
+		}
+
+		heap.Push(&this.pending, res)
+	}
+
+	this.nextId++
+	<-this.slots
+	return heap.Pop(&this.pending).(*parallelBlock)
+}
+
+// Implement kanzi.InputStream interface
+func (this *OrderedParallelDecoder) Read(array []byte) (int, error) {
+	if this.closed == true {
+		return 0, NewIOError("Stream closed", ERR_READ_FILE)
+	}
+
+	read := 0
+
+	for read < len(array) {
+		if this.curIdx >= len(this.current) {
+			if this.err != nil {
+				return read, this.err
+			}
+
+			block := this.nextBlock()
+
+			if block == nil {
+				// Reached end of stream
+				if read == 0 {
+					return -1, nil
+				}
+
+				break
+			}
+
+			if block.err != nil {
+				this.err = block.err
+				return read, this.err
+			}
+
+			this.current = block.data
+			this.curIdx = 0
+		}
+
+		n := copy(array[read:], this.current[this.curIdx:])
+		this.curIdx += n
+		read += n
+	}
+
+	return read, nil
+}
+
+// Stop the workers and close the underlying stream
+func (this *OrderedParallelDecoder) Close() error {
+	if this.closed == true {
+		return nil
+	}
+
+	this.closed = true
+	close(this.quit)
+
+	if err := this.is.Close(); err != nil {
+		return NewIOError(err.Error(), ERR_READ_FILE)
+	}
+
+	return nil
+}
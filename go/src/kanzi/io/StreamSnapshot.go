@@ -0,0 +1,343 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"kanzi"
+	"kanzi/util"
+)
+
+// Checkpoint of a compressed output stream, to resume the compression after a
+// restart of the process. The entropy coders and transforms are created for
+// each block, hence no coder state survives a block: the state of the
+// pipeline is the configuration, the block counter, the checksums, the data
+// not yet encoded and the state of the bitstream.
+// The snapshot flushes the bitstream: the bytes written to the underlying
+// stream so far (see SnapshotOffset) are not part of the snapshot. To resume,
+// the new underlying stream must continue after these bytes (EG. the file
+// truncated to the offset and opened in append mode).
+// Snapshot format:
+// - 32 bits: STREAM_SNAPSHOT_TYPE, 8 bits: version
+// - configuration: entropy type, transform type, block size, jobs, flags
+// - block id, stream checksum
+// - bitstream state, content hash state, pending data (32 bit length + data)
+// - 32 bits: XXHash32 of all the previous bytes
+
+const (
+	STREAM_SNAPSHOT_TYPE    = 0x4B534E50 // "KSNP"
+	STREAM_SNAPSHOT_VERSION = 0
+
+	snapshotBlockChecksum  = 1
+	snapshotStreamChecksum = 2
+	snapshotContentSplit   = 4
+	snapshotRawCheck       = 8
+	snapshotContentHash    = 16
+	snapshotInitialized    = 32
+	snapshotStoredFallback = 64
+	snapshotStateSize      = 16
+)
+
+// Bitstreams which state can be saved and restored
+type snapshotOutputBitStream interface {
+	kanzi.OutputBitStream
+
+	Snapshot() ([]byte, error)
+
+	Restore(snapshot []byte) error
+}
+
+type streamSnapshot struct {
+	entropyType    byte
+	transformType  byte
+	blockSize      uint32
+	jobs           byte
+	flags          byte
+	blockId        uint32
+	streamChecksum uint32
+}
+
+func (this *streamSnapshot) encode() []byte {
+	res := make([]byte, snapshotStateSize)
+	res[0] = this.entropyType
+	res[1] = this.transformType
+	binary.BigEndian.PutUint32(res[2:], this.blockSize)
+	res[6] = this.jobs
+	res[7] = this.flags
+	binary.BigEndian.PutUint32(res[8:], this.blockId)
+	binary.BigEndian.PutUint32(res[12:], this.streamChecksum)
+	return res
+}
+
+func (this *streamSnapshot) decode(buf []byte) {
+	this.entropyType = buf[0]
+	this.transformType = buf[1]
+	this.blockSize = binary.BigEndian.Uint32(buf[2:])
+	this.jobs = buf[6]
+	this.flags = buf[7]
+	this.blockId = binary.BigEndian.Uint32(buf[8:])
+	this.streamChecksum = binary.BigEndian.Uint32(buf[12:])
+}
+
+// Return the state of the stream. Must not be called concurrently with Write.
+func (this *CompressedOutputStream) Snapshot() ([]byte, error) {
+	if this.closed == true {
+		return nil, errors.New("Stream closed")
+	}
+
+	obs, isSnapshot := this.obs.(snapshotOutputBitStream)
+
+	if isSnapshot == false {
+		return nil, errors.New("The state of the output bitstream cannot be saved")
+	}
+
+	state := streamSnapshot{entropyType: this.entropyType, transformType: this.transformType,
+		blockSize: uint32(this.blockSize), jobs: byte(this.jobs), blockId: uint32(this.blockId),
+		streamChecksum: this.streamChecksum}
+
+	if this.hasher != nil {
+		state.flags |= snapshotBlockChecksum
+	}
+
+	if this.streamHasher != nil {
+		state.flags |= snapshotStreamChecksum
+	}
+
+	if this.splitter != nil {
+		state.flags |= snapshotContentSplit
+	}
+
+	if this.rawCheck == true {
+		state.flags |= snapshotRawCheck
+	}
+
+	if this.storedFallback == true {
+		state.flags |= snapshotStoredFallback
+	}
+
+	if this.initialized == true {
+		state.flags |= snapshotInitialized
+	}
+
+	hashState := EMPTY_BYTE_SLICE
+
+	if this.contentHasher != nil {
+		state.flags |= snapshotContentHash
+		var err error
+
+		if hashState, err = this.contentHasher.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+
+	bsState, err := obs.Snapshot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(STREAM_SNAPSHOT_TYPE))
+	buf.WriteByte(STREAM_SNAPSHOT_VERSION)
+	buf.Write(state.encode())
+	writeSnapshotField(&buf, bsState)
+	writeSnapshotField(&buf, hashState)
+	writeSnapshotField(&buf, this.data[0:this.curIdx])
+	hasher, _ := util.NewXXHash(STREAM_SNAPSHOT_TYPE)
+	binary.Write(&buf, binary.BigEndian, hasher.Hash(buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+func writeSnapshotField(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+func readSnapshotField(r *bytes.Reader) ([]byte, error) {
+	var length uint32
+
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	if int64(length) > int64(r.Len()) {
+		return nil, errors.New("Invalid snapshot field length")
+	}
+
+	res := make([]byte, length)
+	r.Read(res)
+	return res, nil
+}
+
+// Check the snapshot and return its fields
+func parseSnapshot(snapshot []byte) (state streamSnapshot, bsState, hashState, pending []byte, err error) {
+	if len(snapshot) < 9+snapshotStateSize {
+		err = errors.New("Invalid snapshot: too short")
+		return
+	}
+
+	hasher, _ := util.NewXXHash(STREAM_SNAPSHOT_TYPE)
+	end := len(snapshot) - 4
+
+	if hasher.Hash(snapshot[0:end]) != binary.BigEndian.Uint32(snapshot[end:]) {
+		err = errors.New("Invalid snapshot: checksum mismatch")
+		return
+	}
+
+	if binary.BigEndian.Uint32(snapshot[0:4]) != STREAM_SNAPSHOT_TYPE || snapshot[4] != STREAM_SNAPSHOT_VERSION {
+		err = errors.New("Invalid snapshot: unknown type or version")
+		return
+	}
+
+	state.decode(snapshot[5:])
+	r := bytes.NewReader(snapshot[5+snapshotStateSize : end])
+
+	if bsState, err = readSnapshotField(r); err != nil {
+		return
+	}
+
+	if hashState, err = readSnapshotField(r); err != nil {
+		return
+	}
+
+	if pending, err = readSnapshotField(r); err != nil {
+		return
+	}
+
+	if r.Len() != 0 {
+		err = errors.New("Invalid snapshot: unexpected trailing bytes")
+	}
+
+	return
+}
+
+// Return the number of bytes written to the underlying stream when the
+// snapshot was taken
+func SnapshotOffset(snapshot []byte) (uint64, error) {
+	_, bsState, _, _, err := parseSnapshot(snapshot)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(bsState) < 8 {
+		return 0, errors.New("Invalid snapshot: bitstream state too short")
+	}
+
+	return binary.BigEndian.Uint64(bsState) >> 3, nil
+}
+
+// Restore the state of a stream saved by Snapshot, the configuration provided
+// to the constructor is replaced. The stream must not have been written to.
+// Listeners and debug writer are kept.
+func (this *CompressedOutputStream) Restore(snapshot []byte) error {
+	if this.closed == true {
+		return errors.New("Stream closed")
+	}
+
+	if this.initialized == true || this.curIdx > 0 || this.blockId > 0 {
+		return errors.New("Cannot restore a stream already written to")
+	}
+
+	obs, isSnapshot := this.obs.(snapshotOutputBitStream)
+
+	if isSnapshot == false {
+		return errors.New("The state of the output bitstream cannot be restored")
+	}
+
+	state, bsState, hashState, pending, err := parseSnapshot(snapshot)
+
+	if err != nil {
+		return err
+	}
+
+	blockSize := uint(state.blockSize)
+	jobs := int(state.jobs)
+
+	if blockSize < MIN_BITSTREAM_BLOCK_SIZE || blockSize > MAX_BITSTREAM_BLOCK_SIZE || jobs < 1 || jobs > 16 ||
+		len(pending) > jobs*int(blockSize) {
+		return errors.New("Invalid snapshot: incorrect block size or number of jobs")
+	}
+
+	var contentHasher = sha256.New()
+
+	if state.flags&snapshotContentHash != 0 {
+		if err = contentHasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(hashState); err != nil {
+			return err
+		}
+	} else {
+		contentHasher = nil
+	}
+
+	var splitter *util.ContentSplitter
+
+	if state.flags&snapshotContentSplit != 0 {
+		if splitter, err = util.NewContentSplitter(); err != nil {
+			return err
+		}
+	}
+
+	if err = obs.Restore(bsState); err != nil {
+		return err
+	}
+
+	this.entropyType = state.entropyType
+	this.transformType = state.transformType
+	this.blockSize = blockSize
+	this.splitter = splitter
+	this.contentHasher = contentHasher
+	this.rawCheck = state.flags&snapshotRawCheck != 0
+	this.storedFallback = state.flags&snapshotStoredFallback != 0
+	this.initialized = state.flags&snapshotInitialized != 0
+	this.blockId = int(state.blockId)
+	this.streamChecksum = state.streamChecksum
+	this.hasher = nil
+	this.streamHasher = nil
+
+	if state.flags&snapshotBlockChecksum != 0 {
+		this.hasher, _ = util.NewXXHash(BITSTREAM_TYPE)
+	}
+
+	if state.flags&snapshotStreamChecksum != 0 {
+		this.streamHasher, _ = util.NewXXHash(BITSTREAM_TYPE)
+	}
+
+	if jobs != this.jobs {
+		this.jobs = jobs
+		this.buffers = make([][]byte, jobs)
+
+		for i := range this.buffers {
+			this.buffers[i] = EMPTY_BYTE_SLICE
+		}
+
+		this.channels = make([]chan error, jobs+1)
+
+		for i := range this.channels {
+			this.channels[i] = make(chan error)
+		}
+	}
+
+	if len(this.data) < jobs*int(blockSize) {
+		this.data = make([]byte, jobs*int(blockSize))
+	}
+
+	this.curIdx = copy(this.data, pending)
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"kanzi/entropy"
+)
+
+// Change the entropy codec of a compressed stream without decompressing it:
+// each block is entropy decoded with the codec of the stream and encoded
+// again with the new codec, one block at a time. The transform is not
+// inverted, the transformed data, the block headers, the checksums (computed
+// on the original data) and the trailer are copied.
+
+type readerInputStream struct {
+	reader io.Reader
+}
+
+func (this *readerInputStream) Read(b []byte) (int, error) {
+	return this.reader.Read(b)
+}
+
+func (this *readerInputStream) Close() error {
+	return nil
+}
+
+type writerOutputStream struct {
+	writer io.Writer
+}
+
+func (this *writerOutputStream) Write(b []byte) (int, error) {
+	return this.writer.Write(b)
+}
+
+func (this *writerOutputStream) Close() error {
+	return nil
+}
+
+// Read the compressed stream from src and write it to dst with the entropy
+// codec 'newCodec'. Blocks stored raw stay raw. The memory used is bounded
+// by the block size.
+func Transcode(src io.Reader, dst io.Writer, newCodec string) (err error) {
+	if src == nil {
+		return errors.New("Invalid null source parameter")
+	}
+
+	if dst == nil {
+		return errors.New("Invalid null destination parameter")
+	}
+
+	// The bitstreams and codec factories panic on invalid parameters or I/O errors
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Transcoding failed: %v", r)
+		}
+	}()
+
+	cis, err := NewCompressedInputStream(&readerInputStream{reader: src}, nil, 1)
+
+	if err != nil {
+		return err
+	}
+
+	if err = cis.ReadHeader(); err != nil {
+		return err
+	}
+
+	cis.initialized = true
+	cos, err := NewCompressedOutputStream(newCodec, "None", &writerOutputStream{writer: dst},
+		cis.blockSize, cis.hasher != nil, nil, 1)
+
+	if err != nil {
+		return err
+	}
+
+	// Same stream parameters, except the entropy codec
+	cos.transformType = cis.transformType
+	cos.SetChecksumMode(cis.ChecksumMode())
+	cos.SetContentHash(cis.hasContentHash)
+
+	if ioerr := cos.WriteHeader(); ioerr != nil {
+		return ioerr
+	}
+
+	cos.initialized = true
+	buffer := cos.data
+
+	for {
+		mode := byte(cis.ibs.ReadBits(8))
+		length := uint(0)
+		dataSize := uint(0)
+		entropyType := cis.entropyType
+		newEntropyType := cos.entropyType
+
+		if mode&SMALL_BLOCK_MASK != 0 {
+			length = uint(mode & COPY_LENGTH_MASK)
+		} else {
+			if mode&RAW_BLOCK_MASK != 0 {
+				entropyType = entropy.NONE_TYPE
+				newEntropyType = entropy.NONE_TYPE
+			}
+
+			dataSize = uint(1 + (mode & 0x03))
+			length = uint(cis.ibs.ReadBits(8 * dataSize))
+		}
+
+		cos.obs.WriteBits(uint64(mode), 8)
+
+		if length == 0 {
+			// End block
+			break
+		}
+
+		if length > MAX_BITSTREAM_BLOCK_SIZE {
+			errMsg := fmt.Sprintf("Invalid compressed block length: %d", length)
+			return NewIOError(errMsg, ERR_BLOCK_SIZE)
+		}
+
+		if dataSize > 0 {
+			cos.obs.WriteBits(uint64(length), 8*dataSize)
+		}
+
+		if cis.hasher != nil {
+			cos.obs.WriteBits(cis.ibs.ReadBits(32), 32)
+		}
+
+		if uint(len(buffer)) < length {
+			buffer = make([]byte, length)
+		}
+
+		ed, err := entropy.NewEntropyDecoder(cis.ibs, entropyType)
+
+		if err != nil {
+			return NewIOError(err.Error(), ERR_INVALID_CODEC)
+		}
+
+		_, err = ed.Decode(buffer[0:length])
+		ed.Dispose()
+
+		if err != nil {
+			return NewIOError(err.Error(), ERR_PROCESS_BLOCK)
+		}
+
+		ee, err := entropy.NewEntropyEncoder(cos.obs, newEntropyType)
+
+		if err != nil {
+			return NewIOError(err.Error(), ERR_CREATE_CODEC)
+		}
+
+		_, err = ee.Encode(buffer[0:length])
+		ee.Dispose()
+
+		if err != nil {
+			return NewIOError(err.Error(), ERR_PROCESS_BLOCK)
+		}
+	}
+
+	// Trailer: byte aligned stream checksum and content hash
+	if cis.streamHasher != nil {
+		if err = cis.readPadding(); err != nil {
+			return err
+		}
+
+		cos.obs.WriteBits(0, uint((8-cos.obs.Written()&7)&7))
+		cos.obs.WriteBits(cis.ibs.ReadBits(32), 32)
+	}
+
+	if cis.hasContentHash == true {
+		if err = cis.readPadding(); err != nil {
+			return err
+		}
+
+		if err = cis.readContentHash(); err != nil {
+			return err
+		}
+
+		cos.obs.WriteBits(0, uint((8-cos.obs.Written()&7)&7))
+
+		for _, b := range cis.contentHash {
+			cos.obs.WriteBits(uint64(b), 8)
+		}
+	}
+
+	if _, err = cos.obs.Close(); err != nil {
+		return err
+	}
+
+	cos.release()
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	"kanzi"
+	"kanzi/entropy"
+	"kanzi/function"
+)
+
+// Coding of a block with a chain of transforms (see ByteFunctionPipeline)
+// followed by an entropy codec. Unlike the stream header, which records a
+// single transform, the header returned by EncodeWithTransforms records the
+// whole chain, so that DecodeWithTransforms rebuilds the inverse chain
+// without knowledge of the parameters used to encode.
+// Header:
+// - 1 byte: entropy codec type
+// - 1 byte: number of transforms N
+// - N bytes: transform types, in forward order
+// Bitstream:
+// - size of the original block (see writeLength)
+// - size of the transformed block
+// - entropy coded transformed block (starting with the pipeline header)
+
+// Encode the data to the bitstream and return the header. The transforms
+// are names such as "BWT+MTF", "ZRLT" or "RLT", applied in order.
+func EncodeWithTransforms(obs kanzi.OutputBitStream, data []byte, entropyCodec string,
+	transforms []string) (header []byte, err error) {
+	if obs == nil {
+		return nil, errors.New("Invalid null bitstream parameter")
+	}
+
+	if data == nil {
+		return nil, errors.New("Invalid null data parameter")
+	}
+
+	if len(data) > MAX_BITSTREAM_BLOCK_SIZE {
+		return nil, fmt.Errorf("The data size must be at most %d", MAX_BITSTREAM_BLOCK_SIZE)
+	}
+
+	// The codec factories panic on invalid names, the bitstream on write errors
+	defer func() {
+		if r := recover(); r != nil {
+			header = nil
+			err = fmt.Errorf("Encoding failed: %v", r)
+		}
+	}()
+
+	types := make([]byte, len(transforms))
+
+	for i := range transforms {
+		types[i] = function.GetByteFunctionType(transforms[i])
+	}
+
+	pipeline, err := function.NewByteFunctionPipeline(0, types)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bound := pipeline.MaxEncodedLen(len(data))
+
+	if bound < 0 {
+		return nil, errors.New("Unknown max size of the transformed data")
+	}
+
+	buf := make([]byte, bound)
+	_, length, err := pipeline.Forward(data, buf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entropyType := entropy.GetEntropyCodecType(entropyCodec)
+	writeLength(obs, uint(len(data)))
+	writeLength(obs, length)
+	ee, err := entropy.NewEntropyEncoder(obs, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = ee.Encode(buf[0:length]); err != nil {
+		return nil, err
+	}
+
+	ee.Dispose()
+	header = make([]byte, 2+len(types))
+	header[0] = entropyType
+	header[1] = byte(len(types))
+	copy(header[2:], types)
+	return header, nil
+}
+
+// Return the original data. The entropy codec and the transform chain are
+// read from the header.
+func DecodeWithTransforms(ibs kanzi.InputBitStream, header []byte) (res []byte, err error) {
+	if ibs == nil {
+		return nil, errors.New("Invalid null bitstream parameter")
+	}
+
+	if header == nil {
+		return nil, errors.New("Invalid null header parameter")
+	}
+
+	if len(header) < 2 || len(header) != 2+int(header[1]) {
+		return nil, errors.New("Invalid transform header: incorrect length")
+	}
+
+	// The bitstream panics on read errors
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = fmt.Errorf("Decoding failed: %v", r)
+		}
+	}()
+
+	pipeline, err := function.NewByteFunctionPipeline(0, header[2:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	size := readLength(ibs)
+	length := readLength(ibs)
+
+	if size > MAX_BITSTREAM_BLOCK_SIZE {
+		return nil, fmt.Errorf("Invalid data size: %v", size)
+	}
+
+	if bound := pipeline.MaxEncodedLen(int(size)); bound < 0 || length > uint(bound) {
+		return nil, fmt.Errorf("Invalid transformed data size: %v (max is %v)", length, bound)
+	}
+
+	ed, err := entropy.NewEntropyDecoder(ibs, header[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, length)
+
+	if _, err = ed.Decode(buf); err != nil {
+		return nil, err
+	}
+
+	ed.Dispose()
+	res = make([]byte, size)
+	_, written, err := pipeline.Inverse(buf, res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if written != size {
+		return nil, fmt.Errorf("Invalid transformed data: decoded %v bytes, expected %v", written, size)
+	}
+
+	return res, nil
+}
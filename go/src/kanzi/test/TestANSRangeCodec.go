@@ -16,6 +16,7 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"kanzi/bitstream"
 	"kanzi/entropy"
@@ -28,6 +29,7 @@ import (
 func main() {
 	TestCorrectness()
 	TestSpeed()
+	TestCompareRange()
 }
 
 func TestCorrectness() {
@@ -216,3 +218,67 @@ func TestSpeed() {
 		fmt.Printf("Throughput [KB/s]: %d\n", (int64(iter*size))*1000000/delta2*1000/1024)
 	}
 }
+
+// Decoding throughput of the ANS and range coders on the same data, for
+// several log ranges (size of the frequency table). The block is coded as a
+// single chunk (chunk size 0) by both coders.
+func TestCompareRange() {
+	fmt.Printf("\n\nANS vs range decoder\n")
+	iter := 200
+	size := 500000
+	rnd := rand.New(rand.NewSource(12345))
+	input := make([]byte, size)
+
+	// Skewed distribution (EG. post BWT+MTF data), most symbols absent
+	for i := range input {
+		input[i] = byte(rnd.ExpFloat64() * 6)
+	}
+
+	buffer := make([]byte, 2*size)
+	output := make([]byte, size)
+
+	for _, logRange := range []uint{12, 15} {
+		for _, name := range []string{"ANS", "Range"} {
+			delta := int64(0)
+			oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+			obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 65536)
+
+			if name == "ANS" {
+				ee, _ := entropy.NewANSRangeEncoder(obs, 0, logRange)
+				ee.Encode(input)
+				ee.Dispose()
+			} else {
+				ee, _ := entropy.NewRangeEncoder(obs, 0, logRange)
+				ee.Encode(input)
+				ee.Dispose()
+			}
+
+			obs.Close()
+
+			for ii := 0; ii < iter; ii++ {
+				iFile, _ := util.NewByteArrayInputStream(buffer, false)
+				ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 65536)
+				before := time.Now()
+
+				if name == "ANS" {
+					ed, _ := entropy.NewANSRangeDecoder(ibs, 0)
+					ed.Decode(output)
+				} else {
+					ed, _ := entropy.NewRangeDecoder(ibs, 0)
+					ed.Decode(output)
+				}
+
+				delta += time.Now().Sub(before).Nanoseconds()
+			}
+
+			if bytes.Equal(input, output) == false {
+				fmt.Printf("Different (%v, log range %v)\n", name, logRange)
+				os.Exit(1)
+			}
+
+			prod := int64(iter) * int64(size)
+			fmt.Printf("%-5v (log range %v): %v => %v bytes, decoding %d MB/s\n", name, logRange, size,
+				(obs.Written()+7)>>3, prod*1000000/delta*1000/(1024*1024))
+		}
+	}
+}
@@ -18,7 +18,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"bytes"
 	"kanzi"
+	kio "kanzi/io"
 	"kanzi/transform"
 	"math/rand"
 	"os"
@@ -47,6 +49,7 @@ func main() {
 	fmt.Printf("TestBWT and TestBWTS")
 	TestCorrectness(true)
 	TestCorrectness(false)
+	TestPipeline()
 	TestSpeed(true)
 	TestSpeed(false)
 }
@@ -73,6 +76,12 @@ func TestCorrectness(isBWT bool) {
 			buf1 = []byte("mississippi")
 		} else if ii == 2 {
 			buf1 = []byte("3.14159265358979323846264338327950288419716939937510")
+		} else if ii == 3 {
+			// All identical bytes
+			buf1 = []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+		} else if ii == 4 {
+			// Single byte
+			buf1 = []byte("x")
 		} else {
 			size = 128
 			buf1 = make([]byte, size)
@@ -131,6 +140,51 @@ func TestCorrectness(isBWT bool) {
 	}
 }
 
+// End to end: BWT -> MTF -> ZRLT -> range coder (the primary index is
+// serialized by the block codec)
+func TestPipeline() {
+	fmt.Printf("\n\nBWT+MTF+ZRLT+Range pipeline test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	words := []string{"the ", "of ", "and ", "to ", "in ", "is ", "that ", "it "}
+	inputs := [][]byte{[]byte("mississippi"), []byte("x"), bytes.Repeat([]byte{'a'}, 100000)}
+	text := make([]byte, 0)
+
+	for len(text) < 200000 {
+		text = append(text, words[rnd.Intn(len(words))]...)
+	}
+
+	inputs = append(inputs, text)
+
+	for _, input := range inputs {
+		raw, err1 := kio.Compress(input, "Range", "None", 1<<20)
+		output, err2 := kio.Compress(input, "Range", "BWT+MTF", 1<<20)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Compression error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%v bytes => range=%v bytes, BWT+MTF+ZRLT+range=%v bytes\n", len(input),
+			len(raw), len(output))
+
+		// The text must compress better (small or constant inputs are
+		// dominated by the headers)
+		if len(input) == len(text) && len(output) >= len(raw) {
+			fmt.Printf("No compression improvement with the BWT\n")
+			os.Exit(1)
+		}
+
+		res, err := kio.Decompress(output)
+
+		if err != nil || bytes.Equal(input, res) == false {
+			fmt.Printf("Different: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Identical\n")
+}
+
 func TestSpeed(isBWT bool) {
 	if isBWT {
 		fmt.Printf("\n\nBWT Speed test")
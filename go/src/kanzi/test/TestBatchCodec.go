@@ -0,0 +1,192 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestBatchCodec\n")
+	TestCorrectness()
+	TestSpeed()
+}
+
+// Small values such as cache entries: short text records and a few random bytes
+func generateInputs(count int, rnd *rand.Rand) [][]byte {
+	words := []string{"user", "id", "name", "value", "session", "token", "42", "true", "false", "{", "}", ":"}
+	inputs := make([][]byte, count)
+
+	for i := range inputs {
+		n := rnd.Intn(100)
+		var buf bytes.Buffer
+
+		for j := 0; j < n; j++ {
+			buf.WriteString(words[rnd.Intn(len(words))])
+
+			if rnd.Intn(20) == 0 {
+				buf.WriteByte(byte(rnd.Intn(256)))
+			}
+		}
+
+		inputs[i] = append([]byte{}, buf.Bytes()...)
+	}
+
+	return inputs
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	entropies := []string{"None", "Huffman", "ANS", "Range", "PAQ", "FPAQ", "CM"}
+	transforms := []string{"None", "BWT", "BWT+MTF", "BWTS", "RLT", "LZ4"}
+
+	for _, entropy := range entropies {
+		for _, transform := range transforms {
+			inputs := generateInputs(200, rnd)
+			inputs[0] = []byte{} // empty input
+			bc, err := kio.NewBatchCodec(entropy, transform)
+
+			if err != nil {
+				fmt.Printf("Failed to create batch codec: %v\n", err)
+				os.Exit(1)
+			}
+
+			encoded, err := bc.BatchEncode(inputs)
+
+			if err != nil {
+				fmt.Printf("Encoding error (%v, %v): %v\n", entropy, transform, err)
+				os.Exit(1)
+			}
+
+			decoded, err := bc.BatchDecode(encoded)
+
+			if err != nil {
+				fmt.Printf("Decoding error (%v, %v): %v\n", entropy, transform, err)
+				os.Exit(1)
+			}
+
+			size1 := 0
+			size2 := 0
+
+			for i := range inputs {
+				if bytes.Equal(inputs[i], decoded[i]) == false {
+					fmt.Printf("Different (%v, %v) at input %v\n", entropy, transform, i)
+					os.Exit(1)
+				}
+
+				size1 += len(inputs[i])
+				size2 += len(encoded[i])
+			}
+
+			fmt.Printf("%-8v %-8v: %v => %v Identical\n", entropy, transform, size1, size2)
+		}
+	}
+
+	if _, err := kio.NewBatchCodec("Unknown", "None"); err == nil {
+		fmt.Printf("Invalid entropy codec not detected\n")
+		os.Exit(1)
+	}
+}
+
+func TestSpeed() {
+	iter := 5
+	count := 2000
+	fmt.Printf("\n\nSpeed test (batch vs per item stream)\n")
+	fmt.Printf("Iterations: %v, items: %v\n", iter, count)
+	rnd := rand.New(rand.NewSource(12345))
+	inputs := generateInputs(count, rnd)
+
+	for _, entropy := range []string{"Huffman", "ANS"} {
+		bc, _ := kio.NewBatchCodec(entropy, "None")
+		delta1 := int64(0)
+		delta2 := int64(0)
+		delta3 := int64(0)
+		delta4 := int64(0)
+		var encoded [][]byte
+		var err error
+		naive := make([][]byte, count)
+
+		for ii := 0; ii < iter; ii++ {
+			before := time.Now()
+
+			if encoded, err = bc.BatchEncode(inputs); err != nil {
+				fmt.Printf("Encoding error: %v\n", err)
+				os.Exit(1)
+			}
+
+			after := time.Now()
+			delta1 += after.Sub(before).Nanoseconds()
+			before = time.Now()
+
+			if _, err = bc.BatchDecode(encoded); err != nil {
+				fmt.Printf("Decoding error: %v\n", err)
+				os.Exit(1)
+			}
+
+			after = time.Now()
+			delta2 += after.Sub(before).Nanoseconds()
+			before = time.Now()
+
+			for i := range inputs {
+				if naive[i], err = kio.Compress(inputs[i], entropy, "None", 1024); err != nil {
+					fmt.Printf("Encoding error: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			after = time.Now()
+			delta3 += after.Sub(before).Nanoseconds()
+			before = time.Now()
+
+			for i := range naive {
+				if _, err = kio.Decompress(naive[i]); err != nil {
+					fmt.Printf("Decoding error: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			after = time.Now()
+			delta4 += after.Sub(before).Nanoseconds()
+		}
+
+		size1 := 0
+		size2 := 0
+
+		for i := range encoded {
+			size1 += len(encoded[i])
+			size2 += len(naive[i])
+		}
+
+		fmt.Printf("\n%v\n", entropy)
+		fmt.Printf("Batch encoding [ms]   : %v\n", delta1/1000000)
+		fmt.Printf("Batch decoding [ms]   : %v\n", delta2/1000000)
+		fmt.Printf("Batch size            : %v\n", size1)
+		fmt.Printf("Per item encoding [ms]: %v\n", delta3/1000000)
+		fmt.Printf("Per item decoding [ms]: %v\n", delta4/1000000)
+		fmt.Printf("Per item size         : %v\n", size2)
+
+		if delta1 >= delta3 || size1 >= size2 {
+			fmt.Printf("Batch encoding is not faster or smaller\n")
+			os.Exit(1)
+		}
+	}
+}
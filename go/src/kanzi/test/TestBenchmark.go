@@ -0,0 +1,299 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/function"
+	"kanzi/transform"
+	"kanzi/util"
+	"os"
+	"testing"
+)
+
+// Baseline throughputs of the range coder and ZRLT on the benchmark corpora
+// (fixed seed). EG. go run TestBenchmark.go -size=4194304 -file=enwik8
+// The inputs are the same from run to run: compare the MB/s before and after
+// a change.
+
+const BENCHMARK_SEED = 12345
+
+func main() {
+	fmt.Printf("TestBenchmark\n")
+	testing.Init()
+	size := flag.Int("size", 1<<20, "size of the corpora in bytes")
+	fileName := flag.String("file", "", "optional file to benchmark (loaded with the corpora)")
+	flag.Parse()
+	TestCorpus()
+	TestEncodeAllocs()
+	corpora, names := loadCorpora(*size, *fileName)
+
+	for i, corpus := range corpora {
+		fmt.Printf("\nCorpus %v (%v bytes)\n", names[i], len(corpus))
+		report("RangeEncoder", testing.Benchmark(func(b *testing.B) { BenchmarkRangeEncoder(b, corpus) }))
+		report("RangeDecoder", testing.Benchmark(func(b *testing.B) { BenchmarkRangeDecoder(b, corpus) }))
+		report("EncodeInto", testing.Benchmark(func(b *testing.B) { BenchmarkEncodeInto(b, corpus) }))
+		report("ZRLT forward", testing.Benchmark(func(b *testing.B) { BenchmarkZRLTForward(b, corpus) }))
+		report("ZRLT inverse", testing.Benchmark(func(b *testing.B) { BenchmarkZRLTInverse(b, corpus) }))
+	}
+}
+
+// The corpora must be reproducible and the BWT corpus must be the output of
+// the BWT
+func TestCorpus() {
+	fmt.Printf("\nCorpus test\n")
+	c1, _ := util.NewBenchmarkCorpus(BENCHMARK_SEED)
+	c2, _ := util.NewBenchmarkCorpus(BENCHMARK_SEED)
+
+	for t := 0; t < util.CORPUS_TYPES; t++ {
+		data1, err1 := c1.Generate(t, 100000)
+		data2, err2 := c2.Generate(t, 100000)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		if len(data1) != 100000 || bytes.Equal(data1, data2) == false {
+			fmt.Printf("Corpus %v not reproducible\n", util.GetCorpusName(t))
+			os.Exit(1)
+		}
+	}
+
+	text, _ := c1.Generate(util.CORPUS_TEXT, 100000)
+	bwtData, _ := c1.Generate(util.CORPUS_BWT, 100000)
+	bwt, _ := transform.NewBWT(0)
+	expected := make([]byte, len(text))
+	bwt.Forward(text, expected)
+
+	if bytes.Equal(bwtData, expected) == false {
+		fmt.Printf("Invalid BWT corpus\n")
+		os.Exit(1)
+	}
+
+	if _, err := c1.Generate(util.CORPUS_TYPES, 10); err == nil {
+		fmt.Printf("Invalid corpus type not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func loadCorpora(size int, fileName string) ([][]byte, []string) {
+	corpus, _ := util.NewBenchmarkCorpus(BENCHMARK_SEED)
+	corpora := make([][]byte, 0)
+	names := make([]string, 0)
+
+	for t := 0; t < util.CORPUS_TYPES; t++ {
+		data, err := corpus.Generate(t, size)
+
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		corpora = append(corpora, data)
+		names = append(names, util.GetCorpusName(t))
+	}
+
+	if len(fileName) > 0 {
+		data, err := corpus.Load(fileName, size)
+
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		corpora = append(corpora, data)
+		names = append(names, fileName)
+	}
+
+	return corpora, names
+}
+
+func report(name string, res testing.BenchmarkResult) {
+	fmt.Printf("%-13v: %v %v\n", name, res, res.MemString())
+}
+
+type bufferOutputStream struct {
+	buffer *bytes.Buffer
+}
+
+func (this *bufferOutputStream) Write(b []byte) (int, error) {
+	return this.buffer.Write(b)
+}
+
+func (this *bufferOutputStream) Close() error {
+	return nil
+}
+
+// Output stream discarding the bytes written
+type nullOutputStream struct {
+}
+
+func (this *nullOutputStream) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (this *nullOutputStream) Close() error {
+	return nil
+}
+
+func rangeEncode(block []byte) []byte {
+	var buffer bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 65536)
+	rc, _ := entropy.NewRangeEncoder(obs)
+
+	if _, err := rc.Encode(block); err != nil {
+		fmt.Printf("An error occured during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	rc.Dispose()
+	obs.Close()
+	return buffer.Bytes()
+}
+
+func BenchmarkRangeEncoder(b *testing.B, block []byte) {
+	b.SetBytes(int64(len(block)))
+
+	for i := 0; i < b.N; i++ {
+		rangeEncode(block)
+	}
+}
+
+func BenchmarkRangeDecoder(b *testing.B, block []byte) {
+	encoded := rangeEncode(block)
+	decoded := make([]byte, len(block))
+	b.SetBytes(int64(len(block)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		in, _ := util.NewByteArrayInputStream(encoded, true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(in, 65536)
+		rd, _ := entropy.NewRangeDecoder(ibs)
+
+		if _, err := rd.Decode(decoded); err != nil {
+			b.Fatalf("An error occured during decoding: %v", err)
+		}
+
+		rd.Dispose()
+		ibs.Close()
+	}
+
+	b.StopTimer()
+
+	if bytes.Equal(block, decoded) == false {
+		b.Fatalf("Different")
+	}
+}
+
+func BenchmarkZRLTForward(b *testing.B, block []byte) {
+	zrlt, _ := function.NewZRLT(0)
+	output := make([]byte, zrlt.MaxEncodedLen(len(block)))
+	b.SetBytes(int64(len(block)))
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := zrlt.Forward(block, output); err != nil {
+			b.Fatalf("An error occured during encoding: %v", err)
+		}
+	}
+}
+
+func BenchmarkZRLTInverse(b *testing.B, block []byte) {
+	zrlt, _ := function.NewZRLT(0)
+	encoded := make([]byte, zrlt.MaxEncodedLen(len(block)))
+	_, dstIdx, _ := zrlt.Forward(block, encoded)
+	decoded := make([]byte, len(block))
+	b.SetBytes(int64(len(block)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		zrlt, _ = function.NewZRLT(dstIdx)
+
+		if _, _, err := zrlt.Inverse(encoded, decoded); err != nil {
+			b.Fatalf("An error occured during decoding: %v", err)
+		}
+	}
+
+	b.StopTimer()
+
+	if bytes.Equal(block, decoded) == false {
+		b.Fatalf("Different")
+	}
+}
+
+// Encoding a block with EntropyEncodeArrayInto must not allocate once the
+// scratch and the encoder have served a first block (several chunks, so that
+// the frequencies of each chunk are normalized)
+func TestEncodeAllocs() {
+	fmt.Printf("\nEncoding allocation test\n")
+	corpus, _ := util.NewBenchmarkCorpus(BENCHMARK_SEED)
+	block, _ := corpus.Generate(util.CORPUS_TEXT, 1<<18)
+	obs, _ := bitstream.NewDefaultOutputBitStream(&nullOutputStream{}, 65536)
+	scratch, _ := entropy.NewEntropyUtils()
+	rc, _ := entropy.NewRangeEncoder(obs, 16384, 12)
+	ans, _ := entropy.NewANSRangeEncoder(obs, 16384, 12)
+	encoders := []kanzi.EntropyEncoder{rc, ans}
+	names := []string{"RangeEncoder", "ANSRangeEncoder"}
+
+	for i, ee := range encoders {
+		var err error
+
+		allocs := testing.AllocsPerRun(20, func() {
+			if _, e := entropy.EntropyEncodeArrayInto(ee, block, scratch); e != nil {
+				err = e
+			}
+		})
+
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-15v: %v allocations per block\n", names[i], allocs)
+
+		if allocs != 0 {
+			fmt.Printf("Failure: the hot path allocates\n")
+			os.Exit(1)
+		}
+	}
+
+	obs.Close()
+	fmt.Printf("Success\n")
+}
+
+func BenchmarkEncodeInto(b *testing.B, block []byte) {
+	obs, _ := bitstream.NewDefaultOutputBitStream(&nullOutputStream{}, 65536)
+	rc, _ := entropy.NewRangeEncoder(obs)
+	scratch, _ := entropy.NewEntropyUtils()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(block)))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := entropy.EntropyEncodeArrayInto(rc, block, scratch); err != nil {
+			b.Fatalf("An error occured during encoding: %v", err)
+		}
+	}
+
+	b.StopTimer()
+	obs.Close()
+}
@@ -16,11 +16,13 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"kanzi/bitstream"
 	"kanzi/entropy"
 	"kanzi/util"
+	"math"
 	"math/rand"
 	"os"
 	"strings"
@@ -29,7 +31,7 @@ import (
 
 func main() {
 
-	var name = flag.String("type", "all", "Type of predictor (all, CM, FPAQ or PAQ)")
+	var name = flag.String("type", "all", "Type of predictor (all, CM, FPAQ, PAQ or PAQLite)")
 
 	// Parse
 	flag.Parse()
@@ -45,6 +47,13 @@ func main() {
 		fmt.Printf("\n\nTestPAQEntropyCoder")
 		TestCorrectness("PAQ")
 		TestSpeed("PAQ")
+		fmt.Printf("\n\nTestPAQLITEEntropyCoder")
+		TestCorrectness("PAQLITE")
+		TestSpeed("PAQLITE")
+		TestRatio()
+		TestPadding()
+		TestScatter()
+		TestSkewed()
 	} else {
 		fmt.Printf("\n\nTest%vEntropyCoder", name_)
 		TestCorrectness(name_)
@@ -67,6 +76,10 @@ func getPredictor(name string) entropy.Predictor {
 		res, _ := entropy.NewCMPredictor()
 		return res
 
+	case "PAQLITE":
+		res, _ := entropy.NewPAQLitePredictor()
+		return res
+
 	default:
 		panic(fmt.Errorf("Unsupported type: '%s'", name))
 	}
@@ -181,6 +194,12 @@ func TestSpeed(name string) {
 		delta2 := int64(0)
 		iter := 2000
 		size := 50000
+
+		if name == "PAQLITE" {
+			// Larger models, slower
+			iter = 100
+		}
+
 		buffer := make([]byte, size*2)
 		values1 := make([]byte, size)
 		values2 := make([]byte, size)
@@ -261,3 +280,340 @@ func TestSpeed(name string) {
 		fmt.Printf("Throughput [KB/s]: %d\n", (int64(iter*size))*1000000/delta2*1000/1024)
 	}
 }
+
+// English like text: sentences built from a small vocabulary
+func generateText(size int, rnd *rand.Rand) []byte {
+	words := strings.Fields("the of and to in is was that for it with as his on be at by " +
+		"had are but from or have an they which one you were her all she there would " +
+		"their we him been has when who will more no if out so said what up its about " +
+		"into than them can only other new some could time these two may then do first")
+	res := make([]byte, 0, size+32)
+
+	for len(res) < size {
+		n := 4 + rnd.Intn(12)
+
+		for i := 0; i < n; i++ {
+			w := words[rnd.Intn(len(words))]
+
+			if i == 0 {
+				w = strings.ToUpper(w[0:1]) + w[1:]
+			}
+
+			res = append(res, w...)
+
+			if i < n-1 {
+				res = append(res, ' ')
+			}
+		}
+
+		res = append(res, ". "...)
+
+		if rnd.Intn(5) == 0 {
+			res = append(res, '\n')
+		}
+	}
+
+	return res[0:size]
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (text)\n")
+	values := generateText(1<<20, rand.New(rand.NewSource(12345)))
+	sizes := make(map[string]uint64)
+
+	for _, name := range []string{"RANGE", "FPAQ", "CM", "PAQ", "PAQLITE"} {
+		buffer := make([]byte, 2*len(values))
+		oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+		obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 65536)
+		entropyType := entropy.GetEntropyCodecType(name)
+		fc, _ := entropy.NewEntropyEncoder(obs, entropyType)
+		before := time.Now()
+
+		if _, err := fc.Encode(values); err != nil {
+			fmt.Printf("Error during encoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		fc.Dispose()
+		obs.Close()
+		after := time.Now()
+		sizes[name] = obs.Written() >> 3
+
+		iFile, _ := util.NewByteArrayInputStream(buffer, true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 65536)
+		fd, _ := entropy.NewEntropyDecoder(ibs, entropyType)
+		values2 := make([]byte, len(values))
+
+		if _, err := fd.Decode(values2); err != nil {
+			fmt.Printf("Error during decoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		fd.Dispose()
+
+		for i := range values {
+			if values[i] != values2[i] {
+				fmt.Printf("Different (%v) at index %v\n", name, i)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("%-8v: %v => %v bytes (%v ms)\n", name, len(values), sizes[name], after.Sub(before).Nanoseconds()/1000000)
+	}
+
+	if sizes["PAQLITE"] >= sizes["RANGE"] {
+		fmt.Printf("No compression improvement over the range coder\n")
+		os.Exit(1)
+	}
+}
+
+// The alignment padding must be skipped by the decoder whatever the pad value
+func TestPadding() {
+	fmt.Printf("\n\nPadding test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	marker := uint64(0xCAFEBABE)
+
+	for _, name := range []string{"FPAQ", "CM", "PAQ", "PAQLITE"} {
+		for _, pad := range []byte{0x00, 0xAA, 0xFF} {
+			for _, alignment := range []uint{0, 1, 16, 256} {
+				values := make([]byte, 1+rnd.Intn(1000))
+
+				for i := range values {
+					values[i] = byte(65 + rnd.Intn(20))
+				}
+
+				buffer := make([]byte, 4096)
+				oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+				obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+
+				// Start at a non byte boundary
+				obs.WriteBits(5, 3)
+				fc, _ := entropy.NewBinaryEntropyEncoder(obs, getPredictor(name))
+
+				if fc.SetPadding(alignment, pad) == false {
+					fmt.Printf("Cannot set padding\n")
+					os.Exit(1)
+				}
+
+				fc.Encode(values)
+				tail := obs.Written() // before the final flush
+				fc.Dispose()
+				tail += 56
+				written := obs.Written()
+				obs.WriteBits(marker, 32)
+				obs.Close()
+				fmt.Printf("%-7v pad=0x%02X alignment=%3v: %4v bytes => %4v bits", name, pad, alignment, len(values), written)
+
+				if alignment > 0 && written%uint64(8*alignment) != 0 {
+					fmt.Printf("\nInvalid alignment\n")
+					os.Exit(1)
+				}
+
+				// The whole bytes after the decodable tail must be pad bytes
+				for i := (tail + 7) / 8; i < written/8; i++ {
+					if buffer[i] != pad {
+						fmt.Printf("\nPad value not found at index %v\n", i)
+						os.Exit(1)
+					}
+				}
+
+				iFile, _ := util.NewByteArrayInputStream(buffer, true)
+				ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+
+				if ibs.ReadBits(3) != 5 {
+					fmt.Printf("\nInvalid prefix\n")
+					os.Exit(1)
+				}
+
+				fd, _ := entropy.NewBinaryEntropyDecoder(ibs, getPredictor(name))
+				fd.SetAlignment(alignment)
+				values2 := make([]byte, len(values))
+				fd.Decode(values2)
+				fd.Dispose()
+
+				for i := range values {
+					if values[i] != values2[i] {
+						fmt.Printf("\nDifferent at index %v\n", i)
+						os.Exit(1)
+					}
+				}
+
+				if m := ibs.ReadBits(32); m != marker {
+					fmt.Printf("\nInvalid data after the padding: %x\n", m)
+					os.Exit(1)
+				}
+
+				fmt.Printf(" Identical\n")
+			}
+		}
+	}
+
+	oFile, _ := util.NewByteArrayOutputStream(make([]byte, 16), false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+	fc, _ := entropy.NewBinaryEntropyEncoder(obs, getPredictor("FPAQ"))
+
+	if fc.SetPadding(3, 0) == true {
+		fmt.Printf("Invalid alignment not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Decoding into several buffers must produce the same bytes as a contiguous decode
+func TestScatter() {
+	fmt.Printf("\n\nScatter test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, name := range []string{"FPAQ", "CM", "PAQ", "PAQLITE"} {
+		for ii := 0; ii < 5; ii++ {
+			values := make([]byte, rnd.Intn(20000))
+
+			for i := range values {
+				values[i] = byte(65 + rnd.Intn(4+i&31))
+			}
+
+			buffer := make([]byte, 32768)
+			oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+			obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+			fc, _ := entropy.NewBinaryEntropyEncoder(obs, getPredictor(name))
+			fc.Encode(values)
+			fc.Dispose()
+			obs.Close()
+
+			// Contiguous
+			iFile, _ := util.NewByteArrayInputStream(buffer, true)
+			ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+			fd, _ := entropy.NewBinaryEntropyDecoder(ibs, getPredictor(name))
+			values1 := make([]byte, len(values))
+			fd.Decode(values1)
+
+			// Scattered in buffers of random sizes (some empty), total size = len(values)
+			dst := make([][]byte, 0)
+
+			for remaining := len(values); remaining > 0; {
+				n := rnd.Intn(4096)
+
+				if n > remaining {
+					n = remaining
+				}
+
+				dst = append(dst, make([]byte, n))
+				remaining -= n
+			}
+
+			iFile, _ = util.NewByteArrayInputStream(buffer, true)
+			ibs, _ = bitstream.NewDefaultInputBitStream(iFile, 16384)
+			fd, _ = entropy.NewBinaryEntropyDecoder(ibs, getPredictor(name))
+			decoded, err := fd.DecodeScatter(dst)
+
+			if err != nil {
+				fmt.Printf("Decoding error: %v\n", err)
+				os.Exit(1)
+			}
+
+			values2 := make([]byte, 0, len(values))
+
+			for _, b := range dst {
+				values2 = append(values2, b...)
+			}
+
+			fmt.Printf("%-7v %5v bytes in %3v buffers: ", name, decoded, len(dst))
+
+			if decoded != len(values) || bytes.Equal(values, values1) == false || bytes.Equal(values1, values2) == false {
+				fmt.Printf("Different\n")
+				os.Exit(1)
+			}
+
+			fmt.Printf("Identical\n")
+		}
+	}
+}
+
+// Custom predictor with a fixed probability of 1 (in [0..4095])
+type fixedPredictor struct {
+	p uint
+}
+
+func (this *fixedPredictor) Update(bit byte) {
+}
+
+func (this *fixedPredictor) Get() uint {
+	return this.p
+}
+
+// Bits with a probability of 10% for 1: the size must be close to the
+// entropy of the source (0.469 bit per bit) with the matching fixed
+// predictor and with the adaptive FPAQ predictor
+func TestSkewed() {
+	fmt.Printf("\n\nSkewed source test (90/10)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	values := make([]byte, 1<<20)
+
+	for i := range values {
+		for j := uint(0); j < 8; j++ {
+			if rnd.Intn(10) == 0 {
+				values[i] |= 1 << j
+			}
+		}
+	}
+
+	entropyBits := -0.1*math.Log2(0.1) - 0.9*math.Log2(0.9)
+	optimal := entropyBits * float64(len(values))
+
+	for _, name := range []string{"fixed", "FPAQ"} {
+		var predictor1, predictor2 entropy.Predictor
+
+		if name == "fixed" {
+			predictor1 = &fixedPredictor{p: 410}
+			predictor2 = &fixedPredictor{p: 410}
+		} else {
+			predictor1 = getPredictor(name)
+			predictor2 = getPredictor(name)
+		}
+
+		buffer := make([]byte, 2*len(values))
+		oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+		obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 65536)
+		ec, _ := entropy.NewBinaryEntropyEncoder(obs, predictor1)
+
+		if _, err := ec.Encode(values); err != nil {
+			fmt.Printf("Error during encoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		ec.Dispose()
+		obs.Close()
+		size := float64(obs.Written() >> 3)
+
+		iFile, _ := util.NewByteArrayInputStream(buffer, true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 65536)
+		ed, _ := entropy.NewBinaryEntropyDecoder(ibs, predictor2)
+		values2 := make([]byte, len(values))
+
+		if _, err := ed.Decode(values2); err != nil {
+			fmt.Printf("Error during decoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		ed.Dispose()
+
+		if bytes.Equal(values, values2) == false {
+			fmt.Printf("Different (%v)\n", name)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-5v: %v => %v bytes (entropy %.0f bytes, %+.2f%%)\n", name, len(values),
+			size, optimal, 100*(size-optimal)/optimal)
+
+		// Within 1% for the exact model, 3% for the adaptive one
+		limit := 1.01
+
+		if name != "fixed" {
+			limit = 1.03
+		}
+
+		if size > optimal*limit {
+			fmt.Printf("Too far from the entropy of the source\n")
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kanzi"
+	"kanzi/entropy"
+	"math/rand"
+	"os"
+)
+
+// Minimal bitstreams implementing only the kanzi.OutputBitStream and
+// kanzi.InputBitStream contracts (one byte per bit, no buffering, no
+// padding). They are strict: a bit count outside [1..64], an access after
+// Close or a read past the written bits panics. Codecs must work with them
+// exactly as they do with the default bitstreams.
+
+type mockOutputBitStream struct {
+	bits   []byte
+	closed bool
+}
+
+func (this *mockOutputBitStream) WriteBit(bit int) {
+	if this.closed == true {
+		panic(errors.New("Stream closed"))
+	}
+
+	this.bits = append(this.bits, byte(bit&1))
+}
+
+func (this *mockOutputBitStream) WriteBits(value uint64, count uint) uint {
+	if count == 0 || count > 64 {
+		panic(fmt.Errorf("Invalid length: %v (must be in [1..64])", count))
+	}
+
+	for i := int(count) - 1; i >= 0; i-- {
+		this.WriteBit(int(value >> uint(i)))
+	}
+
+	return count
+}
+
+func (this *mockOutputBitStream) Close() (bool, error) {
+	this.closed = true
+	return true, nil
+}
+
+func (this *mockOutputBitStream) Written() uint64 {
+	return uint64(len(this.bits))
+}
+
+type mockInputBitStream struct {
+	bits   []byte
+	read   int
+	closed bool
+}
+
+func (this *mockInputBitStream) ReadBit() int {
+	if this.closed == true {
+		panic(errors.New("Stream closed"))
+	}
+
+	if this.read >= len(this.bits) {
+		panic(errors.New("No more data to read in the bitstream"))
+	}
+
+	this.read++
+	return int(this.bits[this.read-1])
+}
+
+func (this *mockInputBitStream) ReadBits(count uint) uint64 {
+	if count == 0 || count > 64 {
+		panic(fmt.Errorf("Invalid length: %v (must be in [1..64])", count))
+	}
+
+	res := uint64(0)
+
+	for i := uint(0); i < count; i++ {
+		res = (res << 1) | uint64(this.ReadBit())
+	}
+
+	return res
+}
+
+func (this *mockInputBitStream) Close() (bool, error) {
+	this.closed = true
+	return true, nil
+}
+
+func (this *mockInputBitStream) Read() uint64 {
+	return uint64(this.read)
+}
+
+func (this *mockInputBitStream) HasMoreToRead() (bool, error) {
+	if this.closed == true {
+		return false, errors.New("Stream closed")
+	}
+
+	return this.read < len(this.bits), nil
+}
+
+func main() {
+	fmt.Printf("\nTestBitStreamInterface")
+	TestEntropyCodecs()
+	TestGolombCodecs()
+}
+
+func generate(size int, kind int) []byte {
+	buf := make([]byte, size)
+
+	for i := range buf {
+		switch kind {
+		case 0:
+			buf[i] = byte(rand.Intn(256))
+		case 1:
+			buf[i] = byte(65 + rand.Intn(4+i%8))
+		default:
+			buf[i] = byte(i >> 6)
+		}
+	}
+
+	return buf
+}
+
+// Encode with the encoder, decode with the decoder, both working on the mock
+// bitstreams. Any panic of the codec or of the bitstreams is an error.
+func roundTrip(input []byte, newEncoder func(kanzi.OutputBitStream) (kanzi.EntropyEncoder, error),
+	newDecoder func(kanzi.InputBitStream) (kanzi.EntropyDecoder, error)) (written uint64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	obs := &mockOutputBitStream{bits: make([]byte, 0)}
+	ee, err := newEncoder(obs)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = ee.Encode(input); err != nil {
+		return 0, err
+	}
+
+	ee.Dispose()
+	obs.Close()
+	written = obs.Written()
+
+	ibs := &mockInputBitStream{bits: obs.bits}
+	ed, err := newDecoder(ibs)
+
+	if err != nil {
+		return 0, err
+	}
+
+	output := make([]byte, len(input))
+
+	if _, err = ed.Decode(output); err != nil {
+		return 0, err
+	}
+
+	ed.Dispose()
+
+	if bytes.Equal(input, output) == false {
+		return 0, errors.New("Decoded data differs from original data")
+	}
+
+	if ibs.Read() > written {
+		return 0, fmt.Errorf("Read %v bits but only %v bits written", ibs.Read(), written)
+	}
+
+	ibs.Close()
+	return written, nil
+}
+
+func TestEntropyCodecs() {
+	fmt.Printf("\n\nEntropy codecs on mock bitstreams")
+	names := []string{"None", "Huffman", "FPAQ", "PAQ", "Range", "ANS", "CM", "PAQLite", "Order1Range", "MixedRange"}
+	sizes := []int{0, 1, 2, 255, 4096, 65536}
+
+	for t := range names {
+		codecType := byte(t)
+		fmt.Printf("\n%-8s", names[t])
+
+		for _, size := range sizes {
+			for kind := 0; kind < 3; kind++ {
+				input := generate(size, kind)
+
+				written, err := roundTrip(input,
+					func(obs kanzi.OutputBitStream) (kanzi.EntropyEncoder, error) {
+						return entropy.NewEntropyEncoder(obs, codecType)
+					},
+					func(ibs kanzi.InputBitStream) (kanzi.EntropyDecoder, error) {
+						return entropy.NewEntropyDecoder(ibs, codecType)
+					})
+
+				if err != nil {
+					fmt.Printf("\nFailure for codec %v, size %v, data kind %v: %v\n", names[t], size, kind, err)
+					os.Exit(1)
+				}
+
+				if kind == 1 && size == 65536 {
+					fmt.Printf(" %v => %v bits", size*8, written)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("\nIdentical\n")
+}
+
+func TestGolombCodecs() {
+	fmt.Printf("\nGolomb codecs on mock bitstreams")
+
+	for _, signed := range []bool{false, true} {
+		for _, size := range []int{0, 1, 100, 10000} {
+			input := generate(size, 1)
+
+			for i := range input {
+				input[i] -= 65
+			}
+
+			if _, err := roundTrip(input,
+				func(obs kanzi.OutputBitStream) (kanzi.EntropyEncoder, error) {
+					return entropy.NewExpGolombEncoder(obs, signed)
+				},
+				func(ibs kanzi.InputBitStream) (kanzi.EntropyDecoder, error) {
+					return entropy.NewExpGolombDecoder(ibs, signed)
+				}); err != nil {
+				fmt.Printf("\nFailure for ExpGolomb codec, size %v: %v\n", size, err)
+				os.Exit(1)
+			}
+
+			for logBase := uint(1); logBase <= 7; logBase += 2 {
+				if _, err := roundTrip(input,
+					func(obs kanzi.OutputBitStream) (kanzi.EntropyEncoder, error) {
+						return entropy.NewRiceGolombEncoder(obs, signed, logBase)
+					},
+					func(ibs kanzi.InputBitStream) (kanzi.EntropyDecoder, error) {
+						return entropy.NewRiceGolombDecoder(ibs, signed, logBase)
+					}); err != nil {
+					fmt.Printf("\nFailure for RiceGolomb codec (log base %v), size %v: %v\n", logBase, size, err)
+					os.Exit(1)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("\nIdentical\n")
+}
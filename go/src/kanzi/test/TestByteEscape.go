@@ -0,0 +1,281 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestByteEscape\n")
+	TestCorrectness()
+	TestErrors()
+	TestCompressed()
+	TestSpeed()
+}
+
+// Escape, check that no disallowed byte is left, unescape and compare
+func roundTrip(be *function.ByteEscape, input []byte) bool {
+	output := make([]byte, be.MaxEncodedLen(len(input)))
+	reverse := make([]byte, len(input))
+	_, dstIdx, err := be.Forward(input, output)
+
+	if err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		return false
+	}
+
+	for i := uint(0); i < dstIdx; i++ {
+		if be.Disallowed(output[i]) == true {
+			fmt.Printf("Disallowed byte %v at index %v in output\n", output[i], i)
+			return false
+		}
+	}
+
+	be.SetSize(dstIdx)
+	_, oIdx, err := be.Inverse(output, reverse)
+	be.SetSize(0)
+
+	if err != nil {
+		fmt.Printf("Decoding error: %v\n", err)
+		return false
+	}
+
+	if int(oIdx) != len(input) || bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		return false
+	}
+
+	return true
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Example from the doc comment
+	{
+		input := []byte{65, 0, 66, 13, 10, 27}
+		expected := []byte{65, 27, 1, 66, 27, 3, 27, 2, 27, 4}
+		be, _ := function.NewByteEscape(0, []byte{0, 10, 13}, 27)
+		output := make([]byte, be.MaxEncodedLen(len(input)))
+		_, dstIdx, _ := be.Forward(input, output)
+
+		if bytes.Equal(output[0:dstIdx], expected) == false {
+			fmt.Printf("Invalid encoding: %v (expected %v)\n", output[0:dstIdx], expected)
+			os.Exit(1)
+		}
+	}
+
+	for ii := 0; ii < 50; ii++ {
+		// Random disallowed set (up to the max size) and escape byte
+		perm := rnd.Perm(256)
+		count := rnd.Intn(function.MAX_ESCAPED_BYTES + 1)
+
+		if ii == 1 {
+			count = function.MAX_ESCAPED_BYTES
+		}
+
+		disallowed := make([]byte, count)
+
+		for i := range disallowed {
+			disallowed[i] = byte(perm[i])
+		}
+
+		escape := byte(perm[count])
+		be, err := function.NewByteEscape(0, disallowed, escape)
+
+		if err != nil {
+			fmt.Printf("Cannot create transform: %v\n", err)
+			os.Exit(1)
+		}
+
+		size := rnd.Intn(10000)
+		input := make([]byte, size)
+
+		switch ii % 4 {
+		case 0:
+			// Random bytes
+			for i := range input {
+				input[i] = byte(rnd.Intn(256))
+			}
+
+		case 1:
+			// Disallowed bytes only (or escape bytes if none is disallowed)
+			for i := range input {
+				if count == 0 {
+					input[i] = escape
+				} else {
+					input[i] = disallowed[rnd.Intn(count)]
+				}
+			}
+
+		case 2:
+			// Escape bytes only
+			for i := range input {
+				input[i] = escape
+			}
+
+		default:
+			// Escape sequences in the input
+			for i := range input {
+				if i&1 == 0 {
+					input[i] = escape
+				} else {
+					input[i] = byte(rnd.Intn(256))
+				}
+			}
+		}
+
+		fmt.Printf("Test %v: %v disallowed bytes, escape %v, size %v\n", ii, count, escape, size)
+
+		if roundTrip(be, input) == false {
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+func TestErrors() {
+	fmt.Printf("\nError test\n")
+
+	if _, err := function.NewByteEscape(0, []byte{1, 2, 3}, 2); err == nil {
+		fmt.Printf("Disallowed escape byte not detected\n")
+		os.Exit(1)
+	}
+
+	tooMany := make([]byte, function.MAX_ESCAPED_BYTES+1)
+
+	for i := range tooMany {
+		tooMany[i] = byte(i)
+	}
+
+	if _, err := function.NewByteEscape(0, tooMany, 255); err == nil {
+		fmt.Printf("Too many disallowed bytes not detected\n")
+		os.Exit(1)
+	}
+
+	be, _ := function.NewByteEscape(0, []byte{0, 10, 13}, 27)
+	output := make([]byte, 16)
+
+	// Invalid encoded data
+	for _, invalid := range [][]byte{{65, 27}, {65, 27, 200}, {65, 10, 66}} {
+		if _, _, err := be.Inverse(invalid, output); err == nil {
+			fmt.Printf("Invalid input not detected: %v\n", invalid)
+			os.Exit(1)
+		} else {
+			fmt.Printf("%v: %v\n", invalid, err)
+		}
+	}
+
+	// Output too small
+	if _, _, err := be.Forward([]byte{0, 0, 0}, make([]byte, 5)); err == nil {
+		fmt.Printf("Output buffer too small not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Make the output of a compressor safe for a text protocol
+func TestCompressed() {
+	fmt.Printf("\nCompressed data test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := make([]byte, 300000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(16))
+	}
+
+	compressed, err := kio.Compress(input, "Huffman", "BWT+MTF", 1<<18)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	be, _ := function.NewByteEscape(0, []byte{0, '\n', '\r'}, '\\')
+	escaped := make([]byte, be.MaxEncodedLen(len(compressed)))
+	_, dstIdx, _ := be.Forward(compressed, escaped)
+	fmt.Printf("Compressed: %v bytes, escaped: %v bytes\n", len(compressed), dstIdx)
+
+	if bytes.IndexAny(escaped[0:dstIdx], "\x00\n\r") >= 0 {
+		fmt.Printf("Disallowed byte in output\n")
+		os.Exit(1)
+	}
+
+	be.SetSize(dstIdx)
+	unescaped := make([]byte, len(compressed))
+	_, oIdx, _ := be.Inverse(escaped, unescaped)
+	res, err := kio.Decompress(unescaped[0:oIdx])
+
+	if err != nil || bytes.Equal(res, input) == false {
+		fmt.Printf("Different: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+func TestSpeed() {
+	iter := 2000
+	size := 50000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	rnd := rand.New(rand.NewSource(12345))
+	input := make([]byte, size)
+
+	for i := range input {
+		input[i] = byte(rnd.Intn(256))
+	}
+
+	be, _ := function.NewByteEscape(0, []byte{0, '\n', '\r'}, '\\')
+	output := make([]byte, be.MaxEncodedLen(size))
+	reverse := make([]byte, size)
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		be.SetSize(0)
+		before := time.Now()
+		_, dstIdx, _ := be.Forward(input, output)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		be.SetSize(dstIdx)
+		before = time.Now()
+		be.Inverse(output, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("ByteEscape encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s]       : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("ByteEscape decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s]       : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
@@ -0,0 +1,355 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Round trip (Forward then Inverse) of every registered function on a set
+// of generated inputs. A function may decline to transform an input (error
+// in Forward), in which case the block would be stored unmodified.
+
+const (
+	MAX_TEST_LENGTH = 4 * 1024 * 1024
+)
+
+func main() {
+	fmt.Printf("TestByteFunctions\n")
+	TestRoundTrip()
+	TestPipeline()
+}
+
+type testInput struct {
+	name string
+	data []byte
+}
+
+func generateInputs(rnd *rand.Rand) []testInput {
+	res := make([]testInput, 0)
+	res = append(res, testInput{"empty", []byte{}})
+	res = append(res, testInput{"single byte", []byte{byte(rnd.Intn(256))}})
+
+	for _, size := range []int{2, 16, 17, 255, 256, 1000, 65536} {
+		random := make([]byte, size)
+
+		for i := range random {
+			random[i] = byte(rnd.Intn(256))
+		}
+
+		res = append(res, testInput{fmt.Sprintf("random %v", size), random})
+	}
+
+	for _, size := range []int{64, 4096, 100000} {
+		zeros := make([]byte, size)
+		res = append(res, testInput{fmt.Sprintf("zeros %v", size), zeros})
+		runs := make([]byte, size)
+
+		for i := 0; i < size; {
+			val := byte(rnd.Intn(4))
+			run := 1 + rnd.Intn(300)
+
+			for j := 0; j < run && i < size; j++ {
+				runs[i] = val
+				i++
+			}
+		}
+
+		res = append(res, testInput{fmt.Sprintf("runs %v", size), runs})
+		text := make([]byte, size)
+		sentence := []byte("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ")
+
+		for i := range text {
+			text[i] = sentence[(i+rnd.Intn(2))%len(sentence)]
+		}
+
+		res = append(res, testInput{fmt.Sprintf("text %v", size), text})
+	}
+
+	// Max length: alternate structured and random regions
+	big := make([]byte, MAX_TEST_LENGTH)
+
+	for i := range big {
+		if (i>>16)&1 == 0 {
+			big[i] = byte(i >> 8)
+		} else {
+			big[i] = byte(rnd.Intn(256))
+		}
+	}
+
+	res = append(res, testInput{"max length", big})
+	return res
+}
+
+// Return the list of function types to test: all registered functions
+// plus the GST variants of the BWT based functions.
+func getFunctionTypes() []byte {
+	res := make([]byte, 0)
+
+	for _, t := range function.GetByteFunctionTypes() {
+		name := function.GetByteFunctionName(t)
+		res = append(res, t)
+
+		if name == "BWT" || name == "BWTS" {
+			for _, gst := range []string{"MTF", "RANK", "TIMESTAMP"} {
+				res = append(res, function.GetByteFunctionType(name+"+"+gst))
+			}
+		}
+	}
+
+	return res
+}
+
+func roundTrip(functionType byte, input []byte) (res bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = false
+			err = fmt.Errorf("Panic: %v", r)
+		}
+	}()
+
+	length := uint(len(input))
+	src := make([]byte, len(input))
+	copy(src, input) // Forward may modify its input
+	fn, err := function.NewByteFunction(length, functionType)
+
+	if err != nil {
+		return false, err
+	}
+
+	requiredSize := fn.MaxEncodedLen(len(input))
+
+	if requiredSize < 0 {
+		requiredSize = len(input) * 5 >> 2
+	}
+
+	// Same buffer size as in the compressed stream
+	buffer := make([]byte, requiredSize)
+	_, oIdx, err := fn.Forward(src, buffer)
+
+	if err != nil {
+		// Transform declined
+		return false, nil
+	}
+
+	// The inverse function may use its input buffer as scratch space
+	if len(buffer) < len(input) {
+		buffer = append(buffer, make([]byte, len(input)-len(buffer))...)
+	}
+
+	if oIdx > length {
+		buffer = buffer[0:oIdx]
+	} else {
+		buffer = buffer[0:length]
+	}
+
+	fn, err = function.NewByteFunction(oIdx, functionType)
+
+	if err != nil {
+		return false, err
+	}
+
+	output := make([]byte, len(input))
+	_, dIdx, err := fn.Inverse(buffer, output)
+
+	if err != nil {
+		return false, fmt.Errorf("Inverse failed: %v", err)
+	}
+
+	if dIdx != length {
+		return false, fmt.Errorf("Decoded length %v, expected %v", dIdx, length)
+	}
+
+	if bytes.Equal(input, output[0:dIdx]) == false {
+		return false, fmt.Errorf("Decoded data differs from input")
+	}
+
+	return true, nil
+}
+
+func TestRoundTrip() {
+	fmt.Printf("Round trip test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	inputs := generateInputs(rnd)
+	failed := 0
+
+	for _, t := range getFunctionTypes() {
+		name := function.GetByteFunctionName(t)
+		fmt.Printf("\n%v\n", name)
+
+		for _, in := range inputs {
+			ok, err := roundTrip(t, in.data)
+
+			if err != nil {
+				fmt.Printf("  %-12v: FAILED (%v)\n", in.name, err)
+				failed++
+			} else if ok == false {
+				fmt.Printf("  %-12v: declined\n", in.name)
+			} else {
+				fmt.Printf("  %-12v: identical\n", in.name)
+			}
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%v round trip(s) failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+// Return false if Inverse failed, an error if it panicked
+func inversePipeline(pipeline *function.ByteFunctionPipeline, encoded []byte, size int) (res bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = false
+			err = fmt.Errorf("Panic: %v", r)
+		}
+	}()
+
+	_, _, err = pipeline.Inverse(encoded, make([]byte, size))
+	return err == nil, nil
+}
+
+func TestPipeline() {
+	fmt.Printf("\nPipeline test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	types := []byte{function.GetByteFunctionType("BWT+MTF"), function.ZRLT_TYPE, function.RLT_TYPE}
+	failed := 0
+
+	for _, in := range generateInputs(rnd) {
+		fwd, err := function.NewByteFunctionPipeline(0, types)
+
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		src := make([]byte, len(in.data))
+		copy(src, in.data)
+		requiredSize := fwd.MaxEncodedLen(len(src))
+
+		if requiredSize < 0 {
+			requiredSize = len(src)*5>>2 + 64
+		}
+
+		buffer := make([]byte, requiredSize)
+		_, oIdx, err := fwd.Forward(src, buffer)
+
+		if e, ok := err.(*function.BufferTooSmallError); ok {
+			// An intermediate stage expanded the data
+			buffer = make([]byte, e.Required())
+			copy(src, in.data)
+			_, oIdx, err = fwd.Forward(src, buffer)
+		}
+
+		if err != nil {
+			fmt.Printf("  %-12v: FAILED (Forward: %v)\n", in.name, err)
+			failed++
+			continue
+		}
+
+		// Rebuild the inverse pipeline from the recipe in the header
+		inv, err := function.NewByteFunctionPipelineFromHeader(oIdx, buffer)
+
+		if err != nil {
+			fmt.Printf("  %-12v: FAILED (%v)\n", in.name, err)
+			failed++
+			continue
+		}
+
+		output := make([]byte, len(in.data))
+		_, dIdx, err := inv.Inverse(buffer, output)
+
+		if err != nil {
+			fmt.Printf("  %-12v: FAILED (Inverse: %v)\n", in.name, err)
+			failed++
+		} else if int(dIdx) != len(in.data) || bytes.Equal(in.data, output[0:dIdx]) == false {
+			fmt.Printf("  %-12v: FAILED (decoded data differs from input)\n", in.name)
+			failed++
+		} else {
+			fmt.Printf("  %-12v: %v => %v bytes (%v)\n", in.name, len(in.data), oIdx, inv)
+		}
+	}
+
+	// The recipe of the header must match the pipeline
+	other, _ := function.NewByteFunctionPipeline(0, []byte{function.RLT_TYPE})
+	src := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaabcd")
+	pipeline, _ := function.NewByteFunctionPipeline(0, types)
+	buffer := make([]byte, 1024)
+	_, oIdx, err := pipeline.Forward(src, buffer)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, _, err := other.Inverse(buffer[0:oIdx], make([]byte, len(src))); err == nil {
+		fmt.Printf("Recipe mismatch not detected\n")
+		failed++
+	}
+
+	// Corrupted or truncated headers must be rejected without panic
+	encoded := buffer[0:oIdx]
+	headerSize := 2 + len(types) + 4*(len(types)+1)
+	corrupt := make([]byte, len(encoded))
+	detected := 0
+
+	for i := 0; i < 2000; i++ {
+		copy(corrupt, encoded)
+		length := len(corrupt)
+
+		switch i % 4 {
+		case 0:
+			// Stage count
+			corrupt[0] = byte(rnd.Intn(256))
+
+		case 1:
+			// One byte of the stage sizes
+			corrupt[2+len(types)+rnd.Intn(4*(len(types)+1))] = byte(rnd.Intn(256))
+
+		case 2:
+			// A stage size set to a huge value
+			idx := 2 + len(types) + 4*rnd.Intn(len(types)+1)
+			corrupt[idx] = byte(0x80 + rnd.Intn(128))
+
+		default:
+			length = rnd.Intn(headerSize + 1)
+		}
+
+		ok, err := inversePipeline(pipeline, corrupt[0:length], len(src))
+
+		if err != nil {
+			fmt.Printf("Corrupted header (test %v): %v\n", i, err)
+			os.Exit(1)
+		}
+
+		if ok == false {
+			detected++
+		}
+	}
+
+	fmt.Printf("2000 corrupted headers: %v errors, no panic\n", detected)
+
+	if failed > 0 {
+		fmt.Printf("\n%v pipeline round trip(s) failed\n", failed)
+		os.Exit(1)
+	}
+}
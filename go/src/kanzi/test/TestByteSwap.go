@@ -0,0 +1,243 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestByteSwap\n")
+	TestCorrectness()
+	TestRatio()
+	TestSpeed()
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	wordSizes := []uint{2, 4, 8}
+
+	for ii := 0; ii < 20; ii++ {
+		wordSize := wordSizes[ii%len(wordSizes)]
+		size := uint(rnd.Intn(64))
+		input := make([]byte, size)
+		output := make([]byte, size)
+		reverse := make([]byte, size)
+
+		for i := range input {
+			input[i] = byte(rnd.Intn(256))
+		}
+
+		fmt.Printf("\nTest %v (word size %v, size %v)\n", ii, wordSize, size)
+		bs, _ := function.NewByteSwap(size, wordSize)
+
+		if _, _, err := bs.Forward(input, output); err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Check the order of bytes in complete words and the trailing bytes
+		for i := uint(0); i < size; i++ {
+			expected := input[i]
+
+			if i < size-size%wordSize {
+				expected = input[i-i%wordSize+wordSize-1-i%wordSize]
+			}
+
+			if output[i] != expected {
+				fmt.Printf("Invalid byte at index %v: expected %v, got %v\n", i, expected, output[i])
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("Original: %v\n", input)
+		fmt.Printf("Coded:    %v\n", output)
+		bs, _ = function.NewByteSwap(size, wordSize)
+
+		if _, _, err := bs.Inverse(output, reverse); err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Decoded:  %v\n", reverse)
+
+		if bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		// In place
+		bs.Forward(output, output)
+
+		if bytes.Equal(input, output) == false {
+			fmt.Printf("Different (in place)\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	if _, err := function.NewByteSwap(0, 3); err == nil {
+		fmt.Printf("Invalid word size not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Replace each big endian word with its difference to the previous word
+func delta(src, dst []byte, wordSize int) {
+	prev := uint64(0)
+	mask := uint64(1<<uint(8*wordSize)) - 1
+
+	if wordSize == 8 {
+		mask = math.MaxUint64
+	}
+
+	end := len(src) - len(src)%wordSize
+
+	for i := 0; i < end; i += wordSize {
+		val := uint64(0)
+
+		for j := 0; j < wordSize; j++ {
+			val = (val << 8) | uint64(src[i+j])
+		}
+
+		d := (val - prev) & mask
+		prev = val
+
+		for j := wordSize - 1; j >= 0; j-- {
+			dst[i+j] = byte(d)
+			d >>= 8
+		}
+	}
+
+	copy(dst[end:], src[end:])
+}
+
+// Order 0 entropy of the data in bytes
+func entropySize(data []byte) int {
+	freqs := make([]int, 256)
+
+	for _, b := range data {
+		freqs[b]++
+	}
+
+	sum := 0.0
+
+	for _, f := range freqs {
+		if f > 0 {
+			p := float64(f) / float64(len(data))
+			sum -= float64(f) * math.Log2(p)
+		}
+	}
+
+	return int(sum / 8)
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (delta on little endian integers)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	wordSizes := []uint{2, 4, 8}
+
+	for _, wordSize := range wordSizes {
+		count := 20000
+		size := count*int(wordSize) + 3 // trailing partial word
+		input := make([]byte, size)
+		val := uint64(1000)
+
+		// Slowly increasing little endian integers
+		for i := 0; i < count; i++ {
+			val += uint64(rnd.Intn(1024))
+
+			for j := 0; j < int(wordSize); j++ {
+				input[i*int(wordSize)+j] = byte(val >> uint(8*j))
+			}
+		}
+
+		swapped := make([]byte, size)
+		out1 := make([]byte, size)
+		out2 := make([]byte, size)
+		bs, _ := function.NewByteSwap(0, wordSize)
+		bs.Forward(input, swapped)
+		delta(input, out1, int(wordSize))
+		delta(swapped, out2, int(wordSize))
+		size1 := entropySize(out1)
+		size2 := entropySize(out2)
+		fmt.Printf("Word size %v: delta=%v bytes, swap+delta=%v bytes (order 0 entropy)\n", wordSize, size1, size2)
+
+		if size2 >= size1 {
+			fmt.Printf("No compression improvement with byte swap\n")
+			os.Exit(1)
+		}
+
+		reverse := make([]byte, size)
+		bs.Inverse(swapped, reverse)
+
+		if bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+	}
+}
+
+func TestSpeed() {
+	iter := 20000
+	size := 50000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := make([]byte, size)
+	output := make([]byte, size)
+	reverse := make([]byte, size)
+
+	for i := range input {
+		input[i] = byte(rand.Intn(256))
+	}
+
+	for _, wordSize := range []uint{2, 4, 8} {
+		bs, _ := function.NewByteSwap(0, wordSize)
+		delta1 := int64(0)
+		delta2 := int64(0)
+
+		for ii := 0; ii < iter; ii++ {
+			before := time.Now()
+			bs.Forward(input, output)
+			after := time.Now()
+			delta1 += after.Sub(before).Nanoseconds()
+			before = time.Now()
+			bs.Inverse(output, reverse)
+			after = time.Now()
+			delta2 += after.Sub(before).Nanoseconds()
+		}
+
+		if bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		prod := int64(iter) * int64(size)
+		fmt.Printf("\nWord size %v\n", wordSize)
+		fmt.Printf("ByteSwap encoding [ms]: %v\n", delta1/1000000)
+		fmt.Printf("Throughput [MB/s]     : %d\n", prod*1000000/delta1*1000/(1024*1024))
+		fmt.Printf("ByteSwap decoding [ms]: %v\n", delta2/1000000)
+		fmt.Printf("Throughput [MB/s]     : %d\n", prod*1000000/delta2*1000/(1024*1024))
+	}
+}
@@ -0,0 +1,339 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestCheckedCodec\n")
+	TestCorrectness()
+	TestRetry()
+	TestIndex()
+}
+
+type byteOutputStream struct {
+	buffer bytes.Buffer
+}
+
+func (this *byteOutputStream) Write(b []byte) (int, error) {
+	return this.buffer.Write(b)
+}
+
+func (this *byteOutputStream) Close() error {
+	return nil
+}
+
+// Reader returning bad data (or an error) for the reads overlapping
+// [start, end) until 'faults' reads have been damaged
+type flakyReaderAt struct {
+	data     []byte
+	start    int64
+	end      int64
+	faults   int
+	fail     bool // return an error instead of bad data
+	attempts int  // reads overlapping [start, end)
+}
+
+func (this *flakyReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := bytes.NewReader(this.data).ReadAt(b, off)
+
+	if off < this.end && off+int64(n) > this.start {
+		this.attempts++
+
+		if this.faults > 0 {
+			this.faults--
+
+			if this.fail == true {
+				return 0, errors.New("Transient read error")
+			}
+
+			// Flip one bit in the range
+			idx := this.start - off
+
+			if idx < 0 {
+				idx = 0
+			}
+
+			b[idx] ^= 0x10
+		}
+	}
+
+	return n, err
+}
+
+func encode(input []byte, codec string, blockSize uint) []byte {
+	output := &byteOutputStream{}
+	enc, err := kio.NewCheckedBlockEncoder(codec, output, blockSize)
+
+	if err != nil {
+		fmt.Printf("Cannot create encoder: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err = enc.Write(input); err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err = enc.Close(); err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return output.buffer.Bytes()
+}
+
+// Return the decoded data and the decoder
+func decode(ra *flakyReaderAt, retries uint) ([]byte, *kio.RetryingBlockDecoder, error) {
+	dec, err := kio.NewRetryingBlockDecoder(ra, retries)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := make([]byte, 0)
+	buf := make([]byte, 1000)
+
+	for {
+		n, err := dec.Read(buf)
+
+		if err != nil {
+			return nil, dec, err
+		}
+
+		if n <= 0 {
+			break
+		}
+
+		res = append(res, buf[0:n]...)
+	}
+
+	return res, dec, dec.Close()
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 10; ii++ {
+		size := rnd.Intn(300000)
+		blockSize := uint(1024 * (1 + rnd.Intn(100)))
+		codec := []string{"None", "Huffman", "Range", "FPAQ", "ANS"}[ii%5]
+
+		if ii == 0 {
+			size = 0
+		}
+
+		input := make([]byte, size)
+
+		for i := range input {
+			input[i] = byte(65 + rnd.Intn(4+i&15))
+		}
+
+		encoded := encode(input, codec, blockSize)
+		res, dec, err := decode(&flakyReaderAt{data: encoded}, kio.DEFAULT_CHECKED_RETRIES)
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Test %v (%-7v, block %6v): %6v => %6v bytes, retried=%v ", ii, codec, blockSize,
+			size, len(encoded), dec.Retried())
+
+		if bytes.Equal(input, res) == false || dec.Retried() != 0 {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	if _, err := kio.NewCheckedBlockEncoder("Huffman", &byteOutputStream{}, 10); err == nil {
+		fmt.Printf("Invalid block size not detected\n")
+		os.Exit(1)
+	}
+
+	if _, err := kio.NewRetryingBlockDecoder(nil, 1); err == nil {
+		fmt.Printf("Invalid null reader not detected\n")
+		os.Exit(1)
+	}
+
+	// Truncated stream
+	encoded := encode(make([]byte, 10000), "Huffman", 4096)
+
+	if _, _, err := decode(&flakyReaderAt{data: encoded[0 : len(encoded)/2]}, 2); err == nil {
+		fmt.Printf("Truncated stream not detected\n")
+		os.Exit(1)
+	}
+}
+
+func TestRetry() {
+	fmt.Printf("\n\nRetry test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := make([]byte, 100000)
+
+	for i := range input {
+		input[i] = byte(97 + rnd.Intn(16))
+	}
+
+	// 10 blocks, corrupt the middle of the stream (somewhere in block 4-5)
+	encoded := encode(input, "Huffman", 10000)
+	start := int64(len(encoded) / 2)
+
+	tests := []struct {
+		name     string
+		reader   *flakyReaderAt
+		retries  uint
+		success  bool
+		attempts int
+	}{
+		{"bad data once         ", &flakyReaderAt{data: encoded, start: start, end: start + 1, faults: 1}, 3, true, 2},
+		{"bad data 3 times      ", &flakyReaderAt{data: encoded, start: start, end: start + 1, faults: 3}, 3, true, 4},
+		{"read error once       ", &flakyReaderAt{data: encoded, start: start, end: start + 1, faults: 1, fail: true}, 1, true, 2},
+		{"bad header once       ", &flakyReaderAt{data: encoded, start: 0, end: 1, faults: 1}, 1, true, 2},
+		{"bad data, no retry    ", &flakyReaderAt{data: encoded, start: start, end: start + 1, faults: 1}, 0, false, 1},
+		{"bad data (persistent) ", &flakyReaderAt{data: encoded, start: start, end: start + 1, faults: 100}, 3, false, 4},
+	}
+
+	for _, t := range tests {
+		res, dec, err := decode(t.reader, t.retries)
+		fmt.Printf("%v: retries=%v attempts=%v error=%v\n", t.name, t.retries, t.reader.attempts, err)
+
+		if t.success == true && (err != nil || bytes.Equal(input, res) == false || dec.Retried() == 0) {
+			fmt.Printf("Failed to recover\n")
+			os.Exit(1)
+		}
+
+		if t.success == false && err == nil {
+			fmt.Printf("Corruption not detected\n")
+			os.Exit(1)
+		}
+
+		if t.reader.attempts != t.attempts {
+			fmt.Printf("Unexpected number of read attempts: %v (expected %v)\n", t.reader.attempts, t.attempts)
+			os.Exit(1)
+		}
+	}
+}
+
+func TestIndex() {
+	fmt.Printf("\n\nIndex test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	blockSize := 10000
+	input := make([]byte, 10*blockSize+1234)
+
+	for i := range input {
+		input[i] = byte(97 + rnd.Intn(16))
+	}
+
+	encoded := encode(input, "ANS", uint(blockSize))
+	size := int64(len(encoded))
+	idx, err := kio.NewBlockIndexReader(&flakyReaderAt{data: encoded}, size)
+
+	if err != nil {
+		fmt.Printf("Cannot load index: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Blocks: %v\n", idx.Count())
+
+	if idx.Count() != 11 {
+		fmt.Printf("Invalid number of blocks\n")
+		os.Exit(1)
+	}
+
+	// Extract a middle block: the previous frames must not be read
+	mid := 5
+	offset, codedSize, blockLen, _ := idx.BlockInfo(mid)
+	reader := &flakyReaderAt{data: encoded, start: kio.CHECKED_HEADER_SIZE, end: offset}
+	idx, _ = kio.NewBlockIndexReader(reader, size)
+	block, err := idx.DecodeBlockAt(mid)
+	fmt.Printf("Block %v: offset=%v, coded size=%v, size=%v, reads of previous frames=%v\n",
+		mid, offset, codedSize, blockLen, reader.attempts)
+
+	if err != nil {
+		fmt.Printf("Decoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if bytes.Equal(block, input[mid*blockSize:(mid+1)*blockSize]) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	if reader.attempts != 0 {
+		fmt.Printf("Previous blocks were read\n")
+		os.Exit(1)
+	}
+
+	// All blocks in reverse order
+	res := make([]byte, 0, len(input))
+
+	for i := idx.Count() - 1; i >= 0; i-- {
+		block, err := idx.DecodeBlockAt(i)
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		res = append(block, res...)
+	}
+
+	if bytes.Equal(res, input) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("All blocks (reverse order): Identical\n")
+
+	if _, err := idx.DecodeBlockAt(idx.Count()); err == nil {
+		fmt.Printf("Invalid block index not detected\n")
+		os.Exit(1)
+	}
+
+	// Corrupted block
+	reader = &flakyReaderAt{data: encoded, start: offset + 20, end: offset + 21, faults: 1}
+	idx, _ = kio.NewBlockIndexReader(reader, size)
+
+	if _, err := idx.DecodeBlockAt(mid); errors.Is(err, kio.ErrChecksumMismatch) == false {
+		fmt.Printf("Corrupted block not detected: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Corrupted index (first entry)
+	reader = &flakyReaderAt{data: encoded, start: size - 8 - 11*16, end: size - 8 - 11*16 + 8, faults: 1}
+
+	if _, err := kio.NewBlockIndexReader(reader, size); err == nil {
+		fmt.Printf("Corrupted index not detected\n")
+		os.Exit(1)
+	}
+
+	// Missing index
+	if _, err := kio.NewBlockIndexReader(&flakyReaderAt{data: encoded}, size-4); err == nil {
+		fmt.Printf("Missing index not detected\n")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestColorTransform\n")
+	TestCorrectness()
+	TestRatio()
+	TestSpeed()
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Example from the doc comment
+	{
+		ct, _ := function.NewColorTransform(0)
+		output := make([]byte, 3)
+		ct.Forward([]byte{120, 100, 80}, output)
+
+		if bytes.Equal(output, []byte{100, 40, 0}) == false {
+			fmt.Printf("Invalid encoding: %v\n", output)
+			os.Exit(1)
+		}
+	}
+
+	for ii := 0; ii < 20; ii++ {
+		size := uint(rnd.Intn(64))
+
+		if ii == 0 {
+			size = 0
+		}
+
+		input := make([]byte, size)
+
+		for i := range input {
+			input[i] = byte(rnd.Intn(256))
+		}
+
+		fmt.Printf("\nTest %v (size %v)\n", ii, size)
+		ct, _ := function.NewColorTransform(0)
+		output := make([]byte, ct.MaxEncodedLen(int(size)))
+		reverse := make([]byte, size)
+		_, dstIdx, err := ct.Forward(input, output)
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Original: %v\n", input)
+		fmt.Printf("Coded:    %v\n", output[0:dstIdx])
+		ct, _ = function.NewColorTransform(dstIdx)
+		_, oIdx, err := ct.Inverse(output, reverse)
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Decoded:  %v\n", reverse[0:oIdx])
+
+		if oIdx != size || bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	// Exhaustive check of all the colors (one value of R at a time)
+	ct, _ := function.NewColorTransform(0)
+	input := make([]byte, 3*65536)
+	output := make([]byte, len(input))
+	reverse := make([]byte, len(input))
+
+	for r := 0; r < 256; r++ {
+		for i := 0; i < 65536; i++ {
+			input[3*i] = byte(r)
+			input[3*i+1] = byte(i >> 8)
+			input[3*i+2] = byte(i)
+		}
+
+		ct.Forward(input, output)
+		ct.Inverse(output, reverse)
+
+		if bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different for R=%v\n", r)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("\nAll colors: Identical\n")
+}
+
+// Synthetic RGB image: smooth shapes and texture shared by the channels (luma)
+// with a slowly varying tint and a little noise per channel
+func generateImage(width, height int, rnd *rand.Rand) []byte {
+	res := make([]byte, 3*width*height)
+	n := 0
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx := float64(x)
+			fy := float64(y)
+			l := 110 + 60*math.Sin(fx/37)*math.Cos(fy/23) + 20*math.Sin((fx+fy)/11)
+			tint := 15 * math.Sin(fx/150)
+			l += float64(rnd.Intn(9) - 4)
+
+			for c := 0; c < 3; c++ {
+				v := l + float64(c-1)*tint + float64(rnd.Intn(3)-1)
+
+				if v < 0 {
+					v = 0
+				} else if v > 255 {
+					v = 255
+				}
+
+				res[n] = byte(v)
+				n++
+			}
+		}
+	}
+
+	return res
+}
+
+// Planes (Columnar) then difference with the previous byte of each plane
+func toDeltaPlanes(data []byte) []byte {
+	col, _ := function.NewColumnar(0, 3)
+	planes := make([]byte, len(data))
+	col.Forward(data, planes)
+	plane := len(data) / 3
+
+	for p := 0; p < 3; p++ {
+		for i := (p+1)*plane - 1; i > p*plane; i-- {
+			planes[i] -= planes[i-1]
+		}
+	}
+
+	return planes
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (RGB image)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateImage(512, 512, rnd)
+	ct, _ := function.NewColorTransform(0)
+	ycocg := make([]byte, len(input))
+	ct.Forward(input, ycocg)
+
+	for _, codec := range []string{"Huffman", "Range"} {
+		raw, err1 := kio.Compress(toDeltaPlanes(input), codec, "None", 1<<20)
+		coded, err2 := kio.Compress(toDeltaPlanes(ycocg), codec, "None", 1<<20)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Compression error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: RGB planes+delta=%v bytes, YCoCg-R planes+delta=%v bytes\n", codec, len(raw), len(coded))
+
+		if len(coded) >= len(raw) {
+			fmt.Printf("No compression improvement with the color transform\n")
+			os.Exit(1)
+		}
+	}
+
+	// Round trip through the stream
+	compressed, err := kio.Compress(input, "Huffman", "Color", 1<<18)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	res, err := kio.Decompress(compressed)
+
+	if err != nil || bytes.Equal(input, res) == false {
+		fmt.Printf("Different (stream): %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func TestSpeed() {
+	iter := 2000
+	size := 3 * 256 * 128
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := generateImage(256, 128, rand.New(rand.NewSource(12345)))
+	ct, _ := function.NewColorTransform(0)
+	output := make([]byte, size)
+	reverse := make([]byte, size)
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		before := time.Now()
+		ct.Forward(input, output)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		before = time.Now()
+		ct.Inverse(output, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("Color encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s]  : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("Color decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s]  : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
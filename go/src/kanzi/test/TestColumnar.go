@@ -0,0 +1,188 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestColumnar\n")
+	TestCorrectness()
+	TestRatio()
+	TestSpeed()
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		recordSize := uint(1 + rnd.Intn(12))
+		size := uint(rnd.Intn(64))
+		input := make([]byte, size)
+		output := make([]byte, size)
+		reverse := make([]byte, size)
+
+		for i := range input {
+			input[i] = byte(rnd.Intn(256))
+		}
+
+		fmt.Printf("\nTest %v (record size %v, size %v)\n", ii, recordSize, size)
+		c, _ := function.NewColumnar(size, recordSize)
+
+		if _, _, err := c.Forward(input, output); err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Check that column i starts with byte i of the first record
+		records := size / recordSize
+
+		for col := uint(0); col < recordSize && records > 0; col++ {
+			if output[col*records] != input[col] {
+				fmt.Printf("Invalid first byte in column %v\n", col)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("Original: %v\n", input)
+		fmt.Printf("Coded:    %v\n", output)
+		c, _ = function.NewColumnar(size, recordSize)
+
+		if _, _, err := c.Inverse(output, reverse); err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Decoded:  %v\n", reverse)
+
+		if bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	if _, err := function.NewColumnar(0, 0); err == nil {
+		fmt.Printf("Invalid record size not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Records of 12 bytes: increasing id (4 bytes), type (2 bytes),
+// random measure (4 bytes), constant flags (2 bytes)
+func generateRecords(count int, rnd *rand.Rand) []byte {
+	res := make([]byte, 0, count*12+5)
+	id := uint32(100000)
+
+	for i := 0; i < count; i++ {
+		id += uint32(1 + rnd.Intn(3))
+		kind := uint16(rnd.Intn(5))
+		measure := rnd.Uint32()
+		res = append(res, byte(id), byte(id>>8), byte(id>>16), byte(id>>24))
+		res = append(res, byte(kind), byte(kind>>8))
+		res = append(res, byte(measure), byte(measure>>8), byte(measure>>16), byte(measure>>24))
+		res = append(res, 0x01, 0x80)
+	}
+
+	// Trailing partial record
+	return append(res, 1, 2, 3, 4, 5)
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (records of 12 bytes)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateRecords(50000, rnd)
+	output := make([]byte, len(input))
+	c, _ := function.NewColumnar(0, 12)
+	c.Forward(input, output)
+
+	for _, codec := range []string{"Huffman", "FPAQ", "CM"} {
+		raw, err1 := kio.Compress(input, codec, "BWT+MTF", 1<<20)
+		columns, err2 := kio.Compress(output, codec, "BWT+MTF", 1<<20)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Compression error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: raw=%v bytes, columnar=%v bytes\n", codec, len(raw), len(columns))
+
+		if len(columns) >= len(raw) {
+			fmt.Printf("No compression improvement with columnar transform\n")
+			os.Exit(1)
+		}
+	}
+
+	reverse := make([]byte, len(input))
+	c.Inverse(output, reverse)
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+}
+
+func TestSpeed() {
+	iter := 20000
+	size := 50000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := make([]byte, size)
+	output := make([]byte, size)
+	reverse := make([]byte, size)
+
+	for i := range input {
+		input[i] = byte(rand.Intn(256))
+	}
+
+	for _, recordSize := range []uint{4, 12, 100} {
+		c, _ := function.NewColumnar(0, recordSize)
+		delta1 := int64(0)
+		delta2 := int64(0)
+
+		for ii := 0; ii < iter; ii++ {
+			before := time.Now()
+			c.Forward(input, output)
+			after := time.Now()
+			delta1 += after.Sub(before).Nanoseconds()
+			before = time.Now()
+			c.Inverse(output, reverse)
+			after = time.Now()
+			delta2 += after.Sub(before).Nanoseconds()
+		}
+
+		if bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		prod := int64(iter) * int64(size)
+		fmt.Printf("\nRecord size %v\n", recordSize)
+		fmt.Printf("Columnar encoding [ms]: %v\n", delta1/1000000)
+		fmt.Printf("Throughput [MB/s]     : %d\n", prod*1000000/delta1*1000/(1024*1024))
+		fmt.Printf("Columnar decoding [ms]: %v\n", delta2/1000000)
+		fmt.Printf("Throughput [MB/s]     : %d\n", prod*1000000/delta2*1000/(1024*1024))
+	}
+}
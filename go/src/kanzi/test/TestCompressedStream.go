@@ -0,0 +1,1597 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	kio "kanzi/io"
+	"kanzi/util"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestCompressedStream\n")
+	TestCorrectness()
+	TestDecompress()
+	TestContentSplit()
+	TestMemoryLimit()
+	TestContentHash()
+	TestDecodePrefix()
+	TestTrailingData()
+	TestIncompressible()
+	TestStoredFallback()
+	TestReset()
+	TestChecksumMode()
+	TestCancel()
+	TestSnapshot()
+	TestVerify()
+	TestVersion()
+	TestTranscode()
+	TestDecodeWithTransforms()
+}
+
+// Concatenation of regions with different statistics
+func generateMixedData(size int, rnd *rand.Rand) []byte {
+	text := []byte("The quick brown fox jumps over the lazy dog. ")
+	data := make([]byte, size)
+	n := 0
+	region := 0
+
+	for n < size {
+		length := 20000 + rnd.Intn(40000)
+
+		if n+length > size {
+			length = size - n
+		}
+
+		switch region % 3 {
+		case 0:
+			for i := 0; i < length; i++ {
+				data[n+i] = text[(n+i)%len(text)]
+			}
+
+		case 1:
+			for i := 0; i < length; i++ {
+				data[n+i] = byte(rnd.Intn(256))
+			}
+
+		default:
+			for i := 0; i < length; i++ {
+				data[n+i] = byte(rnd.Intn(4))
+			}
+		}
+
+		n += length
+		region++
+	}
+
+	return data
+}
+
+func compress(data []byte, entropy, transform string, blockSize uint, split bool, jobs uint) ([]byte, error) {
+	buffer := make([]byte, 2*len(data)+1024)
+	bos, _ := util.NewByteArrayOutputStream(buffer, false)
+	cos, err := kio.NewCompressedOutputStream(entropy, transform, bos, blockSize, true, nil, jobs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cos.SetContentSplit(split)
+
+	if _, err = cos.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = cos.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer[0:cos.GetWritten()], nil
+}
+
+func decompress(data []byte, size int, jobs uint) ([]byte, error) {
+	res, _, err := decompressWithLimit(data, size, jobs, 0)
+	return res, err
+}
+
+// Return the decompressed data and the peak memory used
+func decompressWithLimit(data []byte, size int, jobs uint, maxMemory uint64) ([]byte, uint64, error) {
+	is, _ := util.NewByteArrayInputStream(data, true)
+	cis, err := kio.NewCompressedInputStream(is, nil, jobs)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cis.SetMemoryLimit(maxMemory)
+
+	res := make([]byte, size)
+	read := 0
+
+	for read < size {
+		n, err := cis.Read(res[read:])
+
+		if err != nil {
+			return nil, cis.GetPeakMemory(), err
+		}
+
+		if n <= 0 {
+			break
+		}
+
+		read += n
+	}
+
+	cis.Close()
+	return res[0:read], cis.GetPeakMemory(), nil
+}
+
+func TestCorrectness() {
+	fmt.Printf("\nCorrectness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	entropies := []string{"None", "Huffman", "ANS", "Range", "FPAQ"}
+	transforms := []string{"None", "BWT+MTF", "RLT", "LZ4"}
+
+	for ii := 0; ii < 10; ii++ {
+		size := 65536 + rnd.Intn(300000)
+		data := generateMixedData(size, rnd)
+		entropy := entropies[rnd.Intn(len(entropies))]
+		transform := transforms[rnd.Intn(len(transforms))]
+		split := ii&1 == 1
+		jobs := uint(1 + rnd.Intn(4))
+		fmt.Printf("Test %v: size=%v entropy=%v transform=%v split=%v jobs=%v\n", ii, size, entropy, transform, split, jobs)
+		compressed, err := compress(data, entropy, transform, 65536, split, jobs)
+
+		if err != nil {
+			fmt.Printf("Compression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		decompressed, err := decompress(compressed, size, jobs)
+
+		if err != nil {
+			fmt.Printf("Decompression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(data, decompressed) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical (%v => %v)\n", size, len(compressed))
+	}
+}
+
+// Decompress without knowledge of the codec and transform used to compress
+func TestDecompress() {
+	fmt.Printf("\nDecompress test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	entropies := []string{"None", "Huffman", "ANS", "Range", "PAQ", "FPAQ", "CM"}
+	transforms := []string{"None", "BWT", "BWT+MTF", "BWTS", "BWTS+MTF", "RLT", "LZ4"}
+
+	for ii := 0; ii < 10; ii++ {
+		size := 1 + rnd.Intn(200000)
+		data := generateMixedData(size, rnd)
+		entropy := entropies[rnd.Intn(len(entropies))]
+		transform := transforms[rnd.Intn(len(transforms))]
+		fmt.Printf("Test %v: size=%v entropy=%v transform=%v\n", ii, size, entropy, transform)
+		compressed, err := kio.Compress(data, entropy, transform, 32768)
+
+		if err != nil {
+			fmt.Printf("Compression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		decompressed, err := kio.Decompress(compressed)
+
+		if err != nil {
+			fmt.Printf("Decompression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(data, decompressed) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical (%v => %v)\n", size, len(compressed))
+	}
+
+	// The codecs are read from the stream header
+	data := generateMixedData(100000, rnd)
+
+	for _, entropy := range []string{"Range", "Huffman", "ANS"} {
+		compressed, _ := kio.Compress(data, entropy, "BWT+MTF", 32768)
+		decompressed, err := kio.Decompress(compressed)
+
+		if err != nil || bytes.Equal(data, decompressed) == false {
+			fmt.Printf("Different (%v): %v\n", entropy, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-7v: Identical (%v => %v)\n", entropy, len(data), len(compressed))
+	}
+
+	if _, err := kio.Decompress([]byte("Not a kanzi stream")); errors.Is(err, kio.ErrInvalidStreamType) == false {
+		fmt.Printf("Invalid stream not detected: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Truncated streams must fail, not return partial data or hang
+	compressed, _ := kio.Compress(data, "Range", "BWT+MTF", 32768)
+
+	for _, n := range []int{len(compressed) - 1, len(compressed) / 2, 20, 5} {
+		done := make(chan error, 1)
+
+		go func(n int) {
+			_, err := kio.Decompress(compressed[0:n])
+			done <- err
+		}(n)
+
+		select {
+		case err := <-done:
+			if err == nil {
+				fmt.Printf("Truncated stream (%v of %v bytes) not detected\n", n, len(compressed))
+				os.Exit(1)
+			}
+
+			fmt.Printf("Truncated stream (%v of %v bytes): %v\n", n, len(compressed), err)
+
+		case <-time.After(10 * time.Second):
+			fmt.Printf("Truncated stream (%v of %v bytes): no result after 10 s\n", n, len(compressed))
+			os.Exit(1)
+		}
+	}
+}
+
+func TestContentSplit() {
+	fmt.Printf("\nContent split test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data := generateMixedData(1024*1024, rnd)
+	fixed, err1 := compress(data, "Huffman", "BWT+MTF", 65536, false, 1)
+	split, err2 := compress(data, "Huffman", "BWT+MTF", 65536, true, 1)
+
+	if err1 != nil || err2 != nil {
+		fmt.Printf("Compression error: %v %v\n", err1, err2)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Fixed blocks: %v => %v\n", len(data), len(fixed))
+	fmt.Printf("Split blocks: %v => %v\n", len(data), len(split))
+
+	if len(split) >= len(fixed) {
+		fmt.Printf("Content split did not improve compression\n")
+		os.Exit(1)
+	}
+
+	decompressed, err := decompress(split, len(data), 2)
+
+	if err != nil || bytes.Equal(data, decompressed) == false {
+		fmt.Printf("Failed to decompress split blocks: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+func TestMemoryLimit() {
+	fmt.Printf("\nMemory limit test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data := generateMixedData(300000, rnd)
+	compressed, err := compress(data, "CM", "BWT+MTF", 65536, false, 1)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	decompressed, peak, err := decompressWithLimit(compressed, len(data), 2, 0)
+
+	if err != nil || bytes.Equal(data, decompressed) == false {
+		fmt.Printf("Failed to decompress: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Peak memory with 2 jobs: %v\n", peak)
+
+	// Limit equal to the peak: success
+	if _, _, err = decompressWithLimit(compressed, len(data), 2, peak); err != nil {
+		fmt.Printf("Unexpected failure with limit %v: %v\n", peak, err)
+		os.Exit(1)
+	}
+
+	// Limit below the peak: rejected before any block is decoded
+	_, _, err = decompressWithLimit(compressed, len(data), 2, peak-1)
+
+	if err == nil {
+		fmt.Printf("Memory limit %v not enforced\n", peak-1)
+		os.Exit(1)
+	}
+
+	if ioerr, ok := err.(*kio.IOError); ok == false || ioerr.ErrorCode() != kio.ERR_MEMORY_LIMIT {
+		fmt.Printf("Unexpected error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Rejected: %v\n", err)
+}
+
+func compressWithHash(data []byte, entropy, transform string, blockSize uint, jobs uint) []byte {
+	buffer := make([]byte, 2*len(data)+1024)
+	bos, _ := util.NewByteArrayOutputStream(buffer, false)
+	cos, err := kio.NewCompressedOutputStream(entropy, transform, bos, blockSize, false, nil, jobs)
+
+	if err == nil {
+		cos.SetContentHash(true)
+
+		if _, err = cos.Write(data); err == nil {
+			err = cos.Close()
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return buffer[0:cos.GetWritten()]
+}
+
+func readContentHash(data []byte) []byte {
+	hash, err := kio.ReadContentHash(strings.NewReader(string(data)))
+
+	if err != nil {
+		fmt.Printf("Cannot read content hash: %v\n", err)
+		os.Exit(1)
+	}
+
+	return hash
+}
+
+func TestContentHash() {
+	fmt.Printf("\nContent hash test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data1 := generateMixedData(200000, rnd)
+	data2 := make([]byte, len(data1))
+	copy(data2, data1)
+	data2[len(data2)/2] ^= 1
+
+	// Same input with different compression parameters
+	c1 := compressWithHash(data1, "Huffman", "BWT+MTF", 65536, 1)
+	c2 := compressWithHash(data1, "ANS", "LZ4", 32768, 2)
+	c3 := compressWithHash(data2, "Huffman", "BWT+MTF", 65536, 1)
+	h1 := readContentHash(c1)
+	h2 := readContentHash(c2)
+	h3 := readContentHash(c3)
+	fmt.Printf("Hash 1: %x\n", h1)
+	fmt.Printf("Hash 2: %x\n", h2)
+	fmt.Printf("Hash 3: %x\n", h3)
+
+	if h1 == nil || bytes.Equal(h1, h2) == false {
+		fmt.Printf("Identical inputs have different content hashes\n")
+		os.Exit(1)
+	}
+
+	if bytes.Equal(h1, h3) == true {
+		fmt.Printf("Different inputs have identical content hashes\n")
+		os.Exit(1)
+	}
+
+	// No content hash
+	if readContentHash(compressWithoutHash(data1)) != nil {
+		fmt.Printf("Unexpected content hash\n")
+		os.Exit(1)
+	}
+
+	// The decoder reads the content hash after the last block
+	is, _ := util.NewByteArrayInputStream(c2, true)
+	cis, _ := kio.NewCompressedInputStream(is, nil, 2)
+	buf := make([]byte, len(data1)+1)
+	read := 0
+
+	for {
+		n, err := cis.Read(buf[read:])
+
+		if err != nil {
+			fmt.Printf("Decompression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if n <= 0 {
+			break
+		}
+
+		read += n
+	}
+
+	if bytes.Equal(buf[0:read], data1) == false || bytes.Equal(cis.ContentHash(), h2) == false {
+		fmt.Printf("Failed to decompress stream with content hash (%v bytes, hash %x)\n", read, cis.ContentHash())
+		os.Exit(1)
+	}
+
+	cis.Close()
+	fmt.Printf("Identical\n")
+}
+
+func compressWithoutHash(data []byte) []byte {
+	res, err := compress(data, "Huffman", "None", 65536, false, 1)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return res
+}
+
+func TestDecodePrefix() {
+	fmt.Printf("\nDecode prefix test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	data := generateMixedData(1024*1024, rnd)
+	compressed, err := compress(data, "Huffman", "BWT+MTF", 65536, false, 1)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for ii := 0; ii < 5; ii++ {
+		n := rnd.Intn(len(data) / 2)
+		is, _ := util.NewByteArrayInputStream(compressed, true)
+		cis, _ := kio.NewCompressedInputStream(is, nil, 1)
+		prefix, err := cis.DecodePrefix(n)
+
+		if err != nil {
+			fmt.Printf("Decompression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Prefix of %v bytes: read %v of %v compressed bytes\n", n, cis.GetRead(), len(compressed))
+
+		if bytes.Equal(prefix, data[0:n]) == false {
+			fmt.Printf("Prefix does not match the original data\n")
+			os.Exit(1)
+		}
+
+		if cis.GetRead() >= uint64(len(compressed)) {
+			fmt.Printf("The whole stream was decoded\n")
+			os.Exit(1)
+		}
+
+		// Continue to the end (and past it)
+		rest, err := cis.DecodePrefix(len(data))
+
+		if err != nil || bytes.Equal(rest, data[n:]) == false {
+			fmt.Printf("Failed to resume decoding after prefix: %v\n", err)
+			os.Exit(1)
+		}
+
+		cis.Close()
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Input stream reporting its end (unlike a zero padded ByteArrayInputStream)
+type finiteInputStream struct {
+	reader *bytes.Reader
+}
+
+func (this *finiteInputStream) Read(b []byte) (int, error) {
+	n, _ := this.reader.Read(b)
+	return n, nil
+}
+
+func (this *finiteInputStream) Close() error {
+	return nil
+}
+
+func decompressWithTrailingMode(data []byte, size int, mode int) ([]byte, error) {
+	cis, err := kio.NewCompressedInputStream(&finiteInputStream{reader: bytes.NewReader(data)}, nil, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cis.SetTrailingMode(mode)
+	res := make([]byte, size+1)
+	read := 0
+
+	for {
+		n, err := cis.Read(res[read:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n <= 0 {
+			break
+		}
+
+		read += n
+	}
+
+	cis.Close()
+	return res[0:read], nil
+}
+
+func TestTrailingData() {
+	fmt.Printf("\nTrailing data test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	modes := []int{kio.TRAILING_IGNORE, kio.TRAILING_PADDING, kio.TRAILING_STRICT}
+	names := []string{"ignore", "padding", "strict"}
+
+	for _, hash := range []bool{false, true} {
+		var data, compressed []byte
+		corrupted := []byte(nil)
+
+		// Find a stream with padding bits after the end block (no padding
+		// after the content hash)
+		for tries := 0; tries < 50; tries++ {
+			data = generateMixedData(1000+rnd.Intn(100000), rnd)
+
+			if hash == true {
+				compressed = compressWithHash(data, "Huffman", "BWT+MTF", 65536, 1)
+				break
+			}
+
+			compressed, _ = compress(data, "Huffman", "BWT+MTF", 65536, false, 1)
+			corrupted = append([]byte{}, compressed...)
+			corrupted[len(corrupted)-1] |= 1
+
+			if res, err := decompressWithTrailingMode(corrupted, len(data), kio.TRAILING_IGNORE); err == nil && bytes.Equal(res, data) {
+				break
+			}
+
+			corrupted = nil
+		}
+
+		extra := append(append([]byte{}, compressed...), 1, 2, 3)
+		streams := [][]byte{compressed, extra, corrupted}
+		labels := []string{"clean", "extra bytes", "bad padding"}
+
+		// Expected success for each (stream, mode)
+		expected := [][]bool{{true, true, true}, {true, true, false}, {true, false, false}}
+
+		for i, stream := range streams {
+			if stream == nil {
+				continue
+			}
+
+			for j, mode := range modes {
+				res, err := decompressWithTrailingMode(stream, len(data), mode)
+				ok := err == nil && bytes.Equal(res, data)
+				fmt.Printf("hash=%-5v %-11v %-7v: %v\n", hash, labels[i], names[j], err)
+
+				if ok != expected[i][j] {
+					fmt.Printf("Unexpected result\n")
+					os.Exit(1)
+				}
+			}
+		}
+	}
+
+	cis, _ := kio.NewCompressedInputStream(&finiteInputStream{reader: bytes.NewReader(nil)}, nil, 1)
+
+	if cis.SetTrailingMode(3) == true {
+		fmt.Printf("Invalid trailing mode not detected\n")
+		os.Exit(1)
+	}
+}
+
+func compressWithRawCheck(data []byte, codec, transform string, blockSize uint, jobs uint) ([]byte, int64) {
+	buffer := make([]byte, 2*len(data)+1024)
+	bos, _ := util.NewByteArrayOutputStream(buffer, false)
+	cos, err := kio.NewCompressedOutputStream(codec, transform, bos, blockSize, true, nil, jobs)
+	before := time.Now()
+
+	if err == nil {
+		cos.SetIncompressibleCheck(true)
+
+		if _, err = cos.Write(data); err == nil {
+			err = cos.Close()
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return buffer[0:cos.GetWritten()], time.Now().Sub(before).Nanoseconds()
+}
+
+func TestIncompressible() {
+	fmt.Printf("\nIncompressible data test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	random := make([]byte, 1024*1024)
+
+	for i := range random {
+		random[i] = byte(rnd.Intn(256))
+	}
+
+	text := generateMixedData(len(random), rnd)
+
+	for _, codec := range []string{"Huffman", "ANS", "Range", "FPAQ", "CM"} {
+		if entropy.CheckCompressible(random, entropy.GetEntropyCodecType(codec)) != entropy.ErrIncompressible {
+			fmt.Printf("%v: random data not detected as incompressible\n", codec)
+			os.Exit(1)
+		}
+
+		if entropy.CheckCompressible(text, entropy.GetEntropyCodecType(codec)) != nil {
+			fmt.Printf("%v: compressible data detected as incompressible\n", codec)
+			os.Exit(1)
+		}
+
+		for _, jobs := range []uint{1, 4} {
+			// Random data: stored raw (block headers and checksums only)
+			before := time.Now()
+			coded1, err := compress(random, codec, "None", 262144, false, jobs)
+			delta1 := time.Now().Sub(before).Nanoseconds()
+			coded2, delta2 := compressWithRawCheck(random, codec, "None", 262144, jobs)
+
+			if err != nil {
+				fmt.Printf("Compression error: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("%-8v jobs=%v random: %v => %v bytes (%v ms), raw check: %v bytes (%v ms)\n",
+				codec, jobs, len(random), len(coded1), delta1/1000000, len(coded2), delta2/1000000)
+
+			if len(coded2) > len(random)+64 {
+				fmt.Printf("Incompressible blocks not stored raw\n")
+				os.Exit(1)
+			}
+
+			if res, err := decompress(coded2, len(random), jobs); err != nil || bytes.Equal(res, random) == false {
+				fmt.Printf("Different (random): %v\n", err)
+				os.Exit(1)
+			}
+
+			// Compressible data: the check does not change the output
+			coded1, _ = compress(text, codec, "BWT+MTF", 262144, false, jobs)
+			coded2, _ = compressWithRawCheck(text, codec, "BWT+MTF", 262144, jobs)
+
+			if bytes.Equal(coded1, coded2) == false {
+				fmt.Printf("Compressible blocks stored raw\n")
+				os.Exit(1)
+			}
+
+			if res, err := decompress(coded2, len(text), jobs); err != nil || bytes.Equal(res, text) == false {
+				fmt.Printf("Different (text): %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+func compressWithStoredFallback(data []byte, codec, transform string, blockSize uint, jobs uint, verify bool) []byte {
+	buffer := make([]byte, 2*len(data)+1024)
+	bos, _ := util.NewByteArrayOutputStream(buffer, false)
+	cos, err := kio.NewCompressedOutputStream(codec, transform, bos, blockSize, true, nil, jobs)
+
+	if err == nil {
+		cos.SetStoredFallback(true)
+		cos.SetVerify(verify)
+
+		if _, err = cos.Write(data); err == nil {
+			err = cos.Close()
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return buffer[0:cos.GetWritten()]
+}
+
+func TestStoredFallback() {
+	fmt.Printf("\nStored fallback test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	random := make([]byte, 1024*1024)
+	rnd.Read(random)
+
+	// Half random, half compressible blocks
+	mixed := make([]byte, len(random))
+	copy(mixed, random)
+	text := generateMixedData(len(mixed)/2, rnd)
+	copy(mixed[len(mixed)/2:], text)
+
+	// Header and 4 blocks (mode, length, checksum) of 256 KB
+	maxOverhead := 64
+
+	for _, codec := range []string{"Huffman", "ANS", "Range", "FPAQ"} {
+		for _, jobs := range []uint{1, 4} {
+			coded1, err := compress(random, codec, "None", 262144, false, jobs)
+			coded2 := compressWithStoredFallback(random, codec, "None", 262144, jobs, false)
+
+			if err != nil {
+				fmt.Printf("Compression error: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("%-8v jobs=%v random: %v => %v bytes, stored fallback: %v bytes\n",
+				codec, jobs, len(random), len(coded1), len(coded2))
+
+			// Without fallback, entropy coding expands random data
+			if len(coded1) <= len(random)+maxOverhead || len(coded2) > len(random)+maxOverhead {
+				fmt.Printf("Expanded blocks not stored raw\n")
+				os.Exit(1)
+			}
+
+			if res, err := decompress(coded2, len(random), jobs); err != nil || bytes.Equal(res, random) == false {
+				fmt.Printf("Different (random): %v\n", err)
+				os.Exit(1)
+			}
+
+			// Only the random blocks are stored raw
+			coded3 := compressWithStoredFallback(mixed, codec, "None", 262144, jobs, jobs > 1)
+			textOnly, _ := compress(text, codec, "None", 262144, false, jobs)
+
+			if len(coded3) > len(random)/2+len(textOnly)+maxOverhead {
+				fmt.Printf("Compressible blocks stored raw: %v bytes\n", len(coded3))
+				os.Exit(1)
+			}
+
+			if res, err := decompress(coded3, len(mixed), jobs); err != nil || bytes.Equal(res, mixed) == false {
+				fmt.Printf("Different (mixed): %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+func writeAndClose(cos *kio.CompressedOutputStream, data, buffer []byte) []byte {
+	if _, err := cos.Write(data); err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := cos.Close(); err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return buffer[0:cos.GetWritten()]
+}
+
+func newResetTestStream(bos *util.ByteArrayOutputStream) *kio.CompressedOutputStream {
+	cos, err := kio.NewCompressedOutputStream("Huffman", "BWT+MTF", bos, 65536, true, nil, 2)
+
+	if err != nil {
+		fmt.Printf("Cannot create compressed stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	cos.SetContentHash(true)
+	return cos
+}
+
+// One writer and one reader reused (Reset) across independent streams
+func TestReset() {
+	fmt.Printf("\nReset test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var cos *kio.CompressedOutputStream
+	var cis *kio.CompressedInputStream
+
+	for ii := 0; ii < 6; ii++ {
+		size := rnd.Intn(300000)
+
+		if ii == 1 {
+			size = 0
+		}
+
+		data := generateMixedData(size, rnd)
+
+		// Reference: new stream
+		buffer1 := make([]byte, 2*size+1024)
+		bos1, _ := util.NewByteArrayOutputStream(buffer1, false)
+		expected := writeAndClose(newResetTestStream(bos1), data, buffer1)
+
+		// Reused stream
+		buffer2 := make([]byte, 2*size+1024)
+		bos2, _ := util.NewByteArrayOutputStream(buffer2, false)
+
+		if cos == nil {
+			cos = newResetTestStream(bos2)
+		} else {
+			if ii == 3 {
+				// Discard a partially written stream
+				cos.Reset(&memoryOutputStream{})
+				cos.Write(data[0 : size/2])
+			}
+
+			if err := cos.Reset(bos2); err != nil {
+				fmt.Printf("Reset error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		compressed := writeAndClose(cos, data, buffer2)
+		fmt.Printf("Stream %v: %v => %v bytes ", ii, size, len(compressed))
+
+		if bytes.Equal(expected, compressed) == false {
+			fmt.Printf("\nThe reset stream differs from a new stream\n")
+			os.Exit(1)
+		}
+
+		is := &finiteInputStream{reader: bytes.NewReader(compressed)}
+
+		if cis == nil {
+			cis, _ = kio.NewCompressedInputStream(is, nil, 2)
+		} else if err := cis.Reset(is); err != nil {
+			fmt.Printf("Reset error: %v\n", err)
+			os.Exit(1)
+		}
+
+		res := make([]byte, size+1)
+		read := 0
+
+		for {
+			n, err := cis.Read(res[read:])
+
+			if err != nil {
+				fmt.Printf("\nDecompression error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if n <= 0 {
+				break
+			}
+
+			read += n
+		}
+
+		cis.Close()
+		hash := readContentHash(compressed)
+
+		if bytes.Equal(data, res[0:read]) == false || bytes.Equal(hash, cis.ContentHash()) == false {
+			fmt.Printf("\nDifferent\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	if cos.Reset(nil) == nil || cis.Reset(nil) == nil {
+		fmt.Printf("Invalid null stream not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Record the compressed size of each block
+type blockSizeRecorder struct {
+	sizes map[int]int
+}
+
+func (this *blockSizeRecorder) ProcessEvent(evt *kio.BlockEvent) {
+	if evt.EventType() == kio.EVT_AFTER_ENTROPY {
+		this.sizes[evt.BlockId()] = evt.BlockSize()
+	}
+}
+
+func compressWithChecksum(data []byte, mode int, listener kio.BlockListener) []byte {
+	buffer := make([]byte, 2*len(data)+1024)
+	bos, _ := util.NewByteArrayOutputStream(buffer, false)
+	cos, err := kio.NewCompressedOutputStream("None", "None", bos, 16384, false, nil, 1)
+
+	if err != nil {
+		fmt.Printf("Cannot create compressed stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cos.SetChecksumMode(mode) == false {
+		fmt.Printf("Cannot set checksum mode %v\n", mode)
+		os.Exit(1)
+	}
+
+	if listener != nil {
+		cos.AddListener(listener)
+	}
+
+	if _, err = cos.Write(data); err == nil {
+		err = cos.Close()
+	}
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return buffer[0:cos.GetWritten()]
+}
+
+// Decompress the whole stream (small reads), return the decoded data, the
+// stream and the first error
+func decompressWithChecksum(data []byte, jobs uint) ([]byte, *kio.CompressedInputStream, error) {
+	is, _ := util.NewByteArrayInputStream(data, true)
+	cis, _ := kio.NewCompressedInputStream(is, nil, jobs)
+	res := make([]byte, 0)
+	buf := make([]byte, 1024)
+
+	for {
+		n, err := cis.Read(buf)
+
+		if err != nil {
+			return res, cis, err
+		}
+
+		if n <= 0 {
+			break
+		}
+
+		res = append(res, buf[0:n]...)
+	}
+
+	cis.Close()
+	return res, cis, nil
+}
+
+// Block and stream checksums: round trip, detection of a corrupted block,
+// localization with block checksums
+func TestChecksumMode() {
+	fmt.Printf("\nChecksum mode test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data := generateMixedData(200000, rnd)
+	modes := []int{kio.CHECKSUM_NONE, kio.CHECKSUM_BLOCK, kio.CHECKSUM_STREAM, kio.CHECKSUM_BLOCK | kio.CHECKSUM_STREAM}
+	names := []string{"none", "block", "stream", "block+stream"}
+
+	for i, mode := range modes {
+		compressed := compressWithChecksum(data, mode, nil)
+
+		for jobs := uint(1); jobs <= 3; jobs += 2 {
+			res, cis, err := decompressWithChecksum(compressed, jobs)
+
+			if err != nil || bytes.Equal(res, data) == false || cis.ChecksumMode() != mode {
+				fmt.Printf("Failure for checksum mode %v: %v\n", names[i], err)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("Checksum %-12s: %v => %v bytes Identical\n", names[i], len(data), len(compressed))
+	}
+
+	// Corrupt one byte in the middle of a block (raw coding, block offsets
+	// from the compressed block sizes)
+	const corrupted = 7
+	recorder := &blockSizeRecorder{sizes: make(map[int]int)}
+	blockMode := compressWithChecksum(data, kio.CHECKSUM_BLOCK, recorder)
+	offset := 10 // header
+
+	for id := 1; id < corrupted; id++ {
+		offset += recorder.sizes[id]
+	}
+
+	offset += recorder.sizes[corrupted] / 2
+	streamMode := compressWithChecksum(data, kio.CHECKSUM_STREAM, nil)
+	blockMode[offset] ^= 0x10
+	streamMode[offset] ^= 0x10
+
+	// Block checksums: the corrupted block is reported, the previous blocks
+	// are decoded
+	for jobs := uint(1); jobs <= 3; jobs += 2 {
+		res, cis, err := decompressWithChecksum(blockMode, jobs)
+
+		if errors.Is(err, kio.ErrChecksumMismatch) == false || cis.CorruptedBlock() != corrupted {
+			fmt.Printf("Corrupted block not found (found %v, expected %v): %v\n", cis.CorruptedBlock(), corrupted, err)
+			os.Exit(1)
+		}
+
+		if len(res) < (corrupted-1-int(jobs))*16384 || bytes.Equal(res, data[0:len(res)]) == false {
+			fmt.Printf("Blocks before the corrupted block not decoded (%v bytes)\n", len(res))
+			os.Exit(1)
+		}
+
+		fmt.Printf("Block checksum, %v job(s): corruption found in block %v (%v)\n", jobs, cis.CorruptedBlock(), err)
+	}
+
+	// Stream checksum: the corruption is detected at the end of the stream
+	// but not localized
+	_, cis, err := decompressWithChecksum(streamMode, 1)
+
+	if errors.Is(err, kio.ErrChecksumMismatch) == false || cis.CorruptedBlock() != 0 {
+		fmt.Printf("Corruption not detected with stream checksum: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Stream checksum: corruption found at the end of the stream (%v)\n", err)
+	fmt.Printf("Success\n")
+}
+
+// Cancel the context once a given block has been encoded
+type cancelListener struct {
+	blockId int
+	blocks  int
+	cancel  context.CancelFunc
+}
+
+func (this *cancelListener) ProcessEvent(evt *kio.BlockEvent) {
+	if evt.EventType() == kio.EVT_AFTER_ENTROPY {
+		this.blocks++
+
+		if evt.BlockId() == this.blockId {
+			this.cancel()
+		}
+	}
+}
+
+// Cancellation of compression and decompression through a context
+func TestCancel() {
+	fmt.Printf("\nCancel test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data := generateMixedData(4*1024*1024, rnd)
+
+	// Already cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := kio.CompressContext(ctx, data, "Huffman", "None", 65536); err != context.Canceled {
+		fmt.Printf("Cancelled compression not detected: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Cancelled after the third block: no more block is encoded
+	ctx, cancel = context.WithCancel(context.Background())
+	listener := &cancelListener{blockId: 3, cancel: cancel}
+	bos, _ := util.NewByteArrayOutputStream(make([]byte, len(data)), false)
+	cos, _ := kio.NewCompressedOutputStream("Huffman", "None", bos, 65536, false, nil, 1)
+	cos.SetContext(ctx)
+	cos.AddListener(listener)
+	_, err := cos.Write(data)
+
+	if err != context.Canceled || listener.blocks != 3 {
+		fmt.Printf("Compression not stopped at the block boundary: %v (%v blocks)\n", err, listener.blocks)
+		os.Exit(1)
+	}
+
+	if err = cos.Close(); err != context.Canceled {
+		fmt.Printf("Unexpected close result: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Compression stopped after block %v\n", listener.blocks)
+
+	// Deadline during a slow compression
+	before := time.Now()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = kio.CompressContext(ctx, data, "PAQ", "BWT+MTF", 65536)
+	cancel()
+	elapsed := time.Now().Sub(before)
+
+	if err != context.DeadlineExceeded || elapsed > 2*time.Second {
+		fmt.Printf("Compression not interrupted: %v after %v\n", err, elapsed)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Compression interrupted after %v ms\n", elapsed.Nanoseconds()/1000000)
+
+	// Cancelled during decompression
+	compressed, err := kio.Compress(data, "PAQ", "None", 65536)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	before = time.Now()
+	ctx, cancel = context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = kio.DecompressContext(ctx, compressed)
+	elapsed = time.Now().Sub(before)
+
+	if err != context.Canceled || elapsed > 2*time.Second {
+		fmt.Printf("Decompression not interrupted: %v after %v\n", err, elapsed)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Decompression interrupted after %v ms\n", elapsed.Nanoseconds()/1000000)
+
+	// Not cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	compressed, err = kio.CompressContext(ctx, data[0:300000], "Huffman", "BWT+MTF", 65536)
+
+	if err == nil {
+		var res []byte
+
+		if res, err = kio.DecompressContext(ctx, compressed); err == nil && bytes.Equal(res, data[0:300000]) == false {
+			err = fmt.Errorf("Different")
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Round trip with context failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// In memory output stream
+type memoryOutputStream struct {
+	buffer bytes.Buffer
+}
+
+func (this *memoryOutputStream) Write(b []byte) (int, error) {
+	return this.buffer.Write(b)
+}
+
+func (this *memoryOutputStream) Close() error {
+	return nil
+}
+
+// Write the data in chunks, return the stream and the snapshot taken after
+// 'snapshotChunks' chunks
+func writeChunks(cos *kio.CompressedOutputStream, chunks [][]byte, snapshotChunks int) []byte {
+	var snapshot []byte
+
+	for i, chunk := range chunks {
+		if i == snapshotChunks {
+			var err error
+
+			if snapshot, err = cos.Snapshot(); err != nil {
+				fmt.Printf("Snapshot error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if _, err := cos.Write(chunk); err != nil {
+			fmt.Printf("Compression error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := cos.Close(); err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return snapshot
+}
+
+// Snapshot in the middle of a stream, restore into a new stream and compare
+// the output with the output of the uninterrupted stream
+func TestSnapshot() {
+	fmt.Printf("\nSnapshot test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data := generateMixedData(1000000, rnd)
+
+	for ii := 0; ii < 4; ii++ {
+		// Random chunks
+		chunks := make([][]byte, 0)
+
+		for n := 0; n < len(data); {
+			length := 1 + rnd.Intn(100000)
+
+			if n+length > len(data) {
+				length = len(data) - n
+			}
+
+			chunks = append(chunks, data[n:n+length])
+			n += length
+		}
+
+		snapshotChunks := 1 + rnd.Intn(len(chunks)-1)
+		jobs := uint(1 + ii)
+		entropy := []string{"Huffman", "ANS", "Range", "FPAQ"}[ii]
+
+		// Uninterrupted stream
+		os1 := &memoryOutputStream{}
+		cos1, _ := kio.NewCompressedOutputStream(entropy, "BWT+MTF", os1, 65536, ii&1 == 0, nil, jobs)
+		cos1.SetContentSplit(ii >= 2)
+		cos1.SetContentHash(true)
+
+		if ii&1 == 1 {
+			cos1.SetChecksumMode(kio.CHECKSUM_STREAM)
+		}
+
+		snapshot := writeChunks(cos1, chunks, snapshotChunks)
+		expected := os1.buffer.Bytes()
+		offset, err := kio.SnapshotOffset(snapshot)
+
+		if err != nil {
+			fmt.Printf("Invalid snapshot: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Resume after the snapshot in a new stream with other parameters,
+		// the output starts with the bytes written before the snapshot
+		os2 := &memoryOutputStream{}
+		os2.buffer.Write(expected[0:offset])
+		cos2, _ := kio.NewCompressedOutputStream("None", "None", os2, 1024, false, nil, 1)
+
+		if err := cos2.Restore(snapshot); err != nil {
+			fmt.Printf("Restore error: %v\n", err)
+			os.Exit(1)
+		}
+
+		writeChunks(cos2, chunks[snapshotChunks:], -1)
+		fmt.Printf("Test %v: entropy=%v jobs=%v snapshot after %v/%v chunks (%v bytes, offset %v): ",
+			ii, entropy, jobs, snapshotChunks, len(chunks), len(snapshot), offset)
+
+		if bytes.Equal(os2.buffer.Bytes(), expected) == false {
+			fmt.Printf("Different output\n")
+			os.Exit(1)
+		}
+
+		res, _, err := decompressWithChecksum(expected, 2)
+
+		if err != nil || bytes.Equal(res, data) == false {
+			fmt.Printf("Failed to decompress: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+
+		// Corrupted snapshot
+		snapshot[len(snapshot)/2] ^= 1
+		cos3, _ := kio.NewCompressedOutputStream("None", "None", &memoryOutputStream{}, 1024, false, nil, 1)
+
+		if err := cos3.Restore(snapshot); err == nil {
+			fmt.Printf("Corrupted snapshot not detected\n")
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func compressVerified(data []byte, entropy, transform string, verify bool,
+	fault func(blockId int, encoded []byte)) ([]byte, error) {
+	os := &memoryOutputStream{}
+	cos, err := kio.NewCompressedOutputStream(entropy, transform, os, 65536, true, nil, 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cos.SetVerify(verify)
+	cos.SetEncodeFault(fault)
+
+	if _, err = cos.Write(data); err != nil {
+		cos.Close()
+		return nil, err
+	}
+
+	if err = cos.Close(); err != nil {
+		return nil, err
+	}
+
+	return os.buffer.Bytes(), nil
+}
+
+// Verified encoding: same output as the regular encoding, a corrupted encoded
+// block is detected
+func TestVerify() {
+	fmt.Printf("\nVerify test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data := generateMixedData(500000, rnd)
+	entropies := []string{"None", "Huffman", "ANS", "Range", "FPAQ", "CM"}
+	transforms := []string{"None", "BWT+MTF", "LZ4", "RLT"}
+
+	for ii := range entropies {
+		entropy := entropies[ii]
+		transform := transforms[ii%len(transforms)]
+		expected, err1 := compressVerified(data, entropy, transform, false, nil)
+		verified, err2 := compressVerified(data, entropy, transform, true, nil)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Compression error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v %-8v: %v => %v bytes ", entropy, transform, len(data), len(verified))
+
+		if bytes.Equal(expected, verified) == false {
+			fmt.Printf("\nThe verified output differs from the regular output\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	// Corrupt the encoded data of one block
+	for _, entropy := range []string{"None", "Huffman", "FPAQ"} {
+		fault := func(blockId int, encoded []byte) {
+			if blockId == 3 {
+				encoded[len(encoded)/2] ^= 0x5A
+			}
+		}
+
+		_, err := compressVerified(data, entropy, "BWT+MTF", true, fault)
+
+		if err == nil || strings.Contains(err.Error(), "block 3") == false {
+			fmt.Printf("Corrupted encoding not detected: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: %v\n", entropy, err)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Set the version in a copy of the stream header (7 bits in byte 4)
+func setVersion(stream []byte, version byte) []byte {
+	res := append([]byte{}, stream...)
+	res[4] = (version << 1) | (res[4] & 1)
+	return res
+}
+
+func TestVersion() {
+	fmt.Printf("\nVersion test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data := generateMixedData(200000, rnd)
+	compressed, err := kio.Compress(data, "Huffman", "BWT+MTF", 65536)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The header extension (16 bits size) follows the 80 bits of fixed header
+	const extIdx = kio.BITSTREAM_HEADER_SIZE
+
+	if compressed[extIdx] != 0 || compressed[extIdx+1] != 0 {
+		fmt.Printf("Unexpected header extension: %v\n", compressed[extIdx:extIdx+2])
+		os.Exit(1)
+	}
+
+	// Unknown fields (tag, length, data) must be skipped
+	fields := []byte{0x7F, 3, 1, 2, 3, 0x42, 0, 0x10, 2, 7, 7}
+	extended := append(append([]byte{}, compressed[0:extIdx]...), 0, byte(len(fields)))
+	extended = append(extended, fields...)
+	extended = append(extended, compressed[extIdx+2:]...)
+
+	// Version 0: no header extension
+	old := setVersion(compressed, 0)
+	old = append(old[0:extIdx], compressed[extIdx+2:]...)
+
+	streams := [][]byte{compressed, extended, old}
+	labels := []string{"current", "unknown fields", "version 0"}
+
+	for i, stream := range streams {
+		res, err := kio.Decompress(stream)
+		fmt.Printf("%-14v: %v\n", labels[i], err)
+
+		if err != nil || bytes.Equal(res, data) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+	}
+
+	// Truncated header extension field
+	invalid := append([]byte{}, extended...)
+	invalid[extIdx+1] = 4
+
+	if _, err := kio.Decompress(invalid); err == nil {
+		fmt.Printf("Invalid header extension not detected\n")
+		os.Exit(1)
+	} else {
+		fmt.Printf("%-14v: %v\n", "bad extension", err)
+	}
+
+	// Newer version
+	newer := setVersion(compressed, kio.BITSTREAM_FORMAT_VERSION+1)
+	_, err = kio.Decompress(newer)
+	fmt.Printf("%-14v: %v\n", "newer version", err)
+	required := fmt.Sprintf("version %d required", kio.BITSTREAM_FORMAT_VERSION+1)
+
+	if errors.Is(err, kio.ErrUnsupportedVersion) == false || strings.Contains(err.Error(), required) == false {
+		fmt.Printf("Unsupported version not reported\n")
+		os.Exit(1)
+	}
+
+	if ioerr, ok := err.(*kio.IOError); ok == false || ioerr.ErrorCode() != kio.ERR_STREAM_VERSION {
+		fmt.Printf("Unexpected error code\n")
+		os.Exit(1)
+	}
+
+	if _, err = kio.ReadContentHash(bytes.NewReader(newer)); errors.Is(err, kio.ErrUnsupportedVersion) == false {
+		fmt.Printf("Unsupported version not reported by ReadContentHash: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Compress with all the options affecting the stream layout: block and stream
+// checksums, content hash, raw blocks
+func compressWithOptions(data []byte, entropy, transform string) ([]byte, error) {
+	os := &memoryOutputStream{}
+	cos, err := kio.NewCompressedOutputStream(entropy, transform, os, 65536, true, nil, 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cos.SetChecksumMode(kio.CHECKSUM_BLOCK | kio.CHECKSUM_STREAM)
+	cos.SetContentHash(true)
+	cos.SetIncompressibleCheck(true)
+
+	if _, err = cos.Write(data); err != nil {
+		cos.Close()
+		return nil, err
+	}
+
+	if err = cos.Close(); err != nil {
+		return nil, err
+	}
+
+	return os.buffer.Bytes(), nil
+}
+
+func transcode(data []byte, codec string) ([]byte, error) {
+	var res bytes.Buffer
+	err := kio.Transcode(bytes.NewReader(data), &res, codec)
+	return res.Bytes(), err
+}
+
+// Range => Huffman => Range yields the original stream
+func TestTranscode() {
+	fmt.Printf("\nTranscode test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	data := generateMixedData(300000, rnd)
+
+	// Incompressible region (raw blocks)
+	for i := 100000; i < 170000; i++ {
+		data[i] = byte(rnd.Intn(256))
+	}
+
+	for _, transform := range []string{"None", "BWT+MTF", "LZ4"} {
+		compressed, err := compressWithOptions(data, "Range", transform)
+
+		if err != nil {
+			fmt.Printf("Compression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		huffman, err1 := transcode(compressed, "Huffman")
+		back, err2 := transcode(huffman, "Range")
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Transcoding error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		res1, err1 := decompressWithTrailingMode(huffman, len(data), kio.TRAILING_STRICT)
+		res2, err2 := decompressWithTrailingMode(back, len(data), kio.TRAILING_STRICT)
+		fmt.Printf("%-8v: range=%v bytes, huffman=%v bytes, range=%v bytes\n", transform,
+			len(compressed), len(huffman), len(back))
+
+		if err1 != nil || err2 != nil || bytes.Equal(res1, data) == false || bytes.Equal(res2, data) == false {
+			fmt.Printf("Different: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(huffman, compressed) == true || bytes.Equal(back, compressed) == false {
+			fmt.Printf("Unexpected transcoded stream\n")
+			os.Exit(1)
+		}
+
+		hash1, _ := kio.ReadContentHash(bytes.NewReader(compressed))
+		hash2, _ := kio.ReadContentHash(bytes.NewReader(huffman))
+
+		if hash1 == nil || bytes.Equal(hash1, hash2) == false {
+			fmt.Printf("Different content hash\n")
+			os.Exit(1)
+		}
+	}
+
+	// Invalid codec and invalid stream
+	compressed, _ := kio.Compress(data, "Huffman", "None", 65536)
+
+	if _, err := transcode(compressed, "Foo"); err == nil {
+		fmt.Printf("Invalid codec not detected\n")
+		os.Exit(1)
+	}
+
+	if _, err := transcode(compressed[0:len(compressed)/2], "FPAQ"); err == nil {
+		fmt.Printf("Truncated stream not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Encode blocks with random transform chains to one bitstream, decode them
+// with the chains read from the headers
+func TestDecodeWithTransforms() {
+	fmt.Printf("\nDecodeWithTransforms test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	entropies := []string{"None", "Huffman", "ANS", "Range", "FPAQ", "CM"}
+	transforms := []string{"None", "BWT", "BWT+MTF", "BWTS", "LZ4", "LZ", "RLT", "ZRLT",
+		"LineDedup", "Remap", "Haar", "PredictDelta"}
+	blocks := make([][]byte, 20)
+	headers := make([][]byte, len(blocks))
+	mos := &memoryOutputStream{}
+	obs, _ := bitstream.NewDefaultOutputBitStream(mos, 16384)
+
+	for ii := range blocks {
+		blocks[ii] = generateMixedData(rnd.Intn(50000), rnd)
+		chain := make([]string, 1+rnd.Intn(4))
+
+		for i := range chain {
+			chain[i] = transforms[rnd.Intn(len(transforms))]
+		}
+
+		entropy := entropies[rnd.Intn(len(entropies))]
+		header, err := kio.EncodeWithTransforms(obs, blocks[ii], entropy, chain)
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		headers[ii] = header
+		fmt.Printf("Block %v: size=%v entropy=%v transforms=%v\n", ii, len(blocks[ii]), entropy, chain)
+	}
+
+	obs.Close()
+	encoded := mos.buffer.Bytes()
+	is, _ := util.NewByteArrayInputStream(encoded, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(is, 16384)
+
+	for ii := range blocks {
+		res, err := kio.DecodeWithTransforms(ibs, headers[ii])
+
+		if err != nil {
+			fmt.Printf("Block %v: decoding error: %v\n", ii, err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(res, blocks[ii]) == false {
+			fmt.Printf("Block %v: different\n", ii)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Identical (%v blocks => %v bytes)\n", len(blocks), len(encoded))
+
+	// Invalid transform and codec ids
+	for _, header := range [][]byte{{entropy.RANGE_TYPE, 1, 0x0F}, {0x1F, 1, 1}, {entropy.RANGE_TYPE, 2, 1}} {
+		is, _ := util.NewByteArrayInputStream(encoded, true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(is, 16384)
+
+		if _, err := kio.DecodeWithTransforms(ibs, header); err == nil {
+			fmt.Printf("Invalid header %v not detected\n", header)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Success\n")
+}
@@ -16,12 +16,14 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"kanzi"
 	"kanzi/bitstream"
 	"kanzi/io"
 	"kanzi/util"
+	"math"
 	"math/rand"
 	"os"
 	"time"
@@ -30,6 +32,7 @@ import (
 func main() {
 	testCorrectnessAligned()
 	testCorrectnessMisaligned()
+	testShortReads()
 	testSpeed() // Writes big output.bin file to local dir !!!
 }
 
@@ -49,7 +52,7 @@ func testCorrectnessAligned() {
 			if test < 5 {
 				values[i] = rand.Intn(test*1000 + 100)
 			} else {
-				values[i] = rand.Intn(1 << 31)
+				values[i] = rand.Intn(math.MaxInt32)
 			}
 
 			fmt.Printf("%v ", values[i])
@@ -124,7 +127,7 @@ func testCorrectnessMisaligned() {
 			if test < 5 {
 				values[i] = rand.Intn(test*1000 + 100)
 			} else {
-				values[i] = rand.Intn(1 << 31)
+				values[i] = rand.Intn(math.MaxInt32)
 			}
 
 			mask := (1 << (1 + uint(i&63))) - 1
@@ -187,6 +190,97 @@ func testCorrectnessMisaligned() {
 	}
 }
 
+type bufferOutputStream struct {
+	buffer *bytes.Buffer
+}
+
+func (this *bufferOutputStream) Write(b []byte) (int, error) {
+	return this.buffer.Write(b)
+}
+
+func (this *bufferOutputStream) Close() error {
+	return nil
+}
+
+// Input stream returning at most 3 bytes per read
+type shortInputStream struct {
+	data []byte
+}
+
+func (this *shortInputStream) Read(b []byte) (int, error) {
+	if len(this.data) == 0 {
+		return 0, fmt.Errorf("EOF")
+	}
+
+	n := len(this.data)
+
+	if n > 3 {
+		n = 3
+	}
+
+	n = copy(b, this.data[0:n])
+	this.data = this.data[n:]
+	return n, nil
+}
+
+func (this *shortInputStream) Close() error {
+	return nil
+}
+
+// The input stream may return less than 8 bytes per read (EG. at the end of a
+// file or from a network): the values must be the same and reading past the
+// end of the data must panic
+func testShortReads() {
+	fmt.Printf("\n\nShort reads test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for test := 0; test < 20; test++ {
+		var buffer bytes.Buffer
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+		values := make([]uint64, 1000)
+		sizes := make([]uint, len(values))
+
+		for i := range values {
+			sizes[i] = uint(1 + rnd.Intn(64))
+			values[i] = rnd.Uint64() >> (64 - sizes[i])
+			obs.WriteBits(values[i], sizes[i])
+		}
+
+		obs.Close()
+		ibs, _ := bitstream.NewDefaultInputBitStream(&shortInputStream{data: buffer.Bytes()}, 16384)
+
+		for i := range values {
+			if val := ibs.ReadBits(sizes[i]); val != values[i] {
+				fmt.Printf("Test %v: invalid value %v at index %v (expected %v on %v bits)\n", test, val, i, values[i], sizes[i])
+				os.Exit(1)
+			}
+		}
+
+		// Read the padding of the last byte, then past the end
+		if pad := uint(8*buffer.Len()) - uint(ibs.Read()); pad > 0 {
+			ibs.ReadBits(pad)
+		}
+
+		if readPastEnd(ibs, uint(1+rnd.Intn(64))) == false {
+			fmt.Printf("Test %v: read past the end of the data not detected\n", test)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func readPastEnd(ibs kanzi.InputBitStream, count uint) (res bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = true
+		}
+	}()
+
+	ibs.ReadBits(count)
+	return false
+}
+
 func testWritePostClose(obs kanzi.OutputBitStream) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -0,0 +1,263 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestFrequencyRemap\n")
+	TestCorrectness()
+	TestSmallOutput()
+	TestRatio()
+	TestSpeed()
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		topK := uint(1 + rnd.Intn(8))
+		size := uint(rnd.Intn(64))
+		input := make([]byte, size)
+
+		if ii == 0 {
+			topK = function.MAX_REMAP_SYMBOLS
+		}
+
+		for i := range input {
+			// Skewed: a few symbols are frequent
+			if rnd.Intn(3) == 0 {
+				input[i] = byte(rnd.Intn(256))
+			} else {
+				input[i] = byte(250 + rnd.Intn(6))
+			}
+		}
+
+		fmt.Printf("\nTest %v (top %v symbols, size %v)\n", ii, topK, size)
+		fr, _ := function.NewFrequencyRemap(size, topK)
+		output := make([]byte, fr.MaxEncodedLen(int(size)))
+		reverse := make([]byte, size)
+		_, dstIdx, err := fr.Forward(input, output)
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// The most frequent symbol must be mapped to 0
+		if size > 0 && output[0] > 0 && bytes.Count(input, output[1:2]) != bytes.Count(output[1+output[0]:dstIdx], []byte{0}) {
+			fmt.Printf("Invalid mapping of the most frequent symbol\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Original: %v\n", input)
+		fmt.Printf("Coded:    %v\n", output[0:dstIdx])
+		fr, _ = function.NewFrequencyRemap(dstIdx, topK)
+		_, oIdx, err := fr.Inverse(output, reverse)
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Decoded:  %v\n", reverse[0:oIdx])
+
+		if oIdx != size || bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	if _, err := function.NewFrequencyRemap(0, 0); err == nil {
+		fmt.Printf("Invalid number of symbols not detected\n")
+		os.Exit(1)
+	}
+
+	// Remap table with a duplicate symbol
+	fr, _ := function.NewFrequencyRemap(0, 4)
+
+	if _, _, err := fr.Inverse([]byte{2, 'a', 'a', 0, 1}, make([]byte, 16)); err == nil {
+		fmt.Printf("Invalid remap table not detected\n")
+		os.Exit(1)
+	}
+
+	// Truncated remap table
+	if _, _, err := fr.Inverse([]byte{4, 'a', 'b'}, make([]byte, 16)); err == nil {
+		fmt.Printf("Truncated remap table not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Frequent high symbols in runs (EG. 0xFF padding, text in a high code page)
+// A too small output buffer must be reported with the required size
+func TestSmallOutput() {
+	fmt.Printf("\nSmall output buffer test\n")
+	input := []byte("abracadabra")
+	fr, _ := function.NewFrequencyRemap(uint(len(input)), 3)
+	encoded := make([]byte, fr.MaxEncodedLen(len(input)))
+	_, encodedLen, err := fr.Forward(input, encoded)
+
+	if err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fr, _ = function.NewFrequencyRemap(uint(len(input)), 3)
+	_, _, err = fr.Forward(input, make([]byte, encodedLen-1))
+	e, ok := err.(*function.BufferTooSmallError)
+
+	if ok == false || e.Required() != encodedLen {
+		fmt.Printf("Forward: unexpected error %v (need %v bytes)\n", err, encodedLen)
+		os.Exit(1)
+	}
+
+	fr, _ = function.NewFrequencyRemap(encodedLen, 3)
+	_, _, err = fr.Inverse(encoded, make([]byte, len(input)-1))
+	e, ok = err.(*function.BufferTooSmallError)
+
+	if ok == false || e.Required() != uint(len(input)) {
+		fmt.Printf("Inverse: unexpected error %v (need %v bytes)\n", err, len(input))
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func generateSkewed(size int, rnd *rand.Rand) []byte {
+	res := make([]byte, 0, size)
+
+	for len(res) < size {
+		if rnd.Intn(4) == 0 {
+			for i := rnd.Intn(20); i > 0; i-- {
+				res = append(res, 0xFF)
+			}
+		} else {
+			// Roughly geometric distribution over 0xC0..0xFF
+			s := 0xC0
+
+			for s < 0xFF && rnd.Intn(3) != 0 {
+				s++
+			}
+
+			res = append(res, byte(s))
+		}
+	}
+
+	return res[0:size]
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (skewed data)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateSkewed(500000, rnd)
+	fr, _ := function.NewFrequencyRemap(0, function.DEFAULT_REMAP_SYMBOLS)
+	remapped := make([]byte, fr.MaxEncodedLen(len(input)))
+	_, dstIdx, _ := fr.Forward(input, remapped)
+	remapped = remapped[0:dstIdx]
+
+	// ZRLT only shrinks runs of 0
+	out1 := make([]byte, 2*len(input))
+	out2 := make([]byte, 2*len(input))
+	zrlt, _ := function.NewZRLT(0)
+	_, size1, _ := zrlt.Forward(input, out1)
+	_, size2, _ := zrlt.Forward(remapped, out2)
+	fmt.Printf("ZRLT    : raw=%v bytes, remapped=%v bytes\n", size1, size2)
+
+	if size2 >= size1 {
+		fmt.Printf("No ZRLT improvement with remapping\n")
+		os.Exit(1)
+	}
+
+	for _, codec := range []string{"Huffman", "FPAQ"} {
+		raw, err1 := kio.Compress(out1[0:size1], codec, "None", 1<<20)
+		remap, err2 := kio.Compress(out2[0:size2], codec, "None", 1<<20)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Compression error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: ZRLT=%v bytes, remap+ZRLT=%v bytes\n", codec, len(raw), len(remap))
+
+		if len(remap) >= len(raw) {
+			fmt.Printf("No compression improvement with remapping\n")
+			os.Exit(1)
+		}
+	}
+
+	// Round trip through the stream
+	compressed, err := kio.Compress(input, "Huffman", "Remap", 1<<18)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	res, err := kio.Decompress(compressed)
+
+	if err != nil || bytes.Equal(input, res) == false {
+		fmt.Printf("Different (stream): %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func TestSpeed() {
+	iter := 5000
+	size := 50000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := generateSkewed(size, rand.New(rand.NewSource(12345)))
+	fr, _ := function.NewFrequencyRemap(0, function.DEFAULT_REMAP_SYMBOLS)
+	output := make([]byte, fr.MaxEncodedLen(size))
+	reverse := make([]byte, size)
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		fr.SetSize(0)
+		before := time.Now()
+		_, dstIdx, _ := fr.Forward(input, output)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		fr.SetSize(dstIdx)
+		before = time.Now()
+		fr.Inverse(output, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("Remap encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s]  : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("Remap decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s]  : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestHaarByte\n")
+	TestCorrectness()
+	TestRatio()
+	TestSpeed()
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Example from the doc comment
+	{
+		input := []byte{10, 12, 13, 13, 20}
+		expected := []byte{11, 13, 20, 4, 0}
+		haar, _ := function.NewHaarByte(0, 1)
+		output := make([]byte, len(input))
+		haar.Forward(input, output)
+
+		if bytes.Equal(output, expected) == false {
+			fmt.Printf("Invalid encoding: %v (expected %v)\n", output, expected)
+			os.Exit(1)
+		}
+	}
+
+	for ii := 0; ii < 20; ii++ {
+		levels := uint(1 + rnd.Intn(function.MAX_HAAR_LEVELS))
+		size := uint(rnd.Intn(64))
+		input := make([]byte, size)
+
+		switch ii {
+		case 0:
+			size = 0
+			input = input[0:0]
+
+		case 1:
+			// Extreme differences (wrap around)
+			for i := range input {
+				input[i] = byte(255 * (i & 1))
+			}
+
+		default:
+			for i := range input {
+				input[i] = byte(rnd.Intn(256))
+			}
+		}
+
+		fmt.Printf("\nTest %v (levels %v, size %v)\n", ii, levels, size)
+		haar, _ := function.NewHaarByte(0, levels)
+		output := make([]byte, haar.MaxEncodedLen(int(size)))
+		reverse := make([]byte, size)
+		_, dstIdx, err := haar.Forward(input, output)
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Original: %v\n", input)
+		fmt.Printf("Coded:    %v\n", output[0:dstIdx])
+		haar, _ = function.NewHaarByte(dstIdx, levels)
+		_, oIdx, err := haar.Inverse(output, reverse)
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Decoded:  %v\n", reverse[0:oIdx])
+
+		if oIdx != size || bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	// Exhaustive check of all the pairs
+	haar, _ := function.NewHaarByte(0, 1)
+	input := make([]byte, 2)
+	output := make([]byte, 2)
+	reverse := make([]byte, 2)
+
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			input[0] = byte(a)
+			input[1] = byte(b)
+			haar.Forward(input, output)
+			haar.Inverse(output, reverse)
+
+			if bytes.Equal(input, reverse) == false {
+				fmt.Printf("Different for pair (%v, %v)\n", a, b)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if _, err := function.NewHaarByte(0, 0); err == nil {
+		fmt.Printf("Invalid number of levels not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Slowly varying signal with a little noise (EG. audio or sensor samples)
+func generateSmooth(size int, rnd *rand.Rand) []byte {
+	res := make([]byte, size)
+
+	for i := range res {
+		x := float64(i)
+		v := 128 + 60*math.Sin(x/300) + 30*math.Sin(x/47) + float64(rnd.Intn(3))
+		res[i] = byte(v)
+	}
+
+	return res
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (smooth data)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateSmooth(500000, rnd)
+	haar, _ := function.NewHaarByte(0, function.DEFAULT_HAAR_LEVELS)
+	output := make([]byte, haar.MaxEncodedLen(len(input)))
+	haar.Forward(input, output)
+
+	for _, codec := range []string{"Huffman", "Range", "FPAQ"} {
+		raw, err1 := kio.Compress(input, codec, "None", 1<<20)
+		coded, err2 := kio.Compress(output, codec, "None", 1<<20)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Compression error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: raw=%v bytes, Haar=%v bytes\n", codec, len(raw), len(coded))
+
+		if len(coded) >= len(raw) {
+			fmt.Printf("No compression improvement with the transform\n")
+			os.Exit(1)
+		}
+	}
+
+	// Round trip through the stream
+	compressed, err := kio.Compress(input, "Huffman", "Haar", 1<<18)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	res, err := kio.Decompress(compressed)
+
+	if err != nil || bytes.Equal(input, res) == false {
+		fmt.Printf("Different (stream): %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func TestSpeed() {
+	iter := 5000
+	size := 50000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := generateSmooth(size, rand.New(rand.NewSource(12345)))
+	haar, _ := function.NewHaarByte(0, function.DEFAULT_HAAR_LEVELS)
+	output := make([]byte, haar.MaxEncodedLen(size))
+	reverse := make([]byte, size)
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		before := time.Now()
+		haar.Forward(input, output)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		before = time.Now()
+		haar.Inverse(output, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("Haar encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s] : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("Haar decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s] : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"kanzi/util"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestHistogram\n")
+	TestKnownDistributions()
+	TestAccumulator()
+}
+
+func checkEntropy(name string, data []byte, expected float64) {
+	hist := util.Histogram(data)
+	res := util.Entropy(hist)
+	fmt.Printf("%-10v: %.4f bits per byte\n", name, res)
+
+	if math.Abs(res-expected) > 1e-9 {
+		fmt.Printf("Invalid entropy: %v (expected %v)\n", res, expected)
+		os.Exit(1)
+	}
+}
+
+func TestKnownDistributions() {
+	fmt.Printf("\nKnown distributions test\n")
+	uniform := make([]byte, 256*100)
+
+	for i := range uniform {
+		uniform[i] = byte(i)
+	}
+
+	checkEntropy("uniform", uniform, 8.0)
+	checkEntropy("single", []byte("aaaaaaaaaaaaaaaa"), 0.0)
+	checkEntropy("empty", []byte{}, 0.0)
+	checkEntropy("two", []byte("abababab"), 1.0)
+
+	// 1/2, 1/4, 1/8, 1/8
+	checkEntropy("dyadic", []byte("aaaabbcd"), 1.75)
+	hist := util.Histogram([]byte("hello"))
+
+	if hist['l'] != 2 || hist['h'] != 1 || hist['o'] != 1 || hist['a'] != 0 {
+		fmt.Printf("Invalid histogram\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Chunks of random sizes: same histogram as the whole data
+func TestAccumulator() {
+	fmt.Printf("\nAccumulator test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	data := make([]byte, 100000)
+
+	for i := range data {
+		data[i] = byte(rnd.Intn(1 + i>>9))
+	}
+
+	acc, _ := util.NewHistogramAccumulator()
+
+	for i := 0; i < len(data); {
+		n := rnd.Intn(5000)
+
+		if i+n > len(data) {
+			n = len(data) - i
+		}
+
+		acc.Write(data[i : i+n])
+		i += n
+	}
+
+	if acc.Histogram() != util.Histogram(data) || acc.Count() != uint64(len(data)) ||
+		acc.Entropy() != util.Entropy(util.Histogram(data)) {
+		fmt.Printf("Different histogram\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("%v bytes: %.4f bits per byte\n", acc.Count(), acc.Entropy())
+	acc.Reset()
+
+	if acc.Count() != 0 || acc.Entropy() != 0 {
+		fmt.Printf("Accumulator not reset\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
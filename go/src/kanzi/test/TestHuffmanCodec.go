@@ -16,7 +16,9 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"kanzi"
 	"kanzi/bitstream"
 	"kanzi/entropy"
 	"kanzi/util"
@@ -27,7 +29,9 @@ import (
 
 func main() {
 	TestCorrectness()
+	TestDegenerate()
 	TestSpeed()
+	TestCompareRange()
 }
 
 func TestCorrectness() {
@@ -218,3 +222,113 @@ func TestSpeed() {
 		fmt.Printf("Throughput [KB/s]: %d\n", (int64(iter*size))*1000000/delta2*1000/1024)
 	}
 }
+
+func roundTrip(block []byte, codec byte) (int, error) {
+	buffer := make([]byte, 2*len(block)+1024)
+	oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+	ee, _ := entropy.NewEntropyEncoder(obs, codec)
+
+	if _, err := ee.Encode(block); err != nil {
+		return 0, err
+	}
+
+	ee.Dispose()
+	obs.Close()
+	iFile, _ := util.NewByteArrayInputStream(buffer, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	ed, _ := entropy.NewEntropyDecoder(ibs, codec)
+	decoded := make([]byte, len(block))
+
+	if _, err := ed.Decode(decoded); err != nil {
+		return 0, err
+	}
+
+	ed.Dispose()
+
+	if bytes.Equal(block, decoded) == false {
+		return 0, fmt.Errorf("Different")
+	}
+
+	return int((obs.Written() + 7) >> 3), nil
+}
+
+// Empty block, single symbol blocks (1 bit per symbol), extreme symbols
+func TestDegenerate() {
+	fmt.Printf("\n\nDegenerate blocks test\n")
+	blocks := [][]byte{{}, {42}, {0}, {255}, {0, 255}, bytes.Repeat([]byte{7}, 100000),
+		append(bytes.Repeat([]byte{7}, 100000), 8)}
+
+	for _, block := range blocks {
+		n, err := roundTrip(block, entropy.HUFFMAN_TYPE)
+
+		if err != nil {
+			fmt.Printf("Block of %v bytes: %v\n", len(block), err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%v bytes => %v bytes, identical\n", len(block), n)
+
+		// At most 1 bit per symbol (plus the code lengths)
+		if n > len(block)/8+1024 {
+			fmt.Printf("Invalid encoded size\n")
+			os.Exit(1)
+		}
+	}
+}
+
+// Throughput of the Huffman and range coders on the same data
+func TestCompareRange() {
+	fmt.Printf("\n\nHuffman vs range coder\n")
+	iter := 200
+	size := 500000
+	rnd := rand.New(rand.NewSource(12345))
+	input := make([]byte, size)
+
+	// Skewed distribution (EG. post BWT+MTF data)
+	for i := range input {
+		input[i] = byte(rnd.ExpFloat64() * 8)
+	}
+
+	buffer := make([]byte, 2*size)
+	output := make([]byte, size)
+
+	for _, codec := range []byte{entropy.HUFFMAN_TYPE, entropy.RANGE_TYPE} {
+		delta1 := int64(0)
+		delta2 := int64(0)
+		var written uint64
+
+		for ii := 0; ii < iter; ii++ {
+			oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+			obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 65536)
+			var ee kanzi.EntropyEncoder
+			ee, _ = entropy.NewEntropyEncoder(obs, codec)
+			before := time.Now()
+			ee.Encode(input)
+			ee.Dispose()
+			obs.Close()
+			delta1 += time.Now().Sub(before).Nanoseconds()
+			written = obs.Written()
+
+			iFile, _ := util.NewByteArrayInputStream(buffer, false)
+			ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 65536)
+			var ed kanzi.EntropyDecoder
+			ed, _ = entropy.NewEntropyDecoder(ibs, codec)
+			before = time.Now()
+			ed.Decode(output)
+			ed.Dispose()
+			delta2 += time.Now().Sub(before).Nanoseconds()
+		}
+
+		if bytes.Equal(input, output) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		name := entropy.GetEntropyCodecName(codec)
+		prod := int64(iter) * int64(size)
+		fmt.Printf("%-7v: %v => %v bytes\n", name, size, (written+7)>>3)
+		fmt.Printf("Encode throughput [MB/s]: %d\n", prod*1000000/delta1*1000/(1024*1024))
+		fmt.Printf("Decode throughput [MB/s]: %d\n", prod*1000000/delta2*1000/(1024*1024))
+	}
+}
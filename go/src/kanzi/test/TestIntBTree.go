@@ -18,6 +18,7 @@ package main
 import (
 	"fmt"
 	"kanzi/util"
+	"math"
 	"math/rand"
 	"os"
 	"time"
@@ -33,7 +34,7 @@ func main() {
 		for ii := 0; ii < 5; ii++ {
 			fmt.Printf("\nIteration %v\n", ii)
 			max := 0
-			min := 1 << 31
+			min := math.MaxInt32
 
 			for i := 0; i < 30; i++ {
 				val := 64 + r.Intn(5*i+20)
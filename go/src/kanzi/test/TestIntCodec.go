@@ -0,0 +1,155 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	kio "kanzi/io"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestIntCodec\n")
+	TestCorrectness()
+	TestRatio()
+}
+
+// Values with mixed signs and magnitudes (from a few bits to the full width)
+func generateValues(count int, rnd *rand.Rand) []int64 {
+	res := make([]int64, count)
+
+	for i := range res {
+		bits := uint(rnd.Intn(64))
+		res[i] = int64(rnd.Uint32())<<32 | int64(rnd.Uint32())
+		res[i] >>= bits
+
+		if rnd.Intn(2) == 0 {
+			res[i] = -res[i]
+		}
+	}
+
+	return res
+}
+
+func check(values interface{}) int {
+	encoded, err := kio.EncodeInts(values)
+
+	if err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	decoded, err := kio.DecodeInts(encoded)
+
+	if err != nil {
+		fmt.Printf("Decoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if reflect.DeepEqual(values, decoded) == false {
+		fmt.Printf("Different\n%v\n%v\n", values, decoded)
+		os.Exit(1)
+	}
+
+	return len(encoded)
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		var values64 []int64
+
+		switch ii {
+		case 0:
+			values64 = []int64{}
+
+		case 1:
+			values64 = []int64{0, -1, 1, math.MinInt64, math.MaxInt64, math.MinInt64, 0}
+
+		case 2:
+			values64 = []int64{math.MinInt32, math.MaxInt32, -1, math.MinInt32, 5, math.MaxInt32}
+
+		case 3:
+			// Increasing (delta)
+			values64 = make([]int64, 100)
+
+			for i := range values64 {
+				values64[i] = int64(1000000 + 3*i)
+			}
+
+		default:
+			values64 = generateValues(rnd.Intn(200), rnd)
+		}
+
+		values32 := make([]int32, len(values64))
+
+		for i := range values64 {
+			values32[i] = int32(values64[i])
+		}
+
+		size64 := check(values64)
+		size32 := check(values32)
+		fmt.Printf("Test %v: %v values, int64 => %v bytes, int32 => %v bytes Identical\n", ii, len(values64), size64, size32)
+	}
+
+	if _, err := kio.EncodeInts([]int16{1, 2}); err == nil {
+		fmt.Printf("Invalid values type not detected\n")
+		os.Exit(1)
+	}
+
+	if _, err := kio.DecodeInts([]byte{0x30, 0x00}); err == nil {
+		fmt.Printf("Invalid element width not detected\n")
+		os.Exit(1)
+	}
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	count := 100000
+
+	// Timestamps in ms with small jitter
+	timestamps := make([]int64, count)
+	t := int64(1380000000000)
+
+	for i := range timestamps {
+		t += int64(900 + rnd.Intn(200))
+		timestamps[i] = t
+	}
+
+	// Small signed measures
+	measures := make([]int32, count)
+
+	for i := range measures {
+		measures[i] = int32(rnd.NormFloat64() * 100)
+	}
+
+	size1 := check(timestamps)
+	size2 := check(measures)
+	fmt.Printf("Timestamps: %v => %v bytes\n", 8*count, size1)
+	fmt.Printf("Measures  : %v => %v bytes\n", 4*count, size2)
+
+	if size1 >= 2*count || size2 >= 2*count {
+		fmt.Printf("Insufficient compression\n")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestLZCodec\n")
+	TestCorrectness()
+	TestParameters()
+	TestMalformed()
+	TestRatio()
+	TestSpeed()
+}
+
+func roundTrip(input []byte, logWindow, logHash uint) ([]byte, error) {
+	lz, err := function.NewLZCodec(0, logWindow, logHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]byte, lz.MaxEncodedLen(len(input)))
+	_, dstIdx, err := lz.Forward(input, output)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int(dstIdx) > lz.MaxEncodedLen(len(input)) {
+		return nil, fmt.Errorf("Encoded size %v above MaxEncodedLen()", dstIdx)
+	}
+
+	if len(input) == 0 {
+		// A size of 0 means the whole buffer for the inverse
+		if dstIdx != 0 {
+			return nil, fmt.Errorf("Invalid encoded size for an empty input: %v", dstIdx)
+		}
+
+		return output[0:0], nil
+	}
+
+	reverse := make([]byte, len(input))
+	lz.SetSize(dstIdx)
+	_, oIdx, err := lz.Inverse(output, reverse)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int(oIdx) != len(input) || bytes.Equal(input, reverse) == false {
+		return nil, fmt.Errorf("Different")
+	}
+
+	return output[0:dstIdx], nil
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Example from the doc comment
+	output, err := roundTrip([]byte("abcdabcdabcd"), function.DEFAULT_LZ_LOG_WINDOW, function.DEFAULT_LZ_LOG_HASH)
+	expected := []byte{0x44, 'a', 'b', 'c', 'd', 0x04, 0x00}
+
+	if err != nil || bytes.Equal(output, expected) == false {
+		fmt.Printf("Invalid encoding: %v (expected %v) %v\n", output, expected, err)
+		os.Exit(1)
+	}
+
+	for ii := 0; ii < 40; ii++ {
+		var input []byte
+
+		switch ii % 5 {
+		case 0:
+			// Random (no match)
+			input = make([]byte, rnd.Intn(100000))
+			rnd.Read(input)
+
+		case 1:
+			// Repeated random words (matches of any length and distance)
+			words := make([][]byte, 1+rnd.Intn(50))
+
+			for i := range words {
+				words[i] = make([]byte, 1+rnd.Intn(300))
+				rnd.Read(words[i])
+			}
+
+			for len(input) < 200000 {
+				input = append(input, words[rnd.Intn(len(words))]...)
+			}
+
+		case 2:
+			// Long runs (overlapping matches)
+			for len(input) < 100000 {
+				input = append(input, bytes.Repeat([]byte{byte(rnd.Intn(4))}, rnd.Intn(2000))...)
+			}
+
+		case 3:
+			// Small inputs
+			input = make([]byte, rnd.Intn(20))
+
+			for i := range input {
+				input[i] = byte(rnd.Intn(3))
+			}
+
+		default:
+			input = generateLog(1000+rnd.Intn(10000), rnd)
+		}
+
+		output, err := roundTrip(input, function.DEFAULT_LZ_LOG_WINDOW, function.DEFAULT_LZ_LOG_HASH)
+
+		if err != nil {
+			fmt.Printf("Test %v (%v bytes): %v\n", ii, len(input), err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Test %v: %v => %v bytes\n", ii, len(input), len(output))
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+func TestParameters() {
+	fmt.Printf("\n\nParameters test\n")
+	rnd := rand.New(rand.NewSource(12345))
+
+	// The same block of 20 KB repeated 10 times: matches at distance 20 KB
+	block := make([]byte, 20000)
+	rnd.Read(block)
+	input := bytes.Repeat(block, 10)
+	sizes := make(map[uint]int)
+
+	for _, logWindow := range []uint{10, 14, 16, 24} {
+		for _, logHash := range []uint{8, 12, 20} {
+			output, err := roundTrip(input, logWindow, logHash)
+
+			if err != nil {
+				fmt.Printf("Window %v, hash %v: %v\n", logWindow, logHash, err)
+				os.Exit(1)
+			}
+
+			sizes[logWindow] = len(output)
+		}
+
+		fmt.Printf("Window 2^%v: %v => %v bytes\n", logWindow, len(input), sizes[logWindow])
+	}
+
+	// The repetitions are only found with a window above 20 KB
+	if sizes[16] > len(block)+1000 || sizes[14] < len(input) {
+		fmt.Printf("Invalid window size handling\n")
+		os.Exit(1)
+	}
+
+	for _, params := range [][2]uint{{9, 12}, {25, 12}, {16, 7}, {16, 21}} {
+		if _, err := function.NewLZCodec(0, params[0], params[1]); err == nil {
+			fmt.Printf("Invalid parameters not detected: %v\n", params)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func TestMalformed() {
+	fmt.Printf("\n\nMalformed data test\n")
+	invalid := [][]byte{
+		{0xF0},                  // truncated literal length
+		{0x20, 'a'},             // truncated literals
+		{0x11, 'a', 0x02, 0x00}, // distance beyond the output
+		{0x11, 'a', 0x00, 0x00}, // null distance
+		{0x11, 'a'},             // truncated match
+		{0x1F, 'a', 0x01},       // truncated match length
+		{0x10, 'a', 0x80},       // truncated distance
+	}
+
+	for _, input := range invalid {
+		lz, _ := function.NewLZCodec(0, function.DEFAULT_LZ_LOG_WINDOW, function.DEFAULT_LZ_LOG_HASH)
+
+		if _, _, err := lz.Inverse(input, make([]byte, 100)); err == nil {
+			fmt.Printf("Invalid input not detected: %v\n", input)
+			os.Exit(1)
+		} else {
+			fmt.Printf("%v: %v\n", input, err)
+		}
+	}
+
+	// Output too small
+	lz, _ := function.NewLZCodec(0, function.DEFAULT_LZ_LOG_WINDOW, function.DEFAULT_LZ_LOG_HASH)
+
+	if _, _, err := lz.Inverse([]byte{0x44, 'a', 'b', 'c', 'd', 0x04, 0x00}, make([]byte, 10)); err == nil {
+		fmt.Printf("Output buffer too small not detected\n")
+		os.Exit(1)
+	}
+
+	// Random data must never panic
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 10000; ii++ {
+		input := make([]byte, rnd.Intn(32))
+		rnd.Read(input)
+		lz.SetSize(0)
+		lz.Inverse(input, make([]byte, 256))
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Log lines with timestamps, a few recurring messages and varying ids
+func generateLog(count int, rnd *rand.Rand) []byte {
+	messages := []string{
+		"INFO  [main] server.http - Connection accepted from 10.0.0.%d\n",
+		"INFO  [main] server.http - Connection closed by peer %d\n",
+		"WARN  [pool-3] db.client - Slow query detected (%d ms), retrying\n",
+		"DEBUG [pool-1] cache.lru - Eviction of %d entries\n",
+		"ERROR [worker-2] request %d failed: timeout\n",
+	}
+
+	var buf bytes.Buffer
+	ts := 1600000000
+
+	for i := 0; i < count; i++ {
+		ts += rnd.Intn(3)
+		fmt.Fprintf(&buf, "%d.%03d ", ts, rnd.Intn(1000))
+		fmt.Fprintf(&buf, messages[rnd.Intn(len(messages))], rnd.Intn(256))
+	}
+
+	return buf.Bytes()
+}
+
+// Compare with raw range coding on log files
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (log lines)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateLog(50000, rnd)
+
+	for _, codec := range []string{"Range", "Huffman"} {
+		raw, err1 := kio.Compress(input, codec, "None", 1<<22)
+		lz, err2 := kio.Compress(input, codec, "LZ", 1<<22)
+		lz4, err3 := kio.Compress(input, codec, "LZ4", 1<<22)
+
+		if err1 != nil || err2 != nil || err3 != nil {
+			fmt.Printf("Compression error: %v %v %v\n", err1, err2, err3)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: %v bytes => raw=%v bytes, LZ=%v bytes, LZ4=%v bytes\n", codec, len(input),
+			len(raw), len(lz), len(lz4))
+
+		if len(lz)*2 >= len(raw) {
+			fmt.Printf("No compression improvement with LZ\n")
+			os.Exit(1)
+		}
+
+		res, err := kio.Decompress(lz)
+
+		if err != nil || bytes.Equal(input, res) == false {
+			fmt.Printf("Different: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func TestSpeed() {
+	iter := 50
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := generateLog(20000, rand.New(rand.NewSource(12345)))
+	size := len(input)
+	lz, _ := function.NewLZCodec(0, function.DEFAULT_LZ_LOG_WINDOW, function.DEFAULT_LZ_LOG_HASH)
+	output := make([]byte, lz.MaxEncodedLen(size))
+	reverse := make([]byte, size)
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		lz.SetSize(0)
+		before := time.Now()
+		_, dstIdx, _ := lz.Forward(input, output)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		lz.SetSize(dstIdx)
+		before = time.Now()
+		lz.Inverse(output, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("LZ encoding [ms]  : %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s] : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("LZ decoding [ms]  : %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s] : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
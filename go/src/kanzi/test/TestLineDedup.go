@@ -0,0 +1,233 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestLineDedup\n")
+	TestCorrectness()
+	TestRatio()
+	TestSpeed()
+}
+
+// Lines picked from a small set (some starting with the escape byte),
+// the last line may have no delimiter
+func generateLines(count int, delimiter byte, rnd *rand.Rand) []byte {
+	lines := [][]byte{
+		[]byte("abcd"), []byte("efghij"), []byte("xy"), []byte{},
+		[]byte{0xFF, 'a', 'b', 'c'}, []byte{0xFF}, []byte{0xFF, 0x00, 0x01, 0x02, 0x03},
+	}
+
+	var buf bytes.Buffer
+
+	for i := 0; i < count; i++ {
+		if rnd.Intn(4) == 0 {
+			// Random line
+			n := rnd.Intn(10)
+
+			for j := 0; j < n; j++ {
+				buf.WriteByte(byte(rnd.Intn(256)))
+			}
+		} else {
+			buf.Write(lines[rnd.Intn(len(lines))])
+		}
+
+		if i < count-1 || rnd.Intn(2) == 0 {
+			buf.WriteByte(delimiter)
+		}
+	}
+
+	return append([]byte{}, buf.Bytes()...)
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		delimiter := byte('\n')
+
+		if ii%4 == 3 {
+			delimiter = 0
+		}
+
+		dictSize := uint(1 + rnd.Intn(8))
+		input := generateLines(rnd.Intn(30), delimiter, rnd)
+		size := uint(len(input))
+
+		if ii == 0 {
+			input = []byte{}
+			size = 0
+		}
+
+		fmt.Printf("\nTest %v (delimiter %v, dictionary size %v, size %v)\n", ii, delimiter, dictSize, size)
+		ld, _ := function.NewLineDedup(size, delimiter, dictSize)
+		output := make([]byte, ld.MaxEncodedLen(len(input)))
+		reverse := make([]byte, size)
+		_, dstIdx, err := ld.Forward(input, output)
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Original: %v\n", input)
+		fmt.Printf("Coded:    %v\n", output[0:dstIdx])
+		ld, _ = function.NewLineDedup(dstIdx, delimiter, dictSize)
+		_, oIdx, err := ld.Inverse(output, reverse)
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Decoded:  %v\n", reverse[0:oIdx])
+
+		if oIdx != size || bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	if _, err := function.NewLineDedup(0, 0xFF, 16); err == nil {
+		fmt.Printf("Invalid delimiter not detected\n")
+		os.Exit(1)
+	}
+
+	// Reference to a line not in the dictionary
+	ld, _ := function.NewLineDedup(0, '\n', 16)
+
+	if _, _, err := ld.Inverse([]byte{'a', 'b', 'c', 'd', '\n', 0xFF, 0x02}, make([]byte, 64)); err == nil {
+		fmt.Printf("Invalid line reference not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Log lines with a few recurring messages and varying request ids
+func generateLog(count int, rnd *rand.Rand) []byte {
+	messages := []string{
+		"INFO  [main] server.http - Connection accepted from 10.0.0.12\n",
+		"INFO  [main] server.http - Connection closed by peer\n",
+		"WARN  [pool-3] db.client - Slow query detected, retrying\n",
+		"DEBUG [pool-1] cache.lru - Eviction of 128 entries\n",
+		"INFO  [main] server.health - Health check OK\n",
+	}
+
+	var buf bytes.Buffer
+
+	for i := 0; i < count; i++ {
+		if rnd.Intn(8) == 0 {
+			buf.WriteString(fmt.Sprintf("ERROR [worker-%d] request %d failed\n", rnd.Intn(16), rnd.Intn(1000000)))
+		} else {
+			buf.WriteString(messages[rnd.Intn(len(messages))])
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (repetitive log lines)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateLog(20000, rnd)
+	ld, _ := function.NewLineDedup(0, '\n', function.DEFAULT_LINE_DEDUP_DICT)
+	output := make([]byte, ld.MaxEncodedLen(len(input)))
+	_, dstIdx, err := ld.Forward(input, output)
+
+	if err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Transform: %v => %v bytes\n", len(input), dstIdx)
+
+	for _, codec := range []string{"Huffman", "ANS", "FPAQ"} {
+		raw, err1 := kio.Compress(input, codec, "None", 1<<20)
+		dedup, err2 := kio.Compress(input, codec, "LineDedup", 1<<20)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Compression error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: raw=%v bytes, line dedup=%v bytes\n", codec, len(raw), len(dedup))
+
+		if len(dedup) >= len(raw) {
+			fmt.Printf("No compression improvement with line dedup\n")
+			os.Exit(1)
+		}
+
+		res, err := kio.Decompress(dedup)
+
+		if err != nil {
+			fmt.Printf("Decompression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(input, res) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+	}
+}
+
+func TestSpeed() {
+	iter := 2000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := generateLog(2000, rand.New(rand.NewSource(12345)))
+	size := len(input)
+	ld, _ := function.NewLineDedup(0, '\n', function.DEFAULT_LINE_DEDUP_DICT)
+	output := make([]byte, ld.MaxEncodedLen(size))
+	reverse := make([]byte, size)
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		ld.SetSize(0)
+		before := time.Now()
+		_, dstIdx, _ := ld.Forward(input, output)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		ld.SetSize(dstIdx)
+		before = time.Now()
+		ld.Inverse(output, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("LineDedup encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s]      : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("LineDedup decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s]      : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
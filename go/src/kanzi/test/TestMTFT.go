@@ -16,7 +16,9 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"kanzi/function"
 	"kanzi/transform"
 	"math/rand"
 	"os"
@@ -157,4 +159,96 @@ func main() {
 		fmt.Printf("\nThroughput [KB/s]: %d", (int64(iter*size))*1000000/delta2*1000/1024)
 		println()
 	}
+
+	TestChain()
+}
+
+// Text with repeated words: the BWT output has long runs of identical bytes
+func generateText(size int, rnd *rand.Rand) []byte {
+	words := []string{"the ", "of ", "and ", "to ", "in ", "is ", "that ", "it ", "was ",
+		"for ", "on ", "are ", "as ", "with ", "they ", "at ", "be ", "this ", "from "}
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		buf.WriteString(words[rnd.Intn(len(words))])
+	}
+
+	return buf.Bytes()[0:size]
+}
+
+// BWT -> MTFT -> ZRLT and back, then MTFT speed on BWT output
+func TestChain() {
+	fmt.Printf("\n\nBWT/MTFT/ZRLT chain test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	size := 100000
+	input := generateText(size, rnd)
+	bwtOut := make([]byte, size)
+	mtfOut := make([]byte, size)
+	bwt, _ := transform.NewBWT(uint(size))
+	bwt.Forward(input, bwtOut)
+	mtft, _ := transform.NewMTFT(uint(size))
+	mtft.Forward(bwtOut, mtfOut)
+	zrlt, _ := function.NewZRLT(uint(size))
+	zrltOut := make([]byte, zrlt.MaxEncodedLen(size))
+	_, zrltLen, err := zrlt.Forward(mtfOut, zrltOut)
+
+	if err != nil {
+		fmt.Printf("ZRLT encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%v bytes => %v bytes after ZRLT\n", size, zrltLen)
+
+	if zrltLen >= uint(size)*3/4 {
+		fmt.Printf("Poor ZRLT compression of the MTFT output\n")
+		os.Exit(1)
+	}
+
+	// Reverse
+	reverse := make([]byte, size)
+	zrlt, _ = function.NewZRLT(zrltLen)
+
+	if _, _, err = zrlt.Inverse(zrltOut, mtfOut); err != nil {
+		fmt.Printf("ZRLT decoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	mtft.Inverse(mtfOut, bwtOut)
+	bwt.Inverse(bwtOut, reverse)
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+
+	// Speed on BWT output
+	iter := 500
+	bwt.Forward(input, bwtOut)
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		before := time.Now()
+		mtft.Forward(bwtOut, mtfOut)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		before = time.Now()
+		mtft.Inverse(mtfOut, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(bwtOut, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nBWT output (%v iterations)", iter)
+	fmt.Printf("\nMTFT Forward transform [ms]: %v", delta1/1000000)
+	fmt.Printf("\nThroughput [KB/s]: %d", (int64(iter*size))*1000000/delta1*1000/1024)
+	fmt.Printf("\nMTFT Reverse transform [ms]: %v", delta2/1000000)
+	fmt.Printf("\nThroughput [KB/s]: %d", (int64(iter*size))*1000000/delta2*1000/1024)
+	fmt.Println()
 }
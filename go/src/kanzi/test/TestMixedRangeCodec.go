@@ -0,0 +1,289 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/util"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestMixedRangeCodec\n")
+	TestCorrectness()
+	TestRatio()
+	TestBitPattern()
+	TestSpeed()
+}
+
+func encode(block []byte, codec byte) []byte {
+	buffer := make([]byte, 2*len(block)+1024)
+	oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+	ee, _ := entropy.NewEntropyEncoder(obs, codec)
+
+	if _, err := ee.Encode(block); err != nil {
+		fmt.Printf("Error during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	ee.Dispose()
+	obs.Close()
+	return buffer[0:((obs.Written() + 7) >> 3)]
+}
+
+func decode(encoded []byte, size int, codec byte) []byte {
+	iFile, _ := util.NewByteArrayInputStream(encoded, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	ed, _ := entropy.NewEntropyDecoder(ibs, codec)
+	res := make([]byte, size)
+
+	if _, err := ed.Decode(res); err != nil {
+		fmt.Printf("Error during decoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	ed.Dispose()
+	return res
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		var values []byte
+
+		switch ii {
+		case 0:
+			values = []byte{}
+
+		case 1:
+			values = []byte{255}
+
+		case 2:
+			// All identical (many rescales)
+			values = bytes.Repeat([]byte{2}, 300000)
+
+		case 3:
+			// All contexts and symbols
+			values = make([]byte, 200000)
+
+			for i := range values {
+				values[i] = byte(rnd.Intn(256))
+			}
+
+		default:
+			values = make([]byte, rnd.Intn(50000))
+
+			for i := range values {
+				values[i] = byte(64 + 3*ii + rnd.Intn(ii+1))
+			}
+		}
+
+		encoded := encode(values, entropy.MIXED_TYPE)
+		decoded := decode(encoded, len(values), entropy.MIXED_TYPE)
+		fmt.Printf("Test %v: %v => %v bytes\n", ii, len(values), len(encoded))
+
+		if bytes.Equal(values, decoded) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+	}
+
+	// Corrupted stream
+	values := bytes.Repeat([]byte("abcd"), 1000)
+	encoded := encode(values, entropy.MIXED_TYPE)
+
+	for i := range encoded {
+		encoded[i] = ^encoded[i]
+	}
+
+	iFile, _ := util.NewByteArrayInputStream(encoded, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	ed, _ := entropy.NewMixedRangeDecoder(ibs)
+
+	if _, err := ed.Decode(make([]byte, len(values))); err == nil {
+		fmt.Printf("Corrupted stream not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Random sentences made of common English words
+func generateText(size int, rnd *rand.Rand) []byte {
+	words := strings.Fields("the of and to in is that it was for on are as with his they at be " +
+		"this from have or by one had not but what all were when we there can an your which their " +
+		"said if do will each about how up out them then she many some so these would other into " +
+		"has more her two like him see time could no make than first been its who now people my")
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		n := 4 + rnd.Intn(12)
+
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+
+			buf.WriteString(words[rnd.Intn(len(words))])
+		}
+
+		buf.WriteString(". ")
+	}
+
+	return buf.Bytes()[0:size]
+}
+
+// Mixed content: text, small integers (binary records), skewed bytes
+func generateMixed(size int, rnd *rand.Rand) []byte {
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		switch rnd.Intn(3) {
+		case 0:
+			buf.Write(generateText(1000+rnd.Intn(4000), rnd))
+
+		case 1:
+			for i := rnd.Intn(2000); i > 0; i-- {
+				v := rnd.Intn(1000)
+				buf.Write([]byte{0, 0, byte(v >> 8), byte(v)})
+			}
+
+		default:
+			for i := rnd.Intn(4000); i > 0; i-- {
+				buf.WriteByte(byte(rnd.Intn(1 + rnd.Intn(64))))
+			}
+		}
+	}
+
+	return buf.Bytes()[0:size]
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	inputs := [][]byte{generateText(1000000, rnd), generateMixed(1000000, rnd), generateMixed(30000, rnd)}
+	names := []string{"text", "mixed", "small mixed"}
+
+	for ii, input := range inputs {
+		sizes := make(map[string]int)
+
+		for _, name := range []string{"Range", "Order1Range", "MixedRange"} {
+			codec := entropy.GetEntropyCodecType(name)
+			encoded := encode(input, codec)
+
+			if bytes.Equal(input, decode(encoded, len(input), codec)) == false {
+				fmt.Printf("Different\n")
+				os.Exit(1)
+			}
+
+			sizes[name] = len(encoded)
+		}
+
+		fmt.Printf("%-11v: %v bytes => order 0: %v, order 1: %v, mixed: %v\n", names[ii], len(input),
+			sizes["Range"], sizes["Order1Range"], sizes["MixedRange"])
+
+		if sizes["MixedRange"] >= sizes["Range"] {
+			fmt.Printf("The mixed coder should do better than the order 0 coder\n")
+			os.Exit(1)
+		}
+
+		// Text is homogeneous: the order 1 model alone is close to the best
+		// mix, the weights only have to converge (within 1%)
+		if ii == 0 && sizes["MixedRange"]*100 > sizes["Order1Range"]*101 {
+			fmt.Printf("The mixed coder should be close to the order 1 coder\n")
+			os.Exit(1)
+		}
+
+		if ii > 0 && sizes["MixedRange"] >= sizes["Order1Range"] {
+			fmt.Printf("The mixed coder should do better than the order 1 coder\n")
+			os.Exit(1)
+		}
+	}
+}
+
+// The bitstream format must not change (EG. with a new model implementation):
+// compare the checksums of the encodings of fixed inputs with reference values
+func TestBitPattern() {
+	fmt.Printf("\n\nBit pattern test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	text := generateText(300000, rnd)
+	binary := make([]byte, 300000)
+
+	for i := range binary {
+		binary[i] = byte(rnd.Intn(1 + (i>>8)&255))
+	}
+
+	expected := []uint32{0x473cffde, 0x36f20dcc}
+
+	for i, block := range [][]byte{text, binary} {
+		encoded := encode(block, entropy.MIXED_TYPE)
+		checksum := crc32.ChecksumIEEE(encoded)
+		fmt.Printf("Test %v: %v => %v bytes, checksum %x\n", i, len(block), len(encoded), checksum)
+
+		if checksum != expected[i] {
+			fmt.Printf("Different bit pattern (expected checksum %x)\n", expected[i])
+			os.Exit(1)
+		}
+
+		if bytes.Equal(decode(encoded, len(block), entropy.MIXED_TYPE), block) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+	}
+}
+
+func TestSpeed() {
+	iter := 50
+	size := 500000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	text := generateText(size, rand.New(rand.NewSource(12345)))
+	delta1 := int64(0)
+	delta2 := int64(0)
+	var encoded, decoded []byte
+
+	for ii := 0; ii < iter; ii++ {
+		before := time.Now()
+		encoded = encode(text, entropy.MIXED_TYPE)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		before = time.Now()
+		decoded = decode(encoded, size, entropy.MIXED_TYPE)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(text, decoded) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("MixedRange encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s]       : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("MixedRange decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s]       : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
@@ -0,0 +1,641 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/util"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestOrder1RangeCodec\n")
+	TestCorrectness()
+	TestSymbolSearch()
+	TestRatio()
+	TestResetInterval()
+	TestIncrement()
+	TestCheckModels()
+	TestRangeInvariant()
+	TestBitPattern()
+	TestSpeed()
+}
+
+func encode(block []byte, codec byte) []byte {
+	buffer := make([]byte, 2*len(block)+1024)
+	oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+	ee, _ := entropy.NewEntropyEncoder(obs, codec)
+
+	if _, err := ee.Encode(block); err != nil {
+		fmt.Printf("Error during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	ee.Dispose()
+	obs.Close()
+	return buffer[0:((obs.Written() + 7) >> 3)]
+}
+
+func decode(encoded []byte, size int, codec byte) []byte {
+	iFile, _ := util.NewByteArrayInputStream(encoded, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	ed, _ := entropy.NewEntropyDecoder(ibs, codec)
+	res := make([]byte, size)
+
+	if _, err := ed.Decode(res); err != nil {
+		fmt.Printf("Error during decoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	ed.Dispose()
+	return res
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		var values []byte
+
+		switch ii {
+		case 0:
+			values = []byte{}
+
+		case 1:
+			values = []byte{42}
+
+		case 2:
+			// All identical
+			values = bytes.Repeat([]byte{2}, 100000)
+
+		case 3:
+			// All contexts and symbols
+			values = make([]byte, 200000)
+
+			for i := range values {
+				values[i] = byte(rnd.Intn(256))
+			}
+
+		default:
+			values = make([]byte, rnd.Intn(50000))
+
+			for i := range values {
+				values[i] = byte(64 + 3*ii + rnd.Intn(ii+1))
+			}
+		}
+
+		encoded := encode(values, entropy.ORDER1_TYPE)
+		decoded := decode(encoded, len(values), entropy.ORDER1_TYPE)
+		fmt.Printf("Test %v: %v => %v bytes\n", ii, len(values), len(encoded))
+
+		if bytes.Equal(values, decoded) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+	}
+
+	// Several blocks with the same codec (independent blocks)
+	buffer := make([]byte, 65536)
+	oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+	var ee kanzi.EntropyEncoder
+	ee, _ = entropy.NewOrder1RangeEncoder(obs)
+	blocks := [][]byte{[]byte("abracadabra"), []byte("mississippi"), []byte("abracadabra")}
+
+	for _, block := range blocks {
+		ee.Encode(block)
+	}
+
+	obs.Close()
+	iFile, _ := util.NewByteArrayInputStream(buffer, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	var ed kanzi.EntropyDecoder
+	ed, _ = entropy.NewOrder1RangeDecoder(ibs)
+
+	for _, block := range blocks {
+		res := make([]byte, len(block))
+		ed.Decode(res)
+
+		if bytes.Equal(res, block) == false {
+			fmt.Printf("Different: %v (expected %v)\n", string(res), string(block))
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// The decoder searches the symbols by group of 16, the encoder sums the
+// groups then the frequencies: any difference breaks the round trip
+func TestSymbolSearch() {
+	fmt.Printf("\n\nSymbol search test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// Symbols at the group boundaries and at the ends of the alphabet
+	edges := []byte{0, 1, 15, 16, 17, 31, 32, 127, 128, 239, 240, 254, 255}
+
+	for ii := 0; ii < 50; ii++ {
+		values := make([]byte, 1000+rnd.Intn(100000))
+		alphabet := make([]byte, 1+rnd.Intn(len(edges)))
+
+		for i := range alphabet {
+			if rnd.Intn(2) == 0 {
+				alphabet[i] = edges[rnd.Intn(len(edges))]
+			} else {
+				alphabet[i] = byte(rnd.Intn(256))
+			}
+		}
+
+		// Skewed distribution: long runs of a few contexts trigger rescales
+		for i := range values {
+			if rnd.Intn(4) == 0 {
+				values[i] = byte(rnd.Intn(256))
+			} else {
+				values[i] = alphabet[rnd.Intn(1+rnd.Intn(len(alphabet)))]
+			}
+		}
+
+		encoded := encode(values, entropy.ORDER1_TYPE)
+		decoded := decode(encoded, len(values), entropy.ORDER1_TYPE)
+
+		if bytes.Equal(values, decoded) == false {
+			fmt.Printf("Different (test %v, alphabet %v)\n", ii, alphabet)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Random sentences made of common English words
+func generateText(size int, rnd *rand.Rand) []byte {
+	words := strings.Fields("the of and to in is that it was for on are as with his they at be " +
+		"this from have or by one had not but what all were when we there can an your which their " +
+		"said if do will each about how up out them then she many some so these would other into " +
+		"has more her two like him see time could no make than first been its who now people my " +
+		"made over did down only way find use may water long little very after words called just " +
+		"where most know get through back much before go good new write our used me man too any " +
+		"day same right look think also around another came come work three word must because " +
+		"does part even place well such here take why things help put years different away again")
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		n := 4 + rnd.Intn(12)
+
+		for i := 0; i < n; i++ {
+			w := words[rnd.Intn(len(words))]
+
+			if i == 0 {
+				w = strings.ToUpper(w[0:1]) + w[1:]
+			} else {
+				buf.WriteByte(' ')
+			}
+
+			buf.WriteString(w)
+		}
+
+		buf.WriteString(". ")
+
+		if rnd.Intn(8) == 0 {
+			buf.WriteString("\n")
+		}
+	}
+
+	return buf.Bytes()[0:size]
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (English text)\n")
+	text := generateText(1000000, rand.New(rand.NewSource(12345)))
+	sizes := make(map[string]int)
+
+	for _, name := range []string{"Huffman", "Range", "Order1Range"} {
+		codec := entropy.GetEntropyCodecType(name)
+		encoded := encode(text, codec)
+		decoded := decode(encoded, len(text), codec)
+
+		if bytes.Equal(text, decoded) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		sizes[name] = len(encoded)
+		fmt.Printf("%-11v: %v => %v bytes\n", name, len(text), len(encoded))
+	}
+
+	// At least 20% smaller than the order 0 range coder
+	if sizes["Order1Range"] > sizes["Range"]*8/10 {
+		fmt.Printf("No significant improvement over the order 0 coder\n")
+		os.Exit(1)
+	}
+}
+
+// Encode and decode with a reset interval, return the encoded size
+func encodeWithReset(block []byte, resetInterval uint) int {
+	buffer := make([]byte, 2*len(block)+1024)
+	oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+	ee, _ := entropy.NewOrder1RangeEncoder(obs, resetInterval)
+
+	if _, err := ee.Encode(block); err != nil {
+		fmt.Printf("Error during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	obs.Close()
+	iFile, _ := util.NewByteArrayInputStream(buffer, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	ed, _ := entropy.NewOrder1RangeDecoder(ibs, resetInterval)
+	res := make([]byte, len(block))
+
+	if _, err := ed.Decode(res); err != nil {
+		fmt.Printf("Error during decoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	if bytes.Equal(res, block) == false {
+		fmt.Printf("Different (reset interval %v)\n", resetInterval)
+		os.Exit(1)
+	}
+
+	return int((obs.Written() + 7) >> 3)
+}
+
+// Regions with the same contexts but opposite statistics: the model of a
+// region is a handicap in the next one
+func TestResetInterval() {
+	fmt.Printf("\n\nReset interval test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	region := 1 << 17
+	block := make([]byte, 4*region)
+	prev := byte(0)
+
+	for i := range block {
+		step := byte(1)
+
+		if (i/region)&1 == 1 {
+			step = 15
+		}
+
+		// Next byte mostly prev+1 (even regions) or prev-1 (odd regions) in [0..15]
+		if rnd.Intn(10) != 0 {
+			prev = (prev + step) & 15
+		} else {
+			prev = byte(rnd.Intn(16))
+		}
+
+		block[i] = prev
+	}
+
+	sizes := make(map[uint]int)
+
+	for _, resetInterval := range []uint{0, uint(region), uint(region) / 2, 1000, 1} {
+		sizes[resetInterval] = encodeWithReset(block, resetInterval)
+		fmt.Printf("Reset interval %-6v: %v => %v bytes\n", resetInterval, len(block), sizes[resetInterval])
+	}
+
+	if sizes[uint(region)] >= sizes[0] {
+		fmt.Printf("No improvement with a reset at each region\n")
+		os.Exit(1)
+	}
+
+	oFile, _ := util.NewByteArrayOutputStream(make([]byte, 16), false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+
+	if _, err := entropy.NewOrder1RangeEncoder(obs, 1000, 2000); err == nil {
+		fmt.Printf("Invalid parameters not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Encode and decode with an increment, return the encoded data
+func encodeWithIncrement(block []byte, increment uint) []byte {
+	buffer := make([]byte, 2*len(block)+1024)
+	oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+	ee, _ := entropy.NewOrder1RangeEncoderWithIncrement(obs, increment)
+
+	if _, err := ee.Encode(block); err != nil {
+		fmt.Printf("Error during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	obs.Close()
+	iFile, _ := util.NewByteArrayInputStream(buffer, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	ed, _ := entropy.NewOrder1RangeDecoderWithIncrement(ibs, increment)
+	res := make([]byte, len(block))
+
+	if _, err := ed.Decode(res); err != nil {
+		fmt.Printf("Error during decoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	if bytes.Equal(res, block) == false {
+		fmt.Printf("Different (increment %v)\n", increment)
+		os.Exit(1)
+	}
+
+	return buffer[0:((obs.Written() + 7) >> 3)]
+}
+
+// Increments on a skewed source (a few likely successors per context) and on
+// a uniform one: a larger increment gives less weight to the initial counts,
+// hence a better ratio on skewed data and a worse one on uniform data
+func TestIncrement() {
+	fmt.Printf("\n\nIncrement test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	skewed := make([]byte, 50000)
+	uniform := make([]byte, 50000)
+	prev := byte(0)
+
+	for i := range skewed {
+		if rnd.Intn(20) != 0 {
+			prev = prev*7 + byte(rnd.Intn(2))
+		} else {
+			prev = byte(rnd.Intn(256))
+		}
+
+		skewed[i] = prev
+		uniform[i] = byte(rnd.Intn(256))
+	}
+
+	skewedSizes := make(map[uint]int)
+	uniformSizes := make(map[uint]int)
+
+	for _, increment := range []uint{1, 4, entropy.ORDER1_RANGE_INCREMENT, 96, 512, entropy.ORDER1_RANGE_MAX_INCREMENT} {
+		skewedSizes[increment] = len(encodeWithIncrement(skewed, increment))
+		uniformSizes[increment] = len(encodeWithIncrement(uniform, increment))
+		fmt.Printf("Increment %-4v: skewed %v => %v bytes, uniform %v => %v bytes\n", increment,
+			len(skewed), skewedSizes[increment], len(uniform), uniformSizes[increment])
+	}
+
+	if skewedSizes[96] >= skewedSizes[1] || uniformSizes[1] >= uniformSizes[96] {
+		fmt.Printf("Unexpected ratios\n")
+		os.Exit(1)
+	}
+
+	// The default increment
+	if bytes.Equal(encode(skewed, entropy.ORDER1_TYPE), encodeWithIncrement(skewed, entropy.ORDER1_RANGE_INCREMENT)) == false {
+		fmt.Printf("Different encoding with the default increment\n")
+		os.Exit(1)
+	}
+
+	oFile, _ := util.NewByteArrayOutputStream(make([]byte, 16), false)
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+
+	for _, increment := range []uint{0, entropy.ORDER1_RANGE_MAX_INCREMENT + 1} {
+		if _, err := entropy.NewOrder1RangeEncoderWithIncrement(obs, increment); err == nil {
+			fmt.Printf("Invalid increment %v not detected\n", increment)
+			os.Exit(1)
+		}
+	}
+}
+
+// Check mode: no violation reported on valid data (including rescales), same
+// bitstream as without checks
+func TestCheckModels() {
+	fmt.Printf("\n\nCheck models test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	skewed := make([]byte, 300000)
+
+	// Few contexts with high counts: many rescales
+	for i := range skewed {
+		if rnd.Intn(20) == 0 {
+			skewed[i] = byte(rnd.Intn(256))
+		} else {
+			skewed[i] = byte(rnd.Intn(3))
+		}
+	}
+
+	random := make([]byte, 100000)
+
+	for i := range random {
+		random[i] = byte(rnd.Intn(256))
+	}
+
+	inputs := [][]byte{skewed, random, generateText(200000, rnd)}
+
+	for ii, input := range inputs {
+		buffer := make([]byte, 2*len(input)+1024)
+		oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+		obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+		ee, _ := entropy.NewOrder1RangeEncoder(obs)
+		ee.SetCheckModels(true)
+		before := time.Now()
+
+		if _, err := ee.Encode(input); err != nil {
+			fmt.Printf("Error during encoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		delta := time.Now().Sub(before).Nanoseconds()
+		obs.Close()
+		encoded := buffer[0:((obs.Written() + 7) >> 3)]
+
+		if bytes.Equal(encoded, encode(input, entropy.ORDER1_TYPE)) == false {
+			fmt.Printf("Different bitstream with checks\n")
+			os.Exit(1)
+		}
+
+		iFile, _ := util.NewByteArrayInputStream(encoded, true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+		ed, _ := entropy.NewOrder1RangeDecoder(ibs)
+		ed.SetCheckModels(true)
+		res := make([]byte, len(input))
+
+		if _, err := ed.Decode(res); err != nil {
+			fmt.Printf("Error during decoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(res, input) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Test %v: %v => %v bytes, checked encoding [ms]: %v\n", ii, len(input), len(encoded), delta/1000000)
+	}
+
+	fmt.Printf("Identical\n")
+
+	// A corrupted table must be reported after the byte that corrupted it
+	input := inputs[2]
+	encoded := encode(input, entropy.ORDER1_TYPE)
+	faults := []func(freqs []uint32){
+		func(freqs []uint32) { freqs[5] = 0 },    // null frequency
+		func(freqs []uint32) { freqs[7]++ },      // group sum
+		func(freqs []uint32) { freqs[200] += 2 }, // group sum
+	}
+
+	for ii, fault := range faults {
+		f := fault
+		hook := func(pos int, freqs []uint32) {
+			if pos == 1000 {
+				f(freqs)
+			}
+		}
+
+		buffer := make([]byte, 2*len(input)+1024)
+		oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+		obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 16384)
+		ee, _ := entropy.NewOrder1RangeEncoder(obs)
+		ee.SetCheckModels(true)
+		ee.SetModelFault(hook)
+		_, errEnc := ee.Encode(input)
+
+		iFile, _ := util.NewByteArrayInputStream(encoded, true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+		ed, _ := entropy.NewOrder1RangeDecoder(ibs)
+		ed.SetCheckModels(true)
+		ed.SetModelFault(hook)
+		_, errDec := ed.Decode(make([]byte, len(input)))
+
+		for _, err := range []error{errEnc, errDec} {
+			if err == nil || strings.Contains(err.Error(), "after byte 1000 ") == false {
+				fmt.Printf("Fault %v not reported after byte 1000: %v\n", ii, err)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("Fault %v: %v\n", ii, errDec)
+	}
+}
+
+// The range is always above BOTTOM_RANGE before the division by the total:
+// states below are rejected, extreme probabilities (rare symbols after long
+// runs, minimal ranges after normalization) still round trip
+func TestRangeInvariant() {
+	fmt.Printf("\n\nRange invariant test\n")
+	maxTotal := uint64(1<<16 + 256)
+
+	if r, err := entropy.ScaleRange(entropy.BOTTOM_RANGE+1, maxTotal); err != nil || r == 0 {
+		fmt.Printf("Invalid scaling of the smallest range: %v %v\n", r, err)
+		os.Exit(1)
+	}
+
+	if r, err := entropy.ScaleRange(entropy.TOP_RANGE, 1<<16); err != nil || r != entropy.TOP_RANGE>>16 {
+		fmt.Printf("Invalid scaling of the largest range: %v %v\n", r, err)
+		os.Exit(1)
+	}
+
+	for _, state := range [][2]uint64{{entropy.BOTTOM_RANGE, 256}, {maxTotal - 1, maxTotal}, {0, 256},
+		{entropy.TOP_RANGE, 0}, {entropy.TOP_RANGE, 1 << 20}} {
+		if _, err := entropy.ScaleRange(state[0], state[1]); err == nil {
+			fmt.Printf("Invalid state not detected: range %#x, total %v\n", state[0], state[1])
+			os.Exit(1)
+		} else {
+			fmt.Printf("%v\n", err)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		// Long runs (frequencies near the maximum total) broken by rare bytes
+		block := make([]byte, 50000+rnd.Intn(50000))
+
+		for i := range block {
+			if rnd.Intn(5000) == 0 {
+				block[i] = byte(rnd.Intn(256))
+			} else {
+				block[i] = byte(ii)
+			}
+		}
+
+		for _, codec := range []byte{entropy.ORDER1_TYPE, entropy.MIXED_TYPE} {
+			if bytes.Equal(decode(encode(block, codec), len(block), codec), block) == false {
+				fmt.Printf("Different (%v)\n", entropy.GetEntropyCodecName(codec))
+				os.Exit(1)
+			}
+		}
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// The bitstream format must not change (EG. with a new model implementation):
+// compare the checksums of the encodings of fixed inputs with reference values
+func TestBitPattern() {
+	fmt.Printf("\n\nBit pattern test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	text := generateText(300000, rnd)
+	binary := make([]byte, 300000)
+
+	for i := range binary {
+		binary[i] = byte(rnd.Intn(1 + (i>>8)&255))
+	}
+
+	expected := []uint32{0xeccbaa49, 0x453043e6}
+
+	for i, block := range [][]byte{text, binary} {
+		encoded := encode(block, entropy.ORDER1_TYPE)
+		checksum := crc32.ChecksumIEEE(encoded)
+		fmt.Printf("Test %v: %v => %v bytes, checksum %x\n", i, len(block), len(encoded), checksum)
+
+		if checksum != expected[i] {
+			fmt.Printf("Different bit pattern (expected checksum %x)\n", expected[i])
+			os.Exit(1)
+		}
+
+		if bytes.Equal(decode(encoded, len(block), entropy.ORDER1_TYPE), block) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+	}
+}
+
+func TestSpeed() {
+	iter := 100
+	size := 500000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	text := generateText(size, rand.New(rand.NewSource(12345)))
+	delta1 := int64(0)
+	delta2 := int64(0)
+	var encoded, decoded []byte
+
+	for ii := 0; ii < iter; ii++ {
+		before := time.Now()
+		encoded = encode(text, entropy.ORDER1_TYPE)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		before = time.Now()
+		decoded = decode(encoded, size, entropy.ORDER1_TYPE)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(text, decoded) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("Order1Range encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s]        : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("Order1Range decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s]        : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	kio "kanzi/io"
+	"kanzi/util"
+	"math/rand"
+	"os"
+	"runtime"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestParallelCodec\n")
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	TestCorrectness()
+	TestSequential()
+	TestErrors()
+	TestSpeed()
+}
+
+type byteOutputStream struct {
+	buffer bytes.Buffer
+}
+
+func (this *byteOutputStream) Write(b []byte) (int, error) {
+	return this.buffer.Write(b)
+}
+
+func (this *byteOutputStream) Close() error {
+	return nil
+}
+
+// Text like data with runs and random sections so that blocks compress
+// differently (and workers complete out of order)
+func generateData(size int, rnd *rand.Rand) []byte {
+	words := []string{"the ", "quick ", "brown ", "fox ", "jumps ", "over ", "lazy ", "dog ", "\n"}
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		switch rnd.Intn(3) {
+		case 0:
+			for i := rnd.Intn(500); i > 0; i-- {
+				buf.WriteString(words[rnd.Intn(len(words))])
+			}
+
+		case 1:
+			b := byte(rnd.Intn(256))
+
+			for i := rnd.Intn(2000); i > 0; i-- {
+				buf.WriteByte(b)
+			}
+
+		default:
+			for i := rnd.Intn(3000); i > 0; i-- {
+				buf.WriteByte(byte(rnd.Intn(256)))
+			}
+		}
+	}
+
+	return append([]byte{}, buf.Bytes()[0:size]...)
+}
+
+// Write the data in chunks of random sizes
+func encode(data []byte, codec string, blockSize, jobs uint, rnd *rand.Rand) ([]byte, error) {
+	os := &byteOutputStream{}
+	pe, err := kio.NewOrderedParallelEncoder(codec, os, blockSize, jobs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for off := 0; off < len(data); {
+		end := off + 1 + rnd.Intn(3*int(blockSize))
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if _, err = pe.Write(data[off:end]); err != nil {
+			return nil, err
+		}
+
+		off = end
+	}
+
+	if err = pe.Close(); err != nil {
+		return nil, err
+	}
+
+	return os.buffer.Bytes(), nil
+}
+
+// Read the data in chunks of random sizes
+func decode(data []byte, jobs uint, rnd *rand.Rand) ([]byte, error) {
+	is, _ := util.NewByteArrayInputStream(data, false)
+	pd, err := kio.NewOrderedParallelDecoder(is, jobs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer pd.Close()
+	var res []byte
+	buf := make([]byte, 100000)
+
+	for {
+		n, err := pd.Read(buf[0 : 1+rnd.Intn(len(buf))])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n <= 0 {
+			// End of stream
+			return res, nil
+		}
+
+		res = append(res, buf[0:n]...)
+	}
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	codecs := []string{"None", "Huffman", "ANS", "Range", "FPAQ", "PAQ", "CM"}
+
+	for ii, codec := range codecs {
+		for _, jobs := range []uint{1, 2, 4, 8} {
+			size := rnd.Intn(300000)
+
+			if ii == 0 && jobs == 1 {
+				size = 0
+			}
+
+			blockSize := uint(1024 + rnd.Intn(32768))
+			input := generateData(size, rnd)
+			encoded, err := encode(input, codec, blockSize, jobs, rnd)
+
+			if err != nil {
+				fmt.Printf("Encoding error (%v, %v jobs): %v\n", codec, jobs, err)
+				os.Exit(1)
+			}
+
+			decoded, err := decode(encoded, uint(1+rnd.Intn(8)), rnd)
+
+			if err != nil {
+				fmt.Printf("Decoding error (%v, %v jobs): %v\n", codec, jobs, err)
+				os.Exit(1)
+			}
+
+			if bytes.Equal(input, decoded) == false {
+				fmt.Printf("Different (%v, %v jobs, block size %v)\n", codec, jobs, blockSize)
+				os.Exit(1)
+			}
+
+			fmt.Printf("%-8v jobs=%v block=%-6v: %v => %v Identical\n", codec, jobs, blockSize, size, len(encoded))
+		}
+	}
+}
+
+// The blocks are cut at fixed offsets and coded independently: the output
+// must not depend on the number of jobs nor on the sizes of the writes
+func TestSequential() {
+	fmt.Printf("\n\nSequential equivalence test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := generateData(500000, rnd)
+
+	for _, codec := range []string{"Huffman", "Range", "FPAQ"} {
+		blockSize := uint(1024 + rnd.Intn(65536))
+		sequential, err := encode(input, codec, blockSize, 1, rnd)
+
+		if err != nil {
+			fmt.Printf("Encoding error (%v, 1 job): %v\n", codec, err)
+			os.Exit(1)
+		}
+
+		for _, jobs := range []uint{2, 4, 8, 16} {
+			parallel, err := encode(input, codec, blockSize, jobs, rnd)
+
+			if err != nil {
+				fmt.Printf("Encoding error (%v, %v jobs): %v\n", codec, jobs, err)
+				os.Exit(1)
+			}
+
+			if bytes.Equal(sequential, parallel) == false {
+				fmt.Printf("Different from the sequential output (%v, %v jobs, block size %v)\n",
+					codec, jobs, blockSize)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("%-8v block=%-6v: %v => %v Identical\n", codec, blockSize, len(input), len(sequential))
+	}
+}
+
+func TestErrors() {
+	fmt.Printf("\n\nError test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateData(200000, rnd)
+	encoded, _ := encode(input, "Huffman", 16384, 4, rnd)
+
+	// Truncated stream: the blocks before the truncation are returned first
+	is, _ := util.NewByteArrayInputStream(encoded[0:len(encoded)/2], false)
+	pd, _ := kio.NewOrderedParallelDecoder(is, 4)
+	buf := make([]byte, len(input))
+	read := 0
+	var err error
+
+	for err == nil {
+		var n int
+
+		if n, err = pd.Read(buf[read:]); n <= 0 && err == nil {
+			break
+		}
+
+		read += n
+	}
+
+	pd.Close()
+	fmt.Printf("Truncated stream: read %v bytes, error: %v\n", read, err)
+
+	if err == nil || bytes.Equal(input[0:read], buf[0:read]) == false {
+		fmt.Printf("Truncated stream not detected or invalid data\n")
+		os.Exit(1)
+	}
+
+	// Invalid stream type
+	if _, err := decode([]byte("KANZ0123456789"), 2, rnd); err == nil {
+		fmt.Printf("Invalid stream type not detected\n")
+		os.Exit(1)
+	}
+
+	if _, err := kio.NewOrderedParallelEncoder("Huffman", &byteOutputStream{}, 1024, 17); err == nil {
+		fmt.Printf("Invalid number of jobs not detected\n")
+		os.Exit(1)
+	}
+
+	// Early close of the decoder with blocks in flight
+	is, _ = util.NewByteArrayInputStream(encoded, false)
+	pd, _ = kio.NewOrderedParallelDecoder(is, 4)
+	pd.Read(buf[0:10])
+	pd.Close()
+	fmt.Printf("OK\n")
+}
+
+func TestSpeed() {
+	size := 16 * 1024 * 1024
+	blockSize := uint(1024 * 1024)
+	fmt.Printf("\n\nSpeed test (%v MB, %v CPUs)\n", size>>20, runtime.NumCPU())
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateData(size, rnd)
+	maxJobs := runtime.NumCPU()
+
+	if maxJobs > 8 {
+		maxJobs = 8
+	}
+
+	for _, codec := range []string{"Huffman", "FPAQ"} {
+		delta1 := int64(0)
+
+		for _, jobs := range []int{1, 2, 4, 8} {
+			before := time.Now()
+			encoded, err1 := encode(input, codec, blockSize, uint(jobs), rnd)
+			after := time.Now()
+			deltaE := after.Sub(before).Nanoseconds()
+			before = time.Now()
+			decoded, err2 := decode(encoded, uint(jobs), rnd)
+			after = time.Now()
+			deltaD := after.Sub(before).Nanoseconds()
+
+			if err1 != nil || err2 != nil {
+				fmt.Printf("Error: %v %v\n", err1, err2)
+				os.Exit(1)
+			}
+
+			if bytes.Equal(input, decoded) == false {
+				fmt.Printf("Different\n")
+				os.Exit(1)
+			}
+
+			if jobs == 1 {
+				delta1 = deltaE
+			}
+
+			speedup := float64(delta1) / float64(deltaE)
+			fmt.Printf("%-8v jobs=%v: encoding [ms]=%v, decoding [ms]=%v, speedup=%.2f\n",
+				codec, jobs, deltaE/1000000, deltaD/1000000, speedup)
+
+			// Only meaningful with several CPUs
+			if jobs == maxJobs && jobs >= 2 && speedup < 1.2 {
+				fmt.Printf("No speedup with %v jobs\n", jobs)
+				os.Exit(1)
+			}
+		}
+	}
+}
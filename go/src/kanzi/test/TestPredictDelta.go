@@ -0,0 +1,248 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestPredictDelta\n")
+	TestCorrectness()
+	TestRatio()
+	TestSpeed()
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Example from the doc comment
+	{
+		input := []byte{100, 102, 101, 103}
+		expected := []byte{100, 52, 25, 14}
+		pd, _ := function.NewPredictDelta(0, 1)
+		output := make([]byte, len(input))
+		pd.Forward(input, output)
+
+		if bytes.Equal(output, expected) == false {
+			fmt.Printf("Invalid encoding: %v (expected %v)\n", output, expected)
+			os.Exit(1)
+		}
+	}
+
+	// Shift 0 is a plain delta
+	{
+		input := []byte{10, 12, 9, 200, 3}
+		expected := []byte{10, 2, 253, 191, 59}
+		pd, _ := function.NewPredictDelta(0, 0)
+		output := make([]byte, len(input))
+		pd.Forward(input, output)
+
+		if bytes.Equal(output, expected) == false {
+			fmt.Printf("Invalid encoding: %v (expected %v)\n", output, expected)
+			os.Exit(1)
+		}
+	}
+
+	for ii := 0; ii < 20; ii++ {
+		shift := uint(rnd.Intn(function.MAX_PREDICT_DELTA_SHIFT + 1))
+		size := uint(rnd.Intn(64))
+		input := make([]byte, size)
+
+		switch ii {
+		case 0:
+			size = 0
+			input = input[0:0]
+
+		case 1:
+			// Extreme differences (wrap around)
+			for i := range input {
+				input[i] = byte(255 * (i & 1))
+			}
+
+		default:
+			for i := range input {
+				input[i] = byte(rnd.Intn(256))
+			}
+		}
+
+		fmt.Printf("\nTest %v (shift %v, size %v)\n", ii, shift, size)
+		pd, _ := function.NewPredictDelta(0, shift)
+		output := make([]byte, pd.MaxEncodedLen(int(size)))
+		reverse := make([]byte, size)
+		_, dstIdx, err := pd.Forward(input, output)
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Original: %v\n", input)
+		fmt.Printf("Coded:    %v\n", output[0:dstIdx])
+		pd, _ = function.NewPredictDelta(dstIdx, shift)
+		_, oIdx, err := pd.Inverse(output, reverse)
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Decoded:  %v\n", reverse[0:oIdx])
+
+		if oIdx != size || bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+
+	// Long random blocks, all shifts
+	input := make([]byte, 100000)
+	output := make([]byte, len(input))
+	reverse := make([]byte, len(input))
+
+	for i := range input {
+		input[i] = byte(rnd.Intn(256))
+	}
+
+	for shift := uint(0); shift <= function.MAX_PREDICT_DELTA_SHIFT; shift++ {
+		pd, _ := function.NewPredictDelta(0, shift)
+		pd.Forward(input, output)
+		pd.Inverse(output, reverse)
+
+		if bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different for shift %v\n", shift)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := function.NewPredictDelta(0, function.MAX_PREDICT_DELTA_SHIFT+1); err == nil {
+		fmt.Printf("Invalid shift not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Noisy samples around a slowly drifting baseline (EG. sensor readings)
+func generateDrifting(size int, rnd *rand.Rand) []byte {
+	res := make([]byte, size)
+	baseline := 128.0
+	drift := 0.0
+
+	for i := range res {
+		if i%500 == 0 {
+			drift = (rnd.Float64() - 0.5) / 50
+		}
+
+		baseline += drift
+
+		if baseline < 20 || baseline > 235 {
+			drift = -drift
+		}
+
+		res[i] = byte(int(baseline) + rnd.Intn(9) - 4)
+	}
+
+	return res
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (drifting data)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateDrifting(500000, rnd)
+	delta, _ := function.NewPredictDelta(0, 0)
+	predict, _ := function.NewPredictDelta(0, function.DEFAULT_PREDICT_DELTA_SHIFT)
+	output1 := make([]byte, len(input))
+	output2 := make([]byte, len(input))
+	delta.Forward(input, output1)
+	predict.Forward(input, output2)
+
+	for _, codec := range []string{"Huffman", "Range", "FPAQ"} {
+		raw, err1 := kio.Compress(input, codec, "None", 1<<20)
+		coded1, err2 := kio.Compress(output1, codec, "None", 1<<20)
+		coded2, err3 := kio.Compress(output2, codec, "None", 1<<20)
+
+		if err1 != nil || err2 != nil || err3 != nil {
+			fmt.Printf("Compression error: %v %v %v\n", err1, err2, err3)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: raw=%v bytes, delta=%v bytes, moving average=%v bytes\n",
+			codec, len(raw), len(coded1), len(coded2))
+
+		if len(coded2) >= len(coded1) {
+			fmt.Printf("No compression improvement over the plain delta\n")
+			os.Exit(1)
+		}
+	}
+
+	// Round trip through the stream
+	compressed, err := kio.Compress(input, "Huffman", "PredictDelta", 1<<18)
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	res, err := kio.Decompress(compressed)
+
+	if err != nil || bytes.Equal(input, res) == false {
+		fmt.Printf("Different (stream): %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func TestSpeed() {
+	iter := 5000
+	size := 50000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := generateDrifting(size, rand.New(rand.NewSource(12345)))
+	pd, _ := function.NewPredictDelta(0, function.DEFAULT_PREDICT_DELTA_SHIFT)
+	output := make([]byte, pd.MaxEncodedLen(size))
+	reverse := make([]byte, size)
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		before := time.Now()
+		pd.Forward(input, output)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		before = time.Now()
+		pd.Inverse(output, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(size)
+	fmt.Printf("PredictDelta encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s]         : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("PredictDelta decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s]         : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
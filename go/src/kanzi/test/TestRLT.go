@@ -16,6 +16,7 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"kanzi/function"
 	"math/rand"
@@ -26,6 +27,8 @@ import (
 func main() {
 	fmt.Printf("TestRLT\n")
 	TestCorrectness()
+	TestRoundTrip()
+	TestSmallOutput()
 	TestSpeed()
 }
 
@@ -145,6 +148,160 @@ func TestCorrectness() {
 	}
 }
 
+// Round trip with an output buffer of MaxEncodedLen bytes
+func roundTrip(input []byte, threshold uint) (uint, error) {
+	rlt, _ := function.NewRLT(0, threshold)
+	output := make([]byte, rlt.MaxEncodedLen(len(input)))
+	srcIdx, dstIdx, err := rlt.Forward(input, output)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if srcIdx != uint(len(input)) {
+		return 0, fmt.Errorf("Incomplete encoding: %v bytes of %v", srcIdx, len(input))
+	}
+
+	reverse := make([]byte, len(input))
+	rlt, _ = function.NewRLT(dstIdx, threshold)
+
+	if _, _, err = rlt.Inverse(output, reverse); err != nil {
+		return 0, err
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		return 0, fmt.Errorf("Different")
+	}
+
+	return dstIdx, nil
+}
+
+func TestRoundTrip() {
+	fmt.Printf("\n\nRound trip test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, threshold := range []uint{2, 3, 8, 256} {
+		random := make([]byte, 50000)
+
+		for i := range random {
+			random[i] = byte(rnd.Intn(4))
+		}
+
+		// Runs much longer than RLT_MAX_RUN (several run records)
+		uniform := append(bytes.Repeat([]byte{7}, 3*function.RLT_MAX_RUN+100), 8)
+		noRun := make([]byte, 50000)
+
+		for i := range noRun {
+			noRun[i] = byte(i)
+		}
+
+		// Worst case: runs of exactly 'threshold' bytes
+		worst := make([]byte, 0, 50000)
+
+		for len(worst)+int(threshold) <= cap(worst) {
+			worst = append(worst, bytes.Repeat([]byte{byte(len(worst) / int(threshold))}, int(threshold))...)
+		}
+
+		inputs := [][]byte{{}, {5}, random, uniform, noRun, worst}
+		names := []string{"empty", "1 byte", "random", "uniform", "no run", "worst case"}
+
+		for i, input := range inputs {
+			dstIdx, err := roundTrip(input, threshold)
+
+			if err != nil {
+				fmt.Printf("Threshold %v, %v: %v\n", threshold, names[i], err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Threshold %-3v, %-10v: %v => %v bytes\n", threshold, names[i], len(input), dstIdx)
+		}
+	}
+
+	// Truncated input
+	rlt, _ := function.NewRLT(0, 3)
+
+	if _, _, err := rlt.Inverse([]byte{1, 1, 1}, make([]byte, 16)); err == nil {
+		fmt.Printf("Truncated input not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Return the error of Forward with an output buffer of 'size' bytes, or an
+// error reporting a panic
+func forwardTo(input []byte, threshold uint, size int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic: %v", r)
+		}
+	}()
+
+	rlt, _ := function.NewRLT(0, threshold)
+	_, _, err = rlt.Forward(input, make([]byte, size))
+
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*function.BufferTooSmallError); ok == false {
+		return fmt.Errorf("Unexpected error: %v", err)
+	}
+
+	return err
+}
+
+// Every output buffer smaller than the encoded size must be reported as too
+// small (not overrun)
+func TestSmallOutput() {
+	fmt.Printf("\n\nSmall output buffer test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, threshold := range []uint{2, 3, 8} {
+		random := make([]byte, 300)
+
+		for i := range random {
+			random[i] = byte(rnd.Intn(3))
+		}
+
+		// A final run with a 2 byte length
+		long := append([]byte{1, 2}, bytes.Repeat([]byte{3}, 1000)...)
+		inputs := [][]byte{random, long, bytes.Repeat([]byte{4, 4, 5}, 50)}
+
+		for _, input := range inputs {
+			rlt, _ := function.NewRLT(0, threshold)
+			output := make([]byte, rlt.MaxEncodedLen(len(input)))
+			_, encodedLen, err := rlt.Forward(input, output)
+
+			if err != nil {
+				fmt.Printf("Encoding error: %v\n", err)
+				os.Exit(1)
+			}
+
+			for size := 0; size < int(encodedLen); size++ {
+				err := forwardTo(input, threshold, size)
+
+				if err == nil {
+					fmt.Printf("Threshold %v: output buffer of %v bytes (need %v) not detected\n", threshold, size, encodedLen)
+					os.Exit(1)
+				}
+
+				if _, ok := err.(*function.BufferTooSmallError); ok == false {
+					fmt.Printf("Threshold %v: output buffer of %v bytes: %v\n", threshold, size, err)
+					os.Exit(1)
+				}
+			}
+
+			if err := forwardTo(input, threshold, int(encodedLen)); err != nil {
+				fmt.Printf("Threshold %v: exact output buffer: %v\n", threshold, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Threshold %-2v: %v => %v bytes, smaller buffers rejected\n", threshold, len(input), encodedLen)
+		}
+	}
+}
+
 func TestSpeed() {
 	iter := 50000
 	size := 50000
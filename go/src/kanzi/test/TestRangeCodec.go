@@ -16,18 +16,54 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"kanzi/bitstream"
 	"kanzi/entropy"
 	"kanzi/util"
+	"math"
 	"math/rand"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
 func main() {
 	TestCorrectness()
 	TestSpeed()
+	TestRunSpeed()
+	TestLargeBlock()
+	TestReset()
+	TestStream()
+	TestStreamReads()
+	TestMultiStream()
+	TestFrequencies()
+	TestWideAlphabet()
+	TestBitPattern()
+	TestWriteError()
+	TestBound()
+	TestWritten()
+	TestModel()
+	TestDecodeN()
+	TestTruncated()
+	TestSnapshot()
+	TestEncodeFrom()
+	TestHistogram()
+	TestTermination()
+	TestSegments()
+	TestEncodeCounted()
+	TestWordSize()
+	TestEstimateSize()
+	TestDecodeAll()
+	TestFlush()
+	TestEncodeSpeed()
 }
 
 func TestCorrectness() {
@@ -219,3 +255,2041 @@ func TestSpeed() {
 		fmt.Printf("Throughput [KB/s]: %d\n", (int64(iter*size))*1000000/delta2*1000/1024)
 	}
 }
+
+// Long runs of a single non zero byte (the encoder looks up the symbol
+// interval once per run)
+func TestRunSpeed() {
+	fmt.Printf("\n\nSpeed test (runs)\n")
+	delta1 := int64(0)
+	delta2 := int64(0)
+	iter := 4000
+	size := 50000
+	buffer := make([]byte, size*2)
+	values1 := make([]byte, size)
+	values2 := make([]byte, size)
+	rnd := rand.New(rand.NewSource(12345))
+
+	for i := 0; i < size; {
+		b := byte(1 + rnd.Intn(8))
+
+		for n := 64 + rnd.Intn(1024); n > 0 && i < size; n-- {
+			values1[i] = b
+			i++
+		}
+	}
+
+	for ii := 0; ii < iter; ii++ {
+		oFile, _ := util.NewByteArrayOutputStream(buffer, false)
+		obs, _ := bitstream.NewDefaultOutputBitStream(oFile, uint(size))
+		rc, _ := entropy.NewRangeEncoder(obs)
+		before := time.Now()
+
+		if _, err := rc.Encode(values1); err != nil {
+			fmt.Printf("An error occured during encoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		rc.Dispose()
+
+		if _, err := obs.Close(); err != nil {
+			fmt.Printf("Error during close: %v\n", err)
+			os.Exit(1)
+		}
+
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+	}
+
+	for ii := 0; ii < iter; ii++ {
+		iFile, _ := util.NewByteArrayInputStream(buffer, false)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, uint(size))
+		rd, _ := entropy.NewRangeDecoder(ibs)
+		before := time.Now()
+
+		if _, err := rd.Decode(values2); err != nil {
+			fmt.Printf("An error occured during decoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		rd.Dispose()
+		after := time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	for i := range values1 {
+		if values1[i] != values2[i] {
+			fmt.Printf("Different at index %v\n", i)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Encode [ms]      : %d\n", delta1/1000000)
+	fmt.Printf("Throughput [KB/s]: %d\n", (int64(iter*size))*1000000/delta1*1000/1024)
+	fmt.Printf("Decode [ms]      : %d\n", delta2/1000000)
+	fmt.Printf("Throughput [KB/s]: %d\n", (int64(iter*size))*1000000/delta2*1000/1024)
+}
+
+// 50 MB of skewed data coded as a single chunk: the frequencies are
+// normalized to 1<<logRange for each chunk, hence the totals do not grow with
+// the block size. The coded size must stay close to the order 0 entropy.
+func TestLargeBlock() {
+	fmt.Printf("\n\nLarge block test\n")
+	size := 50 * 1024 * 1024
+	values1 := make([]byte, size)
+	values2 := make([]byte, size)
+	rnd := rand.New(rand.NewSource(12345))
+	freqs := make([]int, 256)
+
+	for i := range values1 {
+		// Geometric distribution
+		b := 0
+
+		for b < 255 && rnd.Intn(4) != 0 {
+			b++
+		}
+
+		values1[i] = byte(b)
+		freqs[b]++
+	}
+
+	entropy0 := 0.0
+
+	for _, f := range freqs {
+		if f > 0 {
+			p := float64(f) / float64(size)
+			entropy0 -= p * math.Log2(p)
+		}
+	}
+
+	var buffer bytes.Buffer
+	oFile := &bufferOutputStream{buffer: &buffer}
+	obs, _ := bitstream.NewDefaultOutputBitStream(oFile, 65536)
+	rc, _ := entropy.NewRangeEncoder(obs, 0, 15)
+
+	if _, err := rc.Encode(values1); err != nil {
+		fmt.Printf("An error occured during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	rc.Dispose()
+	obs.Close()
+
+	if _, err := entropy.NewRangeEncoder(obs, 0, 16); err == nil {
+		fmt.Printf("Invalid log range not detected\n")
+		os.Exit(1)
+	}
+
+	iFile, _ := util.NewByteArrayInputStream(buffer.Bytes(), true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 65536)
+	rd, _ := entropy.NewRangeDecoder(ibs, 0)
+
+	if _, err := rd.Decode(values2); err != nil {
+		fmt.Printf("An error occured during decoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	rd.Dispose()
+
+	if bytes.Equal(values1, values2) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	bound := int(entropy0 * float64(size) / 8)
+	fmt.Printf("Size: %v => %v bytes (order 0 entropy: %v bytes)\n", size, buffer.Len(), bound)
+
+	if buffer.Len() > bound+bound/100 {
+		fmt.Printf("Coded size too far from the entropy\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+type bufferOutputStream struct {
+	buffer *bytes.Buffer
+}
+
+func (this *bufferOutputStream) Write(b []byte) (int, error) {
+	return this.buffer.Write(b)
+}
+
+func (this *bufferOutputStream) Close() error {
+	return nil
+}
+
+// Input stream returning an error at the end of the data (no padding)
+type readerInputStream struct {
+	reader io.Reader
+}
+
+func (this *readerInputStream) Read(b []byte) (int, error) {
+	return this.reader.Read(b)
+}
+
+func (this *readerInputStream) Close() error {
+	return nil
+}
+
+func encodeBlock(rc *entropy.RangeEncoder, obs *bitstream.DefaultOutputBitStream, block []byte) []byte {
+	var buffer bytes.Buffer
+
+	if rc == nil {
+		obs, _ = bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+		rc, _ = entropy.NewRangeEncoder(obs)
+	} else {
+		obs.Reset(&bufferOutputStream{buffer: &buffer})
+		rc.Reset()
+	}
+
+	if _, err := rc.Encode(block); err != nil {
+		fmt.Printf("An error occured during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	obs.Close()
+	return buffer.Bytes()
+}
+
+// One encoder and one decoder reused for independent blocks: same output as
+// fresh codecs
+func TestReset() {
+	fmt.Printf("\n\nReset test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	blocks := [][]byte{make([]byte, 100000), make([]byte, 3000), make([]byte, 70000)}
+
+	for i := range blocks[0] {
+		blocks[0][i] = byte(rnd.Intn(256))
+	}
+
+	for i := range blocks[1] {
+		blocks[1][i] = byte(65 + rnd.Intn(4))
+	}
+
+	for i := range blocks[2] {
+		blocks[2][i] = byte(i >> 10)
+	}
+
+	var dummy bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &dummy}, 16384)
+	rc, _ := entropy.NewRangeEncoder(obs)
+	iFile, _ := util.NewByteArrayInputStream(make([]byte, 0), true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	rd, _ := entropy.NewRangeDecoder(ibs)
+
+	for n, block := range blocks {
+		encoded1 := encodeBlock(nil, nil, block)
+		encoded2 := encodeBlock(rc, obs, block)
+
+		if bytes.Equal(encoded1, encoded2) == false {
+			fmt.Printf("Block %v: different encodings with a reused encoder\n", n)
+			os.Exit(1)
+		}
+
+		iFile, _ := util.NewByteArrayInputStream(encoded2, true)
+		ibs.Reset(iFile)
+		rd.Reset()
+		decoded := make([]byte, len(block))
+
+		if _, err := rd.Decode(decoded); err != nil {
+			fmt.Printf("An error occured during decoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(block, decoded) == false {
+			fmt.Printf("Block %v: different\n", n)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Block %v: %v => %v bytes, identical\n", n, len(block), len(encoded2))
+	}
+}
+
+func TestStream() {
+	fmt.Printf("\n\nStream test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	file, err := ioutil.TempFile("", "TestRangeStream")
+
+	if err != nil {
+		fmt.Printf("Cannot create temporary file: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer os.Remove(file.Name())
+
+	// Several chunks and a partial last chunk
+	input := make([]byte, 3*65536+12345)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(4*(i/65536+1)))
+	}
+
+	// Many small writes
+	rw, _ := entropy.NewRangeWriter(file)
+	writes := 0
+
+	for n := 0; n < len(input); writes++ {
+		end := n + rnd.Intn(100)
+
+		if end > len(input) {
+			end = len(input)
+		}
+
+		if _, err := rw.Write(input[n:end]); err != nil {
+			fmt.Printf("An error occured during encoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		n = end
+	}
+
+	if err := rw.Close(); err != nil {
+		fmt.Printf("An error occured during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	info, _ := file.Stat()
+	file.Close()
+	fmt.Printf("%v writes: %v => %v bytes\n", writes, len(input), info.Size())
+	file, _ = os.Open(file.Name())
+	defer file.Close()
+	rr, _ := entropy.NewRangeReader(file)
+	var output bytes.Buffer
+
+	if _, err := io.Copy(&output, rr); err != nil {
+		fmt.Printf("An error occured during decoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	if bytes.Equal(input, output.Bytes()) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Read everything from r with reads of 'size' bytes
+func readAll(r io.Reader, size int) ([]byte, error) {
+	var res bytes.Buffer
+	buf := make([]byte, size)
+
+	for {
+		n, err := r.Read(buf)
+		res.Write(buf[0:n])
+
+		if err == io.EOF {
+			return res.Bytes(), nil
+		}
+
+		if err != nil {
+			return res.Bytes(), err
+		}
+	}
+}
+
+// Write each input as a separate range stream to the same buffer
+func writeStreams(inputs [][]byte) []byte {
+	var buffer bytes.Buffer
+
+	for _, input := range inputs {
+		rw, _ := entropy.NewRangeWriter(&buffer)
+
+		if _, err := rw.Write(input); err != nil {
+			fmt.Printf("An error occured during encoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := rw.Close(); err != nil {
+			fmt.Printf("An error occured during encoding: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+// Concatenated streams: read in sequence with multi stream enabled, only the
+// first one otherwise
+func TestMultiStream() {
+	fmt.Printf("\n\nMulti stream test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		inputs := make([][]byte, 1+rnd.Intn(3))
+		var all []byte
+
+		for i := range inputs {
+			inputs[i] = make([]byte, rnd.Intn(100000))
+
+			if ii == 0 && i == 0 {
+				inputs[i] = []byte{}
+			}
+
+			for j := range inputs[i] {
+				inputs[i][j] = byte(65 + rnd.Intn(1+i*10))
+			}
+
+			all = append(all, inputs[i]...)
+		}
+
+		encoded := writeStreams(inputs)
+
+		for _, multiStream := range []bool{true, false} {
+			rr, _ := entropy.NewRangeReader(bytes.NewReader(encoded))
+			rr.SetMultiStream(multiStream)
+			var output bytes.Buffer
+
+			if _, err := io.Copy(&output, rr); err != nil {
+				fmt.Printf("An error occured during decoding: %v\n", err)
+				os.Exit(1)
+			}
+
+			expected := inputs[0]
+
+			if multiStream == true {
+				expected = all
+			}
+
+			if bytes.Equal(expected, output.Bytes()) == false {
+				fmt.Printf("Different (%v streams, multi stream %v)\n", len(inputs), multiStream)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("Test %v: %v streams, %v => %v bytes\n", ii, len(inputs), len(all), len(encoded))
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+func TestStreamReads() {
+	fmt.Printf("\n\nStream read test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 2*65536+777)
+
+	for i := range input {
+		input[i] = byte(rnd.Intn(16))
+	}
+
+	var encoded bytes.Buffer
+	rw, _ := entropy.NewRangeWriter(&encoded)
+	rw.Write(input)
+	rw.Close()
+
+	// 1 byte reads, large reads (more than the whole data), reads straddling
+	// the end of the stream (the last read is short)
+	for _, size := range []int{1, 7, 65536, 1 << 20} {
+		rr, _ := entropy.NewRangeReader(bytes.NewReader(encoded.Bytes()))
+		output, err := readAll(rr, size)
+
+		if err != nil {
+			fmt.Printf("Reads of %v bytes: error %v\n", size, err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(input, output) == false {
+			fmt.Printf("Reads of %v bytes: different\n", size)
+			os.Exit(1)
+		}
+
+		// Still at end of stream, matchable with errors.Is
+		if n, err := rr.Read(make([]byte, 10)); n != 0 || errors.Is(err, entropy.ErrEndOfStream) == false {
+			fmt.Printf("Reads of %v bytes: no EOF after the end of stream\n", size)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Reads of %v bytes: identical\n", size)
+	}
+
+	// Through a bufio.Reader
+	rr, _ := entropy.NewRangeReader(bytes.NewReader(encoded.Bytes()))
+	br := bufio.NewReader(rr)
+
+	for i := range input {
+		b, err := br.ReadByte()
+
+		if err != nil || b != input[i] {
+			fmt.Printf("bufio: different at index %v (%v)\n", i, err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := br.ReadByte(); err != io.EOF {
+		fmt.Printf("bufio: no EOF after the end of stream\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("bufio reads: identical\n")
+
+	// Truncated stream: not a clean end of stream
+	rr, _ = entropy.NewRangeReader(bytes.NewReader(encoded.Bytes()[0 : encoded.Len()/2]))
+
+	if _, err := readAll(rr, 4096); errors.Is(err, entropy.ErrCorruptStream) == false ||
+		errors.Is(err, io.ErrUnexpectedEOF) == false {
+		fmt.Printf("Truncated stream not detected: %v\n", err)
+		os.Exit(1)
+	} else {
+		fmt.Printf("Truncated stream: %v\n", err)
+	}
+
+	// Corrupted chunk length
+	corrupted := append([]byte{}, encoded.Bytes()...)
+	corrupted[0] = 0xFF
+	rr, _ = entropy.NewRangeReader(bytes.NewReader(corrupted))
+
+	if _, err := readAll(rr, 4096); errors.Is(err, entropy.ErrCorruptStream) == false {
+		fmt.Printf("Corrupted stream not detected: %v\n", err)
+		os.Exit(1)
+	} else {
+		fmt.Printf("Corrupted stream: %v\n", err)
+	}
+}
+
+func TestFrequencies() {
+	fmt.Printf("\n\nFrequencies test\n")
+	var encoded bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &encoded}, 16384)
+	re, _ := entropy.NewRangeEncoder(obs, 0, 12)
+
+	if freqs := re.Frequencies(); len(freqs) != 256 || freqs['a'] != 0 {
+		fmt.Printf("Invalid initial frequencies\n")
+		os.Exit(1)
+	}
+
+	// 'a': 1/2, 'b': 1/4, 'c' and 'd': 1/8
+	block := bytes.Repeat([]byte("aaaabbcd"), 8192)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rnd.Shuffle(len(block), func(i, j int) { block[i], block[j] = block[j], block[i] })
+	re.Encode(block)
+	freqs := re.Frequencies()
+	expected := map[int]int{'a': 2048, 'b': 1024, 'c': 512, 'd': 512}
+	sum := 0
+
+	for i, f := range freqs {
+		sum += f
+
+		if f != expected[i] {
+			fmt.Printf("Invalid frequency for symbol %v: %v (expected %v)\n", i, f, expected[i])
+			os.Exit(1)
+		}
+	}
+
+	// Estimated size of the block from the entropy of the model
+	bits := 0.0
+
+	for _, b := range block {
+		bits -= math.Log2(float64(freqs[b]) / float64(sum))
+	}
+
+	fmt.Printf("a=%v b=%v c=%v d=%v, estimated size: %v bytes\n", freqs['a'], freqs['b'],
+		freqs['c'], freqs['d'], int(bits/8))
+
+	// Read only copy
+	freqs['a'] = 0
+
+	if re.Frequencies()['a'] != 2048 {
+		fmt.Printf("The encoder frequencies were modified\n")
+		os.Exit(1)
+	}
+
+	// The encoded block is not affected
+	re.Dispose()
+	obs.Close()
+	iFile, _ := util.NewByteArrayInputStream(encoded.Bytes(), true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	rd, _ := entropy.NewRangeDecoder(ibs, 0)
+	decoded := make([]byte, len(block))
+
+	if _, err := rd.Decode(decoded); err != nil || bytes.Equal(block, decoded) == false {
+		fmt.Printf("Different (%v)\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Range coding of alphabets larger than 256 symbols
+func TestWideAlphabet() {
+	fmt.Printf("\n\nWide alphabet test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, nbSymbols := range []uint{1024, 65537} {
+		// Zipf distributed symbols (EG. word indexes), an empty block, a
+		// single symbol block and the extreme symbols
+		zipf := rand.NewZipf(rnd, 1.1, 1, uint64(nbSymbols-1))
+		blocks := [][]int{make([]int, 300000), {}, {int(nbSymbols) - 1}, {0, int(nbSymbols) - 1, 0}}
+
+		for i := range blocks[0] {
+			blocks[0][i] = int(zipf.Uint64())
+		}
+
+		var encoded bytes.Buffer
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &encoded}, 16384)
+		re, err := entropy.NewRangeEncoderN(obs, nbSymbols)
+
+		if err != nil {
+			fmt.Printf("Cannot create the encoder: %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, block := range blocks {
+			if _, err := re.EncodeSymbols(block); err != nil {
+				fmt.Printf("An error occured during encoding: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		re.Dispose()
+		obs.Close()
+		iFile, _ := util.NewByteArrayInputStream(encoded.Bytes(), true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+		rd, _ := entropy.NewRangeDecoderN(ibs, nbSymbols)
+
+		for n, block := range blocks {
+			decoded := make([]int, len(block))
+
+			if _, err := rd.DecodeSymbols(decoded); err != nil {
+				fmt.Printf("An error occured during decoding: %v\n", err)
+				os.Exit(1)
+			}
+
+			for i := range block {
+				if block[i] != decoded[i] {
+					fmt.Printf("Block %v: different at index %v (%v <-> %v)\n", n, i, block[i], decoded[i])
+					os.Exit(1)
+				}
+			}
+		}
+
+		// Order 0 entropy of the first block
+		counts := make(map[int]int)
+
+		for _, s := range blocks[0] {
+			counts[s]++
+		}
+
+		bits := 0.0
+
+		for _, c := range counts {
+			p := float64(c) / float64(len(blocks[0]))
+			bits -= float64(c) * math.Log2(p)
+		}
+
+		fmt.Printf("%v symbols: %v symbols (%v distinct) => %v bytes (entropy: %v bytes), identical\n",
+			nbSymbols, len(blocks[0]), len(counts), encoded.Len(), int(bits/8))
+
+		// The header (symbols and frequencies) is included
+		if float64(encoded.Len()) > bits/8*1.05+float64(6*len(counts)) {
+			fmt.Printf("Too far from the entropy\n")
+			os.Exit(1)
+		}
+
+		if _, err := re.EncodeSymbols([]int{int(nbSymbols)}); err == nil {
+			fmt.Printf("Invalid symbol not detected\n")
+			os.Exit(1)
+		}
+	}
+
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: new(bytes.Buffer)}, 16384)
+
+	if _, err := entropy.NewRangeEncoderN(obs, 1<<21); err == nil {
+		fmt.Printf("Invalid number of symbols not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Encode with the provided chunk size and log range
+func encodeWith(block []byte, args ...uint) []byte {
+	var buffer bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+	rc, _ := entropy.NewRangeEncoder(obs, args...)
+
+	if _, err := rc.Encode(block); err != nil {
+		fmt.Printf("An error occured during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	rc.Dispose()
+	obs.Close()
+	return buffer.Bytes()
+}
+
+// The bitstream format must not change: compare the checksums of the
+// encodings of fixed inputs with reference values
+func TestBitPattern() {
+	fmt.Printf("\n\nBit pattern test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	block := make([]byte, 300000)
+
+	for i := range block {
+		if i < len(block)/2 {
+			block[i] = byte(rnd.Intn(256))
+		} else {
+			block[i] = byte(32 + rnd.Intn(1+(i&63)))
+		}
+	}
+
+	expected := []uint32{0x4e0779b0, 0x6b383391, 0xa5362f55}
+
+	for i, args := range [][]uint{{}, {0, 15}, {1024, 8}} {
+		encoded := encodeWith(block, args...)
+		checksum := crc32.ChecksumIEEE(encoded)
+		fmt.Printf("Test %v: %v => %v bytes, checksum %x\n", i, len(block), len(encoded), checksum)
+
+		if checksum != expected[i] {
+			fmt.Printf("Different bit pattern (expected checksum %x)\n", expected[i])
+			os.Exit(1)
+		}
+	}
+}
+
+// Output stream failing after 'limit' bytes (EG. disk full)
+type failingOutputStream struct {
+	limit int
+}
+
+func (this *failingOutputStream) Write(b []byte) (int, error) {
+	if len(b) > this.limit {
+		n := this.limit
+		this.limit = 0
+		return n, errors.New("No space left on device")
+	}
+
+	this.limit -= len(b)
+	return len(b), nil
+}
+
+func (this *failingOutputStream) Close() error {
+	return nil
+}
+
+// A write error of the underlying stream is returned by Encode
+func TestWriteError() {
+	fmt.Printf("\n\nWrite error test\n")
+	rnd := rand.New(rand.NewSource(12345))
+	block := make([]byte, 200000)
+
+	for i := range block {
+		block[i] = byte(rnd.Intn(256))
+	}
+
+	obs, _ := bitstream.NewDefaultOutputBitStream(&failingOutputStream{limit: 50000}, 16384)
+	rc, _ := entropy.NewRangeEncoder(obs)
+	_, err := rc.Encode(block)
+	fmt.Printf("Error: %v\n", err)
+
+	if err == nil {
+		fmt.Printf("Write error not reported\n")
+		os.Exit(1)
+	}
+
+	// Error while flushing the last bytes (on close)
+	obs, _ = bitstream.NewDefaultOutputBitStream(&failingOutputStream{limit: 100}, 16384)
+	rc, _ = entropy.NewRangeEncoder(obs)
+
+	if _, err = rc.Encode(block[0:1000]); err != nil {
+		fmt.Printf("Unexpected error: %v\n", err)
+		os.Exit(1)
+	}
+
+	rc.Dispose()
+
+	if _, err = obs.Close(); err == nil {
+		fmt.Printf("Write error on close not reported\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// The size of the encoded data never exceeds RangeEncodedBound: random data,
+// sizes around the chunk size, headers with large frequencies in each group
+func TestBound() {
+	fmt.Printf("\n\nBound test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	worst := 0.0
+
+	for _, size := range []int{1, 2, 100, 256, 1023, 1024, 1025, 5000, 65536, 65537, 300000} {
+		for _, args := range [][]uint{{}, {0, 15}, {0, 8}, {1024, 15}, {1024, 8}, {4096, 13}} {
+			for kind := 0; kind < 3; kind++ {
+				block := make([]byte, size)
+
+				for i := range block {
+					if kind == 0 {
+						block[i] = byte(rnd.Intn(256))
+					} else if kind == 1 {
+						block[i] = byte(i)
+					} else if rnd.Intn(2) == 0 {
+						// One frequent symbol per group of 16 symbols
+						block[i] = byte(rnd.Intn(16) << 4)
+					} else {
+						block[i] = byte(rnd.Intn(256))
+					}
+				}
+
+				chunkSize := []uint{entropy.DEFAULT_RANGE_CHUNK_SIZE}
+
+				if len(args) > 0 {
+					chunkSize = args[0:1]
+				}
+
+				bound := entropy.RangeEncodedBound(uint(size), chunkSize...)
+				encoded := encodeWith(block, args...)
+
+				if uint(len(encoded)) > bound {
+					fmt.Printf("Size %v, args %v: %v bytes, above the bound (%v bytes)\n",
+						size, args, len(encoded), bound)
+					os.Exit(1)
+				}
+
+				if r := float64(len(encoded)) / float64(bound); r > worst {
+					worst = r
+				}
+			}
+		}
+	}
+
+	if entropy.RangeEncodedBound(0) != 0 {
+		fmt.Printf("Invalid bound for empty data\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Highest encoded size / bound: %.3f\n", worst)
+	fmt.Printf("Success\n")
+}
+
+// Sizes near the limits of a 32 bit int/uint (also run with GOARCH=386): the
+// bound must not wrap around and block sizes that do not fit in an int must be
+// rejected. Blocks of 2^31 bytes cannot be allocated: the chunk arithmetic is
+// checked with the largest chunk size.
+func TestWordSize() {
+	fmt.Printf("\n\nWord size test\n")
+	maxUint := ^uint(0)
+	maxInt := maxUint >> 1
+
+	for _, size := range []uint{1<<31 - 1, 1 << 31, 1<<32 - 1, maxInt, maxUint - 1, maxUint} {
+		for _, chunkSize := range []uint{0, 1024, entropy.DEFAULT_RANGE_CHUNK_SIZE, 1 << 30} {
+			bound := entropy.RangeEncodedBound(size, chunkSize)
+			chunks := uint64(1)
+
+			if chunkSize != 0 {
+				chunks = (uint64(size) + uint64(chunkSize) - 1) / uint64(chunkSize)
+			}
+
+			// Bound computed with 64 bits, saturated to the max uint
+			expected := uint64(size) + uint64(size>>6) + chunks*entropy.RANGE_MAX_CHUNK_OVERHEAD
+
+			if uint64(size) > math.MaxUint64-uint64(size>>6)-chunks*entropy.RANGE_MAX_CHUNK_OVERHEAD ||
+				expected > uint64(maxUint) {
+				expected = uint64(maxUint)
+			}
+
+			if bound < size || uint64(bound) != expected {
+				fmt.Printf("Invalid bound for size %v, chunk size %v: %v (expected %v)\n",
+					size, chunkSize, bound, expected)
+				os.Exit(1)
+			}
+		}
+	}
+
+	block := make([]byte, 100000)
+
+	for i := range block {
+		block[i] = byte(i >> 8)
+	}
+
+	for _, chunkSize := range []uint{0, 1 << 30} {
+		var buffer bytes.Buffer
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 65536)
+		rc, _ := entropy.NewRangeEncoder(obs, chunkSize, entropy.DEFAULT_RANGE_LOG_RANGE)
+
+		if _, err := rc.Encode(block); err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		rc.Dispose()
+		obs.Close()
+
+		iFile, _ := util.NewByteArrayInputStream(buffer.Bytes(), true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 65536)
+		rd, _ := entropy.NewRangeDecoder(ibs, chunkSize)
+		output := make([]byte, len(block))
+
+		// A block size that does not fit in an int is rejected (no panic)
+		if _, err := rd.DecodeN(output, maxInt+1); err == nil {
+			fmt.Printf("Invalid block size %v not detected\n", maxInt+1)
+			os.Exit(1)
+		}
+
+		for decoded := 0; decoded < len(output); {
+			n, err := rd.DecodeN(output[decoded:], uint(len(block)))
+
+			if err != nil {
+				fmt.Printf("Decoding error (chunk size %v): %v\n", chunkSize, err)
+				os.Exit(1)
+			}
+
+			decoded += n
+		}
+
+		rd.Dispose()
+
+		if bytes.Equal(block, output) == false {
+			fmt.Printf("Different (chunk size %v)\n", chunkSize)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%v bit int: success\n", strconv.IntSize)
+}
+
+// Random data: the encoder writes 16 bits to the bitstream every 2 bytes
+// The number of bits written reported by the encoder grows with each encoded
+// block and matches the size of the output
+func TestWritten() {
+	fmt.Printf("\n\nWritten test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var buffer bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+	rc, _ := entropy.NewRangeEncoder(obs)
+	prev := rc.Written()
+
+	if prev != 0 {
+		fmt.Printf("Invalid initial count: %v\n", prev)
+		os.Exit(1)
+	}
+
+	for ii := 0; ii < 50; ii++ {
+		block := make([]byte, rnd.Intn(30000))
+
+		for i := range block {
+			block[i] = byte(rnd.Intn(1 + ii*5))
+		}
+
+		if _, err := rc.Encode(block); err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		written := rc.Written()
+
+		if written != obs.Written() || (len(block) > 0 && written <= prev) || (len(block) == 0 && written != prev) {
+			fmt.Printf("Invalid count after %v bytes: %v (previous %v)\n", len(block), written, prev)
+			os.Exit(1)
+		}
+
+		prev = written
+	}
+
+	rc.Dispose()
+	obs.Close()
+
+	if uint64(buffer.Len()) != (prev+7)>>3 {
+		fmt.Printf("Invalid count: %v bits for %v bytes\n", prev, buffer.Len())
+		os.Exit(1)
+	}
+
+	fmt.Printf("%v bits written\n", prev)
+	fmt.Printf("Success\n")
+}
+
+// Large blocks decoded 4 KB at a time (with and without chunks), followed by
+// a small block in the same stream
+func TestDecodeN() {
+	fmt.Printf("\n\nDecodeN test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	block := make([]byte, 8*1024*1024+rnd.Intn(100000))
+
+	for i := range block {
+		block[i] = byte(rnd.Intn(1 + i>>16))
+	}
+
+	tail := []byte("and a small block after the large one")
+
+	for _, chunkSize := range []uint{0, 1024, entropy.DEFAULT_RANGE_CHUNK_SIZE} {
+		var buffer bytes.Buffer
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 65536)
+		rc, _ := entropy.NewRangeEncoder(obs, chunkSize, entropy.DEFAULT_RANGE_LOG_RANGE)
+		rc.Encode(block)
+		rc.Encode(tail)
+		rc.Dispose()
+		obs.Close()
+
+		iFile, _ := util.NewByteArrayInputStream(buffer.Bytes(), true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 65536)
+		rd, _ := entropy.NewRangeDecoder(ibs, chunkSize)
+		buf := make([]byte, 4096)
+		crc := crc32.NewIEEE()
+		calls := 0
+
+		for decoded := 0; decoded < len(block); calls++ {
+			if decoded > 0 {
+				if _, err := rd.DecodeN(buf, uint(len(block)-1)); err == nil {
+					fmt.Printf("Invalid block size not detected\n")
+					os.Exit(1)
+				}
+			}
+
+			n, err := rd.DecodeN(buf, uint(len(block)))
+
+			if err != nil || n == 0 {
+				fmt.Printf("Decoding error after %v bytes: %v\n", decoded, err)
+				os.Exit(1)
+			}
+
+			crc.Write(buf[0:n])
+			decoded += n
+		}
+
+		n, err := rd.DecodeN(buf, uint(len(tail)))
+
+		if err != nil || bytes.Equal(buf[0:n], tail) == false {
+			fmt.Printf("Invalid decoding of the next block: %v\n", err)
+			os.Exit(1)
+		}
+
+		rd.Dispose()
+
+		if crc.Sum32() != crc32.ChecksumIEEE(block) {
+			fmt.Printf("Different (chunk size %v)\n", chunkSize)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Chunk size %v: %v bytes decoded in %v calls\n", chunkSize, len(block), calls)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Truncated and corrupted inputs: the decoder must return ErrCorruptStream
+// (or garbage for some corruptions) and never panic
+func TestTruncated() {
+	fmt.Printf("\n\nTruncated input test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	block := make([]byte, 200000)
+
+	for i := range block {
+		block[i] = byte(rnd.Intn(1 + i>>10))
+	}
+
+	encoded := encodeWith(block)
+	output := make([]byte, len(block))
+	lengths := []int{0, 1, 2, 10, len(encoded) / 2, len(encoded) - 8, len(encoded) - 1}
+
+	for i := 0; i < 20; i++ {
+		lengths = append(lengths, rnd.Intn(len(encoded)))
+	}
+
+	for _, length := range lengths {
+		// The input stream pads the truncated data with zeros: the end of
+		// the data must be provided
+		iFile, _ := util.NewByteArrayInputStream(encoded[0:length], true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+		rd, _ := entropy.NewRangeDecoder(ibs)
+		rd.SetInputLimit(8 * uint64(length))
+		_, err1 := rd.Decode(output)
+
+		// End of stream
+		ibs, _ = bitstream.NewDefaultInputBitStream(&readerInputStream{bytes.NewReader(encoded[0:length])}, 16384)
+		rd, _ = entropy.NewRangeDecoder(ibs)
+		_, err2 := rd.Decode(output)
+
+		// Decoding in chunks
+		ibs, _ = bitstream.NewDefaultInputBitStream(&readerInputStream{bytes.NewReader(encoded[0:length])}, 16384)
+		rd, _ = entropy.NewRangeDecoder(ibs)
+		var err3 error
+
+		for decoded := 0; decoded < len(block) && err3 == nil; {
+			var n int
+			n, err3 = rd.DecodeN(output[0:4096], uint(len(block)))
+			decoded += n
+		}
+
+		if errors.Is(err1, entropy.ErrCorruptStream) == false || errors.Is(err2, entropy.ErrCorruptStream) == false ||
+			errors.Is(err3, entropy.ErrCorruptStream) == false {
+			fmt.Printf("Truncation to %v bytes not detected: %v, %v, %v\n", length, err1, err2, err3)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%v truncations detected\n", len(lengths))
+
+	// The whole input within the limit
+	iFile, _ := util.NewByteArrayInputStream(encoded, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	rd, _ := entropy.NewRangeDecoder(ibs)
+	rd.SetInputLimit(8 * uint64(len(encoded)))
+
+	if _, err := rd.Decode(output); err != nil || bytes.Equal(output, block) == false {
+		fmt.Printf("Decoding error with an input limit: %v\n", err)
+		os.Exit(1)
+	}
+
+	corrupted := make([]byte, len(encoded))
+	errs := 0
+
+	for i := 0; i < 1000; i++ {
+		copy(corrupted, encoded)
+
+		for j := 0; j < 1+rnd.Intn(4); j++ {
+			corrupted[rnd.Intn(len(corrupted))] ^= byte(1 + rnd.Intn(255))
+		}
+
+		iFile, _ := util.NewByteArrayInputStream(corrupted, true)
+		ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+		rd, _ := entropy.NewRangeDecoder(ibs)
+
+		if _, err := rd.Decode(output); err != nil {
+			if errors.Is(err, entropy.ErrCorruptStream) == false {
+				fmt.Printf("Unexpected error: %v\n", err)
+				os.Exit(1)
+			}
+
+			errs++
+		}
+	}
+
+	fmt.Printf("1000 corrupted inputs: %v errors, no panic\n", errs)
+	fmt.Printf("Success\n")
+}
+
+// Read 'count' bits at 'offset' in the data (by 64 bit words)
+func readBitRange(data []byte, offset, count uint64) []uint64 {
+	iFile, _ := util.NewByteArrayInputStream(data, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	res := make([]uint64, 0, count/64+1)
+
+	for ; offset >= 64; offset -= 64 {
+		ibs.ReadBits(64)
+	}
+
+	if offset > 0 {
+		ibs.ReadBits(uint(offset))
+	}
+
+	for ; count >= 64; count -= 64 {
+		res = append(res, ibs.ReadBits(64))
+	}
+
+	if count > 0 {
+		res = append(res, ibs.ReadBits(uint(count)))
+	}
+
+	return res
+}
+
+// Encoding after a restored snapshot: same output as without the speculative
+// encoding. Decoding resumed from a snapshot in another decoder: same data.
+func TestSnapshot() {
+	fmt.Printf("\n\nSnapshot test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	blocks := [][]byte{make([]byte, 50000), make([]byte, 20000), make([]byte, 30000)}
+
+	for n := range blocks {
+		for i := range blocks[n] {
+			blocks[n][i] = byte(rnd.Intn(1 + 16*n + i>>8))
+		}
+	}
+
+	// Reference: first block then last block
+	var buffer1 bytes.Buffer
+	obs1, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer1}, 16384)
+	rc1, _ := entropy.NewRangeEncoder(obs1, 1024, entropy.DEFAULT_RANGE_LOG_RANGE)
+	rc1.Encode(blocks[0])
+	written := obs1.Written()
+	rc1.Encode(blocks[2])
+	size := obs1.Written() - written
+	obs1.Close()
+
+	// Speculative encoding of the second block, rolled back
+	var buffer2 bytes.Buffer
+	obs2, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer2}, 16384)
+	rc2, _ := entropy.NewRangeEncoder(obs2, 1024, entropy.DEFAULT_RANGE_LOG_RANGE)
+	rc2.Encode(blocks[0])
+	freqs := rc2.Frequencies()
+	state := rc2.Snapshot()
+	rc2.Encode(blocks[1])
+	speculative := obs2.Written() - written
+
+	if err := rc2.Restore(state); err != nil {
+		fmt.Printf("Restore error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if reflect.DeepEqual(freqs, rc2.Frequencies()) == false {
+		fmt.Printf("Different frequencies after restore\n")
+		os.Exit(1)
+	}
+
+	rc2.Encode(blocks[2])
+	size2 := obs2.Written() - written - speculative
+	obs2.Close()
+
+	if size2 != size ||
+		reflect.DeepEqual(readBitRange(buffer1.Bytes(), written, size),
+			readBitRange(buffer2.Bytes(), written+speculative, size)) == false {
+		fmt.Printf("Different encoding after restore\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Encoder: %v bits rolled back, identical\n", speculative)
+
+	// Decode the first block, then half of the second one: snapshot, decode
+	// the rest and resume from the snapshot in another decoder
+	encoded := buffer1.Bytes()
+	iFile, _ := util.NewByteArrayInputStream(encoded, true)
+	ibs1, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	rd1, _ := entropy.NewRangeDecoder(ibs1, 1024)
+	output1 := make([]byte, len(blocks[2]))
+	rd1.Decode(make([]byte, len(blocks[0])))
+	half := len(blocks[2]) / 2
+
+	for i := 0; i < half; {
+		n, _ := rd1.DecodeN(output1[i:half], uint(len(blocks[2])))
+		i += n
+	}
+
+	state2 := rd1.Snapshot()
+	read := ibs1.Read()
+
+	for i := half; i < len(output1); {
+		n, _ := rd1.DecodeN(output1[i:], uint(len(blocks[2])))
+		i += n
+	}
+
+	iFile, _ = util.NewByteArrayInputStream(encoded, true)
+	ibs2, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	rd2, _ := entropy.NewRangeDecoder(ibs2, 1024)
+	for skip := read; skip > 0; {
+		n := uint64(64)
+
+		if skip < n {
+			n = skip
+		}
+
+		ibs2.ReadBits(uint(n))
+		skip -= n
+	}
+
+	if err := rd2.Restore(state2); err != nil {
+		fmt.Printf("Restore error: %v\n", err)
+		os.Exit(1)
+	}
+
+	output2 := make([]byte, len(blocks[2]))
+	copy(output2, output1[0:half])
+
+	for i := half; i < len(output2); {
+		n, err := rd2.DecodeN(output2[i:], uint(len(blocks[2])))
+
+		if err != nil {
+			fmt.Printf("Decoding error after restore: %v\n", err)
+			os.Exit(1)
+		}
+
+		i += n
+	}
+
+	if bytes.Equal(output1, blocks[2]) == false || bytes.Equal(output2, blocks[2]) == false {
+		fmt.Printf("Different decoding after restore\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Decoder: resumed after %v bits, identical\n", read)
+	fmt.Printf("Success\n")
+}
+
+// Reader returning short reads of random sizes
+type shortReader struct {
+	data []byte
+	rnd  *rand.Rand
+}
+
+func (this *shortReader) Read(b []byte) (int, error) {
+	if len(this.data) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b[0:1+this.rnd.Intn(len(b))], this.data)
+	this.data = this.data[n:]
+	return n, nil
+}
+
+// Reader failing after some data
+type failingReader struct {
+	data []byte
+}
+
+func (this *failingReader) Read(b []byte) (int, error) {
+	if len(this.data) == 0 {
+		return 0, errors.New("read failure")
+	}
+
+	n := copy(b, this.data)
+	this.data = this.data[n:]
+	return n, nil
+}
+
+// Encoding from a reader with short reads: same output as Encode with the
+// whole block
+func TestEncodeFrom() {
+	fmt.Printf("\n\nEncodeFrom test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, size := range []int{0, 1, 1024, 65536, 200000 + rnd.Intn(100000)} {
+		block := make([]byte, size)
+
+		for i := range block {
+			block[i] = byte(rnd.Intn(1 + i>>11))
+		}
+
+		for _, chunkSize := range []uint{1024, entropy.DEFAULT_RANGE_CHUNK_SIZE} {
+			encoded := encodeWith(block, chunkSize, entropy.DEFAULT_RANGE_LOG_RANGE)
+			var buffer bytes.Buffer
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+			rc, _ := entropy.NewRangeEncoder(obs, chunkSize, entropy.DEFAULT_RANGE_LOG_RANGE)
+			n, err := rc.EncodeFrom(&shortReader{data: block, rnd: rnd})
+			rc.Dispose()
+			obs.Close()
+
+			if err != nil || n != int64(len(block)) || bytes.Equal(buffer.Bytes(), encoded) == false {
+				fmt.Printf("Size %v, chunk size %v: different (%v bytes encoded, %v)\n", size, chunkSize, n, err)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("Size %v: identical\n", size)
+	}
+
+	var buffer bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+	rc, _ := entropy.NewRangeEncoder(obs, 1024, entropy.DEFAULT_RANGE_LOG_RANGE)
+
+	if n, err := rc.EncodeFrom(&failingReader{data: make([]byte, 5000)}); err == nil || n != 4096 {
+		fmt.Printf("Read error not reported (%v bytes encoded)\n", n)
+		os.Exit(1)
+	}
+
+	rc, _ = entropy.NewRangeEncoder(obs, 0, entropy.DEFAULT_RANGE_LOG_RANGE)
+
+	if _, err := rc.EncodeFrom(bytes.NewReader(make([]byte, 100))); err == nil {
+		fmt.Printf("Null chunk size not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Histograms written and read back: normalized to 2^logRange, absent symbols
+// kept absent
+func TestHistogram() {
+	fmt.Printf("\n\nHistogram test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for test := 0; test < 6; test++ {
+		freqs := make([]int, 256)
+		var name string
+
+		switch test {
+		case 0:
+			name = "uniform"
+
+			for i := range freqs {
+				freqs[i] = 1000
+			}
+
+		case 1:
+			name = "skewed"
+			freqs[0] = 1000000
+
+			for i := 1; i < 256; i++ {
+				freqs[i] = 1
+			}
+
+		case 2:
+			name = "geometric"
+
+			for i := 0; i < 20; i++ {
+				freqs[i] = 1 << uint(20-i)
+			}
+
+		case 3:
+			name = "one symbol"
+			freqs[65] = 12345
+
+		case 4:
+			name = "empty"
+
+		default:
+			name = "random"
+
+			for i := range freqs {
+				if rnd.Intn(3) == 0 {
+					freqs[i] = rnd.Intn(100000)
+				}
+			}
+		}
+
+		for _, logRange := range []uint{8, 12, 15} {
+			var buffer bytes.Buffer
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+			normalized := make([]int, 256)
+			copy(normalized, freqs)
+			alphabetSize1, err := entropy.WriteHistogram(obs, normalized, logRange)
+
+			if err != nil {
+				fmt.Printf("Write error: %v\n", err)
+				os.Exit(1)
+			}
+
+			written := obs.Written()
+			obs.Close()
+			sum := 0
+
+			for i := range freqs {
+				if (freqs[i] == 0) != (normalized[i] == 0) {
+					fmt.Printf("%v: symbol %v present in one histogram only\n", name, i)
+					os.Exit(1)
+				}
+
+				sum += normalized[i]
+			}
+
+			if alphabetSize1 > 0 && sum != 1<<logRange {
+				fmt.Printf("%v: frequencies add up to %v (expected %v)\n", name, sum, 1<<logRange)
+				os.Exit(1)
+			}
+
+			iFile, _ := util.NewByteArrayInputStream(buffer.Bytes(), true)
+			ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+			decoded := make([]int, 256)
+			alphabetSize2, logRange2, err := entropy.ReadHistogram(ibs, decoded)
+
+			if err != nil || alphabetSize2 != alphabetSize1 || ibs.Read() != written ||
+				(alphabetSize2 > 0 && logRange2 != logRange) || reflect.DeepEqual(decoded, normalized) == false {
+				fmt.Printf("%v: different histogram after reading (%v)\n", name, err)
+				os.Exit(1)
+			}
+
+			if logRange == 12 {
+				fmt.Printf("%-10v: %3v symbols, %4v bits\n", name, alphabetSize1, written)
+			}
+		}
+	}
+
+	var dummy bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &dummy}, 16384)
+
+	if _, err := entropy.WriteHistogram(obs, make([]int, 256), 16); err == nil {
+		fmt.Printf("Invalid log range not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Entropy encoder writing an end of data symbol
+type terminatedEncoder struct {
+	entropy.NullEntropyEncoder
+}
+
+func (this *terminatedEncoder) SelfTerminating() bool {
+	return true
+}
+
+// The block size must be stored for the codecs which do not write an end of
+// data symbol (all the registered codecs)
+func TestTermination() {
+	fmt.Printf("\n\nTermination test\n")
+	var dummy bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &dummy}, 16384)
+	iFile, _ := util.NewByteArrayInputStream(make([]byte, 0), true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+
+	for entropyType := entropy.NONE_TYPE; entropyType <= entropy.MIXED_TYPE; entropyType++ {
+		name := entropy.GetEntropyCodecName(entropyType)
+		ee, err1 := entropy.NewEntropyEncoder(obs, entropyType)
+		ed, err2 := entropy.NewEntropyDecoder(ibs, entropyType)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("%v: cannot create codec (%v, %v)\n", name, err1, err2)
+			os.Exit(1)
+		}
+
+		if entropy.IsSelfTerminating(ee) == true || entropy.IsSelfTerminating(ed) == true {
+			fmt.Printf("%v: unexpected self-terminating codec\n", name)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-11v: block size stored\n", name)
+	}
+
+	if entropy.IsSelfTerminating(&terminatedEncoder{}) == false {
+		fmt.Printf("Self-terminating codec not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Blocks encoded separately (each bitstream closed) and concatenated: decoded
+// with a reset at each boundary
+func TestSegments() {
+	fmt.Printf("\n\nSegments test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	blocks := [][]byte{make([]byte, 100000+rnd.Intn(1000)), make([]byte, 1+rnd.Intn(100)), make([]byte, 70000)}
+	var concatenated []byte
+	sizes := make([]int, len(blocks))
+	all := make([]byte, 0)
+
+	for n := range blocks {
+		for i := range blocks[n] {
+			blocks[n][i] = byte(64*n + rnd.Intn(1+(i>>10)&63))
+		}
+
+		concatenated = append(concatenated, encodeWith(blocks[n], 1024, entropy.DEFAULT_RANGE_LOG_RANGE)...)
+		sizes[n] = len(blocks[n])
+		all = append(all, blocks[n]...)
+	}
+
+	iFile, _ := util.NewByteArrayInputStream(concatenated, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(iFile, 16384)
+	sd, _ := entropy.NewSegmentedDecoder(ibs, 1024)
+	output := make([]byte, len(all))
+	n, err := sd.DecodeSegments(output, sizes)
+
+	if err != nil || n != len(all) || sd.Segments() != len(blocks) || bytes.Equal(output, all) == false {
+		fmt.Printf("Different after decoding %v segments (%v)\n", sd.Segments(), err)
+		os.Exit(1)
+	}
+
+	if ibs.Read() != 8*uint64(len(concatenated)) {
+		fmt.Printf("%v bits read, expected %v\n", ibs.Read(), 8*len(concatenated))
+		os.Exit(1)
+	}
+
+	fmt.Printf("%v segments (%v bytes): identical\n", len(blocks), len(concatenated))
+
+	// Without the reset and the padding skip at the boundaries
+	iFile, _ = util.NewByteArrayInputStream(concatenated, true)
+	ibs, _ = bitstream.NewDefaultInputBitStream(iFile, 16384)
+	rd, _ := entropy.NewRangeDecoder(ibs, 1024)
+	_, err = rd.Decode(output)
+
+	if err == nil && bytes.Equal(output, all) == true {
+		fmt.Printf("Segments decoded without the boundaries\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Per byte output counts: same output as Encode, the counts add up to the
+// output size minus the final flush (no header with a model)
+func TestEncodeCounted() {
+	fmt.Printf("\n\nEncodeCounted test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	block := make([]byte, 300000)
+
+	for i := range block {
+		block[i] = byte(rnd.Intn(1 + i>>11))
+	}
+
+	samples := [][]byte{block[0:100000], block[200000:]}
+	model, _ := entropy.TrainRangeModel(samples)
+
+	for test := 0; test < 2; test++ {
+		var buffer bytes.Buffer
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+		var rc *entropy.RangeEncoder
+		var encoded []byte
+
+		if test == 0 {
+			rc, _ = entropy.NewRangeEncoderWithModel(obs, model)
+			encoded = encodeWithModel(block, model)
+		} else {
+			rc, _ = entropy.NewRangeEncoder(obs)
+			encoded = encodeWith(block)
+		}
+
+		counts := make([]int, len(block))
+
+		if _, err := rc.EncodeCounted(block, counts); err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		written := obs.Written()
+		rc.Dispose()
+		obs.Close()
+
+		if bytes.Equal(buffer.Bytes(), encoded) == false {
+			fmt.Printf("Different output with counts\n")
+			os.Exit(1)
+		}
+
+		sum := 0
+		max := 0
+
+		for _, c := range counts {
+			sum += c
+
+			if c > max {
+				max = c
+			}
+		}
+
+		if test == 0 {
+			if 8*uint64(sum)+56 != written {
+				fmt.Printf("Counts add up to %v bytes, %v bits written\n", sum, written)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Model: %v bytes counted, %v bits written (max %v bytes per byte)\n", sum, written, max)
+		} else {
+			fmt.Printf("Headers: %v bytes counted, %v bits written (max %v bytes per byte)\n", sum, written, max)
+		}
+	}
+
+	var dummy bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &dummy}, 16384)
+	rc, _ := entropy.NewRangeEncoder(obs)
+
+	if _, err := rc.EncodeCounted(block, make([]int, 10)); err == nil {
+		fmt.Printf("Counts too small not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Messages of 200 bytes with similar statistics (JSON events)
+func generateMessage(rnd *rand.Rand) []byte {
+	actions := []string{"login", "logout", "view", "purchase", "search"}
+	var buf bytes.Buffer
+
+	for buf.Len() < 200 {
+		fmt.Fprintf(&buf, "{\"user\":\"u%d\",\"action\":\"%v\",\"ts\":%d,\"ok\":%v}", rnd.Intn(100000),
+			actions[rnd.Intn(len(actions))], 1600000000+rnd.Intn(100000000), rnd.Intn(4) > 0)
+	}
+
+	return buf.Bytes()[0:200]
+}
+
+// The estimated size must be the size of the actual encoding
+func TestEstimateSize() {
+	fmt.Printf("\n\nEstimateSize test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	text := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 3000))
+	random := make([]byte, 200000)
+	skewed := make([]byte, 150000)
+
+	for i := range random {
+		random[i] = byte(rnd.Intn(256))
+	}
+
+	for i := range skewed {
+		skewed[i] = byte(rnd.Intn(1 + i>>10))
+	}
+
+	inputs := map[string][]byte{"text": text, "random": random, "skewed": skewed,
+		"zeros": make([]byte, 100000), "one byte": {42}, "small": text[0:100]}
+	model, _ := entropy.TrainRangeModel([][]byte{skewed})
+
+	for _, name := range []string{"text", "random", "skewed", "zeros", "one byte", "small"} {
+		block := inputs[name]
+
+		for _, args := range [][]uint{{}, {0, 15}, {1024, 8}, {4096, 13}, nil} {
+			var buffer bytes.Buffer
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+			var rc *entropy.RangeEncoder
+			var encoded []byte
+
+			if args == nil {
+				rc, _ = entropy.NewRangeEncoderWithModel(obs, model)
+				encoded = encodeWithModel(block, model)
+			} else {
+				rc, _ = entropy.NewRangeEncoder(obs, args...)
+				encoded = encodeWith(block, args...)
+			}
+
+			estimate := rc.EstimateSize(block)
+
+			if estimate != uint(len(encoded)) {
+				fmt.Printf("%v, args %v: estimated %v bytes, encoded %v bytes\n", name, args, estimate, len(encoded))
+				os.Exit(1)
+			}
+
+			// The estimation does not change the encoder
+			rc.Encode(block)
+			rc.Dispose()
+			obs.Close()
+
+			if bytes.Equal(buffer.Bytes(), encoded) == false {
+				fmt.Printf("%v, args %v: different output after the estimation\n", name, args)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("%-8v: %v => %v bytes\n", name, len(block), len(encodeWith(block)))
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Self-terminating streams: the reader decodes all the data without its size
+func TestDecodeAll() {
+	fmt.Printf("\n\nDecodeAll test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	large := make([]byte, 5*entropy.RANGE_STREAM_CHUNK_SIZE+rnd.Intn(10000))
+
+	for i := range large {
+		large[i] = byte(rnd.Intn(1 + i>>12))
+	}
+
+	inputs := [][]byte{{}, []byte("small self-terminating stream"), large}
+
+	for _, input := range inputs {
+		var encoded bytes.Buffer
+		rw, _ := entropy.NewRangeWriter(&encoded)
+
+		if _, err := rw.Write(input); err != nil {
+			fmt.Printf("An error occured during encoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		rw.Close()
+
+		// Data after the end of the stream is ignored
+		encoded.WriteString("trailing data")
+		rr, _ := entropy.NewRangeReader(bytes.NewReader(encoded.Bytes()))
+
+		// After a partial read, the rest of the stream is decoded
+		head := make([]byte, len(input)/3)
+		io.ReadFull(rr, head)
+		output, err := rr.DecodeAll()
+
+		if err != nil {
+			fmt.Printf("An error occured during decoding: %v\n", err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(input, append(head, output...)) == false {
+			fmt.Printf("Different (%v bytes)\n", len(input))
+			os.Exit(1)
+		}
+
+		// Nothing left
+		if output, err = rr.DecodeAll(); err != nil || len(output) != 0 {
+			fmt.Printf("Unexpected data after the end of stream: %v bytes (%v)\n", len(output), err)
+			os.Exit(1)
+		}
+
+		// Truncated stream (no end of stream marker)
+		if len(input) > 0 {
+			rr, _ = entropy.NewRangeReader(bytes.NewReader(encoded.Bytes()[0 : encoded.Len()/2]))
+
+			if _, err := rr.DecodeAll(); errors.Is(err, entropy.ErrCorruptStream) == false {
+				fmt.Printf("Truncated stream not detected: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("%v bytes => %v bytes: identical\n", len(input), encoded.Len())
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Interleaved writes and flushes through a pipe: the reader must receive each
+// message before the next one is written (no deadlock), and the whole stream
+// must decode to the messages
+func TestFlush() {
+	fmt.Printf("\n\nFlush test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	messages := make([][]byte, 50)
+	var all bytes.Buffer
+
+	for i := range messages {
+		messages[i] = make([]byte, 1+rnd.Intn(3000))
+
+		for j := range messages[i] {
+			messages[i][j] = byte(48 + rnd.Intn(10))
+		}
+
+		all.Write(messages[i])
+	}
+
+	pr, pw := io.Pipe()
+	var stream bytes.Buffer
+	received := make(chan int)
+	errs := make(chan error, 2)
+
+	go func() {
+		// Writer: send a message only after the previous one was received
+		rw, _ := entropy.NewRangeWriter(io.MultiWriter(pw, &stream))
+
+		for i, msg := range messages {
+			if _, err := rw.Write(msg); err != nil {
+				errs <- err
+				return
+			}
+
+			if err := rw.Flush(); err != nil {
+				errs <- err
+				return
+			}
+
+			if <-received != i {
+				errs <- fmt.Errorf("Message %v not received", i)
+				return
+			}
+		}
+
+		errs <- rw.Close()
+		pw.Close()
+	}()
+
+	go func() {
+		rr, _ := entropy.NewRangeReader(pr)
+
+		for i, msg := range messages {
+			buf := make([]byte, len(msg))
+
+			if _, err := io.ReadFull(rr, buf); err != nil || bytes.Equal(buf, msg) == false {
+				errs <- fmt.Errorf("Invalid message %v: %v", i, err)
+				return
+			}
+
+			received <- i
+		}
+
+		if _, err := io.Copy(ioutil.Discard, rr); err != nil {
+			errs <- err
+			return
+		}
+
+		errs <- nil
+	}()
+
+	for n := 0; n < 2; n++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+
+		case <-time.After(30 * time.Second):
+			fmt.Printf("Timeout: the flushed data did not reach the reader\n")
+			os.Exit(1)
+		}
+	}
+
+	// Decode the whole stream
+	rr, _ := entropy.NewRangeReader(bytes.NewReader(stream.Bytes()))
+	output, err := rr.DecodeAll()
+
+	if err != nil || bytes.Equal(output, all.Bytes()) == false {
+		fmt.Printf("Different stream (%v)\n", err)
+		os.Exit(1)
+	}
+
+	// Cost of the flushes
+	var unflushed bytes.Buffer
+	rw, _ := entropy.NewRangeWriter(&unflushed)
+	rw.Write(all.Bytes())
+	rw.Close()
+	fmt.Printf("%v messages, %v bytes => %v bytes (%v bytes without flushes)\n",
+		len(messages), all.Len(), stream.Len(), unflushed.Len())
+
+	// Encode/Flush with a RangeEncoder, decode with a RangeDecoder unaware of
+	// the flushes
+	var flushed bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &flushed}, 16384)
+	rc, _ := entropy.NewRangeEncoder(obs)
+
+	for i, msg := range messages {
+		if _, err := rc.Encode(msg); err != nil {
+			fmt.Printf("Encoding error for message %v: %v\n", i, err)
+			os.Exit(1)
+		}
+
+		if err := rc.Flush(); err != nil {
+			fmt.Printf("Flush error after message %v: %v\n", i, err)
+			os.Exit(1)
+		}
+	}
+
+	rc.Dispose()
+	obs.Close()
+	is, _ := util.NewByteArrayInputStream(flushed.Bytes(), true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(is, 16384)
+	rd, _ := entropy.NewRangeDecoder(ibs)
+
+	for i, msg := range messages {
+		buf := make([]byte, len(msg))
+
+		if _, err := rd.Decode(buf); err != nil || bytes.Equal(buf, msg) == false {
+			fmt.Printf("Invalid message %v after a flush: %v\n", i, err)
+			os.Exit(1)
+		}
+	}
+
+	rd.Dispose()
+
+	// No chunk header to mark a flush with a model
+	model, _ := entropy.TrainRangeModel([][]byte{all.Bytes()})
+	rc, _ = entropy.NewRangeEncoderWithModel(obs, model)
+
+	if rc.Flush() == nil {
+		fmt.Printf("No error for a flush with a model\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func encodeWithModel(block []byte, model *entropy.RangeModel) []byte {
+	var buffer bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+	rc, err := entropy.NewRangeEncoderWithModel(obs, model)
+
+	if err == nil {
+		_, err = rc.Encode(block)
+	}
+
+	if err != nil {
+		fmt.Printf("An error occured during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	rc.Dispose()
+	obs.Close()
+	return buffer.Bytes()
+}
+
+func decodeWithModel(encoded []byte, size int, model *entropy.RangeModel) []byte {
+	is, _ := util.NewByteArrayInputStream(encoded, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(is, 16384)
+	rd, _ := entropy.NewRangeDecoderWithModel(ibs, model)
+	decoded := make([]byte, size)
+
+	if _, err := rd.Decode(decoded); err != nil {
+		fmt.Printf("An error occured during decoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	rd.Dispose()
+	return decoded
+}
+
+// A model trained on similar messages improves the compression of small
+// messages (no header) and any byte can still be coded
+func TestModel() {
+	fmt.Printf("\n\nModel test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	samples := make([][]byte, 500)
+
+	for i := range samples {
+		samples[i] = generateMessage(rnd)
+	}
+
+	model, err := entropy.TrainRangeModel(samples)
+
+	if err != nil {
+		fmt.Printf("Training error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Serialization
+	serialized := model.Bytes()
+	model2, err := entropy.NewRangeModelFromBytes(serialized)
+
+	if err != nil || model2.LogRange() != model.LogRange() ||
+		fmt.Sprint(model2.Frequencies()) != fmt.Sprint(model.Frequencies()) {
+		fmt.Printf("Invalid deserialized model: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Serialized model: %v bytes\n", len(serialized))
+
+	for _, invalid := range [][]byte{serialized[0:100], append(serialized, 0), append([]byte{7}, serialized[1:]...),
+		append([]byte{serialized[0]}, bytes.Repeat([]byte{0}, 256)...)} {
+		if _, err := entropy.NewRangeModelFromBytes(invalid); err == nil {
+			fmt.Printf("Invalid model not detected\n")
+			os.Exit(1)
+		}
+	}
+
+	if _, err := entropy.TrainRangeModel(samples, 16); err == nil {
+		fmt.Printf("Invalid log range not detected\n")
+		os.Exit(1)
+	}
+
+	// Compare with the default encoder on other messages
+	sizeDefault := 0
+	sizeModel := 0
+
+	for ii := 0; ii < 200; ii++ {
+		msg := generateMessage(rnd)
+		encoded := encodeWithModel(msg, model2)
+		sizeDefault += len(encodeWith(msg))
+		sizeModel += len(encoded)
+
+		if bytes.Equal(decodeWithModel(encoded, len(msg), model), msg) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("200 messages of 200 bytes: %v bytes without model, %v bytes with model\n", sizeDefault, sizeModel)
+
+	// The header of the default encoder takes about a quarter of the output
+	if sizeModel*100 > sizeDefault*85 {
+		fmt.Printf("No compression improvement with the model\n")
+		os.Exit(1)
+	}
+
+	// Bytes absent from the samples and large blocks
+	for _, size := range []int{1, 256, 100000} {
+		block := make([]byte, size)
+
+		for i := range block {
+			block[i] = byte(rnd.Intn(256))
+		}
+
+		if bytes.Equal(decodeWithModel(encodeWithModel(block, model), size, model), block) == false {
+			fmt.Printf("Different (%v random bytes)\n", size)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func TestEncodeSpeed() {
+	iter := 100
+	size := 1 << 20
+	fmt.Printf("\n\nEncoding speed test (random data)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	block := make([]byte, size)
+
+	for i := range block {
+		block[i] = byte(rnd.Intn(256))
+	}
+
+	delta := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		before := time.Now()
+		encodeWith(block)
+		after := time.Now()
+		delta += after.Sub(before).Nanoseconds()
+	}
+
+	fmt.Printf("Encode [ms]      : %d\n", delta/1000000)
+	fmt.Printf("Throughput [MB/s]: %d\n", int64(iter)*int64(size)*1000000/delta*1000/(1024*1024))
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/util"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Lock the range coder stream format: the encodings of fixed inputs are
+// compared byte for byte with golden files (and decoded back).
+// Run from the kanzi directory: go run test/TestRangeGolden.go
+// After an intentional format change, rebuild the golden files with -update.
+
+const (
+	GOLDEN_INPUT_SIZE = 20000
+)
+
+type goldenInput struct {
+	name string
+	data []byte
+}
+
+type goldenConfig struct {
+	name string
+	args []uint
+}
+
+func main() {
+	var update = flag.Bool("update", false, "rewrite the golden files")
+	var dir = flag.String("dir", filepath.Join("test", "testdata", "range"), "directory of the golden files")
+	flag.Parse()
+	fmt.Printf("TestRangeGolden\n")
+
+	if *update == true {
+		if err := os.MkdirAll(*dir, 0755); err != nil {
+			fmt.Printf("Cannot create %v: %v\n", *dir, err)
+			os.Exit(1)
+		}
+	}
+
+	configs := []goldenConfig{
+		{"default", []uint{}},
+		{"c0_r15", []uint{0, 15}},
+		{"c1024_r8", []uint{1024, 8}},
+	}
+
+	failed := 0
+
+	for _, in := range goldenInputs() {
+		for _, cfg := range configs {
+			name := filepath.Join(*dir, in.name+"_"+cfg.name+".rng")
+			encoded := encode(in.data, cfg.args)
+
+			if *update == true {
+				if err := ioutil.WriteFile(name, encoded, 0644); err != nil {
+					fmt.Printf("Cannot write %v: %v\n", name, err)
+					os.Exit(1)
+				}
+
+				fmt.Printf("%-30v: %v bytes written\n", name, len(encoded))
+				continue
+			}
+
+			golden, err := ioutil.ReadFile(name)
+
+			if err != nil {
+				fmt.Printf("%-30v: FAILED (%v)\n", name, err)
+				failed++
+				continue
+			}
+
+			if bytes.Equal(encoded, golden) == false {
+				fmt.Printf("%-30v: FAILED (different encoding: %v bytes, golden: %v bytes, first difference at %v)\n",
+					name, len(encoded), len(golden), firstDifference(encoded, golden))
+				failed++
+				continue
+			}
+
+			decoded, err := decode(golden, len(in.data), cfg.args)
+
+			if err != nil || bytes.Equal(decoded, in.data) == false {
+				fmt.Printf("%-30v: FAILED (invalid decoding: %v)\n", name, err)
+				failed++
+				continue
+			}
+
+			fmt.Printf("%-30v: identical\n", name)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%v golden file(s) differ. If the format change is intended, run with -update\n", failed)
+		os.Exit(1)
+	}
+}
+
+// Fixed inputs: the seeded sources of math/rand produce the same values on
+// all platforms
+func goldenInputs() []goldenInput {
+	rnd := rand.New(rand.NewSource(12345))
+	words := strings.Fields("the range coder writes the same bits for the same input " +
+		"regardless of the platform of the implementation or of any refactoring")
+	var text bytes.Buffer
+
+	for text.Len() < GOLDEN_INPUT_SIZE {
+		text.WriteString(words[rnd.Intn(len(words))])
+
+		if rnd.Intn(12) == 0 {
+			text.WriteString(".\n")
+		} else {
+			text.WriteByte(' ')
+		}
+	}
+
+	binary := make([]byte, GOLDEN_INPUT_SIZE)
+
+	for i := range binary {
+		// Skewed distribution with some structure
+		if i&1 == 0 {
+			binary[i] = byte(rnd.Intn(256))
+		} else {
+			binary[i] = byte(rnd.Intn(1 + i>>8))
+		}
+	}
+
+	return []goldenInput{
+		{"text", text.Bytes()[0:GOLDEN_INPUT_SIZE]},
+		{"binary", binary},
+		{"zeros", make([]byte, GOLDEN_INPUT_SIZE)},
+	}
+}
+
+type bufferOutputStream struct {
+	buffer *bytes.Buffer
+}
+
+func (this *bufferOutputStream) Write(b []byte) (int, error) {
+	return this.buffer.Write(b)
+}
+
+func (this *bufferOutputStream) Close() error {
+	return nil
+}
+
+func encode(block []byte, args []uint) []byte {
+	var buffer bytes.Buffer
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bufferOutputStream{buffer: &buffer}, 16384)
+	rc, err := entropy.NewRangeEncoder(obs, args...)
+
+	if err == nil {
+		_, err = rc.Encode(block)
+	}
+
+	if err != nil {
+		fmt.Printf("An error occured during encoding: %v\n", err)
+		os.Exit(1)
+	}
+
+	rc.Dispose()
+	obs.Close()
+	return buffer.Bytes()
+}
+
+func decode(encoded []byte, size int, args []uint) ([]byte, error) {
+	is, _ := util.NewByteArrayInputStream(encoded, true)
+	ibs, _ := bitstream.NewDefaultInputBitStream(is, 16384)
+	var chunkSize []uint
+
+	if len(args) > 0 {
+		chunkSize = args[0:1]
+	}
+
+	rd, err := entropy.NewRangeDecoder(ibs, chunkSize...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := make([]byte, size)
+	_, err = rd.Decode(decoded)
+	rd.Dispose()
+	return decoded, err
+}
+
+func firstDifference(a, b []byte) int {
+	for i := range a {
+		if i >= len(b) || a[i] != b[i] {
+			return i
+		}
+	}
+
+	return len(a)
+}
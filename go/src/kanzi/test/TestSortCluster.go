@@ -0,0 +1,273 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestSortCluster\n")
+	TestCorrectness()
+	TestErrors()
+	TestRatio()
+	TestSpeed()
+}
+
+func TestCorrectness() {
+	fmt.Printf("Correctness test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Example from the doc comment
+	{
+		input := []byte("c1a2c3b4a5")
+		expected := append([]byte{0, 0, 0, 5}, []byte("a2a5b4c1c3")...)
+		expected = append(expected, 1, 3, 4, 2, 2)
+		sc, _ := function.NewSortCluster(0, 2, 0, 1)
+		output := make([]byte, sc.MaxEncodedLen(len(input)))
+		_, dstIdx, _ := sc.Forward(input, output)
+
+		if bytes.Equal(output[0:dstIdx], expected) == false {
+			fmt.Printf("Invalid encoding: %v (expected %v)\n", output[0:dstIdx], expected)
+			os.Exit(1)
+		}
+	}
+
+	for ii := 0; ii < 20; ii++ {
+		recordSize := uint(1 + rnd.Intn(12))
+		keyOffset := uint(rnd.Intn(int(recordSize)))
+		keyLength := uint(1 + rnd.Intn(int(recordSize-keyOffset)))
+		size := uint(rnd.Intn(64))
+
+		if ii == 0 {
+			size = 0
+		} else if ii == 1 {
+			// More than 256 records: indexes on 2 bytes
+			size = 300*recordSize + 1
+		}
+
+		input := make([]byte, size)
+
+		for i := range input {
+			// Few distinct values to get equal keys (stability)
+			input[i] = byte(rnd.Intn(4))
+		}
+
+		fmt.Printf("\nTest %v (record size %v, key %v+%v, size %v)\n", ii, recordSize,
+			keyOffset, keyLength, size)
+		sc, _ := function.NewSortCluster(size, recordSize, keyOffset, keyLength)
+		output := make([]byte, sc.MaxEncodedLen(int(size)))
+		reverse := make([]byte, size)
+		_, dstIdx, err := sc.Forward(input, output)
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Check that the records are sorted by key
+		records := size / recordSize
+
+		for i := uint(1); i < records; i++ {
+			k1 := 4 + (i-1)*recordSize + keyOffset
+			k2 := 4 + i*recordSize + keyOffset
+
+			if bytes.Compare(output[k1:k1+keyLength], output[k2:k2+keyLength]) > 0 {
+				fmt.Printf("Records %v and %v not sorted\n", i-1, i)
+				os.Exit(1)
+			}
+		}
+
+		if size < 64 {
+			fmt.Printf("Original: %v\n", input)
+			fmt.Printf("Coded:    %v\n", output[0:dstIdx])
+		}
+
+		sc, _ = function.NewSortCluster(dstIdx, recordSize, keyOffset, keyLength)
+		_, oIdx, err := sc.Inverse(output, reverse)
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if size < 64 {
+			fmt.Printf("Decoded:  %v\n", reverse[0:oIdx])
+		}
+
+		if oIdx != size || bytes.Equal(input, reverse) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("Identical\n")
+	}
+}
+
+func TestErrors() {
+	fmt.Printf("\nError test\n")
+
+	if _, err := function.NewSortCluster(0, 0, 0, 1); err == nil {
+		fmt.Printf("Invalid record size not detected\n")
+		os.Exit(1)
+	}
+
+	if _, err := function.NewSortCluster(0, 4, 2, 3); err == nil {
+		fmt.Printf("Key outside of the record not detected\n")
+		os.Exit(1)
+	}
+
+	if _, err := function.NewSortCluster(0, 4, 0, 0); err == nil {
+		fmt.Printf("Empty key not detected\n")
+		os.Exit(1)
+	}
+
+	sc, _ := function.NewSortCluster(0, 2, 0, 1)
+	output := make([]byte, 16)
+
+	// Invalid encoded data: truncated, index out of range, duplicate index
+	for _, invalid := range [][]byte{{0, 0}, {0, 0, 0, 3, 1, 1, 2, 2, 3, 3, 0},
+		{0, 0, 0, 2, 1, 1, 2, 2, 5, 1}, {0, 0, 0, 2, 1, 1, 2, 2, 1, 0}} {
+		if _, _, err := sc.Inverse(invalid, output); err == nil {
+			fmt.Printf("Invalid input not detected: %v\n", invalid)
+			os.Exit(1)
+		} else {
+			fmt.Printf("%v: %v\n", invalid, err)
+		}
+	}
+
+	// Output too small (4+4+2 bytes required)
+	if _, _, err := sc.Forward([]byte{1, 2, 3, 4}, make([]byte, 6)); err == nil {
+		fmt.Printf("Output buffer too small not detected\n")
+		os.Exit(1)
+	} else if e, ok := err.(*function.BufferTooSmallError); ok == false || e.Missing() != 4 {
+		fmt.Printf("Invalid missing capacity: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Log records of 16 bytes: type (1 byte), per type counter (4 bytes),
+// per type text (8 bytes), random status (3 bytes). The types are interleaved.
+func generateLogRecords(count int, rnd *rand.Rand) []byte {
+	names := []string{"OPEN    ", "CLOSE   ", "READ    ", "WRITE   ", "SEEK    ", "FLUSH   "}
+	counters := make([]uint32, len(names))
+	res := make([]byte, 0, count*16+3)
+
+	for i := range counters {
+		counters[i] = rnd.Uint32()
+	}
+
+	for i := 0; i < count; i++ {
+		kind := rnd.Intn(len(names))
+		counters[kind] += uint32(1 + rnd.Intn(2))
+		c := counters[kind]
+		res = append(res, byte(kind))
+		res = append(res, byte(c>>24), byte(c>>16), byte(c>>8), byte(c))
+		res = append(res, names[kind]...)
+		res = append(res, byte(rnd.Intn(2)), 0, byte(kind*3))
+	}
+
+	// Trailing partial record
+	return append(res, 1, 2, 3)
+}
+
+func TestRatio() {
+	fmt.Printf("\n\nRatio test (records of 16 bytes)\n")
+	rnd := rand.New(rand.NewSource(12345))
+	input := generateLogRecords(50000, rnd)
+	sc, _ := function.NewSortCluster(0, 16, 0, 1)
+	output := make([]byte, sc.MaxEncodedLen(len(input)))
+	_, dstIdx, err := sc.Forward(input, output)
+
+	if err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	output = output[0:dstIdx]
+
+	// Sorting helps the coders with a local context (LZ, context mixing)
+	for _, codecs := range [][]string{{"Huffman", "LZ4"}, {"FPAQ", "LZ4"}, {"CM", "None"}, {"PAQ", "None"}} {
+		raw, err1 := kio.Compress(input, codecs[0], codecs[1], 1<<20)
+		sorted, err2 := kio.Compress(output, codecs[0], codecs[1], 1<<20)
+
+		if err1 != nil || err2 != nil {
+			fmt.Printf("Compression error: %v %v\n", err1, err2)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v %-5v: raw=%v bytes, sorted=%v bytes\n", codecs[0], codecs[1], len(raw), len(sorted))
+
+		if len(sorted) >= len(raw) {
+			fmt.Printf("No compression improvement with sorted records\n")
+			os.Exit(1)
+		}
+	}
+
+	reverse := make([]byte, len(input))
+	sc.SetSize(dstIdx)
+	sc.Inverse(output, reverse)
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+}
+
+func TestSpeed() {
+	iter := 500
+	size := 50000
+	fmt.Printf("\n\nSpeed test\n")
+	fmt.Printf("Iterations: %v\n", iter)
+	input := generateLogRecords(size/16, rand.New(rand.NewSource(12345)))
+	reverse := make([]byte, len(input))
+	sc, _ := function.NewSortCluster(0, 16, 0, 1)
+	output := make([]byte, sc.MaxEncodedLen(len(input)))
+	delta1 := int64(0)
+	delta2 := int64(0)
+
+	for ii := 0; ii < iter; ii++ {
+		sc.SetSize(0)
+		before := time.Now()
+		_, dstIdx, _ := sc.Forward(input, output)
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		sc.SetSize(dstIdx)
+		before = time.Now()
+		sc.Inverse(output, reverse)
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		fmt.Printf("Different\n")
+		os.Exit(1)
+	}
+
+	prod := int64(iter) * int64(len(input))
+	fmt.Printf("SortCluster encoding [ms]: %v\n", delta1/1000000)
+	fmt.Printf("Throughput [MB/s]        : %d\n", prod*1000000/delta1*1000/(1024*1024))
+	fmt.Printf("SortCluster decoding [ms]: %v\n", delta2/1000000)
+	fmt.Printf("Throughput [MB/s]        : %d\n", prod*1000000/delta2*1000/(1024*1024))
+}
@@ -16,16 +16,32 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"kanzi"
 	"kanzi/function"
+	kio "kanzi/io"
 	"math/rand"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
 func main() {
 	fmt.Printf("TestZRLT\n")
 	TestCorrectness()
+	TestBoundary()
+	TestRunValue()
+	TestRandom()
+	TestStateful()
+	TestResumable()
+	TestMaxEncodedLen()
+	TestMalformed()
+	TestInPlace()
+	TestGain()
+	TestCompress()
 	TestSpeed()
 }
 
@@ -114,6 +130,870 @@ func TestCorrectness() {
 	}
 }
 
+func roundTrip(input []byte) ([]byte, error) {
+	return roundTripValue(input, 0)
+}
+
+// Round trip encoding the runs of 'runValue'
+func roundTripValue(input []byte, runValue byte) ([]byte, error) {
+	output := make([]byte, 2*len(input)+2)
+	reverse := make([]byte, len(input))
+	ZRLT, _ := function.NewZRLTForValue(0, runValue)
+	_, dstIdx, err := ZRLT.Forward(input, output)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n := ZRLT.EncodedLen(input); n != int(dstIdx) {
+		return nil, fmt.Errorf("Invalid encoded length for %v: %v (expected %v)", input, n, dstIdx)
+	}
+
+	// Size 0 means the whole input buffer
+	ZRLT, _ = function.NewZRLTForValue(0, runValue)
+
+	if _, _, err = ZRLT.Inverse(output[0:dstIdx], reverse); err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(input, reverse) == false {
+		return nil, fmt.Errorf("Different: %v => %v => %v", input, output[0:dstIdx], reverse)
+	}
+
+	return output[0:dstIdx], nil
+}
+
+// Literals next to runs: output values 0 and 1 (run length bits) must never
+// be confused with shifted literals
+func TestBoundary() {
+	fmt.Printf("\n\nBoundary test\n")
+
+	// Hand crafted sequences with their expected encoding
+	cases := [][2][]byte{
+		{{0}, {0}},
+		{{0, 0}, {1}},
+		{{0, 0, 0}, {0, 0}},
+		{{1}, {2}},
+		{{0, 1}, {0, 2}},
+		{{1, 0}, {2, 0}},
+		{{1, 0, 1}, {2, 0, 2}},
+		{{0, 1, 0, 0, 0xFE}, {0, 2, 1, 0xFF, 0}},
+		{{0xFF, 0, 0xFF}, {0xFF, 1, 0, 0xFF, 1}},
+		{{0, 0xFE, 0, 0}, {0, 0xFF, 0, 1}},
+		{{1, 1, 0, 0, 0, 0, 1, 1}, {2, 2, 0, 1, 2, 2}},
+	}
+
+	for _, c := range cases {
+		output, err := roundTrip(c[0])
+
+		if err != nil {
+			fmt.Printf("%v: %v\n", c[0], err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%v => %v\n", c[0], output)
+
+		if bytes.Equal(output, c[1]) == false {
+			fmt.Printf("Unexpected encoding, expected %v\n", c[1])
+			os.Exit(1)
+		}
+	}
+
+	// Runs of 2^k-1, 2^k and 2^k+1 zeros between literals 1 (encoded as 2)
+	for k := uint(1); k < 17; k++ {
+		for _, n := range []int{1<<k - 1, 1 << k, 1<<k + 1} {
+			input := make([]byte, n+2)
+			input[0] = 1
+			input[n+1] = 1
+
+			if _, err := roundTrip(input); err != nil {
+				fmt.Printf("Run of %v zeros: %v\n", n, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// All sequences of up to 8 symbols in {0, 1, 0xFE, 0xFF}
+	symbols := []byte{0, 1, 0xFE, 0xFF}
+	count := 0
+
+	for length := 0; length <= 8; length++ {
+		for code := 0; code < 1<<uint(2*length); code++ {
+			input := make([]byte, length)
+
+			for i := range input {
+				input[i] = symbols[(code>>uint(2*i))&3]
+			}
+
+			if _, err := roundTrip(input); err != nil {
+				fmt.Printf("%v: %v\n", input, err)
+				os.Exit(1)
+			}
+
+			count++
+		}
+	}
+
+	fmt.Printf("%v sequences: Identical\n", count)
+
+	// Invalid escape sequences
+	ZRLT, _ := function.NewZRLT(0)
+
+	if _, _, err := ZRLT.Inverse([]byte{2, 0xFF}, make([]byte, 16)); err == nil {
+		fmt.Printf("Truncated escape sequence not detected\n")
+		os.Exit(1)
+	}
+
+	if _, _, err := ZRLT.Inverse([]byte{0xFF, 2}, make([]byte, 16)); err == nil {
+		fmt.Printf("Invalid escape sequence not detected\n")
+		os.Exit(1)
+	}
+}
+
+// Runs of a byte value other than 0
+func TestRunValue() {
+	fmt.Printf("\n\nRun value test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Example from the doc comment
+	if output, err := roundTripValue([]byte{0x20, 0x20, 0x20, 0x41}, 0x20); err != nil ||
+		bytes.Equal(output, []byte{0, 0, 0x62}) == false {
+		fmt.Printf("Unexpected encoding: %v (%v)\n", output, err)
+		os.Exit(1)
+	}
+
+	for _, runValue := range []byte{0x20, 0xFF, 0xFE, 1} {
+		// All sequences of up to 6 symbols in {0, 1, 0xFE, 0xFF, runValue, runValue^1}
+		symbols := []byte{0, 1, 0xFE, 0xFF, runValue, runValue ^ 1}
+		count := 0
+
+		for length := 0; length <= 6; length++ {
+			codes := 1
+
+			for i := 0; i < length; i++ {
+				codes *= len(symbols)
+			}
+
+			for code := 0; code < codes; code++ {
+				input := make([]byte, length)
+
+				for i, c := 0, code; i < length; i++ {
+					input[i] = symbols[c%len(symbols)]
+					c /= len(symbols)
+				}
+
+				if _, err := roundTripValue(input, runValue); err != nil {
+					fmt.Printf("Run value %v, %v: %v\n", runValue, input, err)
+					os.Exit(1)
+				}
+
+				count++
+			}
+		}
+
+		// Data full of runs of the run value
+		input := make([]byte, 100000)
+
+		for i := 0; i < len(input); {
+			n := 1 + rnd.Intn(40)
+
+			for j := 0; j < n && i < len(input); j++ {
+				input[i] = runValue
+				i++
+			}
+
+			if i < len(input) {
+				input[i] = byte(rnd.Intn(256))
+				i++
+			}
+		}
+
+		encoded, err := roundTripValue(input, runValue)
+
+		if err != nil {
+			fmt.Printf("Run value %v: %v\n", runValue, err)
+			os.Exit(1)
+		}
+
+		zrlt, _ := function.NewZRLT(0)
+		fmt.Printf("Run value %v: %v sequences identical, runs: %v => %v bytes (%v with runs of 0)\n",
+			runValue, count, len(input), len(encoded), zrlt.EncodedLen(input))
+
+		if len(encoded)*2 >= len(input) {
+			fmt.Printf("Expected a strong gain with runs of the run value\n")
+			os.Exit(1)
+		}
+	}
+}
+
+// Forward with an output buffer of 'size' bytes, recover from panics
+func forward(input []byte, size int) (dstIdx uint, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic: %v", r)
+		}
+	}()
+
+	ZRLT, _ := function.NewZRLT(0)
+	_, dstIdx, err = ZRLT.Forward(input, make([]byte, size))
+	return dstIdx, err
+}
+
+// Random blocks (including all zero blocks and blocks ending with a run):
+// the round trip must be the identity, an output buffer of the exact encoded
+// size must be enough and a smaller one must be reported as too small
+func TestRandom() {
+	fmt.Printf("\n\nRandom test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 2000; ii++ {
+		input := make([]byte, rnd.Intn(300))
+		zeroProb := rnd.Intn(101)
+
+		for i := range input {
+			if rnd.Intn(100) >= zeroProb {
+				// Favor the escaped and run length like values
+				if rnd.Intn(2) == 0 {
+					input[i] = []byte{1, 2, 0xFE, 0xFF}[rnd.Intn(4)]
+				} else {
+					input[i] = byte(rnd.Intn(256))
+				}
+			}
+		}
+
+		if ii%10 == 0 {
+			// All zeros
+			input = make([]byte, ii/10)
+		}
+
+		encoded, err := roundTrip(input)
+
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+
+		if dstIdx, err := forward(input, len(encoded)); err != nil || dstIdx != uint(len(encoded)) {
+			fmt.Printf("Exact output size failed for %v: %v\n", input, err)
+			os.Exit(1)
+		}
+
+		if len(encoded) > 0 {
+			if _, err := forward(input, len(encoded)-1); err == nil || err.Error() != "Output buffer is too small" {
+				fmt.Printf("Output buffer too small not detected for %v: %v\n", input, err)
+				os.Exit(1)
+			}
+
+			// Grow the output buffer by the missing capacity
+			size := rnd.Intn(len(encoded))
+			_, err := forward(input, size)
+			var e *function.BufferTooSmallError
+
+			if errors.As(err, &e) == false || size+int(e.Missing()) != len(encoded) {
+				fmt.Printf("Invalid missing capacity for %v with %v bytes: %v\n", input, size, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Output buffers of every size smaller than the encoded size
+	for _, input := range [][]byte{{0, 0, 0}, {0xFF}, {1, 0, 0, 0}, {0xFE, 0, 0xFF, 0, 0, 0, 0, 0, 0, 0}} {
+		encoded, _ := roundTrip(input)
+
+		for size := 0; size < len(encoded); size++ {
+			if _, err := forward(input, size); err == nil || err.Error() != "Output buffer is too small" {
+				fmt.Printf("Output buffer of %v bytes not detected for %v: %v\n", size, input, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Run length larger than any valid run
+	ZRLT, _ := function.NewZRLT(0)
+
+	if _, _, err := ZRLT.Inverse(make([]byte, 64), make([]byte, 16)); err == nil {
+		fmt.Printf("Invalid run length not detected\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Encode the input in several calls (split at the given indexes) in stateful mode
+func encodeStateful(input []byte, splits []int) ([]byte, error) {
+	ZRLT, _ := function.NewZRLTWithState(0, 0, true)
+	output := make([]byte, 2*len(input)+2)
+	dstIdx := uint(0)
+	start := 0
+
+	for _, end := range append(splits, len(input)) {
+		srcIdx, n, err := ZRLT.Forward(input[start:end], output[dstIdx:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if srcIdx != uint(end-start) {
+			return nil, fmt.Errorf("Invalid number of bytes consumed: %v (expected %v)", srcIdx, end-start)
+		}
+
+		dstIdx += n
+		start = end
+	}
+
+	n, err := ZRLT.Flush(output[dstIdx:])
+	return output[0 : dstIdx+n], err
+}
+
+func TestStateful() {
+	fmt.Printf("\n\nStateful test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// A run of 10000 zeros in two halves
+	input := make([]byte, 10000)
+	whole, _ := roundTrip(input)
+	split, err := encodeStateful(input, []int{5000})
+
+	if err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("10000 zeros: %v bytes in one call, %v bytes in two calls\n", len(whole), len(split))
+
+	if bytes.Equal(whole, split) == false {
+		fmt.Printf("Different encodings\n")
+		os.Exit(1)
+	}
+
+	// Random data split at random indexes (including empty inputs)
+	for ii := 0; ii < 1000; ii++ {
+		input := make([]byte, rnd.Intn(500))
+
+		for i := range input {
+			if rnd.Intn(4) == 0 {
+				input[i] = byte(rnd.Intn(256))
+			}
+		}
+
+		splits := make([]int, rnd.Intn(6))
+
+		for i := range splits {
+			splits[i] = rnd.Intn(len(input) + 1)
+		}
+
+		sort.Ints(splits)
+		whole, _ := roundTrip(input)
+		split, err := encodeStateful(input, splits)
+
+		if err != nil || bytes.Equal(whole, split) == false {
+			fmt.Printf("Different encodings for %v split at %v: %v\n", input, splits, err)
+			os.Exit(1)
+		}
+	}
+
+	// Flush with an output buffer too small keeps the pending run
+	ZRLT, _ := function.NewZRLTWithState(0, 0, true)
+	ZRLT.Forward([]byte{1, 0, 0, 0}, make([]byte, 4))
+
+	if _, err := ZRLT.Flush(make([]byte, 1)); err == nil {
+		fmt.Printf("Output buffer too small not detected\n")
+		os.Exit(1)
+	}
+
+	if n, err := ZRLT.Flush(make([]byte, 2)); n != 2 || err != nil {
+		fmt.Printf("Pending run lost\n")
+		os.Exit(1)
+	}
+
+	// Output buffer too small: the pending run is kept, retry with the
+	// required capacity
+	ZRLT, _ = function.NewZRLTWithState(0, 0, true)
+	ZRLT.Forward([]byte{1, 0, 0, 0}, make([]byte, 4))
+	_, _, err = ZRLT.Forward([]byte{0, 0, 0, 0, 5, 0}, make([]byte, 1))
+	e, isTooSmall := err.(*function.BufferTooSmallError)
+
+	if isTooSmall == false || e.Required() != 4 {
+		fmt.Printf("Invalid error for a too small output buffer: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, n, err := ZRLT.Forward([]byte{0, 0, 0, 0, 5, 0}, make([]byte, e.Required())); n != 4 || err != nil {
+		fmt.Printf("Retry failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Encode with output buffers of random sizes, growing the buffer and resuming
+// after each BufferTooSmallError. Return the concatenated outputs.
+func encodeResumed(input []byte, stateful bool, rnd *rand.Rand) ([]byte, error) {
+	ZRLT, _ := function.NewZRLTWithState(0, 0, stateful)
+	ZRLT.SetResumable(true)
+	res := make([]byte, 0)
+	src := input
+	dstSize := rnd.Intn(4)
+
+	for {
+		// The pending run of the previous call counts in both sizes
+		expected := ZRLT.EncodedLen(src)
+
+		if max := ZRLT.MaxEncodedLen(len(src)); expected > max {
+			return nil, fmt.Errorf("EncodedLen()=%v above MaxEncodedLen()=%v", expected, max)
+		}
+
+		dst := make([]byte, dstSize)
+		srcIdx, dstIdx, err := ZRLT.Forward(src, dst)
+		res = append(res, dst[0:dstIdx]...)
+		src = src[srcIdx:]
+
+		if err == nil {
+			if int(dstIdx) != expected {
+				return nil, fmt.Errorf("Output index %v, EncodedLen()=%v", dstIdx, expected)
+			}
+
+			break
+		}
+
+		e, isTooSmall := err.(*function.BufferTooSmallError)
+
+		if isTooSmall == false {
+			return nil, err
+		}
+
+		// Size of the output of the rest of the input
+		if n := ZRLT.EncodedLen(src); int(e.Required()) != n {
+			return nil, fmt.Errorf("Required size %v, EncodedLen()=%v after the call", e.Required(), n)
+		}
+
+		// Resume with a buffer larger than the last one, up to the required size
+		dstSize = int(e.Available()) + 1 + rnd.Intn(int(e.Required()-e.Available())+1)
+	}
+
+	if stateful == true {
+		dst := make([]byte, 32)
+		n, err := ZRLT.Flush(dst)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, dst[0:n]...)
+	}
+
+	return res, nil
+}
+
+// Output buffers too small: the calls resumed with the rest of the input and
+// a larger buffer give the same output as one call
+func TestResumable() {
+	fmt.Printf("\n\nResumable test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 2000; ii++ {
+		input := make([]byte, rnd.Intn(300))
+
+		for i := range input {
+			switch rnd.Intn(6) {
+			case 0:
+				input[i] = byte(rnd.Intn(256))
+			case 1:
+				input[i] = 0xFE + byte(rnd.Intn(2))
+			}
+		}
+
+		whole, _ := roundTrip(input)
+
+		for _, stateful := range []bool{false, true} {
+			resumed, err := encodeResumed(input, stateful, rnd)
+
+			if err != nil || bytes.Equal(whole, resumed) == false {
+				fmt.Printf("Different encodings for %v (stateful %v): %v\n", input, stateful, err)
+				os.Exit(1)
+			}
+		}
+
+		reverse := make([]byte, len(input))
+		ZRLT, _ := function.NewZRLT(0)
+
+		if _, _, err := ZRLT.Inverse(whole, reverse); err != nil || bytes.Equal(reverse, input) == false {
+			fmt.Printf("Different after decoding the resumed encoding: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// A run which length does not fit: consumed and written by the next call
+	ZRLT, _ := function.NewZRLT(0)
+	ZRLT.SetResumable(true)
+	dst := make([]byte, 2)
+	srcIdx, dstIdx, err := ZRLT.Forward([]byte{7, 0, 0, 0, 0, 0, 0, 0, 9}, dst)
+	e, isTooSmall := err.(*function.BufferTooSmallError)
+
+	if isTooSmall == false || srcIdx != 8 || dstIdx != 1 || e.Required() != 4 {
+		fmt.Printf("Invalid state after a too small buffer: %v, %v, %v\n", srcIdx, dstIdx, err)
+		os.Exit(1)
+	}
+
+	if n, max := ZRLT.EncodedLen([]byte{9}), ZRLT.MaxEncodedLen(1); n != 4 || max < n {
+		fmt.Printf("Pending run not counted: EncodedLen()=%v, MaxEncodedLen()=%v\n", n, max)
+		os.Exit(1)
+	}
+
+	dst = make([]byte, 4)
+
+	if _, dstIdx, err = ZRLT.Forward([]byte{9}, dst); err != nil || bytes.Equal(dst[0:dstIdx], []byte{0, 0, 0, 10}) == false {
+		fmt.Printf("Invalid resumed output: %v (%v)\n", dst[0:dstIdx], err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical\n")
+}
+
+// Inverse with an output buffer of 'size' bytes, recover from panics
+func inverse(input []byte, size uint, dstSize int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic: %v", r)
+		}
+	}()
+
+	ZRLT, _ := function.NewZRLT(size)
+	_, _, err = ZRLT.Inverse(input, make([]byte, dstSize))
+	return err
+}
+
+// Fuzzing: arbitrary bytes (mostly run length bits and escapes), truncated
+// encodings, invalid sizes. Inverse must never panic and must report the
+// truncated escapes, the too long runs and the too small output buffers.
+func TestMalformed() {
+	fmt.Printf("\n\nMalformed input test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	symbols := []byte{0, 1, 2, 0xFE, 0xFF}
+
+	for _, invalid := range [][]byte{{0xFF}, {5, 0xFF}, {0xFF, 2}, bytes.Repeat([]byte{1}, 40)} {
+		if err := inverse(invalid, 0, 1<<16); err == nil {
+			fmt.Printf("Invalid input not detected: %v\n", invalid)
+			os.Exit(1)
+		} else if strings.HasPrefix(err.Error(), "Panic") == true {
+			fmt.Printf("%v: %v\n", invalid, err)
+			os.Exit(1)
+		}
+	}
+
+	// Size larger than the input
+	if err := inverse([]byte{2, 3}, 3, 16); err == nil || strings.HasPrefix(err.Error(), "Panic") == true {
+		fmt.Printf("Invalid size not detected: %v\n", err)
+		os.Exit(1)
+	}
+
+	for ii := 0; ii < 100000; ii++ {
+		input := make([]byte, rnd.Intn(64))
+
+		for i := range input {
+			if rnd.Intn(4) == 0 {
+				input[i] = byte(rnd.Intn(256))
+			} else {
+				input[i] = symbols[rnd.Intn(len(symbols))]
+			}
+		}
+
+		size := uint(rnd.Intn(len(input) + 2))
+
+		if rnd.Intn(2) == 0 {
+			size = 0
+		}
+
+		if err := inverse(input, size, rnd.Intn(256)); err != nil && strings.HasPrefix(err.Error(), "Panic") == true {
+			fmt.Printf("%v (size %v): %v\n", input, size, err)
+			os.Exit(1)
+		}
+	}
+
+	// Encoded data with a missing byte: never decoded silently to the original
+	for ii := 0; ii < 1000; ii++ {
+		input := make([]byte, 1+rnd.Intn(200))
+
+		for i := range input {
+			input[i] = symbols[rnd.Intn(len(symbols))] ^ 1
+		}
+
+		ZRLT, _ := function.NewZRLT(0)
+		output := make([]byte, ZRLT.MaxEncodedLen(len(input)))
+		_, dstIdx, _ := ZRLT.Forward(input, output)
+		reverse := make([]byte, len(input))
+		ZRLT, _ = function.NewZRLT(dstIdx - 1)
+		_, oIdx, err := ZRLT.Inverse(output, reverse)
+
+		if err == nil && oIdx == uint(len(input)) && bytes.Equal(input, reverse) == true {
+			fmt.Printf("Truncated encoding decoded: %v\n", input)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Success\n")
+}
+
+// Worst cases: escaped literals (0xFE and 0xFF), literals between runs of 1
+// value, with the run value 0 and XORed with another run value
+// Encode in place and compare with Forward: same output if the aliasing is
+// safe, error and unchanged buffer otherwise
+func inPlace(input []byte, runValue byte) ([]byte, error) {
+	ZRLT, _ := function.NewZRLTForValue(0, runValue)
+	expected := make([]byte, ZRLT.MaxEncodedLen(len(input)))
+	_, dstIdx, err := ZRLT.Forward(input, expected)
+
+	if err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	buf := make([]byte, len(input))
+	copy(buf, input)
+	n, err := ZRLT.InPlaceForward(buf)
+
+	if err != nil {
+		if bytes.Equal(buf, input) == false {
+			fmt.Printf("Buffer modified after in place error: %v\n", err)
+			os.Exit(1)
+		}
+
+		return nil, err
+	}
+
+	if bytes.Equal(buf[0:n], expected[0:dstIdx]) == false {
+		fmt.Printf("Different in place output: %v (expected %v)\n", buf[0:n], expected[0:dstIdx])
+		os.Exit(1)
+	}
+
+	return buf[0:n], nil
+}
+
+func TestInPlace() {
+	fmt.Printf("\n\nIn place test\n")
+
+	// Aliasing boundaries: an escape needs one byte saved by the previous runs
+	valid := [][]byte{{}, {0}, {5}, {0xFD}, {0, 0, 0xFE}, {0, 0, 0, 0, 0xFF, 0xFE}, {1, 0, 0, 0, 0}, {0, 0, 0xFE, 0, 0}}
+	invalid := [][]byte{{0xFE}, {0xFF, 0}, {0, 0xFE}, {0, 0, 0xFE, 0xFE}, {1, 2, 0, 0xFF, 0}}
+
+	for _, input := range valid {
+		output, err := inPlace(input, 0)
+
+		if err != nil {
+			fmt.Printf("%v: unexpected error: %v\n", input, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%v => %v\n", input, output)
+	}
+
+	for _, input := range invalid {
+		if _, err := inPlace(input, 0); err == nil {
+			fmt.Printf("%v: overlap not detected\n", input)
+			os.Exit(1)
+		} else {
+			fmt.Printf("%v: %v\n", input, err)
+		}
+	}
+
+	// Run value: 0x20 ^ 0xDE = 0xFE is escaped, 0xFE is not
+	if _, err := inPlace([]byte{0xFE, 0x20, 0xDE}, 0x20); err == nil {
+		fmt.Printf("Overlap not detected with a run value\n")
+		os.Exit(1)
+	}
+
+	if _, err := inPlace([]byte{0xFE, 0xFF}, 0x20); err != nil {
+		fmt.Printf("Unexpected error with a run value: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Stateful: the pending run of the previous call expands the output
+	ZRLT, _ := function.NewZRLTWithState(0, 0, true)
+	ZRLT.Forward(make([]byte, 100), make([]byte, 16))
+
+	if _, err := ZRLT.InPlaceForward([]byte{0, 5}); err == nil {
+		fmt.Printf("Overlap of the pending run not detected\n")
+		os.Exit(1)
+	}
+
+	// Only the first Size() bytes are encoded
+	ZRLT, _ = function.NewZRLT(3)
+	buf := []byte{0, 0, 7, 0xFE}
+
+	if n, err := ZRLT.InPlaceForward(buf); err != nil || n != 2 || bytes.Equal(buf, []byte{1, 8, 7, 0xFE}) == false {
+		fmt.Printf("Invalid encoding with a size: %v %v %v\n", buf, n, err)
+		os.Exit(1)
+	}
+
+	// Random inputs with runs and escapes
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	ok := 0
+
+	for ii := 0; ii < 1000; ii++ {
+		input := make([]byte, rnd.Intn(64))
+
+		for i := range input {
+			input[i] = []byte{0, 0, 0, 1, 0xFE, 0xFF}[rnd.Intn(6)]
+		}
+
+		if _, err := inPlace(input, 0); err == nil {
+			ok++
+		}
+	}
+
+	fmt.Printf("%v random inputs encoded in place\n", ok)
+	fmt.Printf("Success\n")
+}
+
+func TestMaxEncodedLen() {
+	fmt.Printf("\n\nMax encoded length test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		size := 1 + rnd.Intn(10000)
+		input := make([]byte, size)
+
+		for i := range input {
+			switch ii % 4 {
+			case 0:
+				input[i] = 0xFE + byte(i&1)
+			case 1:
+				input[i] = 0xFE + byte(rnd.Intn(2))
+			case 2:
+				input[i] = byte((i & 1) * 0xFF)
+			default:
+				input[i] = []byte{0, 0xFE, 0xFF}[rnd.Intn(3)]
+			}
+		}
+
+		for _, runValue := range []byte{0, 0x20} {
+			for _, stateful := range []bool{false, true} {
+				ZRLT, _ := function.NewZRLTWithState(0, runValue, stateful)
+				var bf kanzi.ByteFunction = ZRLT
+				max := bf.MaxEncodedLen(size)
+				output := make([]byte, max)
+
+				// Stateful: continue a pending run of the previous call
+				if stateful == true {
+					ZRLT.Forward(bytes.Repeat([]byte{runValue}, 1<<20), output)
+				}
+
+				_, dstIdx, err := ZRLT.Forward(input, output)
+
+				if err == nil && stateful == true {
+					n, err2 := ZRLT.Flush(output[dstIdx:])
+					dstIdx += n
+					err = err2
+				}
+
+				if err != nil {
+					fmt.Printf("Error with an output of MaxEncodedLen()=%v bytes: %v\n", max, err)
+					os.Exit(1)
+				}
+
+				if int(dstIdx) > max {
+					fmt.Printf("Encoded length %v above MaxEncodedLen()=%v\n", dstIdx, max)
+					os.Exit(1)
+				}
+			}
+		}
+
+		fmt.Printf("Test %v: %v bytes, max %v bytes\n", ii, size, 2*size)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func TestGain() {
+	fmt.Printf("\n\nGain test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	zeros := make([]byte, 100000)
+	random := make([]byte, 100000)
+
+	// Mostly zeros (EG. post BWT+MTF data)
+	for i := range zeros {
+		if rnd.Intn(10) == 0 {
+			zeros[i] = byte(1 + rnd.Intn(8))
+		}
+	}
+
+	for i := range random {
+		random[i] = byte(rnd.Intn(256))
+	}
+
+	for _, t := range []struct {
+		name string
+		data []byte
+	}{{"zeros ", zeros}, {"random", random}} {
+		encoded, err := roundTrip(t.data)
+
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+
+		gain := function.ZRLTGain(t.data)
+		fmt.Printf("%v: %v => %v bytes, gain=%.3f\n", t.name, len(t.data), len(encoded), gain)
+
+		if gain != float64(len(encoded))/float64(len(t.data)) {
+			fmt.Printf("Invalid gain\n")
+			os.Exit(1)
+		}
+	}
+
+	if function.ZRLTGain(zeros) >= 0.5 {
+		fmt.Printf("Expected a strong gain for zero heavy data\n")
+		os.Exit(1)
+	}
+
+	if function.ZRLTGain(random) <= 1 {
+		fmt.Printf("Expected a loss for random data\n")
+		os.Exit(1)
+	}
+
+	if function.ZRLTGain([]byte{}) != 1 {
+		fmt.Printf("Invalid gain for empty data\n")
+		os.Exit(1)
+	}
+}
+
+// ZRLT as a stream transform (followed by range coding)
+func TestCompress() {
+	fmt.Printf("\n\nCompress test\n")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 500000)
+
+	// Mostly zeros with runs (EG. post BWT+MTF data)
+	for i := 0; i < len(input); i += 1 + rnd.Intn(16) {
+		input[i] = byte(1 + rnd.Intn(8))
+	}
+
+	for _, transform := range []string{"ZRLT", "None"} {
+		compressed, err := kio.Compress(input, "Range", transform, 1<<17)
+
+		if err != nil {
+			fmt.Printf("Compression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		decompressed, err := kio.Decompress(compressed)
+
+		if err != nil {
+			fmt.Printf("Decompression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(input, decompressed) == false {
+			fmt.Printf("Different\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-4v+Range: %v => %v bytes\n", transform, len(input), len(compressed))
+	}
+
+	fmt.Printf("Identical\n")
+}
+
 func TestSpeed() {
 	iter := 50000
 	size := 50000
@@ -0,0 +1,191 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+)
+
+// Reproducible inputs for the codec benchmarks: the same seed and size always
+// give the same bytes, so that throughputs can be compared between versions.
+// EG. corpus, _ := util.NewBenchmarkCorpus(12345)
+//     text, _ := corpus.Generate(util.CORPUS_TEXT, 1<<20)
+// A file can also be loaded (truncated or repeated to the requested size).
+
+const (
+	CORPUS_COMPRESSIBLE = 0 // Long runs of a few symbols
+	CORPUS_RANDOM       = 1 // Uniformly distributed bytes
+	CORPUS_TEXT         = 2 // Sentences of common english words
+	CORPUS_BWT          = 3 // BWT of the text corpus (clusters of similar bytes)
+	CORPUS_TYPES        = 4
+)
+
+type BenchmarkCorpus struct {
+	seed int64
+}
+
+func NewBenchmarkCorpus(seed int64) (*BenchmarkCorpus, error) {
+	this := new(BenchmarkCorpus)
+	this.seed = seed
+	return this, nil
+}
+
+func (this *BenchmarkCorpus) Seed() int64 {
+	return this.seed
+}
+
+func GetCorpusName(corpusType int) string {
+	switch corpusType {
+	case CORPUS_COMPRESSIBLE:
+		return "compressible"
+
+	case CORPUS_RANDOM:
+		return "random"
+
+	case CORPUS_TEXT:
+		return "text"
+
+	case CORPUS_BWT:
+		return "bwt"
+
+	default:
+		return fmt.Sprintf("unknown(%v)", corpusType)
+	}
+}
+
+// Return 'size' bytes of the given corpus type
+func (this *BenchmarkCorpus) Generate(corpusType int, size int) ([]byte, error) {
+	if size < 0 {
+		return nil, errors.New("Invalid negative size parameter")
+	}
+
+	rnd := rand.New(rand.NewSource(this.seed))
+
+	switch corpusType {
+	case CORPUS_COMPRESSIBLE:
+		return generateRuns(size, rnd), nil
+
+	case CORPUS_RANDOM:
+		res := make([]byte, size)
+		rnd.Read(res)
+		return res, nil
+
+	case CORPUS_TEXT:
+		return generateText(size, rnd), nil
+
+	case CORPUS_BWT:
+		return bwtOf(generateText(size, rnd))
+
+	default:
+		return nil, fmt.Errorf("Invalid corpus type: %v", corpusType)
+	}
+}
+
+// Return 'size' bytes of the file (repeated if the file is smaller)
+func (this *BenchmarkCorpus) Load(fileName string, size int) ([]byte, error) {
+	if size < 0 {
+		return nil, errors.New("Invalid negative size parameter")
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 && size > 0 {
+		return nil, fmt.Errorf("Empty corpus file: %v", fileName)
+	}
+
+	res := make([]byte, size)
+
+	for i := 0; i < size; i += len(data) {
+		copy(res[i:], data)
+	}
+
+	return res, nil
+}
+
+func generateRuns(size int, rnd *rand.Rand) []byte {
+	res := make([]byte, size)
+
+	for i := 0; i < size; {
+		val := byte(rnd.Intn(4))
+		end := i + 1 + rnd.Intn(256)
+
+		for i < end && i < size {
+			res[i] = val
+			i++
+		}
+	}
+
+	return res
+}
+
+func generateText(size int, rnd *rand.Rand) []byte {
+	words := strings.Fields("the of and to in is that it was for on are as with his they at be " +
+		"this from have or by one had not but what all were when we there can an your which their " +
+		"said if do will each about how up out them then she many some so these would other into " +
+		"has more her two like him see time could no make than first been its who now people my")
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		n := 4 + rnd.Intn(12)
+
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+
+			buf.WriteString(words[rnd.Intn(len(words))])
+		}
+
+		buf.WriteString(". ")
+	}
+
+	return buf.Bytes()[0:size]
+}
+
+// Same output as the forward BWT (without the primary index)
+func bwtOf(src []byte) ([]byte, error) {
+	if len(src) < 2 {
+		return src, nil
+	}
+
+	saAlgo, err := NewDivSufSort()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sa := saAlgo.ComputeSuffixArray(src)
+	res := make([]byte, len(src))
+
+	for i := range res {
+		if sa[i] == 0 {
+			res[i] = src[len(src)-1]
+		} else {
+			res[i] = src[sa[i]-1]
+		}
+	}
+
+	return res, nil
+}
@@ -72,8 +72,9 @@ func NewByteArrayInputStream(buffer []byte, autogrow bool) (*ByteArrayInputStrea
 func (this *ByteArrayInputStream) Read(b []byte) (n int, err error) {
 	if this.index+len(b) > len(this.array) {
 		if this.autogrow == true {
+			// Keep unread data, pad with zeros
 			buffer := make([]byte, this.index+len(b))
-			copy(buffer, this.array[0:this.index])
+			copy(buffer, this.array)
 			this.array = buffer
 		} else {
 			return 0, fmt.Errorf("Input buffer too small, required:%v, available:%v", len(b), len(this.array)-this.index)
@@ -0,0 +1,122 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+)
+
+// Find block boundaries that follow content transitions rather than fixed
+// offsets. Two adjacent windows slide over the data and the byte histograms
+// of both windows are compared. A boundary placed where the distributions
+// differ the most keeps similar content in the same block, which improves
+// the locality of block transforms such as the BWT.
+
+const (
+	SPLIT_WINDOW_SIZE = 4096
+	SPLIT_STEP        = SPLIT_WINDOW_SIZE >> 3
+	SPLIT_THRESHOLD   = SPLIT_WINDOW_SIZE // half of max histogram distance
+)
+
+type ContentSplitter struct {
+	histo1 []int
+	histo2 []int
+}
+
+func NewContentSplitter() (*ContentSplitter, error) {
+	this := new(ContentSplitter)
+	this.histo1 = make([]int, 256)
+	this.histo2 = make([]int, 256)
+	return this, nil
+}
+
+// Return the index in [minIdx..maxIdx] where the current block should end.
+// Data beyond maxIdx (if any) is used to evaluate the candidate boundaries.
+// If no significant transition is found, maxIdx is returned.
+func (this *ContentSplitter) Split(data []byte, minIdx, maxIdx int) (int, error) {
+	if data == nil {
+		return 0, errors.New("Invalid null data parameter")
+	}
+
+	if minIdx < 0 || minIdx > maxIdx || maxIdx > len(data) {
+		return 0, errors.New("Invalid range parameters")
+	}
+
+	start := minIdx
+	end := maxIdx
+
+	if start < SPLIT_WINDOW_SIZE {
+		start = SPLIT_WINDOW_SIZE
+	}
+
+	if end > len(data)-SPLIT_WINDOW_SIZE {
+		end = len(data) - SPLIT_WINDOW_SIZE
+	}
+
+	if start > end {
+		return maxIdx, nil
+	}
+
+	h1 := this.histo1 // window before candidate boundary
+	h2 := this.histo2 // window after candidate boundary
+
+	for i := range h1 {
+		h1[i] = 0
+		h2[i] = 0
+	}
+
+	for i := start - SPLIT_WINDOW_SIZE; i < start; i++ {
+		h1[data[i]]++
+	}
+
+	for i := start; i < start+SPLIT_WINDOW_SIZE; i++ {
+		h2[data[i]]++
+	}
+
+	bestIdx := maxIdx
+	bestScore := SPLIT_THRESHOLD
+
+	for idx := start; idx <= end; idx += SPLIT_STEP {
+		score := 0
+
+		for i := range h1 {
+			if h1[i] > h2[i] {
+				score += h1[i] - h2[i]
+			} else {
+				score += h2[i] - h1[i]
+			}
+		}
+
+		if score > bestScore {
+			bestScore = score
+			bestIdx = idx
+		}
+
+		if idx+SPLIT_STEP > end {
+			break
+		}
+
+		// Slide both windows
+		for i := idx; i < idx+SPLIT_STEP; i++ {
+			h1[data[i-SPLIT_WINDOW_SIZE]]--
+			h1[data[i]]++
+			h2[data[i]]--
+			h2[data[i+SPLIT_WINDOW_SIZE]]++
+		}
+	}
+
+	return bestIdx, nil
+}
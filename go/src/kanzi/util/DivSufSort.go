@@ -1167,8 +1167,8 @@ func ssIsqrt(x int) int {
 
 	var e int
 
-	if x&0xFFFF0000 != 0 {
-		if x&0xFF000000 != 0 {
+	if (x>>16)&0xFFFF != 0 {
+		if (x>>24)&0xFF != 0 {
 			e = 24 + LOG_TABLE[(x>>24)&0xFF]
 		} else {
 			e = 16 + LOG_TABLE[(x>>16)&0xFF]
@@ -2532,8 +2532,8 @@ func (this *DivSufSort) trCopy(isa, first, a, b, last, depth int) {
 }
 
 func trIlg(n int) int {
-	if n&0xFFFF0000 != 0 {
-		if n&0xFF000000 != 0 {
+	if (n>>16)&0xFFFF != 0 {
+		if (n>>24)&0xFF != 0 {
 			return 24 + LOG_TABLE[(n>>24)&0xFF]
 		}
 
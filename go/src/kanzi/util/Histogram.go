@@ -0,0 +1,96 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"math"
+)
+
+// Byte frequencies of the data (EG. to choose a codec or train a model)
+func Histogram(data []byte) [256]int {
+	var res [256]int
+
+	for _, b := range data {
+		res[b]++
+	}
+
+	return res
+}
+
+// Order 0 Shannon entropy of the histogram in bits per byte: 0 for a single
+// symbol (or no data), 8 for uniformly distributed bytes. The size of the
+// order 0 coding of the data is at least entropy*count/8 bytes.
+func Entropy(hist [256]int) float64 {
+	total := 0
+
+	for _, f := range hist {
+		total += f
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	res := 0.0
+
+	for _, f := range hist {
+		if f > 0 {
+			p := float64(f) / float64(total)
+			res -= p * math.Log2(p)
+		}
+	}
+
+	return res
+}
+
+// Histogram of a stream of data fed by chunks (io.Writer)
+type HistogramAccumulator struct {
+	counts [256]int
+	total  uint64
+}
+
+func NewHistogramAccumulator() (*HistogramAccumulator, error) {
+	return new(HistogramAccumulator), nil
+}
+
+// Add the bytes to the histogram (never fails)
+func (this *HistogramAccumulator) Write(b []byte) (int, error) {
+	for _, v := range b {
+		this.counts[v]++
+	}
+
+	this.total += uint64(len(b))
+	return len(b), nil
+}
+
+// Return the byte frequencies of the data written so far
+func (this *HistogramAccumulator) Histogram() [256]int {
+	return this.counts
+}
+
+// Return the number of bytes written so far
+func (this *HistogramAccumulator) Count() uint64 {
+	return this.total
+}
+
+func (this *HistogramAccumulator) Entropy() float64 {
+	return Entropy(this.counts)
+}
+
+func (this *HistogramAccumulator) Reset() {
+	this.counts = [256]int{}
+	this.total = 0
+}